@@ -11,6 +11,7 @@ import (
 	"github.com/GGP1/adak/pkg/memcached"
 	"github.com/GGP1/adak/pkg/postgres"
 	"github.com/GGP1/adak/pkg/redis"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
 
 	_ "github.com/lib/pq"
 	"github.com/spf13/viper"
@@ -47,6 +48,9 @@ func main() {
 	}
 	defer rdb.Close()
 
+	// Release the stock held by abandoned carts
+	go inventory.NewService(db, conf.Inventory).Sweep(ctx)
+
 	router := rest.NewRouter(conf, db, mc, rdb)
 	srv := server.New(conf, router)
 
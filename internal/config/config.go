@@ -18,8 +18,13 @@ type Config struct {
 	Admins      []string
 	Development bool
 
+	Cart        Cart
+	Currency    Currency
 	Email       Email
+	Inventory   Inventory
+	Media       Media
 	Memcached   Memcached
+	OIDC        OIDC
 	Postgres    Postgres
 	RateLimiter RateLimiter
 	Redis       Redis
@@ -29,6 +34,18 @@ type Config struct {
 	Stripe      Stripe
 }
 
+// Cart contains the carts configuration.
+type Cart struct {
+	// Hours the guest carts are kept since they were last modified
+	GuestTTL int64
+}
+
+// Currency contains the currencies configuration.
+type Currency struct {
+	// ISO-4217 code of the currency the carts amounts are expressed in
+	Base string
+}
+
 // Email holds email attributes.
 type Email struct {
 	Host     string
@@ -37,11 +54,43 @@ type Email struct {
 	Password string
 }
 
+// Inventory contains the stock reservations configuration.
+type Inventory struct {
+	// Minutes the products added to a cart are held for it
+	HoldTTL int64
+	// Minutes between each release of the expired holds
+	SweepInterval int64
+}
+
+// Media contains the uploaded files configuration.
+type Media struct {
+	// Directory where the files are stored
+	Dir string
+	// Maximum size of the uploads in megabytes
+	MaxSize int64
+}
+
 // Memcached is the LRU-cache configuration.
 type Memcached struct {
 	Servers []string
 }
 
+// OIDC contains the OpenID Connect providers users can log in with.
+type OIDC struct {
+	// Base URL of the callbacks, the provider callback is "<RedirectURL>/<name>/callback"
+	RedirectURL string
+	Providers   map[string]OIDCProvider
+}
+
+// OIDCProvider is an OpenID Connect provider, its endpoints are discovered from the issuer.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Scopes requested besides "openid", "email" and "profile" are used if it's empty
+	Scopes []string
+}
+
 // Postgres hols the database attributes.
 type Postgres struct {
 	Username string
@@ -84,6 +133,11 @@ type Session struct {
 	Attempts int64
 	Delay    int64
 	Length   int
+	// Hours a session is kept since it was last used (0 means no expiration)
+	TTL int64
+	// AdminTwoFactor requires administrators to enable two-factor authentication
+	// before their privileges apply
+	AdminTwoFactor bool
 }
 
 // Static contains the static file system.
@@ -93,8 +147,9 @@ type Static struct {
 
 // Stripe hold stripe attributes
 type Stripe struct {
-	SecretKey string
-	Logger    struct {
+	SecretKey     string
+	WebhookSecret string
+	Logger        struct {
 		Level stripe.Level
 	}
 }
@@ -172,17 +227,28 @@ var (
 		"admins": []string{},
 		// Development
 		"development": true,
+		// Cart
+		"cart.guestttl": 72,
+		// Currency
+		"currency.base": "USD",
 		// Email
 		"email.host":     "smtp.default.com",
 		"email.port":     "587",
 		"email.sender":   "default@adak.com",
 		"email.password": "default",
 		"email.admins":   "../pkg/auth/",
-		// Google
-		"google.client.id":     "id",
-		"google.client.secret": "secret",
+		// Inventory
+		"inventory.holdttl":       15,
+		"inventory.sweepinterval": 1,
+		// Media
+		"media.dir":     "media",
+		"media.maxsize": 5,
 		// Memcached
 		"memcached.servers": []string{"memcached:11211"},
+		// OIDC
+		"oidc.redirecturl":               "http://localhost:4000/login/oidc",
+		"oidc.providers.google.issuer":   "https://accounts.google.com",
+		"oidc.providers.google.clientid": "",
 		// Postgres
 		"postgres.username": "adak",
 		"postgres.password": "adak",
@@ -205,12 +271,15 @@ var (
 		"server.timeout.write":    5,
 		"server.timeout.shutdown": 5,
 		// Session
-		"session.attempts": 5,
-		"session.delay":    0,
-		"session.length":   0,
+		"session.attempts":       5,
+		"session.delay":          0,
+		"session.length":         0,
+		"session.ttl":            720,
+		"session.admintwofactor": false,
 		// Stripe
-		"stripe.secretkey":    "sk_test_default",
-		"stripe.logger.level": "4",
+		"stripe.secretkey":     "sk_test_default",
+		"stripe.webhooksecret": "whsec_default",
+		"stripe.logger.level":  "4",
 		// Token
 		"token.secretkey": "secretkey",
 	}
@@ -220,16 +289,27 @@ var (
 		"admins": "ADAK_ADMINS",
 		// Development
 		"development": "DEVELOPMENT",
+		// Cart
+		"cart.guestttl": "CART_GUEST_TTL",
+		// Currency
+		"currency.base": "CURRENCY_BASE",
 		// Email
 		"email.host":     "EMAIL_HOST",
 		"email.port":     "EMAIL_PORT",
 		"email.sender":   "EMAIL_SENDER",
 		"email.password": "EMAIL_PASSWORD",
-		// Google
-		"google.client.id":     "GOOGLE_CLIENT_ID",
-		"google.client.secret": "GOOGLE_CLIENT_SECRET",
+		// Inventory
+		"inventory.holdttl":       "INVENTORY_HOLD_TTL",
+		"inventory.sweepinterval": "INVENTORY_SWEEP_INTERVAL",
+		// Media
+		"media.dir":     "MEDIA_DIR",
+		"media.maxsize": "MEDIA_MAX_SIZE",
 		// Memcached
 		"memcached.servers": "MEMCACHED_SERVERS",
+		// OIDC
+		"oidc.redirecturl":                   "OIDC_REDIRECT_URL",
+		"oidc.providers.google.clientid":     "GOOGLE_CLIENT_ID",
+		"oidc.providers.google.clientsecret": "GOOGLE_CLIENT_SECRET",
 		// Postgres
 		"postgres.username": "POSTGRES_USERNAME",
 		"postgres.password": "POSTGRES_PASSWORD",
@@ -252,12 +332,15 @@ var (
 		"server.timeout.write":    "SV_TIMEOUT_WRITE",
 		"server.timeout.shutdown": "SV_TIMEOUT_SHUTDOWN",
 		// Session
-		"session.attempts": "SESSION_ATTEMPTS",
-		"session.delay":    "SESSION_DELAY",
-		"session.length":   "SESSION_LENGTH",
+		"session.attempts":       "SESSION_ATTEMPTS",
+		"session.delay":          "SESSION_DELAY",
+		"session.length":         "SESSION_LENGTH",
+		"session.ttl":            "SESSION_TTL",
+		"session.admintwofactor": "SESSION_ADMIN_TWO_FACTOR",
 		// Stripe
-		"stripe.secretkey":    "STRIPE_SECRET_KEY",
-		"stripe.logger.level": "STRIPE_LOGGER_LEVEL",
+		"stripe.secretkey":     "STRIPE_SECRET_KEY",
+		"stripe.webhooksecret": "STRIPE_WEBHOOK_SECRET",
+		"stripe.logger.level":  "STRIPE_LOGGER_LEVEL",
 		// Token
 		"token.secretkey": "TOKEN_SECRET_KEY",
 	}
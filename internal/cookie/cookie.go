@@ -9,11 +9,13 @@ import (
 	"github.com/pkg/errors"
 )
 
-// Delete a cookie.
-func Delete(w http.ResponseWriter, name string) {
+// Delete a cookie, the path must be the one used to set it.
+func Delete(w http.ResponseWriter, name, path string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     name,
 		Value:    "",
+		Path:     path,
+		Domain:   "localhost",
 		HttpOnly: true,
 		MaxAge:   -1,
 	})
@@ -60,6 +62,16 @@ func IsSet(r *http.Request, name string) bool {
 
 // Set a cookie.
 func Set(w http.ResponseWriter, name, value, path string, age int) error {
+	return set(w, name, value, path, age, http.SameSiteStrictMode)
+}
+
+// SetLax is like Set but the cookie is also sent when the user navigates from another site,
+// like when an identity provider redirects back to us.
+func SetLax(w http.ResponseWriter, name, value, path string, age int) error {
+	return set(w, name, value, path, age, http.SameSiteLaxMode)
+}
+
+func set(w http.ResponseWriter, name, value, path string, age int, sameSite http.SameSite) error {
 	ciphertext, err := crypt.Encrypt([]byte(value))
 	if err != nil {
 		return err
@@ -72,7 +84,7 @@ func Set(w http.ResponseWriter, name, value, path string, age int) error {
 		Domain:   "localhost",
 		Secure:   false,
 		HttpOnly: true, // True means no scripts, http requests only. It does not refer to http(s)
-		SameSite: http.SameSiteStrictMode,
+		SameSite: sameSite,
 		MaxAge:   age,
 	})
 
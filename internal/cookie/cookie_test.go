@@ -22,9 +22,12 @@ func TestDelete(t *testing.T) {
 		Path:  "/",
 	})
 
-	Delete(w, name)
+	Delete(w, name, "/")
 
-	assert.NotEqual(t, 0, len(w.Result().Cookies()))
+	cookies := w.Result().Cookies()
+	assert.Equal(t, 2, len(cookies))
+	assert.Equal(t, "/", cookies[1].Path)
+	assert.Equal(t, -1, cookies[1].MaxAge)
 }
 
 func TestGet(t *testing.T) {
@@ -121,3 +124,14 @@ func TestSet(t *testing.T) {
 
 	assert.Equal(t, 1, len(w.Result().Cookies()))
 }
+
+func TestSetLax(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	err := SetLax(w, "test-set-lax", "adak", "/", 0)
+	assert.NoError(t, err)
+
+	cookies := w.Result().Cookies()
+	assert.Equal(t, 1, len(cookies))
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+}
@@ -29,6 +29,8 @@ type Emailer struct {
 
 	validation  *template.Template
 	changeEmail *template.Template
+	invitation  *template.Template
+	reset       *template.Template
 }
 
 // Items is a struct that keeps the values passed to the templates.
@@ -38,6 +40,8 @@ type Items struct {
 	Email    string
 	Token    string
 	NewEmail string
+	ShopName string
+	Role     string
 }
 
 // New returns a new emailer.
@@ -63,6 +67,14 @@ func New() Emailer {
 		if err != nil {
 			logger.Fatalf("Failed parsing change email template")
 		}
+		emailer.invitation, err = template.ParseFS(fs, "static/templates/invitation.html")
+		if err != nil {
+			logger.Fatalf("Failed parsing invitation template")
+		}
+		emailer.reset, err = template.ParseFS(fs, "static/templates/passwordReset.html")
+		if err != nil {
+			logger.Fatalf("Failed parsing password reset template")
+		}
 	}
 
 	return emailer
@@ -155,6 +167,93 @@ func (e *Emailer) SendChangeConfirmation(id, username, email, newEmail, token st
 	return nil
 }
 
+// SendInvitation sends an invitation to join a shop.
+func (e *Emailer) SendInvitation(ctx context.Context, email, shopName, role, token string) error {
+	// Email content
+	from := mail.Address{Name: e.name, Address: e.senderAddr}
+	to := mail.Address{Address: email}
+	items := Items{
+		Email:    email,
+		Token:    token,
+		ShopName: shopName,
+		Role:     role,
+	}
+
+	headers := make(map[string]string, 4)
+	headers["From"] = from.String()
+	headers["To"] = to.String()
+	headers["Subject"] = "Invitation to join " + shopName
+	headers["Content-Type"] = `text/html; charset="UTF-8"`
+
+	message := bufferpool.Get()
+	defer bufferpool.Put(message)
+
+	for k, v := range headers {
+		fmtHeaders(message, k, v)
+	}
+
+	buf := bufferpool.Get()
+	if err := e.invitation.Execute(buf, items); err != nil {
+		return err
+	}
+	message.Write(buf.Bytes())
+	bufferpool.Put(buf)
+
+	// Connect to smtp
+	auth := smtp.PlainAuth("", e.senderAddr, e.senderPwd, e.host)
+
+	if err := smtp.SendMail(e.addr, auth, from.Address, []string{to.Address}, message.Bytes()); err != nil {
+		logger.Debugf("Couldn't send the invitation email: %v.\nAddr: %s\nEmail: %s", err, e.addr, to.Address)
+		return errors.Wrap(err, "couldn't send the email")
+	}
+
+	logger.Infof("Successfully sent email to: %s", to.Address)
+	return nil
+}
+
+// SendPasswordReset sends the link to reset the password to the user.
+func (e *Emailer) SendPasswordReset(ctx context.Context, username, email, token string) error {
+	// Email content
+	from := mail.Address{Name: e.name, Address: e.senderAddr}
+	to := mail.Address{Name: username, Address: email}
+	items := Items{
+		Name:  username,
+		Email: email,
+		Token: token,
+	}
+
+	headers := make(map[string]string, 4)
+	headers["From"] = from.String()
+	headers["To"] = to.String()
+	headers["Subject"] = "Password reset"
+	headers["Content-Type"] = `text/html; charset="UTF-8"`
+
+	message := bufferpool.Get()
+	defer bufferpool.Put(message)
+
+	for k, v := range headers {
+		fmtHeaders(message, k, v)
+	}
+
+	buf := bufferpool.Get()
+	if err := e.reset.Execute(buf, items); err != nil {
+		return err
+	}
+	message.Write(buf.Bytes())
+	bufferpool.Put(buf)
+
+	// Connect to smtp
+	auth := smtp.PlainAuth("", e.senderAddr, e.senderPwd, e.host)
+
+	if err := smtp.SendMail(e.addr, auth, from.Address, []string{to.Address}, message.Bytes()); err != nil {
+		logger.Debugf("Couldn't send the password reset email: %v.\nAddr: %s\nEmail: %s", err, e.addr, to.Address)
+		return errors.Wrap(err, "couldn't send the email")
+	}
+
+	logger.Infof("Successfully sent email to: %s", to.Address)
+	return nil
+}
+
 func fmtHeaders(buf *bytes.Buffer, k, v string) {
 	// "key: value\r\n"
 	buf.WriteString(k)
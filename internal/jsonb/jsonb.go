@@ -0,0 +1,32 @@
+// Package jsonb stores values in json and jsonb columns.
+package jsonb
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Value returns the value encoded as a string, lib/pq sends []byte values as bytea.
+func Value(v interface{}) (driver.Value, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan decodes the column value into dst, null values leave it untouched.
+func Scan(src, dst interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(src, dst)
+	case string:
+		return json.Unmarshal([]byte(src), dst)
+	default:
+		return errors.Errorf("unsupported type %T", src)
+	}
+}
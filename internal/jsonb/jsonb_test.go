@@ -0,0 +1,36 @@
+package jsonb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValue(t *testing.T) {
+	got, err := Value(map[string]int{"a": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, got)
+}
+
+func TestScan(t *testing.T) {
+	cases := []struct {
+		desc     string
+		src      interface{}
+		expected []string
+	}{
+		{desc: "Bytes", src: []byte(`["a","b"]`), expected: []string{"a", "b"}},
+		{desc: "String", src: `["c"]`, expected: []string{"c"}},
+		{desc: "Null", src: nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []string
+			assert.NoError(t, Scan(tc.src, &got))
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+
+	var got []string
+	assert.Error(t, Scan(1, &got))
+}
@@ -0,0 +1,166 @@
+package params
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GGP1/adak/internal/validate"
+	"github.com/pkg/errors"
+)
+
+// Search results sort options.
+const (
+	SortRelevance = "relevance"
+	SortNewest    = "newest"
+	SortPriceAsc  = "price_asc"
+	SortPriceDesc = "price_desc"
+)
+
+// Search contains the product search parameters provided by the client.
+//
+// Zero values mean that the filter is not applied.
+type Search struct {
+	Query     string
+	Brands    []string
+	ShopID    string
+	MinPrice  int64
+	MaxPrice  int64
+	MinRating int64
+	InStock   bool
+	Sort      string
+	Cursor    SearchCursor
+	Limit     string
+}
+
+// SearchCursor contains the values used for the search results pagination, Value is the
+// one of the field the results are sorted by.
+type SearchCursor struct {
+	Used  bool
+	Value string
+	ID    string
+}
+
+// DecodeSearchCursor decodes the search cursor and validates its value for the sort given.
+func DecodeSearchCursor(encodedCursor, sort string) (SearchCursor, error) {
+	if encodedCursor == "" {
+		return SearchCursor{Used: false}, nil
+	}
+
+	cursor, err := base64.StdEncoding.DecodeString(encodedCursor)
+	if err != nil {
+		return SearchCursor{}, errors.Wrap(err, "decoding cursor")
+	}
+
+	split := strings.Split(string(cursor), ",")
+	if len(split) != 2 {
+		return SearchCursor{}, errors.New("invalid cursor")
+	}
+
+	value := split[0]
+	switch sort {
+	case SortRelevance:
+		_, err = strconv.ParseFloat(value, 32)
+	case SortNewest:
+		_, err = time.Parse(time.RFC3339Nano, value)
+	case SortPriceAsc, SortPriceDesc:
+		_, err = strconv.ParseInt(value, 10, 64)
+	}
+	if err != nil {
+		return SearchCursor{}, errors.Wrap(err, "invalid cursor value")
+	}
+
+	c := SearchCursor{
+		Used:  true,
+		Value: value,
+		ID:    split[1],
+	}
+	return c, nil
+}
+
+// EncodeSearchCursor encodes the sort value and id with base64.
+func EncodeSearchCursor(value, id string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value + "," + id))
+}
+
+// ParseSearch returns the search params received after validating them.
+//
+// Results are sorted by relevance when there is a query and by newest otherwise.
+func ParseSearch(rawQuery string) (Search, error) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return Search{}, err
+	}
+
+	search := Search{
+		Query:  strings.TrimSpace(values.Get("q")),
+		Brands: split(values.Get("brand")),
+		ShopID: values.Get("shop"),
+		Sort:   values.Get("sort"),
+	}
+
+	if search.ShopID != "" {
+		if err := validate.UUID(search.ShopID); err != nil {
+			return Search{}, errors.Wrap(err, "shop")
+		}
+	}
+
+	if search.MinPrice, err = parseInt64(values.Get("min_price")); err != nil {
+		return Search{}, errors.Wrap(err, "min_price")
+	}
+	if search.MaxPrice, err = parseInt64(values.Get("max_price")); err != nil {
+		return Search{}, errors.Wrap(err, "max_price")
+	}
+	if search.MinRating, err = parseInt64(values.Get("min_rating")); err != nil || search.MinRating > 5 {
+		return Search{}, errors.Errorf("min_rating: invalid rating %q", values.Get("min_rating"))
+	}
+
+	if inStock := values.Get("in_stock"); inStock != "" {
+		if search.InStock, err = strconv.ParseBool(inStock); err != nil {
+			return Search{}, errors.Wrap(err, "in_stock")
+		}
+	}
+
+	switch search.Sort {
+	case "":
+		search.Sort = SortNewest
+		if search.Query != "" {
+			search.Sort = SortRelevance
+		}
+	case SortNewest, SortPriceAsc, SortPriceDesc:
+	case SortRelevance:
+		if search.Query == "" {
+			return Search{}, errors.New("sort: relevance requires a query")
+		}
+	default:
+		return Search{}, errors.Errorf("sort: invalid option %q", search.Sort)
+	}
+
+	if search.Cursor, err = DecodeSearchCursor(values.Get("cursor"), search.Sort); err != nil {
+		return Search{}, err
+	}
+
+	if search.Limit, err = parseInt(values.Get("limit"), "20", maxResults); err != nil {
+		return Search{}, errors.Wrap(err, "limit")
+	}
+
+	return search, nil
+}
+
+// parseInt64 parses a non-negative integer, an empty value is zero.
+func parseInt64(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid number")
+	}
+	if i < 0 {
+		return 0, errors.Errorf("number provided (%d) is negative", i)
+	}
+	return i, nil
+}
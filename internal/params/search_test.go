@@ -0,0 +1,77 @@
+package params
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSearch(t *testing.T) {
+	shopID := "1cf8a0a9-6d1e-4c6a-9d5c-6f0d0c1b5b3e"
+	cursor := EncodeSearchCursor("1500", "1234567890")
+
+	cases := []struct {
+		desc     string
+		rawQuery string
+		expected Search
+	}{
+		{
+			desc:     "Defaults",
+			rawQuery: "",
+			expected: Search{Sort: SortNewest, Limit: "20"},
+		},
+		{
+			desc:     "Query",
+			rawQuery: "q=running+shoes",
+			expected: Search{Query: "running shoes", Sort: SortRelevance, Limit: "20"},
+		},
+		{
+			desc: "Filters",
+			rawQuery: "q=shoes&brand=adak,other&shop=" + shopID +
+				"&min_price=100&max_price=5000&min_rating=4&in_stock=true&sort=price_asc&cursor=" + cursor + "&limit=10",
+			expected: Search{
+				Query:     "shoes",
+				Brands:    []string{"adak", "other"},
+				ShopID:    shopID,
+				MinPrice:  100,
+				MaxPrice:  5000,
+				MinRating: 4,
+				InStock:   true,
+				Sort:      SortPriceAsc,
+				Cursor:    SearchCursor{Used: true, Value: "1500", ID: "1234567890"},
+				Limit:     "10",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := ParseSearch(tc.rawQuery)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestParseSearchErrors(t *testing.T) {
+	newest := EncodeSearchCursor(time.Unix(15000, 0).Format(time.RFC3339Nano), "1")
+	cases := []struct {
+		desc     string
+		rawQuery string
+	}{
+		{desc: "Invalid shop", rawQuery: "shop=1"},
+		{desc: "Negative price", rawQuery: "min_price=-1"},
+		{desc: "Invalid rating", rawQuery: "min_rating=6"},
+		{desc: "Invalid sort", rawQuery: "sort=name"},
+		{desc: "Relevance without query", rawQuery: "sort=relevance"},
+		{desc: "Cursor of another sort", rawQuery: "sort=price_desc&cursor=" + newest},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := ParseSearch(tc.rawQuery)
+			assert.Error(t, err)
+		})
+	}
+}
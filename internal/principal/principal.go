@@ -0,0 +1,57 @@
+// Package principal carries the identity of the authenticated user through the request context.
+package principal
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnauthenticated is returned when the request doesn't belong to a logged in user.
+var ErrUnauthenticated = errors.New("please log in to access")
+
+type ctxKey struct{}
+
+// Principal is the user a request is made on behalf of, it's loaded from the session store.
+type Principal struct {
+	UserID string
+	CartID string
+	Admin  bool
+	// SessionID is the id of the session used to authenticate the request
+	SessionID string
+}
+
+// NewContext returns a copy of the context carrying the principal.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the principal stored in the context, if any.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(Principal)
+	return p, ok
+}
+
+// UserID returns the id of the authenticated user.
+func UserID(ctx context.Context) (string, error) {
+	p, ok := FromContext(ctx)
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	return p.UserID, nil
+}
+
+// CartID returns the id of the authenticated user's cart.
+func CartID(ctx context.Context) (string, error) {
+	p, ok := FromContext(ctx)
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	return p.CartID, nil
+}
+
+// IsAdmin returns whether the authenticated user is an administrator.
+func IsAdmin(ctx context.Context) bool {
+	p, ok := FromContext(ctx)
+	return ok && p.Admin
+}
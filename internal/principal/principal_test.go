@@ -0,0 +1,30 @@
+package principal_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GGP1/adak/internal/principal"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrincipal(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := principal.UserID(ctx)
+	assert.Equal(t, principal.ErrUnauthenticated, err)
+	_, err = principal.CartID(ctx)
+	assert.Equal(t, principal.ErrUnauthenticated, err)
+	assert.False(t, principal.IsAdmin(ctx))
+
+	ctx = principal.NewContext(ctx, principal.Principal{UserID: "user", CartID: "cart", Admin: true})
+	userID, err := principal.UserID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", userID)
+
+	cartID, err := principal.CartID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "cart", cartID)
+	assert.True(t, principal.IsAdmin(ctx))
+}
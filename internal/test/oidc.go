@@ -0,0 +1,217 @@
+package test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const oidcKeyID = "test"
+
+// OIDCIssuer is a fake OpenID Connect provider, every authorization request is approved
+// on behalf of the user set in it.
+type OIDCIssuer struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+	// User authenticated
+	Subject       string
+	Email         string
+	EmailVerified bool
+
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]oidcGrant
+}
+
+type oidcGrant struct {
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+// NewOIDCIssuer starts a fake OpenID Connect provider, it's closed when the test finishes.
+func NewOIDCIssuer(t testing.TB) *OIDCIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	issuer := &OIDCIssuer{
+		ClientID:      "adak",
+		ClientSecret:  "secret",
+		Subject:       "oidc-subject",
+		Email:         "oidc@adak.com",
+		EmailVerified: true,
+		key:           key,
+		codes:         make(map[string]oidcGrant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/authorize", issuer.authorize)
+	mux.HandleFunc("/jwks", issuer.jwks)
+	mux.HandleFunc("/token", issuer.token(t))
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+
+	return issuer
+}
+
+// Claims returns valid ID token claims of the issuer user.
+func (i *OIDCIssuer) Claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            i.URL,
+		"sub":            i.Subject,
+		"aud":            i.ClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          i.Email,
+		"email_verified": i.EmailVerified,
+		"name":           "OIDC User",
+	}
+}
+
+// IDToken signs the claims with the issuer key.
+func (i *OIDCIssuer) IDToken(t testing.TB, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": oidcKeyID, "typ": "JWT"})
+	assert.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	assert.NoError(t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Authorize follows the authorization URL and returns the callback the issuer redirects to.
+func (i *OIDCIssuer) Authorize(t testing.TB, authURL string) *url.URL {
+	t.Helper()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Get(authURL)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+
+	callback, err := res.Location()
+	assert.NoError(t, err)
+	return callback
+}
+
+func (i *OIDCIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != i.ClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	code := hex.EncodeToString(b)
+
+	i.mu.Lock()
+	i.codes[code] = oidcGrant{
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	i.mu.Unlock()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (i *OIDCIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"jwks_uri":               i.URL + "/jwks",
+	})
+}
+
+func (i *OIDCIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := i.key.PublicKey
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": oidcKeyID,
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (i *OIDCIssuer) token(t testing.TB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok {
+			clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		if clientID != i.ClientID || clientSecret != i.ClientSecret {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+
+		i.mu.Lock()
+		grant, ok := i.codes[r.PostForm.Get("code")]
+		delete(i.codes, r.PostForm.Get("code"))
+		i.mu.Unlock()
+
+		challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+			r.PostForm.Get("redirect_uri") != grant.redirectURI ||
+			base64.RawURLEncoding.EncodeToString(challenge[:]) != grant.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     i.IDToken(t, i.Claims(grant.nonce)),
+		})
+	}
+}
@@ -1,11 +1,11 @@
 package token
 
 import (
+	"context"
 	"crypto/rand"
 	"math/big"
-	"net/http"
 
-	"github.com/GGP1/adak/internal/cookie"
+	"github.com/GGP1/adak/internal/principal"
 
 	"github.com/pkg/errors"
 )
@@ -29,12 +29,13 @@ func RandString(length int) string {
 
 // CheckPermits cheks if the user is trying to perform and action on his own
 // account (return nil) or not (return error).
-func CheckPermits(r *http.Request, paramID string) error {
-	if len(paramID) > 32 {
+func CheckPermits(ctx context.Context, paramID string) error {
+	// User and order ids are UUIDs
+	if len(paramID) > 36 {
 		return errors.New("invalid id")
 	}
 
-	userID, err := cookie.GetValue(r, "UID")
+	userID, err := principal.UserID(ctx)
 	if err != nil {
 		return err
 	}
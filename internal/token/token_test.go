@@ -1,13 +1,13 @@
 package token_test
 
 import (
-	"encoding/hex"
-	"net/http"
+	"context"
 	"testing"
 
-	"github.com/GGP1/adak/internal/crypt"
+	"github.com/GGP1/adak/internal/principal"
 	"github.com/GGP1/adak/internal/token"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,30 +22,31 @@ func TestGenerateString(t *testing.T) {
 
 func TestCheckPermits(t *testing.T) {
 	id := "checkPermitsTest"
-	r, err := http.NewRequest("GET", "/", nil)
-	assert.NoError(t, err)
-
-	ciphertext, err := crypt.Encrypt([]byte(id))
-	assert.NoError(t, err)
-
-	r.AddCookie(&http.Cookie{
-		Name:  "UID",
-		Value: hex.EncodeToString(ciphertext),
-		Path:  "/",
-	})
+	ctx := principal.NewContext(context.Background(), principal.Principal{UserID: id})
 
 	t.Run("Success", func(t *testing.T) {
-		err = token.CheckPermits(r, id)
+		err := token.CheckPermits(ctx, id)
 		assert.NoError(t, err, "Failed checking permits")
 	})
 
 	t.Run("Permission denied", func(t *testing.T) {
-		err := token.CheckPermits(r, id+"fail")
+		err := token.CheckPermits(ctx, id+"fail")
 		assert.Error(t, err)
 	})
 
+	t.Run("UUID", func(t *testing.T) {
+		id := uuid.NewString()
+		ctx := principal.NewContext(context.Background(), principal.Principal{UserID: id})
+		assert.NoError(t, token.CheckPermits(ctx, id))
+	})
+
 	t.Run("ID too long", func(t *testing.T) {
-		err := token.CheckPermits(r, "9 }NkbKPLja;As[0<|d4nMG!5l3>x$+Qp")
+		err := token.CheckPermits(ctx, "9 }NkbKPLja;As[0<|d4nMG!5l3>x$+Qp-long")
 		assert.Error(t, err)
 	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		err := token.CheckPermits(context.Background(), id)
+		assert.Equal(t, principal.ErrUnauthenticated, err)
+	})
 }
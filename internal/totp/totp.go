@@ -0,0 +1,119 @@
+// Package totp implements time-based one-time passwords (RFC 6238) compatible with
+// authenticator applications.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Digits is the length of the codes
+	Digits = 6
+	// Period is the number of seconds each code is valid for
+	Period = 30
+	// skew is the number of periods before and after the current one accepted to tolerate clock drift
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random base32 encoded secret.
+func NewSecret() (string, error) {
+	// 160 bits as recommended by RFC 4226
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating secret")
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the key URI used by authenticator applications to register the secret,
+// usually shared in a QR code.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Code returns the code of the secret at the time provided.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, counter(t), Digits), nil
+}
+
+// Validate reports whether the code is valid for the secret at the time provided, the
+// counter of the period matched is returned to prevent the code from being reused.
+func Validate(secret, passcode string, t time.Time) (int64, bool) {
+	passcode = strings.TrimSpace(passcode)
+	if len(passcode) != Digits {
+		return 0, false
+	}
+
+	key, err := decode(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	current := counter(t)
+	for i := -skew; i <= skew; i++ {
+		c := current + int64(i)
+		if subtle.ConstantTimeCompare([]byte(code(key, c, Digits)), []byte(passcode)) == 1 {
+			return c, true
+		}
+	}
+
+	return 0, false
+}
+
+// code implements the HOTP algorithm (RFC 4226).
+func code(key []byte, counter int64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+func counter(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+func decode(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid secret")
+	}
+	return key, nil
+}
@@ -0,0 +1,81 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCode(t *testing.T) {
+	// Test vectors from RFC 6238 appendix B (SHA1)
+	key := []byte("12345678901234567890")
+	cases := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "94287082"},
+		{unix: 1111111109, expected: "07081804"},
+		{unix: 1111111111, expected: "14050471"},
+		{unix: 1234567890, expected: "89005924"},
+		{unix: 2000000000, expected: "69279037"},
+		{unix: 20000000000, expected: "65353130"},
+	}
+
+	for _, tc := range cases {
+		got := code(key, counter(time.Unix(tc.unix, 0)), 8)
+		assert.Equal(t, tc.expected, got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+
+	passcode, err := Code(secret, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "050471", passcode)
+
+	cases := []struct {
+		desc     string
+		passcode string
+		at       time.Time
+		valid    bool
+	}{
+		{desc: "Current period", passcode: passcode, at: now, valid: true},
+		{desc: "Clock drift", passcode: passcode, at: now.Add(Period * time.Second), valid: true},
+		{desc: "Expired", passcode: passcode, at: now.Add(3 * Period * time.Second), valid: false},
+		{desc: "Wrong code", passcode: "123456", at: now, valid: false},
+		{desc: "Wrong length", passcode: "50471", at: now, valid: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			c, ok := Validate(secret, tc.passcode, tc.at)
+			assert.Equal(t, tc.valid, ok)
+			if ok {
+				assert.Equal(t, counter(now), c)
+			}
+		})
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	assert.NoError(t, err)
+
+	key, err := decode(secret)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, len(key))
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Adak", "user@adak.com", "JBSWY3DPEHPK3PXP")
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Adak:user@adak.com?"))
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=Adak")
+	assert.Contains(t, uri, "digits=6")
+}
@@ -0,0 +1,208 @@
+package address
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/response"
+	"github.com/GGP1/adak/internal/token"
+	"github.com/GGP1/adak/internal/validate"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Handler handles address book endpoints.
+type Handler struct {
+	service Service
+}
+
+// NewHandler returns a new address book handler.
+func NewHandler(service Service) Handler {
+	return Handler{service: service}
+}
+
+// Create saves a new address in the user's book.
+func (h *Handler) Create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := token.CheckPermits(ctx, userID); err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		var address UserAddress
+		if err := json.NewDecoder(r.Body).Decode(&address); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validateAddress(r, &address); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		address.ID = uuid.NewString()
+		address.UserID = userID
+		if err := h.service.Create(ctx, address); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, address)
+	}
+}
+
+// Delete removes an address from the user's book.
+func (h *Handler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, id, err := ids(r)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := token.CheckPermits(ctx, userID); err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		if err := h.service.Delete(ctx, userID, id); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, id)
+	}
+}
+
+// Get lists the user's addresses.
+func (h *Handler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := token.CheckPermits(ctx, userID); err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		addresses, err := h.service.Get(ctx, userID)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, addresses)
+	}
+}
+
+// GetByID lists the address requested.
+func (h *Handler) GetByID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, id, err := ids(r)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := token.CheckPermits(ctx, userID); err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		address, err := h.service.GetByID(ctx, userID, id)
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, address)
+	}
+}
+
+// Update replaces an address of the user's book.
+func (h *Handler) Update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, id, err := ids(r)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := token.CheckPermits(ctx, userID); err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		var address UserAddress
+		if err := json.NewDecoder(r.Body).Decode(&address); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validateAddress(r, &address); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		address.ID = id
+		address.UserID = userID
+		if err := h.service.Update(ctx, address); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, id)
+	}
+}
+
+// ids returns the user and address ids from the url.
+func ids(r *http.Request) (string, string, error) {
+	userID, err := params.URLID(r.Context())
+	if err != nil {
+		return "", "", err
+	}
+
+	id := chi.URLParam(r, "address_id")
+	if err := validate.UUID(id); err != nil {
+		return "", "", err
+	}
+
+	return userID, id, nil
+}
+
+func validateAddress(r *http.Request, address *UserAddress) error {
+	if err := validate.Struct(r.Context(), address); err != nil {
+		return err
+	}
+	address.Normalize()
+	return address.Validate()
+}
@@ -0,0 +1,61 @@
+// Package address contains the postal addresses used across the system and
+// the customers address book.
+package address
+
+import (
+	"strings"
+	"time"
+
+	"github.com/GGP1/adak/internal/sanitize"
+
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// Address is a postal address.
+type Address struct {
+	Country string `json:"country,omitempty" validate:"required"`
+	State   string `json:"state,omitempty"`
+	City    string `json:"city,omitempty" validate:"required"`
+	ZipCode string `json:"zip_code,omitempty" db:"zip_code"`
+	Street  string `json:"address,omitempty" db:"address" validate:"required"`
+}
+
+// UserAddress is an address saved in a user's address book.
+type UserAddress struct {
+	ID     string `json:"id,omitempty"`
+	UserID string `json:"user_id,omitempty" db:"user_id"`
+	Name   string `json:"name,omitempty" validate:"max=50"`
+	Address
+	DefaultShipping bool      `json:"default_shipping" db:"default_shipping"`
+	DefaultBilling  bool      `json:"default_billing" db:"default_billing"`
+	CreatedAt       time.Time `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt       zero.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// Normalize removes accents and surrounding spaces from the address fields,
+// postal codes are upper cased.
+func (a *Address) Normalize() {
+	a.Country = sanitize.Normalize(strings.TrimSpace(a.Country))
+	a.State = sanitize.Normalize(strings.TrimSpace(a.State))
+	a.City = sanitize.Normalize(strings.TrimSpace(a.City))
+	a.ZipCode = strings.ToUpper(strings.TrimSpace(a.ZipCode))
+	a.Street = sanitize.Normalize(strings.TrimSpace(a.Street))
+}
+
+// Validate checks the postal code against the format of the address country.
+//
+// Countries without a known format accept any postal code.
+func (a Address) Validate() error {
+	format, ok := postalCodes[CountryCode(a.Country)]
+	if !ok {
+		return nil
+	}
+	if a.ZipCode == "" {
+		return errors.Errorf("a postal code is required for %s", a.Country)
+	}
+	if !format.MatchString(strings.ToUpper(a.ZipCode)) {
+		return errors.Errorf("invalid postal code %q for %s", a.ZipCode, a.Country)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package address_test
+
+import (
+	"testing"
+
+	"github.com/GGP1/adak/pkg/address"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountryCode(t *testing.T) {
+	cases := []struct {
+		country  string
+		expected string
+	}{
+		{country: "AR", expected: "AR"},
+		{country: "us", expected: "US"},
+		{country: "United States", expected: "US"},
+		{country: " new zealand ", expected: "NZ"},
+		{country: "UK", expected: "GB"},
+		{country: "Atlantis"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.country, func(t *testing.T) {
+			assert.Equal(t, tc.expected, address.CountryCode(tc.country))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		desc  string
+		addr  address.Address
+		valid bool
+	}{
+		{desc: "Argentina", addr: address.Address{Country: "Argentina", ZipCode: "C1043AAB"}, valid: true},
+		{desc: "Argentina legacy", addr: address.Address{Country: "Argentina", ZipCode: "5000"}, valid: true},
+		{desc: "Canada", addr: address.Address{Country: "CA", ZipCode: "k1a 0b1"}, valid: true},
+		{desc: "United Kingdom", addr: address.Address{Country: "United Kingdom", ZipCode: "SW1A 1AA"}, valid: true},
+		{desc: "United States plus four", addr: address.Address{Country: "USA", ZipCode: "43004-1234"}, valid: true},
+		{desc: "Unknown country", addr: address.Address{Country: "Atlantis", ZipCode: "anything"}, valid: true},
+		{desc: "Invalid", addr: address.Address{Country: "United States", ZipCode: "4300"}},
+		{desc: "Missing", addr: address.Address{Country: "Germany"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.addr.Validate()
+			assert.Equal(t, tc.valid, err == nil, err)
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	addr := address.Address{Country: " España ", City: "Málaga", ZipCode: " 29001", Street: "Calle Larios 1 "}
+	addr.Normalize()
+
+	expected := address.Address{Country: "Espana", City: "Malaga", ZipCode: "29001", Street: "Calle Larios 1"}
+	assert.Equal(t, expected, addr)
+	assert.NoError(t, addr.Validate())
+}
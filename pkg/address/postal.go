@@ -0,0 +1,67 @@
+package address
+
+import (
+	"regexp"
+	"strings"
+)
+
+// postalCodes contains the postal code formats indexed by ISO 3166-1 alpha-2 country code.
+var postalCodes = map[string]*regexp.Regexp{
+	"AR": regexp.MustCompile(`^[A-Z]?\d{4}([A-Z]{3})?$`),
+	"AU": regexp.MustCompile(`^\d{4}$`),
+	"BR": regexp.MustCompile(`^\d{5}-?\d{3}$`),
+	"CA": regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`),
+	"CL": regexp.MustCompile(`^\d{7}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"JP": regexp.MustCompile(`^\d{3}-?\d{4}$`),
+	"MX": regexp.MustCompile(`^\d{5}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`),
+	"NZ": regexp.MustCompile(`^\d{4}$`),
+	"PT": regexp.MustCompile(`^\d{4}-\d{3}$`),
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+	"UY": regexp.MustCompile(`^\d{5}$`),
+}
+
+// countryCodes maps the (normalized) country names to their ISO 3166-1 alpha-2 code.
+var countryCodes = map[string]string{
+	"argentina":                "AR",
+	"australia":                "AU",
+	"brazil":                   "BR",
+	"brasil":                   "BR",
+	"canada":                   "CA",
+	"chile":                    "CL",
+	"germany":                  "DE",
+	"spain":                    "ES",
+	"espana":                   "ES",
+	"france":                   "FR",
+	"united kingdom":           "GB",
+	"uk":                       "GB",
+	"great britain":            "GB",
+	"italy":                    "IT",
+	"japan":                    "JP",
+	"mexico":                   "MX",
+	"netherlands":              "NL",
+	"new zealand":              "NZ",
+	"portugal":                 "PT",
+	"united states":            "US",
+	"united states of america": "US",
+	"usa":                      "US",
+	"uruguay":                  "UY",
+}
+
+// CountryCode returns the ISO 3166-1 alpha-2 code of the country, it accepts
+// both codes and names. An empty string is returned if the country is unknown.
+func CountryCode(country string) string {
+	country = strings.TrimSpace(country)
+	if len(country) == 2 {
+		code := strings.ToUpper(country)
+		if _, ok := postalCodes[code]; ok {
+			return code
+		}
+	}
+	return countryCodes[strings.ToLower(country)]
+}
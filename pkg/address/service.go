@@ -0,0 +1,154 @@
+package address
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// Service contains the address book functionalities.
+type Service interface {
+	Create(ctx context.Context, address UserAddress) error
+	Delete(ctx context.Context, userID, id string) error
+	Get(ctx context.Context, userID string) ([]UserAddress, error)
+	GetByID(ctx context.Context, userID, id string) (UserAddress, error)
+	Update(ctx context.Context, address UserAddress) error
+}
+
+type service struct {
+	db      *sqlx.DB
+	metrics metrics
+}
+
+// NewService returns a new address book service.
+func NewService(db *sqlx.DB) Service {
+	return &service{db, initMetrics()}
+}
+
+// Create saves an address in the user's book, if it's marked as default
+// the previous default address loses the flag.
+func (s *service) Create(ctx context.Context, address UserAddress) error {
+	s.metrics.incMethodCalls("Create")
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := unsetDefaults(ctx, tx, address); err != nil {
+		return err
+	}
+
+	q := `INSERT INTO user_addresses
+	(id, user_id, name, country, state, city, zip_code, address, default_shipping, default_billing, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	_, err = tx.ExecContext(ctx, q, address.ID, address.UserID, address.Name, address.Country,
+		address.State, address.City, address.ZipCode, address.Street,
+		address.DefaultShipping, address.DefaultBilling, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "couldn't create the address")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// Delete removes an address from the user's book.
+func (s *service) Delete(ctx context.Context, userID, id string) error {
+	s.metrics.incMethodCalls("Delete")
+
+	q := "DELETE FROM user_addresses WHERE id=$1 AND user_id=$2"
+	if _, err := s.db.ExecContext(ctx, q, id, userID); err != nil {
+		return errors.Wrap(err, "couldn't delete the address")
+	}
+
+	return nil
+}
+
+// Get returns the user's addresses, defaults first.
+func (s *service) Get(ctx context.Context, userID string) ([]UserAddress, error) {
+	s.metrics.incMethodCalls("Get")
+
+	var addresses []UserAddress
+	q := `SELECT * FROM user_addresses WHERE user_id=$1 
+	ORDER BY default_shipping DESC, default_billing DESC, created_at`
+	if err := s.db.SelectContext(ctx, &addresses, q, userID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the addresses")
+	}
+
+	return addresses, nil
+}
+
+// GetByID returns the address requested, it must belong to the user.
+func (s *service) GetByID(ctx context.Context, userID, id string) (UserAddress, error) {
+	s.metrics.incMethodCalls("GetByID")
+
+	var address UserAddress
+	q := "SELECT * FROM user_addresses WHERE id=$1 AND user_id=$2"
+	if err := s.db.GetContext(ctx, &address, q, id, userID); err != nil {
+		return UserAddress{}, errors.Wrap(err, "couldn't find the address")
+	}
+
+	return address, nil
+}
+
+// Update replaces the address fields and flags.
+func (s *service) Update(ctx context.Context, address UserAddress) error {
+	s.metrics.incMethodCalls("Update")
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := unsetDefaults(ctx, tx, address); err != nil {
+		return err
+	}
+
+	q := `UPDATE user_addresses SET name=$3, country=$4, state=$5, city=$6, zip_code=$7, address=$8,
+	default_shipping=$9, default_billing=$10, updated_at=$11 
+	WHERE id=$1 AND user_id=$2`
+	res, err := tx.ExecContext(ctx, q, address.ID, address.UserID, address.Name, address.Country,
+		address.State, address.City, address.ZipCode, address.Street,
+		address.DefaultShipping, address.DefaultBilling, zero.TimeFrom(time.Now()))
+	if err != nil {
+		return errors.Wrap(err, "couldn't update the address")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.Wrap(sql.ErrNoRows, "couldn't find the address")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// unsetDefaults takes the default flags set in the address from the rest of the user's addresses.
+func unsetDefaults(ctx context.Context, tx *sqlx.Tx, address UserAddress) error {
+	if address.DefaultShipping {
+		q := "UPDATE user_addresses SET default_shipping=false WHERE user_id=$1 AND id<>$2 AND default_shipping"
+		if _, err := tx.ExecContext(ctx, q, address.UserID, address.ID); err != nil {
+			return errors.Wrap(err, "couldn't unset the default shipping address")
+		}
+	}
+
+	if address.DefaultBilling {
+		q := "UPDATE user_addresses SET default_billing=false WHERE user_id=$1 AND id<>$2 AND default_billing"
+		if _, err := tx.ExecContext(ctx, q, address.UserID, address.ID); err != nil {
+			return errors.Wrap(err, "couldn't unset the default billing address")
+		}
+	}
+
+	return nil
+}
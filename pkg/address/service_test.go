@@ -0,0 +1,84 @@
+package address_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/address"
+	"github.com/GGP1/adak/pkg/user"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressService(t *testing.T) {
+	logger.Disable()
+	ctx := context.Background()
+	db := test.StartPostgres(t)
+	mc := test.StartMemcached(t)
+	s := address.NewService(db)
+
+	userID := uuid.NewString()
+	err := user.NewService(db, mc).Create(ctx, user.AddUser{ID: userID, CartID: "test", Email: "test", Username: "test", Password: "test"})
+	assert.NoError(t, err)
+
+	home := address.UserAddress{
+		ID:              uuid.NewString(),
+		UserID:          userID,
+		Name:            "Home",
+		Address:         address.Address{Country: "New Zealand", City: "Auckland", ZipCode: "1023", Street: "8 Hopetoun St"},
+		DefaultShipping: true,
+		DefaultBilling:  true,
+	}
+	work := address.UserAddress{
+		ID:      uuid.NewString(),
+		UserID:  userID,
+		Name:    "Work",
+		Address: address.Address{Country: "New Zealand", City: "Wellington", ZipCode: "6011", Street: "101 Lambton Quay"},
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		assert.NoError(t, s.Create(ctx, home))
+		assert.NoError(t, s.Create(ctx, work))
+
+		addresses, err := s.Get(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(addresses))
+		assert.Equal(t, home.ID, addresses[0].ID)
+	})
+
+	t.Run("Update defaults", func(t *testing.T) {
+		work.DefaultShipping = true
+		assert.NoError(t, s.Update(ctx, work))
+
+		got, err := s.GetByID(ctx, userID, home.ID)
+		assert.NoError(t, err)
+		assert.False(t, got.DefaultShipping)
+		assert.True(t, got.DefaultBilling)
+
+		got, err = s.GetByID(ctx, userID, work.ID)
+		assert.NoError(t, err)
+		assert.True(t, got.DefaultShipping)
+	})
+
+	t.Run("Other users", func(t *testing.T) {
+		_, err := s.GetByID(ctx, uuid.NewString(), home.ID)
+		assert.Equal(t, sql.ErrNoRows, errors.Cause(err))
+
+		other := work
+		other.UserID = uuid.NewString()
+		assert.Equal(t, sql.ErrNoRows, errors.Cause(s.Update(ctx, other)))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, s.Delete(ctx, userID, home.ID))
+
+		addresses, err := s.Get(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(addresses))
+	})
+}
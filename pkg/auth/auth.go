@@ -3,12 +3,17 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"encoding/hex"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/GGP1/adak/internal/config"
 	"github.com/GGP1/adak/internal/cookie"
 	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/principal"
+	"github.com/GGP1/adak/pkg/auth/oidc"
+	"github.com/GGP1/adak/pkg/shopping/cart"
 	"github.com/GGP1/adak/pkg/tracking"
 
 	"github.com/go-redis/redis/v8"
@@ -21,42 +26,47 @@ import (
 type Session interface {
 	AlreadyLoggedIn(ctx context.Context, r *http.Request) bool
 	Login(ctx context.Context, w http.ResponseWriter, r *http.Request, email, password string) error
-	LoginOAuth(ctx context.Context, w http.ResponseWriter, r *http.Request, email string) error
-	Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	LoginOIDC(ctx context.Context, w http.ResponseWriter, r *http.Request, identity oidc.Identity, register Registerer) error
+	LoginTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request, code string) error
+	Logout(ctx context.Context, w http.ResponseWriter) error
+	Principal(ctx context.Context, r *http.Request) (principal.Principal, error)
+	Revoke(ctx context.Context, id string) error
+	RevokeAll(ctx context.Context, userID string) error
+	RevokeOthers(ctx context.Context) error
+	Sessions(ctx context.Context) ([]Info, error)
+	DisableTwoFactor(ctx context.Context, code string) error
+	EnrollTwoFactor(ctx context.Context) (Enrollment, error)
+	VerifyTwoFactor(ctx context.Context, code string) ([]string, error)
 }
 
 type session struct {
 	conf    config.Session
 	db      *sqlx.DB
 	dev     bool
+	guests  cart.GuestService
 	metrics metrics
 	rdb     *redis.Client
+	ttl     time.Duration
 }
 
 // NewSession creates a new session with the necessary dependencies.
-func NewSession(db *sqlx.DB, rdb *redis.Client, config config.Session, development bool) Session {
+func NewSession(db *sqlx.DB, rdb *redis.Client, guests cart.GuestService, config config.Session, development bool) Session {
 	return &session{
 		conf:    config,
 		db:      db,
 		dev:     development,
+		guests:  guests,
 		metrics: initMetrics(),
 		rdb:     rdb,
+		ttl:     time.Duration(config.TTL) * time.Hour,
 	}
 }
 
-// AlreadyLoggedIn returns if the user is logged in or not.
+// AlreadyLoggedIn returns if the user is logged in or not, the session expiration is renewed
+// on each call.
 func (s *session) AlreadyLoggedIn(ctx context.Context, r *http.Request) bool {
-	sID, err := cookie.GetValue(r, "SID")
-	if err != nil {
-		return false
-	}
-
-	value, err := s.rdb.Get(ctx, sID).Result()
-	if err != nil {
-		return false
-	}
-
-	return value == sID[len(sID)-16:]
+	_, err := s.Principal(ctx, r)
+	return err == nil
 }
 
 // Login attempts to log a user in.
@@ -71,12 +81,7 @@ func (s *session) Login(ctx context.Context, w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	query := "SELECT id, cart_id, username, email, password, verified_email FROM users WHERE email=$1"
-	row := s.db.QueryRowContext(ctx, query, email)
-
-	var user User
-	err := row.Scan(&user.ID, &user.CartID, &user.Username,
-		&user.Email, &user.Password, &user.VerifiedEmail)
+	user, err := s.getUser(ctx, "email=$1", email)
 	if err != nil {
 		logger.Debug(err)
 		if err := s.addDelay(ctx, ip); err != nil {
@@ -97,43 +102,172 @@ func (s *session) Login(ctx context.Context, w http.ResponseWriter, r *http.Requ
 		return errors.New("invalid email or password")
 	}
 
-	return s.storeSession(ctx, w, user.ID, user.CartID)
+	return s.login(ctx, w, r, user)
+}
+
+// Logout removes the user session and its cookies.
+func (s *session) Logout(ctx context.Context, w http.ResponseWriter) error {
+	p, ok := principal.FromContext(ctx)
+	if !ok {
+		return principal.ErrUnauthenticated
+	}
+	if err := s.delete(ctx, p.UserID, p.SessionID); err != nil {
+		return err
+	}
+	cookie.Delete(w, "SID", "/")
+	// Cookies set by previous versions, identity is no longer taken from them
+	cookie.Delete(w, "UID", "/")
+	cookie.Delete(w, "CID", "/")
+	return nil
 }
 
-// LoginOAuth authenticates users using OAuth2.
-func (s *session) LoginOAuth(ctx context.Context, w http.ResponseWriter, r *http.Request, email string) error {
-	query := "SELECT id, cart_id, username, email, password, verified_email FROM users WHERE email=$1"
-	row := s.db.QueryRowContext(ctx, query, email)
+// Principal resolves the user that owns the session of the request token, the session
+// expiration is renewed on each call.
+func (s *session) Principal(ctx context.Context, r *http.Request) (principal.Principal, error) {
+	token, err := cookie.GetValue(r, "SID")
+	if err != nil {
+		return principal.Principal{}, principal.ErrUnauthenticated
+	}
 
-	var user User
-	err := row.Scan(&user.ID, &user.CartID, &user.Username,
-		&user.Email, &user.Password, &user.VerifiedEmail)
+	id := sessionID(token)
+	key := sessionKey(id)
+	values, err := s.rdb.HMGet(ctx, key, fieldUserID, fieldCartID, fieldAdmin).Result()
 	if err != nil {
+		return principal.Principal{}, errors.Wrap(err, "fetching the session")
+	}
+
+	userID, _ := values[0].(string)
+	if userID == "" {
+		return principal.Principal{}, principal.ErrUnauthenticated
+	}
+	cartID, _ := values[1].(string)
+	admin, _ := values[2].(string)
+
+	pipe := s.rdb.Pipeline()
+	pipe.HSet(ctx, key, fieldLastSeen, time.Now().Unix())
+	s.renew(ctx, pipe, key, userSessionsKey(userID))
+	if _, err := pipe.Exec(ctx); err != nil {
 		logger.Debug(err)
-		return errors.New("invalid email or password")
 	}
 
-	if !user.VerifiedEmail && !s.dev {
-		return errors.New("please verify your email before logging in")
+	return principal.Principal{
+		UserID:    userID,
+		CartID:    cartID,
+		Admin:     admin == "1",
+		SessionID: id,
+	}, nil
+}
+
+// Revoke deletes one of the sessions of the user making the request.
+func (s *session) Revoke(ctx context.Context, id string) error {
+	userID, err := principal.UserID(ctx)
+	if err != nil {
+		return err
 	}
 
-	return s.storeSession(ctx, w, user.ID, user.CartID)
+	owner, err := s.rdb.HGet(ctx, sessionKey(id), fieldUserID).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return ErrSessionNotFound
+		}
+		return errors.Wrap(err, "fetching the session")
+	}
+	if owner != userID {
+		return ErrSessionNotFound
+	}
+
+	return s.delete(ctx, userID, id)
 }
 
-// Logout removes the user session and its cookies.
-func (s *session) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	// The error is already checked by AlreadyLoggedIn
-	sID, _ := cookie.GetValue(r, "SID")
-	if err := s.rdb.Del(ctx, sID).Err(); err != nil {
-		return errors.Wrap(err, "deleting the session")
+// RevokeAll deletes all the sessions of the user.
+func (s *session) RevokeAll(ctx context.Context, userID string) error {
+	ids, err := s.rdb.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return errors.Wrap(err, "fetching the user sessions")
+	}
+
+	for _, id := range ids {
+		if err := s.delete(ctx, userID, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevokeOthers deletes all the sessions of the user making the request except the one used.
+func (s *session) RevokeOthers(ctx context.Context) error {
+	p, ok := principal.FromContext(ctx)
+	if !ok {
+		return principal.ErrUnauthenticated
+	}
+
+	ids, err := s.rdb.SMembers(ctx, userSessionsKey(p.UserID)).Result()
+	if err != nil {
+		return errors.Wrap(err, "fetching the user sessions")
+	}
+
+	for _, id := range ids {
+		if id == p.SessionID {
+			continue
+		}
+		if err := s.delete(ctx, p.UserID, id); err != nil {
+			return err
+		}
 	}
-	cookie.Delete(w, "SID")
-	cookie.Delete(w, "UID")
-	cookie.Delete(w, "CID")
-	s.metrics.activeSessions.Dec()
+
 	return nil
 }
 
+// Sessions returns the active sessions of the user making the request, the most recently used first.
+func (s *session) Sessions(ctx context.Context) ([]Info, error) {
+	p, ok := principal.FromContext(ctx)
+	if !ok {
+		return nil, principal.ErrUnauthenticated
+	}
+	userID := p.UserID
+
+	indexKey := userSessionsKey(userID)
+	ids, err := s.rdb.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching the user sessions")
+	}
+
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, sessionKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, errors.Wrap(err, "fetching the sessions")
+	}
+
+	sessions := make([]Info, 0, len(ids))
+	var expired []interface{}
+	for i, cmd := range cmds {
+		values := cmd.Val()
+		if values[fieldUserID] != userID {
+			expired = append(expired, ids[i])
+			continue
+		}
+		info := newInfo(ids[i], values)
+		info.Current = ids[i] == p.SessionID
+		sessions = append(sessions, info)
+	}
+
+	// Expired sessions are removed from the index lazily
+	if len(expired) > 0 {
+		if err := s.rdb.SRem(ctx, indexKey, expired...).Err(); err != nil {
+			logger.Debug(err)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
 func (s *session) addDelay(ctx context.Context, key string) error {
 	if s.conf.Delay == 0 {
 		return nil
@@ -147,30 +281,115 @@ func (s *session) addDelay(ctx context.Context, key string) error {
 	return nil
 }
 
-// storeSession saves the user key and sets the cookies used to authentication.
-func (s *session) storeSession(ctx context.Context, w http.ResponseWriter, userID, cartID string) error {
-	// The salt that will be used to identify the user's session
-	salt := make([]byte, 16)
-	if _, err := rand.Read(salt); err != nil {
-		return errors.Wrap(err, "generating salt")
-	}
+// getUser returns the user matching the condition provided.
+func (s *session) getUser(ctx context.Context, where string, arg interface{}) (User, error) {
+	query := `SELECT id, cart_id, username, email, password, verified_email, is_admin,
+	COALESCE(totp_secret, ''), totp_enabled FROM users WHERE ` + where
+	row := s.db.QueryRowContext(ctx, query, arg)
 
-	sID := userID + ":" + string(salt)
+	var user User
+	err := row.Scan(&user.ID, &user.CartID, &user.Username, &user.Email, &user.Password,
+		&user.VerifiedEmail, &user.IsAdmin, &user.TOTPSecret, &user.TOTPEnabled)
+	return user, err
+}
 
-	// Store the salt as the value
-	if err := s.rdb.Set(ctx, sID, salt, 0).Err(); err != nil {
-		return errors.Wrap(err, "saving session")
+// login stores the user session, or the pending login when the user has two-factor authentication enabled.
+func (s *session) login(ctx context.Context, w http.ResponseWriter, r *http.Request, user User) error {
+	if user.TOTPEnabled {
+		if err := s.storePending(ctx, w, user.ID); err != nil {
+			return err
+		}
+		return ErrTwoFactorRequired
 	}
-	// -SID- session id
-	if err := cookie.Set(w, "SID", sID, "/", s.conf.Length); err != nil {
+
+	if err := s.storeSession(ctx, w, r, user); err != nil {
 		return err
 	}
-	// -UID- user id, used to deny users from making requests to other accounts
-	if err := cookie.Set(w, "UID", userID, "/", s.conf.Length); err != nil {
-		return err
+
+	s.mergeGuestCart(ctx, w, r, user.CartID)
+	return nil
+}
+
+// mergeGuestCart moves the products of the visitor's guest cart into the user cart.
+//
+// A failure doesn't prevent the user from logging in, the guest cart is kept to merge it in the next login.
+func (s *session) mergeGuestCart(ctx context.Context, w http.ResponseWriter, r *http.Request, cartID string) {
+	guestID, err := cookie.GetValue(r, "GCID")
+	if err != nil {
+		return
 	}
-	// -CID- cart id, used to identify which cart belongs to each user
-	if err := cookie.Set(w, "CID", cartID, "/", s.conf.Length); err != nil {
+
+	if err := s.guests.Merge(ctx, guestID, cartID); err != nil {
+		logger.Errorf("failed merging guest cart: %v", err)
+		return
+	}
+	cookie.Delete(w, "GCID", "/")
+}
+
+// delete removes the session and its reference from the user index.
+func (s *session) delete(ctx context.Context, userID, id string) error {
+	pipe := s.rdb.TxPipeline()
+	del := pipe.Del(ctx, sessionKey(id))
+	pipe.SRem(ctx, userSessionsKey(userID), id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrap(err, "deleting the session")
+	}
+
+	if del.Val() > 0 {
+		s.metrics.activeSessions.Dec()
+	}
+	return nil
+}
+
+// renew extends the expiration of the keys, nothing is done if sessions don't expire.
+func (s *session) renew(ctx context.Context, pipe redis.Pipeliner, keys ...string) {
+	if s.ttl == 0 {
+		return
+	}
+	for _, key := range keys {
+		pipe.Expire(ctx, key, s.ttl)
+	}
+}
+
+// storeSession saves the session along with the user identity and the device metadata and sets
+// the cookie used to authentication.
+//
+// The identity is copied at login, changes to it apply to the sessions created afterwards.
+func (s *session) storeSession(ctx context.Context, w http.ResponseWriter, r *http.Request, user User) error {
+	// The token identifies the user's session, only its hash is stored
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return errors.Wrap(err, "generating token")
+	}
+	token := hex.EncodeToString(b)
+	id := sessionID(token)
+
+	now := time.Now().Unix()
+	admin := "0"
+	// Administrators may be required to use a second factor before their privileges apply
+	if user.IsAdmin && (user.TOTPEnabled || !s.conf.AdminTwoFactor) {
+		admin = "1"
+	}
+	key, indexKey := sessionKey(id), userSessionsKey(user.ID)
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, key, map[string]interface{}{
+		fieldUserID:    user.ID,
+		fieldCartID:    user.CartID,
+		fieldAdmin:     admin,
+		fieldUserAgent: r.UserAgent(),
+		fieldIP:        tracking.GetUserIP(r),
+		fieldCreatedAt: now,
+		fieldLastSeen:  now,
+	})
+	pipe.SAdd(ctx, indexKey, id)
+	// The index lives as long as the most recently used session
+	s.renew(ctx, pipe, key, indexKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrap(err, "saving session")
+	}
+
+	// -SID- session token, the user identity is resolved from it on each request
+	if err := cookie.Set(w, "SID", token, "/", s.conf.Length); err != nil {
 		return err
 	}
 
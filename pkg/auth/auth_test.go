@@ -6,11 +6,17 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/GGP1/adak/internal/config"
 	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/principal"
 	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/internal/totp"
 	"github.com/GGP1/adak/pkg/auth"
+	"github.com/GGP1/adak/pkg/auth/oidc"
+	"github.com/GGP1/adak/pkg/shopping/cart"
+	"github.com/GGP1/adak/pkg/user"
 	"github.com/go-redis/redis/v8"
 	"github.com/jmoiron/sqlx"
 	"golang.org/x/crypto/bcrypt"
@@ -42,7 +48,7 @@ func TestMain(m *testing.M) {
 	db = sqlxDB
 	rdb = redisDB
 
-	session = auth.NewSession(db, rdb, config, true)
+	session = auth.NewSession(db, rdb, nil, config, true)
 	if err := createUser(context.Background()); err != nil {
 		logger.Fatal(err)
 	}
@@ -62,14 +68,9 @@ func TestMain(m *testing.M) {
 func TestAlreadyLoggedIn(t *testing.T) {
 	t.Run("True", func(t *testing.T) {
 		ctx := context.Background()
-		salt := "0123456789111213"
-		sID := "1:" + salt
-		err := rdb.Set(ctx, sID, salt, 0).Err()
-		assert.NoError(t, err)
+		req := login(t)
 
-		r := httptest.NewRequest(http.MethodGet, "/", nil)
-		test.AddCookie(t, r, "SID", sID)
-		got := session.AlreadyLoggedIn(ctx, r)
+		got := session.AlreadyLoggedIn(ctx, req)
 		assert.Equal(t, true, got)
 	})
 
@@ -78,6 +79,94 @@ func TestAlreadyLoggedIn(t *testing.T) {
 		got := session.AlreadyLoggedIn(context.Background(), r)
 		assert.Equal(t, false, got)
 	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: "SID", Value: "unknown"})
+		got := session.AlreadyLoggedIn(context.Background(), r)
+		assert.Equal(t, false, got)
+	})
+}
+
+func TestPrincipal(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Session", func(t *testing.T) {
+		p, err := session.Principal(ctx, login(t))
+		assert.NoError(t, err)
+
+		assert.Equal(t, "1", p.UserID)
+		assert.Equal(t, "2", p.CartID)
+		assert.Equal(t, false, p.Admin)
+		assert.NotEmpty(t, p.SessionID)
+	})
+
+	t.Run("Forged cookies", func(t *testing.T) {
+		r := login(t)
+		r.AddCookie(&http.Cookie{Name: "UID", Value: "3"})
+		r.AddCookie(&http.Cookie{Name: "CID", Value: "4"})
+
+		p, err := session.Principal(ctx, r)
+		assert.NoError(t, err)
+		assert.Equal(t, "1", p.UserID)
+		assert.Equal(t, "2", p.CartID)
+	})
+
+	t.Run("No session", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		_, err := session.Principal(ctx, r)
+		assert.Equal(t, principal.ErrUnauthenticated, err)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	current := authenticate(t, login(t))
+	other := login(t)
+
+	sessions, err := session.Sessions(current)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(sessions), 2)
+
+	var currentID, otherID string
+	for _, s := range sessions {
+		if s.Current {
+			currentID = s.ID
+		} else {
+			otherID = s.ID
+		}
+		assert.Equal(t, "adak-test", s.UserAgent)
+	}
+	assert.NotEmpty(t, currentID)
+
+	t.Run("Revoke", func(t *testing.T) {
+		assert.Equal(t, auth.ErrSessionNotFound, session.Revoke(current, "unknown"))
+		assert.NoError(t, session.Revoke(current, otherID))
+
+		sessions, err := session.Sessions(current)
+		assert.NoError(t, err)
+		for _, s := range sessions {
+			assert.NotEqual(t, otherID, s.ID)
+		}
+	})
+
+	t.Run("Revoke others", func(t *testing.T) {
+		assert.NoError(t, session.RevokeOthers(current))
+		assert.Equal(t, false, session.AlreadyLoggedIn(current, other))
+
+		sessions, err := session.Sessions(current)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(sessions))
+		assert.Equal(t, currentID, sessions[0].ID)
+	})
+}
+
+func TestRevokeAll(t *testing.T) {
+	ctx := context.Background()
+	first, second := login(t), login(t)
+
+	assert.NoError(t, session.RevokeAll(ctx, "1"))
+	assert.Equal(t, false, session.AlreadyLoggedIn(ctx, first))
+	assert.Equal(t, false, session.AlreadyLoggedIn(ctx, second))
 }
 
 func TestLogin(t *testing.T) {
@@ -89,34 +178,127 @@ func TestLogin(t *testing.T) {
 		assert.NoError(t, err)
 
 		cookies := rec.Result().Cookies()
-		assert.Equal(t, 3, len(cookies))
+		assert.Equal(t, 1, len(cookies))
 		assert.Equal(t, "SID", cookies[0].Name)
-		assert.Equal(t, "UID", cookies[1].Name)
-		assert.Equal(t, "CID", cookies[2].Name)
 	})
 
-	t.Run("OAuth", func(t *testing.T) {
+	t.Run("OpenID Connect", func(t *testing.T) {
 		rec := httptest.NewRecorder()
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
 
-		err := session.LoginOAuth(context.Background(), rec, req, email)
+		identity := oidc.Identity{Provider: "test", Subject: "login", Email: email, EmailVerified: true}
+		err := session.LoginOIDC(context.Background(), rec, req, identity, nil)
 		assert.NoError(t, err)
 
 		cookies := rec.Result().Cookies()
-		assert.Equal(t, 3, len(cookies))
+		assert.Equal(t, 1, len(cookies))
 		assert.Equal(t, "SID", cookies[0].Name)
-		assert.Equal(t, "UID", cookies[1].Name)
-		assert.Equal(t, "CID", cookies[2].Name)
 	})
 }
 
-func TestLogout(t *testing.T) {
+func TestLoginOIDC(t *testing.T) {
 	ctx := context.Background()
+	issuer := test.NewOIDCIssuer(t)
+	issuer.Email = "test_auth_oidc@test.com"
+	providers := oidc.NewRegistry(config.OIDC{
+		RedirectURL: "http://localhost:4000/login/oidc",
+		Providers: map[string]config.OIDCProvider{
+			"fake": {
+				Issuer:       issuer.URL,
+				ClientID:     issuer.ClientID,
+				ClientSecret: issuer.ClientSecret,
+			},
+		},
+	}, rdb)
+	register := user.Registerer(user.NewService(db, nil), cart.NewService(db, nil, nil, nil, nil))
+
+	loginOIDC := func(t *testing.T) (string, error) {
+		authURL, state, err := providers.AuthCodeURL(ctx, "fake")
+		assert.NoError(t, err)
+		callback := issuer.Authorize(t, authURL)
+
+		identity, err := providers.Exchange(ctx, "fake", state, callback.Query().Get("code"))
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if err := session.LoginOIDC(ctx, rec, req, identity, register); err != nil {
+			return "", err
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, c := range rec.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		userID, err := principal.UserID(authenticate(t, r))
+		assert.NoError(t, err)
+		return userID, nil
+	}
+
+	t.Run("Provision", func(t *testing.T) {
+		userID, err := loginOIDC(t)
+		assert.NoError(t, err)
+
+		var username, cartID string
+		err = db.QueryRowContext(ctx, "SELECT username, cart_id FROM users WHERE id=$1 AND email=$2",
+			userID, issuer.Email).Scan(&username, &cartID)
+		assert.NoError(t, err)
+		assert.Equal(t, "test_auth_oidc", username)
+
+		var exists bool
+		err = db.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM carts WHERE id=$1)", cartID)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		// The identity is linked, the account is not created again
+		again, err := loginOIDC(t)
+		assert.NoError(t, err)
+		assert.Equal(t, userID, again)
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		var expected string
+		err := db.GetContext(ctx, &expected, "SELECT id FROM users WHERE email=$1", email)
+		assert.NoError(t, err)
+
+		issuer.Subject, issuer.Email = "link", email
+		userID, err := loginOIDC(t)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, userID)
+	})
+
+	t.Run("Unverified account", func(t *testing.T) {
+		const unverified = "test_auth_unverified@test.com"
+		q := `INSERT INTO users (id, cart_id, username, email, password, verified_email)
+		VALUES ('unverified', 'unverified', 'unverified', $1, 'password', false)`
+		_, err := db.ExecContext(ctx, q, unverified)
+		assert.NoError(t, err)
+
+		issuer.Subject, issuer.Email = "unverified_account", unverified
+		_, err = loginOIDC(t)
+		assert.Error(t, err)
+
+		var linked bool
+		q = "SELECT EXISTS(SELECT 1 FROM user_identities WHERE user_id='unverified')"
+		assert.NoError(t, db.GetContext(ctx, &linked, q))
+		assert.False(t, linked)
+	})
+
+	t.Run("Unverified email", func(t *testing.T) {
+		issuer.Subject, issuer.EmailVerified = "unverified", false
+		_, err := loginOIDC(t)
+		assert.Error(t, err)
+	})
+}
+
+func TestLogout(t *testing.T) {
+	req := login(t)
+	ctx := authenticate(t, req)
 
 	rec := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	err := session.Logout(ctx, rec, req)
+	err := session.Logout(ctx, rec)
 	assert.NoError(t, err)
+	assert.Equal(t, false, session.AlreadyLoggedIn(context.Background(), req))
 
 	cookies := rec.Result().Cookies()
 	// They are not deleted by the recorder
@@ -126,6 +308,104 @@ func TestLogout(t *testing.T) {
 	assert.Equal(t, "", cookies[2].Value)
 }
 
+func TestTwoFactor(t *testing.T) {
+	ctx := authenticate(t, login(t))
+
+	enrollment, err := session.EnrollTwoFactor(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, enrollment.URI, enrollment.Secret)
+
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	assert.NoError(t, err)
+
+	_, err = session.VerifyTwoFactor(ctx, "000000")
+	assert.Equal(t, auth.ErrInvalidCode, err)
+
+	recoveryCodes, err := session.VerifyTwoFactor(ctx, code)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(recoveryCodes))
+
+	t.Run("Pending login", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		err := session.Login(context.Background(), rec, req, email, "password")
+		assert.Equal(t, auth.ErrTwoFactorRequired, err)
+
+		cookies := rec.Result().Cookies()
+		assert.Equal(t, 1, len(cookies))
+		assert.Equal(t, "2FA", cookies[0].Name)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(cookies[0])
+
+		// The code was already used to verify the enrollment
+		err = session.LoginTwoFactor(context.Background(), httptest.NewRecorder(), r, code)
+		assert.Equal(t, auth.ErrInvalidCode, err)
+
+		rec = httptest.NewRecorder()
+		err = session.LoginTwoFactor(context.Background(), rec, r, recoveryCodes[0])
+		assert.NoError(t, err)
+		assert.Equal(t, "SID", rec.Result().Cookies()[1].Name)
+	})
+
+	t.Run("Expired pending login", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		err := session.Login(context.Background(), rec, req, email, "password")
+		assert.Equal(t, auth.ErrTwoFactorRequired, err)
+
+		keys, err := rdb.Keys(context.Background(), "2fa:*").Result()
+		assert.NoError(t, err)
+		assert.NoError(t, rdb.Del(context.Background(), keys...).Err())
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(rec.Result().Cookies()[0])
+		err = session.LoginTwoFactor(context.Background(), httptest.NewRecorder(), r, code)
+		assert.Error(t, err)
+
+		// The attempt must not leave a pending login without expiration
+		keys, err = rdb.Keys(context.Background(), "2fa:*").Result()
+		assert.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("Recovery codes are used once", func(t *testing.T) {
+		err := session.DisableTwoFactor(ctx, recoveryCodes[0])
+		assert.Equal(t, auth.ErrInvalidCode, err)
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		err := session.DisableTwoFactor(ctx, recoveryCodes[1])
+		assert.NoError(t, err)
+
+		_, err = session.VerifyTwoFactor(ctx, code)
+		assert.Error(t, err)
+	})
+}
+
+// login creates a session and returns a request carrying its cookies.
+func login(t *testing.T) *http.Request {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", "adak-test")
+	assert.NoError(t, session.Login(context.Background(), rec, req, email, "password"))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+// authenticate returns a context carrying the principal of the request session.
+func authenticate(t *testing.T, r *http.Request) context.Context {
+	t.Helper()
+	p, err := session.Principal(context.Background(), r)
+	assert.NoError(t, err)
+	return principal.NewContext(context.Background(), p)
+}
+
 func createUser(ctx context.Context) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
 	if err != nil {
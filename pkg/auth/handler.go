@@ -3,35 +3,17 @@ package auth
 import (
 	"encoding/json"
 	"net/http"
-	"os"
 
+	"github.com/GGP1/adak/internal/cookie"
 	"github.com/GGP1/adak/internal/response"
 	"github.com/GGP1/adak/internal/sanitize"
-	"github.com/GGP1/adak/internal/token"
 	"github.com/GGP1/adak/internal/validate"
+	"github.com/GGP1/adak/pkg/auth/oidc"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/pkg/errors"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
-var (
-	googleState  = token.RandString(20)
-	googleConfig = &oauth2.Config{
-		RedirectURL: "http://localhost:4000/login/oauth2/google",
-		Scopes:      []string{"https://www.googleapis.com/auth/userinfo.email"},
-		Endpoint:    google.Endpoint,
-	}
-)
-
-// There is no need to validate this data as it comes directly from Google.
-type oauthRes struct {
-	ID            string `json:"id"`
-	Email         string `json:"email"`
-	VerifiedEmail bool   `json:"verified_email"`
-	Picture       string `json:"picture"`
-}
-
 // BasicAuth provides basic authentication.
 func BasicAuth(s Session) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -49,7 +31,7 @@ func BasicAuth(s Session) http.HandlerFunc {
 		}
 
 		if err := s.Login(ctx, w, r, username, password); err != nil {
-			response.Error(w, http.StatusForbidden, err)
+			loginError(w, err)
 			return
 		}
 
@@ -83,6 +65,32 @@ func Login(s Session) http.HandlerFunc {
 		auth.Password = sanitize.Normalize(auth.Password)
 
 		if err := s.Login(ctx, w, r, auth.Email, auth.Password); err != nil {
+			loginError(w, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, "logged in")
+	}
+}
+
+// LoginTwoFactor completes the login of users with two-factor authentication enabled.
+func LoginTwoFactor(s Session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var code TwoFactorCode
+		if err := json.NewDecoder(r.Body).Decode(&code); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, code); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.LoginTwoFactor(ctx, w, r, code.Code); err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
 		}
@@ -95,7 +103,7 @@ func Login(s Session) http.HandlerFunc {
 func Logout(s Session) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Logout user from the session and delete cookies
-		if err := s.Logout(r.Context(), w, r); err != nil {
+		if err := s.Logout(r.Context(), w); err != nil {
 			response.Error(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -104,63 +112,189 @@ func Logout(s Session) http.HandlerFunc {
 	}
 }
 
-// LoginGoogle redirects the user to the google oauth2.
-func LoginGoogle(s Session) http.HandlerFunc {
+// RevokeSession logs out one of the user sessions.
+func RevokeSession(s Session) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if s.AlreadyLoggedIn(r.Context(), r) {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+		id := chi.URLParam(r, "id")
+		if err := s.Revoke(r.Context(), id); err != nil {
+			if err == ErrSessionNotFound {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
 			return
 		}
 
-		googleConfig.ClientID = os.Getenv("GOOGLE_CLIENT_ID")
-		googleConfig.ClientSecret = os.Getenv("GOOGLE_CLIENT_SECRET")
-		url := googleConfig.AuthCodeURL(googleState)
+		response.JSONText(w, http.StatusOK, id)
+	}
+}
 
-		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+// Sessions lists the user active sessions.
+func Sessions(s Session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessions, err := s.Sessions(r.Context())
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, sessions)
 	}
 }
 
-// OAuth2Google executes the oauth2 login with Google.
-func OAuth2Google(s Session) http.HandlerFunc {
+// DisableTwoFactor turns off the user two-factor authentication.
+func DisableTwoFactor(s Session) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var oauth oauthRes
 		ctx := r.Context()
 
-		res, err := userInfoGoogle(r.FormValue("state"), r.FormValue("code"))
+		var code TwoFactorCode
+		if err := json.NewDecoder(r.Body).Decode(&code); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, code); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.DisableTwoFactor(ctx, code.Code); err != nil {
+			response.Error(w, twoFactorStatus(err), err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, "two-factor authentication disabled")
+	}
+}
+
+// EnrollTwoFactor returns a new secret to register in an authenticator application.
+func EnrollTwoFactor(s Session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enrollment, err := s.EnrollTwoFactor(r.Context())
 		if err != nil {
+			response.Error(w, twoFactorStatus(err), err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, enrollment)
+	}
+}
+
+// VerifyTwoFactor enables two-factor authentication and returns the recovery codes.
+func VerifyTwoFactor(s Session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var code TwoFactorCode
+		if err := json.NewDecoder(r.Body).Decode(&code); err != nil {
 			response.Error(w, http.StatusBadRequest, err)
 			return
 		}
+		defer r.Body.Close()
 
-		if err := json.NewDecoder(res.Body).Decode(&oauth); err != nil {
-			response.Error(w, http.StatusInternalServerError, err)
+		if err := validate.Struct(ctx, code); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
 			return
 		}
-		defer res.Body.Close()
 
-		if err := s.LoginOAuth(ctx, w, r, oauth.Email); err != nil {
-			response.Error(w, http.StatusForbidden, err)
+		codes, err := s.VerifyTwoFactor(ctx, code.Code)
+		if err != nil {
+			response.Error(w, twoFactorStatus(err), err)
 			return
 		}
 
-		response.JSONText(w, http.StatusOK, "logged in")
+		response.JSON(w, http.StatusOK, codes)
 	}
 }
 
-func userInfoGoogle(state, code string) (*http.Response, error) {
-	if state != googleState {
-		return nil, errors.New("invalid OAuth state")
+// LoginOIDC redirects the user to the OpenID Connect provider of the URL.
+func LoginOIDC(s Session, providers *oidc.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if s.AlreadyLoggedIn(ctx, r) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		url, state, err := providers.AuthCodeURL(ctx, chi.URLParam(r, "provider"))
+		if err != nil {
+			if err == oidc.ErrUnknownProvider {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// -OIDC- login state, ties the provider callback to the browser that started the login.
+		// A strict cookie wouldn't be sent in the redirection from the provider
+		if err := cookie.SetLax(w, "OIDC", state, "/", int(oidc.StateTTL.Seconds())); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 	}
+}
+
+// OIDCCallback completes the login with the OpenID Connect provider of the URL, the users
+// logging in for the first time are registered with the function given.
+func OIDCCallback(s Session, providers *oidc.Registry, register Registerer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if errCode := r.FormValue("error"); errCode != "" {
+			response.Error(w, http.StatusBadRequest, errors.Errorf("login failed: %s", errCode))
+			return
+		}
+
+		state := r.FormValue("state")
+		expected, err := cookie.GetValue(r, "OIDC")
+		if err != nil || state != expected {
+			response.Error(w, http.StatusBadRequest, oidc.ErrInvalidState)
+			return
+		}
+		cookie.Delete(w, "OIDC", "/")
 
-	token, err := googleConfig.Exchange(oauth2.NoContext, code)
-	if err != nil {
-		return nil, errors.Wrap(err, "code exchange failed")
+		identity, err := providers.Exchange(ctx, chi.URLParam(r, "provider"), state, r.FormValue("code"))
+		if err != nil {
+			switch err {
+			case oidc.ErrUnknownProvider:
+				response.Error(w, http.StatusNotFound, err)
+			case oidc.ErrInvalidState:
+				response.Error(w, http.StatusBadRequest, err)
+			default:
+				response.Error(w, http.StatusForbidden, err)
+			}
+			return
+		}
+
+		if err := s.LoginOIDC(ctx, w, r, identity, register); err != nil {
+			loginError(w, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, "logged in")
 	}
+}
 
-	res, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + token.AccessToken)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed getting user info")
+// loginError responds with the error of a login attempt, those requiring a second factor are
+// reported as accepted.
+func loginError(w http.ResponseWriter, err error) {
+	if err == ErrTwoFactorRequired {
+		response.JSONText(w, http.StatusAccepted, err.Error())
+		return
 	}
+	response.Error(w, http.StatusForbidden, err)
+}
 
-	return res, nil
+func twoFactorStatus(err error) int {
+	switch err {
+	case ErrInvalidCode, errTwoFactorOn, errTwoFactorOff, errNotEnrolled:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
 }
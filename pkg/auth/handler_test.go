@@ -6,6 +6,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/GGP1/adak/internal/config"
+	"github.com/GGP1/adak/internal/principal"
+	"github.com/GGP1/adak/pkg/auth/oidc"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type mockSession struct{}
@@ -16,12 +22,47 @@ func (s *mockSession) AlreadyLoggedIn(ctx context.Context, r *http.Request) bool
 func (s *mockSession) Login(ctx context.Context, w http.ResponseWriter, r *http.Request, email, password string) error {
 	return nil
 }
-func (s *mockSession) LoginOAuth(ctx context.Context, w http.ResponseWriter, r *http.Request, email string) error {
+func (s *mockSession) LoginOIDC(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	identity oidc.Identity, register Registerer) error {
+	return nil
+}
+func (s *mockSession) LoginTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request, code string) error {
+	if code != "123456" {
+		return ErrInvalidCode
+	}
 	return nil
 }
-func (s *mockSession) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+func (s *mockSession) Logout(ctx context.Context, w http.ResponseWriter) error {
+	return nil
+}
+func (s *mockSession) Principal(ctx context.Context, r *http.Request) (principal.Principal, error) {
+	return principal.Principal{}, principal.ErrUnauthenticated
+}
+func (s *mockSession) Revoke(ctx context.Context, id string) error {
+	if id != "current" {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+func (s *mockSession) RevokeAll(ctx context.Context, userID string) error {
+	return nil
+}
+func (s *mockSession) RevokeOthers(ctx context.Context) error {
+	return nil
+}
+func (s *mockSession) Sessions(ctx context.Context) ([]Info, error) {
+	return []Info{{ID: "current", Current: true}}, nil
+}
+
+func (s *mockSession) DisableTwoFactor(ctx context.Context, code string) error {
 	return nil
 }
+func (s *mockSession) EnrollTwoFactor(ctx context.Context) (Enrollment, error) {
+	return Enrollment{}, nil
+}
+func (s *mockSession) VerifyTwoFactor(ctx context.Context, code string) ([]string, error) {
+	return nil, nil
+}
 
 func TestLoginHandler(t *testing.T) {
 	// Actually I should use the real session instead
@@ -72,20 +113,64 @@ func TestLogoutHandler(t *testing.T) {
 	}
 }
 
-func TestLoginGoogleHandler(t *testing.T) {
+func TestLoginOIDCHandler(t *testing.T) {
 	var session *mockSession
-	rec := httptest.NewRecorder()
+	providers := oidc.NewRegistry(config.OIDC{}, nil)
+	r := chi.NewRouter()
+	r.Get("/login/oidc/{provider}", LoginOIDC(session, providers))
+	r.Get("/login/oidc/{provider}/callback", OIDCCallback(session, providers, nil))
+
+	cases := map[string]int{
+		"/login/oidc/unknown":                                http.StatusNotFound,
+		"/login/oidc/unknown/callback?state=state&code=code": http.StatusBadRequest,
+		"/login/oidc/unknown/callback?error=access_denied":   http.StatusBadRequest,
+	}
+	for path, expected := range cases {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != expected {
+			t.Errorf("%s: expected %d, got %d", path, expected, rec.Code)
+		}
+	}
+}
 
-	req, err := http.NewRequest("GET", "https://localhost:4000/login/google", nil)
-	if err != nil {
-		t.Fatalf("Failed sending logout request: %v", err)
+func TestRevokeSessionHandler(t *testing.T) {
+	var session *mockSession
+	r := chi.NewRouter()
+	r.Delete("/sessions/{id}", RevokeSession(session))
+
+	cases := map[string]int{
+		"current": http.StatusOK,
+		"unknown": http.StatusNotFound,
+	}
+	for id, expected := range cases {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, "/sessions/"+id, nil)
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != expected {
+			t.Errorf("%s: expected %d, got %d", id, expected, rec.Code)
+		}
 	}
+}
 
-	hFunc := LoginGoogle(session)
-	hFunc.ServeHTTP(rec, req)
+func TestLoginTwoFactorHandler(t *testing.T) {
+	var session *mockSession
 
-	res := rec.Result()
-	if res.StatusCode != http.StatusTemporaryRedirect {
-		t.Errorf("Expected OK, got %s", res.Status)
+	cases := map[string]int{
+		"123456": http.StatusOK,
+		"654321": http.StatusForbidden,
+	}
+	for code, expected := range cases {
+		rec := httptest.NewRecorder()
+		body := bytes.NewBufferString(`{"code": "` + code + `"}`)
+		req := httptest.NewRequest(http.MethodPost, "/login/2fa", body)
+		LoginTwoFactor(session).ServeHTTP(rec, req)
+
+		if rec.Code != expected {
+			t.Errorf("%s: expected %d, got %d", code, expected, rec.Code)
+		}
 	}
 }
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/GGP1/adak/internal/sanitize"
+	"github.com/GGP1/adak/internal/token"
+	"github.com/GGP1/adak/pkg/auth/oidc"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// usernameLength is the maximum length of the usernames generated for provisioned users.
+const usernameLength = 25
+
+var (
+	errEmailNotVerified   = errors.New("the provider didn't verify the account email")
+	errAccountNotVerified = errors.New("there is an account with the email but it wasn't verified, verify it to log in with the provider")
+)
+
+// Registerer creates a user account and its cart, the users logging in with a provider
+// for the first time must be registered the same way as the ones signing up.
+type Registerer func(ctx context.Context, user User) error
+
+// LoginOIDC authenticates users with the identity verified by an OpenID Connect provider.
+//
+// The first time an identity is used it's linked to the verified user with the same email,
+// if there is none a new account is registered.
+func (s *session) LoginOIDC(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	identity oidc.Identity, register Registerer) error {
+	user, err := s.identityUser(ctx, identity, register)
+	if err != nil {
+		return err
+	}
+
+	if !user.VerifiedEmail && !s.dev {
+		return errors.New("please verify your email before logging in")
+	}
+
+	return s.login(ctx, w, r, user)
+}
+
+// identityUser returns the user linked to the identity, linking or provisioning one if it's new.
+func (s *session) identityUser(ctx context.Context, identity oidc.Identity, register Registerer) (User, error) {
+	var userID string
+	q := "SELECT user_id FROM user_identities WHERE provider=$1 AND subject=$2"
+	err := s.db.GetContext(ctx, &userID, q, identity.Provider, identity.Subject)
+	if err == nil {
+		user, err := s.getUser(ctx, "id=$1", userID)
+		if err != nil {
+			return User{}, errors.Wrap(err, "couldn't find the user")
+		}
+		return user, nil
+	}
+	if err != sql.ErrNoRows {
+		return User{}, errors.Wrap(err, "couldn't find the identity")
+	}
+
+	// Otherwise anyone able to register an email in a provider could take over the account using it
+	if identity.Email == "" || !identity.EmailVerified {
+		return User{}, errEmailNotVerified
+	}
+	email := sanitize.Normalize(identity.Email)
+
+	user, err := s.getUser(ctx, "email=$1", email)
+	switch {
+	case err == sql.ErrNoRows:
+		user, err = s.provisionUser(ctx, email, register)
+		if err != nil {
+			return User{}, err
+		}
+	case err != nil:
+		return User{}, errors.Wrap(err, "couldn't find the user")
+	case !user.VerifiedEmail:
+		// Whoever signed up with the email may not own it
+		return User{}, errAccountNotVerified
+	}
+
+	q = "INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)"
+	if _, err := s.db.ExecContext(ctx, q, identity.Provider, identity.Subject, user.ID, email); err != nil {
+		return User{}, errors.Wrap(err, "couldn't link the identity")
+	}
+
+	return user, nil
+}
+
+// provisionUser registers an account for a user authenticated by a provider.
+//
+// The password is random, users can set one by resetting it.
+func (s *session) provisionUser(ctx context.Context, email string, register Registerer) (User, error) {
+	username, err := availableUsername(ctx, s.db, email)
+	if err != nil {
+		return User{}, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return User{}, errors.Wrap(err, "generating password")
+	}
+
+	user := User{
+		ID:            uuid.NewString(),
+		CartID:        uuid.NewString(),
+		Username:      username,
+		Email:         email,
+		Password:      hex.EncodeToString(b),
+		VerifiedEmail: true,
+	}
+	if err := register(ctx, user); err != nil {
+		return User{}, err
+	}
+
+	// Take the fields set by the registration, like the administrator role
+	user, err = s.getUser(ctx, "id=$1", user.ID)
+	if err != nil {
+		return User{}, errors.Wrap(err, "couldn't find the user")
+	}
+
+	return user, nil
+}
+
+// availableUsername derives a username from the email, a random suffix is added if it's taken.
+func availableUsername(ctx context.Context, db *sqlx.DB, email string) (string, error) {
+	username := email
+	if i := strings.IndexByte(email, '@'); i > 0 {
+		username = email[:i]
+	}
+	// Leave room for the suffix
+	if runes := []rune(username); len(runes) > usernameLength-5 {
+		username = string(runes[:usernameLength-5])
+	}
+
+	candidate := username
+	for i := 0; i < 5; i++ {
+		var exists bool
+		q := "SELECT EXISTS(SELECT 1 FROM users WHERE username=$1)"
+		if err := db.GetContext(ctx, &exists, q, candidate); err != nil {
+			return "", errors.Wrap(err, "couldn't check the username")
+		}
+		if !exists {
+			return candidate, nil
+		}
+		candidate = username + "_" + token.RandString(4)
+	}
+
+	return "", errors.New("couldn't find an available username")
+}
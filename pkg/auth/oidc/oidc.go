@@ -0,0 +1,192 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE for the
+// providers set in the configuration.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/GGP1/adak/internal/config"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// StateTTL is the time users have to complete a login with the provider.
+const StateTTL = 10 * time.Minute
+
+// Logins in progress are stored as hashes under "oidc:<state>".
+const (
+	statePrefix    = "oidc:"
+	fieldProvider  = "provider"
+	fieldNonce     = "nonce"
+	fieldVerifier  = "verifier"
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	// ErrUnknownProvider is returned when the provider requested is not configured.
+	ErrUnknownProvider = errors.New("unknown provider")
+	// ErrInvalidState is returned when the login doesn't exist, expired or belongs to another provider.
+	ErrInvalidState = errors.New("invalid or expired login state")
+)
+
+// Identity is the user authenticated by a provider.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Registry contains the providers users can log in with, they are discovered the first time they are used.
+type Registry struct {
+	client *http.Client
+	conf   config.OIDC
+	rdb    *redis.Client
+
+	mu        sync.Mutex
+	providers map[string]*provider
+}
+
+// NewRegistry returns a registry with the providers configured.
+func NewRegistry(conf config.OIDC, rdb *redis.Client) *Registry {
+	return &Registry{
+		client:    &http.Client{Timeout: requestTimeout},
+		conf:      conf,
+		rdb:       rdb,
+		providers: make(map[string]*provider, len(conf.Providers)),
+	}
+}
+
+// AuthCodeURL starts a login with the provider, it returns the URL the user must be redirected to
+// and the state that identifies the login.
+func (r *Registry) AuthCodeURL(ctx context.Context, name string) (string, string, error) {
+	p, err := r.provider(ctx, name)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randString()
+	if err != nil {
+		return "", "", err
+	}
+
+	key := statePrefix + state
+	pipe := r.rdb.TxPipeline()
+	pipe.HSet(ctx, key, fieldProvider, name, fieldNonce, nonce, fieldVerifier, verifier)
+	pipe.Expire(ctx, key, StateTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", "", errors.Wrap(err, "saving login state")
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	url := p.oauth.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+	return url, state, nil
+}
+
+// Exchange completes the login identified by the state using the authorization code, the ID token
+// received is validated and the identity it contains returned.
+//
+// Each state can be used only once.
+func (r *Registry) Exchange(ctx context.Context, name, state, code string) (Identity, error) {
+	p, err := r.provider(ctx, name)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	key := statePrefix + state
+	pipe := r.rdb.TxPipeline()
+	get := pipe.HGetAll(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Identity{}, errors.Wrap(err, "fetching login state")
+	}
+	login := get.Val()
+	if login[fieldProvider] != name {
+		return Identity{}, ErrInvalidState
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, r.client)
+	token, err := p.oauth.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", login[fieldVerifier]))
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "code exchange failed")
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, errors.New("the provider didn't return an ID token")
+	}
+
+	claims, err := p.verify(ctx, rawIDToken, login[fieldNonce], time.Now())
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{
+		Provider:      name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+// provider returns the provider with the name passed, discovering its configuration if it wasn't already.
+//
+// The discovery is made without holding the lock so it doesn't delay the logins with other providers.
+func (r *Registry) provider(ctx context.Context, name string) (*provider, error) {
+	r.mu.Lock()
+	p, ok := r.providers[name]
+	r.mu.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	conf, ok := r.conf.Providers[name]
+	if !ok || conf.Issuer == "" || conf.ClientID == "" {
+		return nil, ErrUnknownProvider
+	}
+
+	p, err := discover(ctx, r.client, conf, r.conf.RedirectURL+"/"+name+"/callback")
+	if err != nil {
+		return nil, errors.Wrapf(err, "discovering %s", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Keep the provider discovered first, its keys may have been fetched already
+	if existing, ok := r.providers[name]; ok {
+		return existing, nil
+	}
+	r.providers[name] = p
+	return p, nil
+}
+
+func randString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating random string")
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,66 @@
+package oidc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GGP1/adak/internal/config"
+	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/auth/oidc"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	rdb := test.StartRedis(t)
+	issuer := test.NewOIDCIssuer(t)
+
+	registry := oidc.NewRegistry(config.OIDC{
+		RedirectURL: "http://localhost:4000/login/oidc",
+		Providers: map[string]config.OIDCProvider{
+			"fake": {
+				Issuer:       issuer.URL,
+				ClientID:     issuer.ClientID,
+				ClientSecret: issuer.ClientSecret,
+			},
+		},
+	}, rdb)
+
+	authURL, state, err := registry.AuthCodeURL(ctx, "fake")
+	assert.NoError(t, err)
+
+	callback := issuer.Authorize(t, authURL)
+	assert.Equal(t, "/login/oidc/fake/callback", callback.Path)
+	assert.Equal(t, state, callback.Query().Get("state"))
+	code := callback.Query().Get("code")
+
+	t.Run("Unknown provider", func(t *testing.T) {
+		_, err := registry.Exchange(ctx, "unknown", state, code)
+		assert.Equal(t, oidc.ErrUnknownProvider, err)
+	})
+
+	t.Run("Unknown state", func(t *testing.T) {
+		_, err := registry.Exchange(ctx, "fake", "unknown", code)
+		assert.Equal(t, oidc.ErrInvalidState, err)
+	})
+
+	t.Run("Exchange", func(t *testing.T) {
+		identity, err := registry.Exchange(ctx, "fake", state, code)
+		assert.NoError(t, err)
+
+		expected := oidc.Identity{
+			Provider:      "fake",
+			Subject:       issuer.Subject,
+			Email:         issuer.Email,
+			EmailVerified: true,
+			Name:          "OIDC User",
+		}
+		assert.Equal(t, expected, identity)
+	})
+
+	t.Run("Reused state", func(t *testing.T) {
+		_, err := registry.Exchange(ctx, "fake", state, code)
+		assert.Equal(t, oidc.ErrInvalidState, err)
+	})
+}
@@ -0,0 +1,414 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GGP1/adak/internal/config"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// leeway is the clock skew tolerated when validating the token times.
+	leeway = time.Minute
+	// refetchInterval is the minimum time between fetches of the key set, otherwise each token
+	// with an unknown key id would make us request it.
+	refetchInterval = 5 * time.Minute
+)
+
+type provider struct {
+	issuer  string
+	oauth   oauth2.Config
+	client  *http.Client
+	jwksURI string
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// fetching is closed once the fetch of the key set in progress finishes
+	fetching chan struct{}
+}
+
+type discovery struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURI  string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// claims are the ID token claims used.
+type claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified boolean  `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience may be a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var arr []string
+	if err := json.Unmarshal(data, &arr); err != nil {
+		return err
+	}
+	*a = arr
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// boolean may be a bool or a string, some providers send "email_verified" quoted.
+type boolean bool
+
+func (b *boolean) UnmarshalJSON(data []byte) error {
+	*b = boolean(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+// discover fetches the provider metadata from the issuer (OpenID Connect Discovery 1.0).
+func discover(ctx context.Context, client *http.Client, conf config.OIDCProvider, redirectURL string) (*provider, error) {
+	issuer := strings.TrimSuffix(conf.Issuer, "/")
+
+	var doc discovery
+	if err := getJSON(ctx, client, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, errors.Errorf("issuer mismatch, expected %q and got %q", issuer, doc.Issuer)
+	}
+	if doc.AuthURL == "" || doc.TokenURL == "" || doc.JWKSURI == "" {
+		return nil, errors.New("incomplete provider metadata")
+	}
+
+	scopes := conf.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+
+	return &provider{
+		issuer: doc.Issuer,
+		oauth: oauth2.Config{
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  doc.AuthURL,
+				TokenURL: doc.TokenURL,
+			},
+			RedirectURL: redirectURL,
+			Scopes:      withOpenID(scopes),
+		},
+		client:  client,
+		jwksURI: doc.JWKSURI,
+		keys:    make(map[string]crypto.PublicKey),
+	}, nil
+}
+
+// verify validates the ID token signature and claims.
+func (p *provider) verify(ctx context.Context, rawIDToken, nonce string, now time.Time) (claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return claims{}, errors.New("malformed ID token")
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return claims{}, errors.Wrap(err, "invalid ID token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims{}, errors.Wrap(err, "invalid ID token signature")
+	}
+
+	key, err := p.key(ctx, h.Kid)
+	if err != nil {
+		return claims{}, err
+	}
+	if err := verifySignature(h.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return claims{}, err
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return claims{}, errors.Wrap(err, "invalid ID token claims")
+	}
+
+	clientID := p.oauth.ClientID
+	switch {
+	case c.Issuer != p.issuer:
+		return claims{}, errors.New("ID token issued by another provider")
+	case !c.Audience.contains(clientID):
+		return claims{}, errors.New("ID token issued to another client")
+	case len(c.Audience) > 1 && c.AuthorizedBy != clientID:
+		return claims{}, errors.New("ID token authorized to another client")
+	case now.After(time.Unix(c.Expiry, 0).Add(leeway)):
+		return claims{}, errors.New("ID token expired")
+	case now.Add(leeway).Before(time.Unix(c.IssuedAt, 0)):
+		return claims{}, errors.New("ID token issued in the future")
+	case c.Nonce != nonce:
+		return claims{}, errors.New("invalid ID token nonce")
+	case c.Subject == "":
+		return claims{}, errors.New("ID token without subject")
+	}
+
+	return c, nil
+}
+
+// key returns the public key with the id provided, the key set is fetched again if it's not
+// found as the provider may have rotated its keys.
+//
+// Only one fetch is made at a time, the requests arriving meanwhile wait for its result.
+func (p *provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	for {
+		p.mu.Lock()
+		if key, ok := lookup(p.keys, kid); ok {
+			p.mu.Unlock()
+			return key, nil
+		}
+
+		if fetching := p.fetching; fetching != nil {
+			p.mu.Unlock()
+			select {
+			case <-fetching:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if time.Since(p.fetchedAt) < refetchInterval {
+			p.mu.Unlock()
+			return nil, errors.Errorf("signing key %q not found", kid)
+		}
+		fetching := make(chan struct{})
+		p.fetching = fetching
+		p.mu.Unlock()
+
+		keys, err := p.fetchKeys(ctx)
+
+		p.mu.Lock()
+		if err == nil {
+			p.keys = keys
+			p.fetchedAt = time.Now()
+		}
+		p.fetching = nil
+		close(fetching)
+		p.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fetchKeys requests the provider key set, the keys not used for signing are discarded.
+func (p *provider) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, p.client, p.jwksURI, &set); err != nil {
+		return nil, errors.Wrap(err, "fetching key set")
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+// lookup returns the key with the id provided.
+func lookup(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, bool) {
+	// Tokens without key id are accepted only if the provider has a single key
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	key, ok := keys[kid]
+	return key, ok
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, errors.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, errors.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var h hash.Hash
+	var hashFunc crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		h, hashFunc = sha256.New(), crypto.SHA256
+	case "RS384", "ES384":
+		h, hashFunc = sha512.New384(), crypto.SHA384
+	case "RS512":
+		h, hashFunc = sha512.New(), crypto.SHA512
+	default:
+		return errors.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(key, hashFunc, digest, signature); err != nil {
+			return errors.New("invalid ID token signature")
+		}
+		return nil
+
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" || len(signature)%2 != 0 {
+			break
+		}
+		half := len(signature) / 2
+		r := new(big.Int).SetBytes(signature[:half])
+		s := new(big.Int).SetBytes(signature[half:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid ID token signature")
+		}
+		return nil
+	}
+
+	return errors.New("signing algorithm doesn't match the key")
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid key")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("%s: unexpected status %s", url, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// withOpenID makes sure the "openid" scope is requested.
+func withOpenID(scopes []string) []string {
+	for _, scope := range scopes {
+		if scope == "openid" {
+			return scopes
+		}
+	}
+	return append([]string{"openid"}, scopes...)
+}
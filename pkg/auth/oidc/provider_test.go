@@ -0,0 +1,172 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GGP1/adak/internal/config"
+	"github.com/GGP1/adak/internal/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	issuer := test.NewOIDCIssuer(t)
+	conf := config.OIDCProvider{Issuer: issuer.URL, ClientID: issuer.ClientID}
+
+	p, err := discover(ctx, http.DefaultClient, conf, "http://localhost/callback")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"openid", "email", "profile"}, p.oauth.Scopes)
+
+	now := time.Now()
+	cases := []struct {
+		desc   string
+		modify func(claims map[string]interface{})
+		valid  bool
+	}{
+		{desc: "Valid", modify: func(claims map[string]interface{}) {}, valid: true},
+		{desc: "Audience list", modify: func(claims map[string]interface{}) {
+			claims["aud"] = []string{issuer.ClientID, "other"}
+			claims["azp"] = issuer.ClientID
+		}, valid: true},
+		{desc: "Quoted email verified", modify: func(claims map[string]interface{}) {
+			claims["email_verified"] = "true"
+		}, valid: true},
+		{desc: "Nonce mismatch", modify: func(claims map[string]interface{}) {
+			claims["nonce"] = "other"
+		}},
+		{desc: "Other audience", modify: func(claims map[string]interface{}) {
+			claims["aud"] = "other"
+		}},
+		{desc: "Unauthorized party", modify: func(claims map[string]interface{}) {
+			claims["aud"] = []string{issuer.ClientID, "other"}
+		}},
+		{desc: "Other issuer", modify: func(claims map[string]interface{}) {
+			claims["iss"] = "https://issuer.com"
+		}},
+		{desc: "Expired", modify: func(claims map[string]interface{}) {
+			claims["exp"] = now.Add(-time.Hour).Unix()
+		}},
+		{desc: "Issued in the future", modify: func(claims map[string]interface{}) {
+			claims["iat"] = now.Add(time.Hour).Unix()
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			claims := issuer.Claims("nonce")
+			tc.modify(claims)
+
+			got, err := p.verify(ctx, issuer.IDToken(t, claims), "nonce", now)
+			if !tc.valid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, issuer.Subject, got.Subject)
+			assert.Equal(t, issuer.Email, got.Email)
+			assert.Equal(t, true, bool(got.EmailVerified))
+		})
+	}
+
+	t.Run("Tampered", func(t *testing.T) {
+		parts := strings.Split(issuer.IDToken(t, issuer.Claims("nonce")), ".")
+		other := strings.Split(issuer.IDToken(t, issuer.Claims("other")), ".")
+
+		_, err := p.verify(ctx, parts[0]+"."+other[1]+"."+parts[2], "other", now)
+		assert.Error(t, err)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		_, err := p.verify(ctx, "header.payload", "nonce", now)
+		assert.Error(t, err)
+	})
+}
+
+func TestKeyRefetch(t *testing.T) {
+	ctx := context.Background()
+	issuer := test.NewOIDCIssuer(t)
+	transport := &countingTransport{}
+	client := &http.Client{Transport: transport}
+
+	p, err := discover(ctx, client, config.OIDCProvider{Issuer: issuer.URL, ClientID: issuer.ClientID}, "")
+	assert.NoError(t, err)
+
+	_, err = p.verify(ctx, issuer.IDToken(t, issuer.Claims("nonce")), "nonce", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), transport.requests("/jwks"))
+
+	// Tokens with unknown keys don't fetch the key set again until the interval passes
+	parts := strings.Split(issuer.IDToken(t, issuer.Claims("nonce")), ".")
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"rotated"}`))
+	token := header + "." + parts[1] + "." + parts[2]
+	for i := 0; i < 3; i++ {
+		_, err = p.verify(ctx, token, "nonce", time.Now())
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(1), transport.requests("/jwks"))
+
+	p.mu.Lock()
+	p.fetchedAt = time.Now().Add(-refetchInterval)
+	p.mu.Unlock()
+	_, err = p.verify(ctx, token, "nonce", time.Now())
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), transport.requests("/jwks"))
+}
+
+func TestDiscover(t *testing.T) {
+	ctx := context.Background()
+	issuer := test.NewOIDCIssuer(t)
+
+	t.Run("Scopes", func(t *testing.T) {
+		conf := config.OIDCProvider{Issuer: issuer.URL + "/", ClientID: "id", Scopes: []string{"email"}}
+		p, err := discover(ctx, http.DefaultClient, conf, "")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"openid", "email"}, p.oauth.Scopes)
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		conf := config.OIDCProvider{Issuer: issuer.URL + "/unknown", ClientID: "id"}
+		_, err := discover(ctx, http.DefaultClient, conf, "")
+		assert.Error(t, err)
+	})
+}
+
+func TestUnknownProvider(t *testing.T) {
+	registry := NewRegistry(config.OIDC{
+		Providers: map[string]config.OIDCProvider{"no-issuer": {ClientID: "id"}},
+	}, nil)
+
+	for _, name := range []string{"unknown", "no-issuer"} {
+		_, _, err := registry.AuthCodeURL(context.Background(), name)
+		assert.Equal(t, ErrUnknownProvider, err)
+	}
+}
+
+// countingTransport counts the requests made to each path.
+type countingTransport struct {
+	mu     sync.Mutex
+	counts map[string]int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	if c.counts == nil {
+		c.counts = make(map[string]int32)
+	}
+	c.counts[req.URL.Path]++
+	c.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (c *countingTransport) requests(path string) int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[path]
+}
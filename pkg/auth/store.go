@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Sessions are stored as hashes under "session:<id>", the ids of each user sessions
+// are kept in the set "sessions:<user_id>".
+const (
+	sessionPrefix      = "session:"
+	userSessionsPrefix = "sessions:"
+)
+
+// Session hash fields
+const (
+	fieldUserID    = "user_id"
+	fieldCartID    = "cart_id"
+	fieldAdmin     = "admin"
+	fieldUserAgent = "user_agent"
+	fieldIP        = "ip"
+	fieldCreatedAt = "created_at"
+	fieldLastSeen  = "last_seen"
+)
+
+// ErrSessionNotFound is returned when the session doesn't exist or belongs to another user.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Info describes an active session.
+type Info struct {
+	ID        string    `json:"id"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Current is true for the session used to make the request
+	Current bool `json:"current"`
+}
+
+// sessionID returns the id the session of the token is stored under.
+//
+// Only the token holder can use the session, the id is safe to share.
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sessionKey(id string) string {
+	return sessionPrefix + id
+}
+
+func userSessionsKey(userID string) string {
+	return userSessionsPrefix + userID
+}
+
+func newInfo(id string, values map[string]string) Info {
+	return Info{
+		ID:        id,
+		UserAgent: values[fieldUserAgent],
+		IP:        values[fieldIP],
+		CreatedAt: unixTime(values[fieldCreatedAt]),
+		LastSeen:  unixTime(values[fieldLastSeen]),
+	}
+}
+
+func unixTime(value string) time.Time {
+	sec, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
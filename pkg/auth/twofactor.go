@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GGP1/adak/internal/cookie"
+	"github.com/GGP1/adak/internal/crypt"
+	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/principal"
+	"github.com/GGP1/adak/internal/totp"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Logins waiting for the second factor are stored as hashes under "2fa:<id>", the codes
+// already used are kept under "totp:<user_id>:<counter>" until they expire.
+const (
+	pendingPrefix  = "2fa:"
+	totpUsedPrefix = "totp:"
+	fieldAttempts  = "attempts"
+)
+
+const (
+	issuer = "Adak"
+	// pendingTTL is the time the user has to provide the second factor after the password
+	pendingTTL = 5 * time.Minute
+	// pendingAttempts is the number of codes accepted per login before it has to be restarted
+	pendingAttempts = 5
+	recoveryCodes   = 10
+)
+
+var (
+	// ErrTwoFactorRequired is returned when the password is correct but the login must be
+	// completed with a second factor.
+	ErrTwoFactorRequired = errors.New("two-factor authentication required")
+	// ErrInvalidCode is returned when the second factor code is not valid.
+	ErrInvalidCode = errors.New("invalid authentication code")
+
+	errPendingExpired = errors.New("two-factor authentication expired, please log in again")
+	errTwoFactorOn    = errors.New("two-factor authentication is already enabled")
+	errTwoFactorOff   = errors.New("two-factor authentication is not enabled")
+	errNotEnrolled    = errors.New("two-factor authentication enrollment not started")
+	recoveryEncoding  = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// Enrollment contains the secret the user must register in an authenticator application.
+type Enrollment struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth key URI, usually shared using a QR code
+	URI string `json:"uri"`
+}
+
+// DisableTwoFactor turns off the user two-factor authentication and logs out the rest of
+// the user sessions.
+func (s *session) DisableTwoFactor(ctx context.Context, code string) error {
+	p, ok := principal.FromContext(ctx)
+	if !ok {
+		return principal.ErrUnauthenticated
+	}
+
+	user, err := s.getUser(ctx, "id=$1", p.UserID)
+	if err != nil {
+		return errors.Wrap(err, "fetching user")
+	}
+	if !user.TOTPEnabled {
+		return errTwoFactorOff
+	}
+
+	valid, err := s.checkCode(ctx, user, code)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrInvalidCode
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	q := "UPDATE users SET totp_secret=NULL, totp_enabled=false WHERE id=$1"
+	if _, err := tx.ExecContext(ctx, q, user.ID); err != nil {
+		return errors.Wrap(err, "couldn't disable two-factor authentication")
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id=$1", user.ID); err != nil {
+		return errors.Wrap(err, "couldn't delete recovery codes")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	if user.IsAdmin && s.conf.AdminTwoFactor {
+		if err := s.rdb.HSet(ctx, sessionKey(p.SessionID), fieldAdmin, "0").Err(); err != nil {
+			return errors.Wrap(err, "updating session")
+		}
+	}
+
+	return s.RevokeOthers(ctx)
+}
+
+// EnrollTwoFactor generates a new secret for the user, two-factor authentication is enabled
+// once a code generated with it is verified.
+func (s *session) EnrollTwoFactor(ctx context.Context) (Enrollment, error) {
+	userID, err := principal.UserID(ctx)
+	if err != nil {
+		return Enrollment{}, err
+	}
+
+	user, err := s.getUser(ctx, "id=$1", userID)
+	if err != nil {
+		return Enrollment{}, errors.Wrap(err, "fetching user")
+	}
+	if user.TOTPEnabled {
+		return Enrollment{}, errTwoFactorOn
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return Enrollment{}, err
+	}
+	encrypted, err := crypt.Encrypt([]byte(secret))
+	if err != nil {
+		return Enrollment{}, errors.Wrap(err, "encrypting secret")
+	}
+
+	q := "UPDATE users SET totp_secret=$2 WHERE id=$1 AND NOT totp_enabled"
+	if _, err := s.db.ExecContext(ctx, q, user.ID, hex.EncodeToString(encrypted)); err != nil {
+		return Enrollment{}, errors.Wrap(err, "couldn't save the secret")
+	}
+
+	return Enrollment{
+		Secret: secret,
+		URI:    totp.URI(issuer, user.Email, secret),
+	}, nil
+}
+
+// LoginTwoFactor completes the pending login of the request using the code provided.
+func (s *session) LoginTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request, code string) error {
+	token, err := cookie.GetValue(r, "2FA")
+	if err != nil {
+		return errPendingExpired
+	}
+
+	// The attempt is counted in the same transaction the login is read and the expiration
+	// is set again, otherwise the increment would recreate an expired key without it.
+	// The cookie still expires after pendingTTL since the password was provided
+	key := pendingKey(sessionID(token))
+	pipe := s.rdb.TxPipeline()
+	userIDCmd := pipe.HGet(ctx, key, fieldUserID)
+	attemptsCmd := pipe.HIncrBy(ctx, key, fieldAttempts, 1)
+	pipe.Expire(ctx, key, pendingTTL)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return errors.Wrap(err, "fetching the pending login")
+	}
+
+	userID, err := userIDCmd.Result()
+	if err != nil {
+		// Remove the key created by the increment
+		s.deletePending(ctx, w, key)
+		if err == redis.Nil {
+			return errPendingExpired
+		}
+		return errors.Wrap(err, "fetching the pending login")
+	}
+
+	if attempts := attemptsCmd.Val(); attempts > pendingAttempts {
+		s.deletePending(ctx, w, key)
+		return errPendingExpired
+	}
+
+	user, err := s.getUser(ctx, "id=$1", userID)
+	if err != nil {
+		return errors.Wrap(err, "fetching user")
+	}
+
+	valid, err := s.checkCode(ctx, user, code)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrInvalidCode
+	}
+
+	s.deletePending(ctx, w, key)
+	if err := s.storeSession(ctx, w, r, user); err != nil {
+		return err
+	}
+
+	s.mergeGuestCart(ctx, w, r, user.CartID)
+	return nil
+}
+
+// VerifyTwoFactor enables two-factor authentication if the code was generated with the secret
+// enrolled, it returns the recovery codes that can be used if the device is lost.
+func (s *session) VerifyTwoFactor(ctx context.Context, code string) ([]string, error) {
+	p, ok := principal.FromContext(ctx)
+	if !ok {
+		return nil, principal.ErrUnauthenticated
+	}
+
+	user, err := s.getUser(ctx, "id=$1", p.UserID)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching user")
+	}
+	if user.TOTPEnabled {
+		return nil, errTwoFactorOn
+	}
+	if user.TOTPSecret == "" {
+		return nil, errNotEnrolled
+	}
+
+	valid, err := s.validateTOTP(ctx, user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrInvalidCode
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_enabled=true WHERE id=$1", user.ID); err != nil {
+		return nil, errors.Wrap(err, "couldn't enable two-factor authentication")
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing transaction")
+	}
+
+	if user.IsAdmin {
+		if err := s.rdb.HSet(ctx, sessionKey(p.SessionID), fieldAdmin, "1").Err(); err != nil {
+			return nil, errors.Wrap(err, "updating session")
+		}
+	}
+
+	return codes, nil
+}
+
+// checkCode validates an authenticator code or, if it doesn't match, uses one of the recovery codes.
+func (s *session) checkCode(ctx context.Context, user User, code string) (bool, error) {
+	valid, err := s.validateTOTP(ctx, user, code)
+	if err != nil || valid {
+		return valid, err
+	}
+
+	q := `UPDATE user_recovery_codes SET used_at=$3
+	WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`
+	res, err := s.db.ExecContext(ctx, q, user.ID, hashRecoveryCode(code), time.Now())
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't use the recovery code")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't use the recovery code")
+	}
+
+	return n == 1, nil
+}
+
+func (s *session) deletePending(ctx context.Context, w http.ResponseWriter, key string) {
+	if err := s.rdb.Del(ctx, key).Err(); err != nil {
+		logger.Debug(err)
+	}
+	cookie.Delete(w, "2FA", "/")
+}
+
+// storePending saves the login of a user that has to provide a second factor and
+// sets the cookie used to complete it.
+func (s *session) storePending(ctx context.Context, w http.ResponseWriter, userID string) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return errors.Wrap(err, "generating token")
+	}
+	token := hex.EncodeToString(b)
+
+	key := pendingKey(sessionID(token))
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, key, fieldUserID, userID, fieldAttempts, 0)
+	pipe.Expire(ctx, key, pendingTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrap(err, "saving pending login")
+	}
+
+	// -2FA- pending login token, exchanged for the session token once the second factor is verified
+	return cookie.Set(w, "2FA", token, "/", int(pendingTTL.Seconds()))
+}
+
+// validateTOTP checks the code against the user secret, each code is accepted only once.
+func (s *session) validateTOTP(ctx context.Context, user User, code string) (bool, error) {
+	if user.TOTPSecret == "" {
+		return false, nil
+	}
+
+	encrypted, err := hex.DecodeString(user.TOTPSecret)
+	if err != nil {
+		return false, errors.Wrap(err, "decoding secret")
+	}
+	secret, err := crypt.Decrypt(encrypted)
+	if err != nil {
+		return false, errors.Wrap(err, "decrypting secret")
+	}
+
+	counter, ok := totp.Validate(string(secret), code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// Codes are accepted during three periods to tolerate clock drift
+	key := totpUsedPrefix + user.ID + ":" + strconv.FormatInt(counter, 10)
+	fresh, err := s.rdb.SetNX(ctx, key, 1, 3*totp.Period*time.Second).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "checking code reuse")
+	}
+
+	return fresh, nil
+}
+
+func pendingKey(id string) string {
+	return pendingPrefix + id
+}
+
+// hashRecoveryCode returns the hash of the code the database stores, the codes are random
+// enough to not need a slow hash.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCode returns a random code formatted as "xxxxx-xxxxx".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating recovery code")
+	}
+	code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// replaceRecoveryCodes invalidates the user recovery codes and generates new ones.
+func replaceRecoveryCodes(ctx context.Context, tx *sqlx.Tx, userID string) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id=$1", userID); err != nil {
+		return nil, errors.Wrap(err, "couldn't delete recovery codes")
+	}
+
+	q := "INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)"
+	codes := make([]string, recoveryCodes)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, q, userID, hashRecoveryCode(code)); err != nil {
+			return nil, errors.Wrap(err, "couldn't save recovery codes")
+		}
+		codes[i] = code
+	}
+
+	return codes, nil
+}
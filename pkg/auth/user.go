@@ -8,6 +8,15 @@ type User struct {
 	Email         string `json:"email" validate:"email,required"`
 	Password      string `json:"password" validate:"required,min=6"`
 	VerifiedEmail bool   `json:"-" db:"verified_email"`
+	IsAdmin       bool   `json:"-" db:"is_admin"`
+	TOTPSecret    string `json:"-" db:"totp_secret"`
+	TOTPEnabled   bool   `json:"-" db:"totp_enabled"`
+}
+
+// TwoFactorCode is the one-time code used as a second authentication factor, it may be
+// generated by an authenticator application or be one of the recovery codes.
+type TwoFactorCode struct {
+	Code string `json:"code" validate:"required,max=20"`
 }
 
 // UserAuth is the login request used to authenticate users.
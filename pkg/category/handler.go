@@ -0,0 +1,208 @@
+package category
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/response"
+	"github.com/GGP1/adak/internal/sanitize"
+	"github.com/GGP1/adak/internal/validate"
+	"github.com/GGP1/adak/pkg/product"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+type productsResponse struct {
+	Category   Category          `json:"category"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	Products   []product.Product `json:"products,omitempty"`
+	Facets     Facets            `json:"facets"`
+}
+
+// Handler handles category endpoints.
+type Handler struct {
+	service Service
+}
+
+// NewHandler returns a new category handler.
+func NewHandler(service Service) Handler {
+	return Handler{service: service}
+}
+
+// Create creates a new category.
+func (h *Handler) Create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var c Category
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := prepare(r, &c); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		c.ID = uuid.NewString()
+		c.CreatedAt = zero.TimeFrom(time.Now())
+		if err := h.service.Create(ctx, c); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, c)
+	}
+}
+
+// Delete removes a category and its descendants.
+func (h *Handler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.Delete(ctx, id); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, id)
+	}
+}
+
+// Get lists the categories tree.
+func (h *Handler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		categories, err := h.service.Get(r.Context())
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, categories)
+	}
+}
+
+// GetBySlug lists the category with the slug requested and its descendants.
+func (h *Handler) GetBySlug() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		category, err := h.service.GetBySlug(r.Context(), chi.URLParam(r, "slug"))
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, category)
+	}
+}
+
+// Products lists the products of the category and its descendants, with their facets.
+func (h *Handler) Products() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		slug := chi.URLParam(r, "slug")
+
+		urlParams, err := params.ParseQuery(r.URL.RawQuery, params.Product)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		category, err := h.service.GetBySlug(ctx, slug)
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		products, err := h.service.Products(ctx, slug, urlParams)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		facets, err := h.service.Facets(ctx, slug)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		var nextCursor string
+		if len(products) > 0 {
+			nextCursor = params.EncodeCursor(
+				products[len(products)-1].CreatedAt.Time,
+				products[len(products)-1].ID.String,
+			)
+		}
+
+		response.JSON(w, http.StatusOK, productsResponse{
+			Category:   category,
+			NextCursor: nextCursor,
+			Products:   products,
+			Facets:     facets,
+		})
+	}
+}
+
+// Update updates the category with the given id.
+func (h *Handler) Update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var c Category
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := prepare(r, &c); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		c.ID = id
+		c.UpdatedAt = zero.TimeFrom(time.Now())
+		if err := h.service.Update(ctx, id, c); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, c)
+	}
+}
+
+// prepare validates the category and normalizes its name and slug.
+func prepare(r *http.Request, c *Category) error {
+	if err := validate.Struct(r.Context(), c); err != nil {
+		return err
+	}
+
+	c.Name = sanitize.Normalize(c.Name)
+	if c.Slug == "" {
+		c.Slug = c.Name
+	}
+	c.Slug = slugify(c.Slug)
+	if c.Slug == "" {
+		return errors.New("the category slug must contain letters or numbers")
+	}
+
+	return nil
+}
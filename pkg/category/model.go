@@ -0,0 +1,89 @@
+package category
+
+import (
+	"strings"
+	"unicode"
+
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// Category is a node of the products taxonomy, root categories have no parent.
+type Category struct {
+	ID       string      `json:"id,omitempty"`
+	ParentID zero.String `json:"parent_id,omitempty" db:"parent_id" validate:"omitempty,uuid4_rfc4122"`
+	Name     string      `json:"name,omitempty" validate:"required,max=64"`
+	// Slug identifies the category in the URLs, it's generated from the name if it's empty
+	Slug string `json:"slug,omitempty" validate:"omitempty,max=64"`
+	// Position is used to sort the categories that have the same parent
+	Position  int64      `json:"position"`
+	CreatedAt zero.Time  `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt zero.Time  `json:"updated_at,omitempty" db:"updated_at"`
+	Children  []Category `json:"children,omitempty" db:"-"`
+}
+
+// Facets contains the number of products of a category (and its descendants) by
+// brand, price range and rating.
+type Facets struct {
+	Brands  []BrandFacet  `json:"brands"`
+	Prices  []PriceFacet  `json:"prices"`
+	Ratings []RatingFacet `json:"ratings"`
+}
+
+// BrandFacet is the number of products of a brand.
+type BrandFacet struct {
+	Brand string `json:"brand"`
+	Count int64  `json:"count"`
+}
+
+// PriceFacet is the number of products whose unit total is between Min and Max.
+//
+// Amounts are in the currency’s smallest unit of each product.
+type PriceFacet struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max"`
+	Count int64 `json:"count"`
+}
+
+// RatingFacet is the number of products whose average rating of approved reviews, rounded down, is Stars.
+type RatingFacet struct {
+	Stars int64 `json:"stars"`
+	Count int64 `json:"count"`
+}
+
+// slugify returns a lowercase version of the name with its words separated by dashes.
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// tree nests the categories under their parents, keeping the order they have in the slice,
+// and returns the children of the parent given.
+func tree(categories []Category, parentID string) []Category {
+	children := make(map[string][]Category, len(categories))
+	for _, c := range categories {
+		children[c.ParentID.String] = append(children[c.ParentID.String], c)
+	}
+
+	var build func(parentID string) []Category
+	build = func(parentID string) []Category {
+		nodes := children[parentID]
+		for i := range nodes {
+			nodes[i].Children = build(nodes[i].ID)
+		}
+		return nodes
+	}
+
+	return build(parentID)
+}
@@ -0,0 +1,211 @@
+// Package category manages the tree of categories the products are classified in.
+package category
+
+import (
+	"context"
+
+	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/pkg/product"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// priceBuckets is the number of ranges the products prices are split in.
+const priceBuckets = 5
+
+// Service contains category functionalities.
+//
+// The products of a category include the ones of all its descendants.
+type Service interface {
+	Create(ctx context.Context, c Category) error
+	Delete(ctx context.Context, id string) error
+	Facets(ctx context.Context, slug string) (Facets, error)
+	Get(ctx context.Context) ([]Category, error)
+	GetBySlug(ctx context.Context, slug string) (Category, error)
+	Products(ctx context.Context, slug string, params params.Query) ([]product.Product, error)
+	Update(ctx context.Context, id string, c Category) error
+}
+
+type service struct {
+	db      *sqlx.DB
+	metrics metrics
+}
+
+// NewService returns a new category service.
+func NewService(db *sqlx.DB) Service {
+	return &service{db, initMetrics()}
+}
+
+// Create creates a category.
+func (s *service) Create(ctx context.Context, c Category) error {
+	s.metrics.incMethodCalls("Create")
+
+	q := `INSERT INTO categories
+	(id, parent_id, name, slug, position, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := s.db.ExecContext(ctx, q, c.ID, c.ParentID, c.Name, c.Slug, c.Position, c.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create the category")
+	}
+
+	return nil
+}
+
+// Delete removes the category and its descendants, their products are left uncategorized.
+func (s *service) Delete(ctx context.Context, id string) error {
+	s.metrics.incMethodCalls("Delete")
+
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM categories WHERE id=$1", id); err != nil {
+		return errors.Wrap(err, "couldn't delete the category")
+	}
+
+	return nil
+}
+
+// Facets returns the number of products of the category by brand, price range and rating.
+func (s *service) Facets(ctx context.Context, slug string) (Facets, error) {
+	s.metrics.incMethodCalls("Facets")
+
+	facets := Facets{
+		Brands:  []BrandFacet{},
+		Prices:  []PriceFacet{},
+		Ratings: []RatingFacet{},
+	}
+
+	brandsQ := descendants("slug=$1") + `
+	SELECT brand, COUNT(*) AS count FROM products
+	WHERE category_id IN (SELECT id FROM descendants)
+	GROUP BY brand
+	ORDER BY count DESC, brand`
+	if err := s.db.SelectContext(ctx, &facets.Brands, brandsQ, slug); err != nil {
+		return Facets{}, errors.Wrap(err, "couldn't count the products by brand")
+	}
+
+	pricesQ := descendants("slug=$1") + `,
+	p AS (SELECT total FROM products WHERE category_id IN (SELECT id FROM descendants)),
+	bounds AS (SELECT MIN(total) AS lo, MAX(total) AS hi FROM p)
+	SELECT MIN(p.total) AS min, MAX(p.total) AS max, COUNT(*) AS count
+	FROM p, bounds
+	GROUP BY width_bucket(p.total, bounds.lo, bounds.hi + 1, $2)
+	ORDER BY min`
+	if err := s.db.SelectContext(ctx, &facets.Prices, pricesQ, slug, priceBuckets); err != nil {
+		return Facets{}, errors.Wrap(err, "couldn't count the products by price")
+	}
+
+	// The average keeps only the approved reviews, products without any are left out
+	ratingsQ := descendants("slug=$1") + `
+	SELECT FLOOR(rating_average)::integer AS stars, COUNT(*) AS count FROM products
+	WHERE category_id IN (SELECT id FROM descendants) AND rating_count > 0
+	GROUP BY stars
+	ORDER BY stars DESC`
+	if err := s.db.SelectContext(ctx, &facets.Ratings, ratingsQ, slug); err != nil {
+		return Facets{}, errors.Wrap(err, "couldn't count the products by rating")
+	}
+
+	return facets, nil
+}
+
+// Get returns the categories tree.
+func (s *service) Get(ctx context.Context) ([]Category, error) {
+	s.metrics.incMethodCalls("Get")
+
+	var categories []Category
+	if err := s.db.SelectContext(ctx, &categories, "SELECT * FROM categories ORDER BY position, name"); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the categories")
+	}
+
+	return tree(categories, ""), nil
+}
+
+// GetBySlug returns the category with its descendants.
+func (s *service) GetBySlug(ctx context.Context, slug string) (Category, error) {
+	s.metrics.incMethodCalls("GetBySlug")
+
+	var categories []Category
+	q := descendants("slug=$1") + `
+	SELECT c.* FROM categories AS c
+	WHERE c.id IN (SELECT id FROM descendants)
+	ORDER BY c.position, c.name`
+	if err := s.db.SelectContext(ctx, &categories, q, slug); err != nil {
+		return Category{}, errors.Wrap(err, "couldn't find the category")
+	}
+
+	for _, c := range categories {
+		if c.Slug == slug {
+			return tree(categories, c.ParentID.String)[0], nil
+		}
+	}
+
+	return Category{}, errors.Errorf("category %q not found", slug)
+}
+
+// Products returns the products of the category and its descendants, newest first.
+func (s *service) Products(ctx context.Context, slug string, params params.Query) ([]product.Product, error) {
+	s.metrics.incMethodCalls("Products")
+
+	q := descendants("slug=$1") + `
+	SELECT p.* FROM products AS p
+	WHERE p.category_id IN (SELECT id FROM descendants)`
+	args := []interface{}{slug, params.Limit}
+	if params.Cursor.Used {
+		q += " AND (p.created_at < $3 OR (p.created_at = $3 AND p.id < $4))"
+		args = append(args, params.Cursor.CreatedAt, params.Cursor.ID)
+	}
+	q += " ORDER BY p.created_at DESC, p.id DESC LIMIT $2"
+
+	var products []product.Product
+	if err := s.db.SelectContext(ctx, &products, q, args...); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the products")
+	}
+
+	return products, nil
+}
+
+// Update updates the category fields, a category can't be moved under itself or one of its descendants.
+func (s *service) Update(ctx context.Context, id string, c Category) error {
+	s.metrics.incMethodCalls("Update")
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if c.ParentID.Valid {
+		var cycle bool
+		q := descendants("id=$1") + " SELECT EXISTS(SELECT 1 FROM descendants WHERE id=$2)"
+		if err := tx.GetContext(ctx, &cycle, q, id, c.ParentID); err != nil {
+			return errors.Wrap(err, "couldn't find the category descendants")
+		}
+		if cycle {
+			return errors.New("a category can't be moved under itself or one of its descendants")
+		}
+	}
+
+	q := `UPDATE categories SET parent_id=$2, name=$3, slug=$4, position=$5, updated_at=$6
+	WHERE id=$1`
+	res, err := tx.ExecContext(ctx, q, id, c.ParentID, c.Name, c.Slug, c.Position, c.UpdatedAt)
+	if err != nil {
+		return errors.Wrap(err, "couldn't update the category")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.New("category not found")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// descendants returns a common table expression that contains the ids of the
+// category matching the condition and all its descendants.
+func descendants(condition string) string {
+	return `WITH RECURSIVE descendants AS (
+		SELECT id FROM categories WHERE ` + condition + `
+		UNION ALL
+		SELECT c.id FROM categories AS c JOIN descendants AS d ON c.parent_id=d.id
+	)`
+}
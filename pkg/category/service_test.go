@@ -0,0 +1,152 @@
+package category_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/category"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+const (
+	clothingID = "0b6d4a55-1f7e-4c3e-8a4e-5d2c9f1b7a01"
+	shirtsID   = "0b6d4a55-1f7e-4c3e-8a4e-5d2c9f1b7a02"
+	shopID     = "1"
+)
+
+func NewCategoryService(t *testing.T) (context.Context, *sqlx.DB, category.Service) {
+	t.Helper()
+	logger.Disable()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	db := test.StartPostgres(t)
+	service := category.NewService(db)
+
+	t.Cleanup(func() {
+		cancel()
+	})
+
+	return ctx, db, service
+}
+
+func TestCategoryService(t *testing.T) {
+	ctx, db, s := NewCategoryService(t)
+
+	t.Run("Create", create(ctx, s))
+	t.Run("Get", get(ctx, s))
+	t.Run("Products", products(ctx, db, s))
+	t.Run("Facets", facets(ctx, db, s))
+	t.Run("Update", update(ctx, s))
+}
+
+func create(ctx context.Context, s category.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		clothing := category.Category{ID: clothingID, Name: "Clothing", Slug: "clothing"}
+		assert.NoError(t, s.Create(ctx, clothing))
+
+		shirts := category.Category{
+			ID:       shirtsID,
+			ParentID: zero.StringFrom(clothingID),
+			Name:     "Shirts",
+			Slug:     "shirts",
+		}
+		assert.NoError(t, s.Create(ctx, shirts))
+
+		shirts.ID = "another"
+		assert.Error(t, s.Create(ctx, shirts), "duplicated slug")
+	}
+}
+
+func get(ctx context.Context, s category.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		categories, err := s.Get(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(categories))
+		assert.Equal(t, 1, len(categories[0].Children))
+		assert.Equal(t, shirtsID, categories[0].Children[0].ID)
+
+		shirts, err := s.GetBySlug(ctx, "shirts")
+		assert.NoError(t, err)
+		assert.Equal(t, shirtsID, shirts.ID)
+		assert.Empty(t, shirts.Children)
+
+		_, err = s.GetBySlug(ctx, "shoes")
+		assert.Error(t, err)
+	}
+}
+
+func products(ctx context.Context, db *sqlx.DB, s category.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		_, err := db.ExecContext(ctx, "INSERT INTO shops (id, name) VALUES ($1, 'shop')", shopID)
+		assert.NoError(t, err)
+
+		q := `INSERT INTO products
+		(id, shop_id, stock, brand, category, type, weight, subtotal, total, category_id)
+		VALUES ($1, $2, 1, $3, 'category', 'type', 1, $4, $4, $5)`
+		_, err = db.ExecContext(ctx, q, "1", shopID, "adak", 1000, clothingID)
+		assert.NoError(t, err)
+		_, err = db.ExecContext(ctx, q, "2", shopID, "adak", 2000, shirtsID)
+		assert.NoError(t, err)
+		_, err = db.ExecContext(ctx, q, "3", shopID, "other", 9000, shirtsID)
+		assert.NoError(t, err)
+
+		all, err := s.Products(ctx, "clothing", params.Query{Limit: "2"})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(all))
+
+		cursor := params.Cursor{Used: true, CreatedAt: all[1].CreatedAt.Time, ID: all[1].ID.String}
+		next, err := s.Products(ctx, "clothing", params.Query{Cursor: cursor, Limit: "2"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(next))
+
+		shirts, err := s.Products(ctx, "shirts", params.Query{Limit: "10"})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(shirts))
+	}
+}
+
+func facets(ctx context.Context, db *sqlx.DB, s category.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		facets, err := s.Facets(ctx, "clothing")
+		assert.NoError(t, err)
+		assert.Equal(t, []category.BrandFacet{{Brand: "adak", Count: 2}, {Brand: "other", Count: 1}}, facets.Brands)
+
+		var total int64
+		for _, p := range facets.Prices {
+			total += p.Count
+		}
+		assert.Equal(t, int64(3), total)
+		assert.Equal(t, int64(1000), facets.Prices[0].Min)
+		assert.Empty(t, facets.Ratings)
+
+		q := "UPDATE products SET rating_average=$2, rating_count=$3 WHERE id=$1"
+		_, err = db.ExecContext(ctx, q, "2", 4.6, 5)
+		assert.NoError(t, err)
+		_, err = db.ExecContext(ctx, q, "3", 4, 1)
+		assert.NoError(t, err)
+
+		facets, err = s.Facets(ctx, "clothing")
+		assert.NoError(t, err)
+		assert.Equal(t, []category.RatingFacet{{Stars: 4, Count: 2}}, facets.Ratings)
+	}
+}
+
+func update(ctx context.Context, s category.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		clothing := category.Category{ParentID: zero.StringFrom(shirtsID), Name: "Clothing", Slug: "clothing"}
+		assert.Error(t, s.Update(ctx, clothingID, clothing), "cycle")
+
+		shirts := category.Category{Name: "T-Shirts", Slug: "t-shirts", Position: 1}
+		assert.NoError(t, s.Update(ctx, shirtsID, shirts))
+
+		categories, err := s.Get(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(categories))
+	}
+}
@@ -1,45 +1,53 @@
 package middleware
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"net/http"
-	"strings"
 
-	"github.com/GGP1/adak/internal/cookie"
+	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/principal"
 	"github.com/GGP1/adak/internal/response"
 	"github.com/GGP1/adak/pkg/auth"
-	"github.com/GGP1/adak/pkg/user"
+	"github.com/GGP1/adak/pkg/shop"
 
 	"github.com/jmoiron/sqlx"
 )
 
 // Auth contains the elements needed to authorize users.
 type Auth struct {
-	DB          *sqlx.DB
-	UserService user.Service
-	Session     auth.Session
+	DB            *sqlx.DB
+	MemberService shop.MemberService
+	Session       auth.Session
 }
 
-// AdminsOnly requires the user to be an administrator to proceed.
-func (a *Auth) AdminsOnly(next http.Handler) http.Handler {
+// Authenticate resolves the session of the request and stores its principal in the context,
+// requests without a session continue as anonymous.
+//
+// Handlers must take the user identity only from the principal.
+func (a *Auth) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		sessionID, err := cookie.GetValue(r, "SID")
+		p, err := a.Session.Principal(ctx, r)
 		if err != nil {
-			response.Error(w, http.StatusForbidden, errors.New("unauthorized"))
+			if err != principal.ErrUnauthenticated {
+				response.Error(w, http.StatusInternalServerError, err)
+				return
+			}
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		id := strings.Split(sessionID, ":")[0]
-
-		isAdmin, err := a.UserService.IsAdmin(ctx, id)
-		if err != nil {
-			response.Error(w, http.StatusNotFound, err)
-			return
-		}
+		next.ServeHTTP(w, r.WithContext(principal.NewContext(ctx, p)))
+	})
+}
 
-		if !isAdmin {
+// AdminsOnly requires the user to be an administrator to proceed.
+func (a *Auth) AdminsOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !principal.IsAdmin(r.Context()) {
 			// Return 404 instead of 401 to not give additional information
 			response.Error(w, http.StatusNotFound, errors.New("not found"))
 			return
@@ -53,11 +61,77 @@ func (a *Auth) AdminsOnly(next http.Handler) http.Handler {
 // it returns an error otherwise.
 func (a *Auth) RequireLogin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !a.Session.AlreadyLoggedIn(r.Context(), r) {
-			response.Error(w, http.StatusForbidden, errors.New("please log in to access"))
+		if _, ok := principal.FromContext(r.Context()); !ok {
+			response.Error(w, http.StatusForbidden, principal.ErrUnauthenticated)
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// ShopMember requires the user to have at least the role provided in the shop
+// of the URL id to proceed, administrators have access to every shop.
+func (a *Auth) ShopMember(role shop.Role) func(http.Handler) http.Handler {
+	return a.shopMember(role, func(ctx context.Context, id string) (string, error) {
+		return id, nil
+	})
+}
+
+// ProductShopMember is like ShopMember but takes the shop of the product with the URL id.
+//
+// Products that don't belong to any shop can only be managed by administrators.
+func (a *Auth) ProductShopMember(role shop.Role) func(http.Handler) http.Handler {
+	return a.shopMember(role, func(ctx context.Context, id string) (string, error) {
+		var shopID sql.NullString
+		if err := a.DB.GetContext(ctx, &shopID, "SELECT shop_id FROM products WHERE id=$1", id); err != nil {
+			return "", err
+		}
+		return shopID.String, nil
+	})
+}
+
+// shopMember checks the user role in the shop returned by shopID.
+func (a *Auth) shopMember(role shop.Role, shopID func(ctx context.Context, id string) (string, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			p, ok := principal.FromContext(ctx)
+			if !ok {
+				response.Error(w, http.StatusForbidden, principal.ErrUnauthenticated)
+				return
+			}
+			if p.Admin {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id, err := params.URLID(ctx)
+			if err != nil {
+				response.Error(w, http.StatusBadRequest, err)
+				return
+			}
+
+			shopID, err := shopID(ctx, id)
+			if err != nil || shopID == "" {
+				response.Error(w, http.StatusNotFound, errors.New("not found"))
+				return
+			}
+
+			memberRole, err := a.MemberService.Role(ctx, shopID, p.UserID)
+			if err != nil {
+				response.Error(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			if !memberRole.Includes(role) {
+				// Return 404 instead of 401 to not give additional information
+				response.Error(w, http.StatusNotFound, errors.New("not found"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -48,6 +48,8 @@ func (g *GZIPReponseWriter) Write(d []byte) (int, error) {
 
 // WriteHeader is implemented to satisfy the response writer interface.
 func (g *GZIPReponseWriter) WriteHeader(statuscode int) {
+	// The length set by the handler is the one of the uncompressed content
+	g.w.Header().Del("Content-Length")
 	g.w.WriteHeader(statuscode)
 }
 
@@ -5,14 +5,26 @@ import (
 
 	"github.com/GGP1/adak/internal/config"
 	"github.com/GGP1/adak/internal/email"
+	"github.com/GGP1/adak/pkg/address"
 	"github.com/GGP1/adak/pkg/auth"
+	"github.com/GGP1/adak/pkg/auth/oidc"
+	"github.com/GGP1/adak/pkg/category"
 	"github.com/GGP1/adak/pkg/http/rest/middleware"
+	"github.com/GGP1/adak/pkg/media"
+	"github.com/GGP1/adak/pkg/media/storage/local"
 	"github.com/GGP1/adak/pkg/product"
 	"github.com/GGP1/adak/pkg/review"
 	"github.com/GGP1/adak/pkg/shop"
 	"github.com/GGP1/adak/pkg/shopping/cart"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
 	"github.com/GGP1/adak/pkg/shopping/ordering"
+	"github.com/GGP1/adak/pkg/shopping/payment"
+	"github.com/GGP1/adak/pkg/shopping/payment/fake"
 	"github.com/GGP1/adak/pkg/shopping/payment/stripe"
+	"github.com/GGP1/adak/pkg/shopping/promotion"
+	"github.com/GGP1/adak/pkg/shopping/shipping"
+	"github.com/GGP1/adak/pkg/shopping/wishlist"
 	"github.com/GGP1/adak/pkg/tracking"
 	"github.com/GGP1/adak/pkg/user"
 	"github.com/GGP1/adak/pkg/user/account"
@@ -30,25 +42,51 @@ func NewRouter(config config.Config, db *sqlx.DB, mc *memcache.Client, rdb *redi
 	router := chi.NewRouter()
 
 	// Services
-	accountService := account.NewService(db)
-	cartService := cart.NewService(db, mc)
-	orderingService := ordering.NewService(db)
+	accountService := account.NewService(db, rdb)
+	inventoryService := inventory.NewService(db, config.Inventory)
+	currencyService := currency.NewService(db, config.Currency.Base)
+	promotionService := promotion.NewService(db, currencyService)
+	cartService := cart.NewService(db, mc, inventoryService, promotionService, currencyService)
+	shippingService := shipping.NewService(db, currencyService)
+	addressService := address.NewService(db)
+	orderingService := ordering.NewService(db, inventoryService, promotionService, currencyService,
+		shippingService, addressService)
 	productService := product.NewService(db, mc)
-	reviewService := review.NewService(db, mc)
+	categoryService := category.NewService(db)
+	mediaService := media.NewService(db, mc, local.NewStorage(config.Media.Dir))
+	reviewService := review.NewService(db, mc, orderingService)
 	shopService := shop.NewService(db, mc)
+	memberService := shop.NewMemberService(db)
 	userService := user.NewService(db, mc)
 	trackingService := tracking.NewService(db)
-	session := auth.NewSession(db, rdb, config.Session, config.Development)
+	wishlistService := wishlist.NewService(db, cartService)
+	guestService := cart.NewGuestService(db, rdb, cartService, currencyService, config.Cart)
+	session := auth.NewSession(db, rdb, guestService, config.Session, config.Development)
+	oidcProviders := oidc.NewRegistry(config.OIDC, rdb)
 	emailer := email.New()
 
+	// Payments are simulated during development
+	var payments payment.Provider
+	if config.Development {
+		payments = fake.NewProvider(orderingService.ProcessPaymentEvent)
+	} else {
+		payments = stripe.NewProvider(config.Stripe.SecretKey)
+	}
+
 	// Authentication middleware
 	mAuth := middleware.Auth{
-		DB:          db,
-		UserService: userService,
-		Session:     session,
+		DB:            db,
+		MemberService: memberService,
+		Session:       session,
 	}
 	adminsOnly := mAuth.AdminsOnly
 	requireLogin := mAuth.RequireLogin
+	// Shop members are authorized by their role in the shop, administrators have access to all of them
+	shopStaff := mAuth.ShopMember(shop.RoleStaff)
+	shopManager := mAuth.ShopMember(shop.RoleManager)
+	shopOwner := mAuth.ShopMember(shop.RoleOwner)
+	productStaff := mAuth.ProductShopMember(shop.RoleStaff)
+	productManager := mAuth.ProductShopMember(shop.RoleManager)
 	// Metrics middleware
 	metrics := middleware.NewMetrics()
 
@@ -61,32 +99,80 @@ func NewRouter(config config.Config, db *sqlx.DB, mc *memcache.Client, rdb *redi
 		rateLimiter := middleware.NewRateLimiter(config.RateLimiter, rdb)
 		router.Use(rateLimiter.Limit)
 	}
+	// Identity is taken only from the session, not from client cookies
+	router.Use(mAuth.Authenticate)
 
 	// Auth
 	router.Post("/login", auth.Login(session))
 	router.Get("/login/basic", auth.BasicAuth(session))
+	router.Post("/login/2fa", auth.LoginTwoFactor(session))
 	router.With(requireLogin).Get("/logout", auth.Logout(session))
-	router.Get("/login/google", auth.LoginGoogle(session))
-	router.Get("/login/oauth2/google", auth.OAuth2Google(session))
+	router.With(requireLogin).Get("/sessions", auth.Sessions(session))
+	router.With(requireLogin).Delete("/sessions/{id}", auth.RevokeSession(session))
+	router.With(requireLogin).Post("/2fa/enroll", auth.EnrollTwoFactor(session))
+	router.With(requireLogin).Post("/2fa/verify", auth.VerifyTwoFactor(session))
+	router.With(requireLogin).Delete("/2fa", auth.DisableTwoFactor(session))
+	router.Get("/login/oidc/{provider}", auth.LoginOIDC(session, oidcProviders))
+	router.Get("/login/oidc/{provider}/callback", auth.OIDCCallback(session, oidcProviders,
+		user.Registerer(userService, cartService)))
 
 	// Cart
-	cart := cart.NewHandler(cartService, db, mc)
+	// Visitors that aren't logged in use a guest cart, merged into theirs when they log in
+	cart := cart.NewHandler(cartService, guestService, shippingService, config.Cart, db, mc)
 	router.Route("/cart", func(r chi.Router) {
-		r.Use(requireLogin)
-
 		r.Get("/", cart.Get())
 		r.Post("/add", cart.Add())
-		r.Get("/filter/{field}/{args}", cart.FilterBy())
-		r.Get("/checkout", cart.Checkout())
+		r.With(requireLogin).Get("/filter/{field}/{args}", cart.FilterBy())
+		r.With(requireLogin).Get("/checkout", cart.Checkout())
+		r.With(requireLogin).Post("/coupon", cart.ApplyCoupon())
+		r.With(requireLogin).Delete("/coupon", cart.RemoveCoupon())
 		r.Get("/products", cart.Products())
 		r.Delete("/remove/{id}/{quantity}", cart.Remove())
 		r.Post("/reset", cart.Reset())
+		r.Get("/shipping-options", cart.ShippingOptions())
 		r.Get("/size", cart.Size())
 	})
 
+	// Categories
+	category := category.NewHandler(categoryService)
+	router.Route("/categories", func(r chi.Router) {
+		r.Get("/", category.Get())
+		r.Get("/{slug}", category.GetBySlug())
+		r.Get("/{slug}/products", category.Products())
+		r.With(adminsOnly).Post("/create", category.Create())
+		r.With(adminsOnly).Put("/{id}", category.Update())
+		r.With(adminsOnly).Delete("/{id}", category.Delete())
+	})
+
+	// Coupons
+	coupon := promotion.NewHandler(promotionService)
+	router.Route("/coupons", func(r chi.Router) {
+		r.Use(adminsOnly)
+
+		r.Get("/", coupon.Get())
+		r.Post("/create", coupon.Create())
+		r.Get("/{code}", coupon.GetByCode())
+		r.Delete("/{code}", coupon.Delete())
+		r.Get("/{code}/redemptions", coupon.GetRedemptions())
+	})
+
+	// Exchange rates
+	exchangeRates := currency.NewHandler(currencyService)
+	router.Route("/exchange-rates", func(r chi.Router) {
+		r.Use(adminsOnly)
+
+		r.Get("/", exchangeRates.GetRates())
+		r.Post("/upload", exchangeRates.UploadRates())
+	})
+
 	// Home
 	router.Get("/", Home(trackingService))
 
+	// Media
+	// Galleries are managed under the products and shops routes
+	images := media.NewHandler(mediaService, config.Media.MaxSize<<20)
+	router.Get("/media/*", images.Serve())
+
 	// Metrics
 	router.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
 		Registry: prometheus.DefaultRegisterer,
@@ -96,11 +182,16 @@ func NewRouter(config config.Config, db *sqlx.DB, mc *memcache.Client, rdb *redi
 	}))
 
 	// Ordering
-	order := ordering.NewHandler(config.Development, orderingService, cartService, db, mc)
+	order := ordering.NewHandler(orderingService, cartService, payments, db, mc)
 	router.Route("/orders", func(r chi.Router) {
 		r.With(adminsOnly).Get("/", order.Get())
 		r.With(adminsOnly).Delete("/{id}", order.Delete())
 		r.With(adminsOnly).Get("/{id}", order.GetByID())
+		r.With(adminsOnly).Get("/{id}/status", order.GetStatusHistory())
+		r.With(adminsOnly).Put("/{id}/status", order.UpdateStatus())
+		r.With(adminsOnly).Get("/{id}/refunds", order.GetRefunds())
+		r.With(adminsOnly).Post("/{id}/refund", order.Refund())
+		r.With(requireLogin).Post("/{id}/cancel", order.Cancel())
 		r.With(requireLogin).Get("/user/{id}", order.GetByUserID())
 		r.With(requireLogin).Post("/new", order.New())
 	})
@@ -110,10 +201,29 @@ func NewRouter(config config.Config, db *sqlx.DB, mc *memcache.Client, rdb *redi
 	router.Route("/products", func(r chi.Router) {
 		r.Get("/", product.Get())
 		r.Get("/{id}", product.GetByID())
-		r.With(adminsOnly).Put("/{id}", product.Update())
-		r.With(adminsOnly).Delete("/{id}", product.Delete())
+		r.With(productStaff).Put("/{id}", product.Update())
+		r.With(productManager).Delete("/{id}", product.Delete())
 		r.With(adminsOnly).Post("/create", product.Create())
+		r.With(adminsOnly).Get("/export", product.Export())
+		r.With(adminsOnly).Post("/import", product.Import())
+		r.With(adminsOnly).Get("/import/{job_id}", product.ImportJob())
+		r.Get("/search", product.Search())
 		r.Get("/search/{query}", product.Search())
+		r.With(requireLogin).Get("/price-drops", product.PriceDrops())
+
+		r.Route("/{id}/variants", func(r chi.Router) {
+			r.Get("/", product.GetVariants())
+			r.With(productStaff).Post("/create", product.CreateVariant())
+			r.With(productStaff).Put("/{variant_id}", product.UpdateVariant())
+			r.With(productStaff).Delete("/{variant_id}", product.DeleteVariant())
+		})
+
+		r.Route("/{id}/images", func(r chi.Router) {
+			r.Get("/", images.Get(media.Products))
+			r.With(productStaff).Post("/upload", images.Upload(media.Products))
+			r.With(productStaff).Put("/", images.Reorder(media.Products))
+			r.With(productStaff).Delete("/{image_id}", images.Delete(media.Products))
+		})
 	})
 
 	// Review
@@ -121,31 +231,78 @@ func NewRouter(config config.Config, db *sqlx.DB, mc *memcache.Client, rdb *redi
 	router.Route("/reviews", func(r chi.Router) {
 		r.Get("/", review.Get())
 		r.Get("/{id}", review.GetByID())
-		r.With(adminsOnly).Delete("/{id}", review.Delete())
+		r.With(requireLogin).Put("/{id}", review.Update())
+		r.With(requireLogin).Delete("/{id}", review.Delete())
 		r.With(requireLogin).Post("/create", review.Create())
+
+		r.Route("/moderation", func(r chi.Router) {
+			r.Use(adminsOnly)
+
+			r.Get("/", review.Queue())
+			r.Put("/{id}", review.Moderate())
+			r.Delete("/{id}", review.Remove())
+		})
+	})
+
+	// Shipping
+	shipping := shipping.NewHandler(shippingService)
+	router.Route("/shipping", func(r chi.Router) {
+		r.Use(adminsOnly)
+
+		r.Get("/zones", shipping.GetZones())
+		r.Post("/zones", shipping.CreateZone())
+		r.Delete("/zones/{id}", shipping.DeleteZone())
+		r.Post("/zones/{id}/rates", shipping.CreateRate())
+		r.Delete("/rates/{rate_id}", shipping.DeleteRate())
 	})
 
 	// Shop
-	shop := shop.NewHandler(shopService, mc)
+	shop := shop.NewHandler(config.Development, shopService, memberService, emailer, mc)
 	router.Route("/shops", func(r chi.Router) {
 		r.Get("/", shop.Get())
 		r.Get("/{id}", shop.GetByID())
-		r.With(adminsOnly).Delete("/{id}", shop.Delete())
-		r.With(adminsOnly).Put("/{id}", shop.Update())
-		r.With(adminsOnly).Post("/create", shop.Create())
+		r.With(shopOwner).Delete("/{id}", shop.Delete())
+		r.With(shopManager).Put("/{id}", shop.Update())
+		r.With(shopManager).Put("/{id}/location", shop.UpdateLocation())
+		r.With(requireLogin).Post("/create", shop.Create())
 		r.Get("/search/{query}", shop.Search())
+		r.With(requireLogin).Get("/invitations/{token}/accept", shop.AcceptInvitation())
+		r.With(shopStaff).Post("/{id}/products", product.CreateInShop())
+
+		r.Route("/{id}/images", func(r chi.Router) {
+			r.Get("/", images.Get(media.Shops))
+			r.With(shopManager).Post("/upload", images.Upload(media.Shops))
+			r.With(shopManager).Put("/", images.Reorder(media.Shops))
+			r.With(shopManager).Delete("/{image_id}", images.Delete(media.Shops))
+		})
+
+		r.Route("/{id}/members", func(r chi.Router) {
+			r.With(shopStaff).Get("/", shop.Members())
+			r.With(shopOwner).Post("/invite", shop.Invite())
+			r.With(shopOwner).Put("/{user_id}", shop.UpdateMemberRole())
+			r.With(shopOwner).Delete("/{user_id}", shop.RemoveMember())
+		})
+
+		// Members only see the products and shipment of their shop in each order
+		r.With(shopStaff).Get("/{id}/orders", order.GetByShopID())
+		r.With(shopStaff).Put("/{id}/shipments/{shipment_id}", order.UpdateShipment())
 	})
 
 	// Stripe
-	stripe := stripe.NewHandler()
+	stripe := stripe.NewHandler(db, config.Stripe.WebhookSecret)
 	router.Route("/stripe", func(r chi.Router) {
-		r.Use(adminsOnly)
+		// Stripe requests are authenticated by their signature
+		r.Post("/webhook", stripe.Webhook(orderingService.ProcessPaymentEvent))
 
-		r.Get("/balance", stripe.GetBalance())
-		r.Get("/event/{event}", stripe.GetEvent())
-		r.Get("/transactions/{txID}", stripe.GetTxBalance())
-		r.Get("/events", stripe.ListEvents())
-		r.Get("/transactions", stripe.ListTxs())
+		r.Group(func(r chi.Router) {
+			r.Use(adminsOnly)
+
+			r.Get("/balance", stripe.GetBalance())
+			r.Get("/event/{event}", stripe.GetEvent())
+			r.Get("/transactions/{txID}", stripe.GetTxBalance())
+			r.Get("/events", stripe.ListEvents())
+			r.Get("/transactions", stripe.ListTxs())
+		})
 	})
 
 	// Tracking
@@ -160,6 +317,7 @@ func NewRouter(config config.Config, db *sqlx.DB, mc *memcache.Client, rdb *redi
 	})
 
 	// User
+	addresses := address.NewHandler(addressService)
 	user := user.NewHandler(config.Development, userService, cartService, emailer, mc)
 	router.Route("/users", func(r chi.Router) {
 		r.Get("/", user.Get())
@@ -170,12 +328,40 @@ func NewRouter(config config.Config, db *sqlx.DB, mc *memcache.Client, rdb *redi
 		r.Get("/username/{username}", user.GetByUsername())
 		r.Post("/create", user.Create())
 		r.Get("/search/{query}", user.Search())
+
+		r.Route("/{id}/addresses", func(r chi.Router) {
+			r.Use(requireLogin)
+
+			r.Get("/", addresses.Get())
+			r.Post("/", addresses.Create())
+			r.Get("/{address_id}", addresses.GetByID())
+			r.Put("/{address_id}", addresses.Update())
+			r.Delete("/{address_id}", addresses.Delete())
+		})
+	})
+
+	// Wishlists
+	wishlist := wishlist.NewHandler(wishlistService)
+	router.Route("/wishlists", func(r chi.Router) {
+		r.With(requireLogin).Get("/", wishlist.Get())
+		r.With(requireLogin).Post("/create", wishlist.Create())
+		r.Get("/shared/{token}", wishlist.GetShared())
+		r.With(requireLogin).Get("/{id}", wishlist.GetByID())
+		r.With(requireLogin).Delete("/{id}", wishlist.Delete())
+		r.With(requireLogin).Post("/{id}/add", wishlist.AddItem())
+		r.With(requireLogin).Delete("/{id}/remove/{product_id}", wishlist.RemoveItem())
+		r.With(requireLogin).Post("/{id}/from-cart/{product_id}", wishlist.MoveFromCart())
+		r.With(requireLogin).Post("/{id}/to-cart/{product_id}", wishlist.MoveToCart())
+		r.With(requireLogin).Post("/{id}/share", wishlist.Share())
+		r.With(requireLogin).Delete("/{id}/share", wishlist.Unshare())
 	})
 
 	// Account
 	account := account.NewHandler(accountService, userService, emailer)
 	router.With(requireLogin).Post("/settings/email", account.SendChangeConfirmation())
-	router.With(requireLogin).Post("/settings/password", account.ChangePassword())
+	router.With(requireLogin).Post("/settings/password", account.ChangePassword(session))
+	router.Post("/password/forgot", account.ForgotPassword())
+	router.Post("/password/reset", account.ResetPassword(session))
 	router.Get("/verification/{email}/{token}", account.SendEmailValidation(userService))
 	router.Get("/verification/{token}/{email}/{id}", account.ChangeEmail())
 
@@ -0,0 +1,225 @@
+package media
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/response"
+	"github.com/GGP1/adak/internal/validate"
+	"github.com/GGP1/adak/pkg/media/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// multipartOverhead is the space given to the form boundaries and headers on top of the file size limit.
+const multipartOverhead = 1 << 20
+
+type reorderRequest struct {
+	IDs []string `json:"ids" validate:"required,dive,uuid4_rfc4122"`
+}
+
+// Handler handles media endpoints.
+type Handler struct {
+	service Service
+	// Maximum size of the uploaded files in bytes
+	maxSize int64
+}
+
+// NewHandler returns a new media handler.
+func NewHandler(service Service, maxSize int64) Handler {
+	return Handler{
+		service: service,
+		maxSize: maxSize,
+	}
+}
+
+// Delete removes an image from the owner gallery.
+func (h *Handler) Delete(owner Owner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		ownerID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		imageID := chi.URLParam(r, "image_id")
+		if err := validate.UUID(imageID); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.Delete(ctx, owner, ownerID, imageID); err != nil {
+			var notFound *NotFoundError
+			if errors.As(err, &notFound) {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, imageID)
+	}
+}
+
+// Get lists the images of the owner gallery.
+func (h *Handler) Get(owner Owner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		ownerID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		images, err := h.service.Get(ctx, owner, ownerID)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, images)
+	}
+}
+
+// Reorder changes the order of the owner gallery images.
+func (h *Handler) Reorder(owner Owner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		ownerID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var req reorderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, req); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.Reorder(ctx, owner, ownerID, req.IDs); err != nil {
+			var orderErr *OrderError
+			if errors.As(err, &orderErr) {
+				response.Error(w, http.StatusBadRequest, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		images, err := h.service.Get(ctx, owner, ownerID)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, images)
+	}
+}
+
+// Serve responds with the stored file requested.
+//
+// Files are never modified once saved, so clients are allowed to cache them indefinitely.
+func (h *Handler) Serve() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		obj, err := h.service.Open(ctx, chi.URLParam(r, "*"))
+		if err != nil {
+			if errors.Cause(err) == storage.ErrNotFound {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer obj.Close()
+
+		info := obj.Info()
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size))
+		// ServeContent handles the conditional and range requests
+		http.ServeContent(w, r, path.Base(info.Key), info.ModTime, obj)
+	}
+}
+
+// Upload adds the image sent in the "image" field of a multipart form to the end of the owner gallery.
+func (h *Handler) Upload(owner Owner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		ownerID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxSize+multipartOverhead)
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			if err.Error() == "http: request body too large" {
+				response.Error(w, http.StatusRequestEntityTooLarge, err)
+				return
+			}
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, h.maxSize+1))
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		if int64(len(data)) > h.maxSize {
+			response.Error(w, http.StatusRequestEntityTooLarge,
+				errors.Errorf("the image exceeds the maximum size of %d bytes", h.maxSize))
+			return
+		}
+
+		// The content type declared by the client is ignored, it's sniffed from the content instead
+		if contentType := http.DetectContentType(data); !Supported(contentType) {
+			response.Error(w, http.StatusUnsupportedMediaType,
+				errors.Errorf("unsupported content type %q, use jpeg, png or gif", contentType))
+			return
+		}
+		if err := CheckDimensions(data); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		img := Image{
+			ID:        uuid.NewString(),
+			CreatedAt: time.Now(),
+		}
+		img, err = h.service.Upload(ctx, owner, ownerID, img, data)
+		if err != nil {
+			var notFound *NotFoundError
+			if errors.As(err, &notFound) {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, img)
+	}
+}
@@ -0,0 +1,174 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/pkg/errors"
+)
+
+// Original is the name used to refer to the file uploaded.
+const Original = "original"
+
+// maxPixels limits the dimensions of the images so decoding them doesn't exhaust the memory.
+const maxPixels = 40_000_000
+
+// Size is a thumbnail size, the images are scaled down to fit in a square of Max pixels per side.
+type Size struct {
+	Name string
+	Max  int
+}
+
+// Sizes are the thumbnails generated for every image.
+var Sizes = []Size{
+	{Name: "small", Max: 160},
+	{Name: "medium", Max: 480},
+	{Name: "large", Max: 1200},
+}
+
+// extensions maps the content types supported to the extension of their files.
+var extensions = map[string]string{
+	"image/gif":  "gif",
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+}
+
+// thumbnailExtension returns the extension of the thumbnails of an image with the content type provided.
+//
+// JPEG thumbnails are smaller, the other formats are converted to PNG to keep their transparency.
+func thumbnailExtension(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "jpg"
+	}
+	return "png"
+}
+
+// Supported returns whether images with the content type provided can be uploaded.
+func Supported(contentType string) bool {
+	_, ok := extensions[contentType]
+	return ok
+}
+
+// CheckDimensions makes sure the image is not too big to be processed without decoding it entirely.
+func CheckDimensions(data []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "invalid image")
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return errors.Errorf("image dimensions (%dx%d) exceed the maximum of %d pixels",
+			cfg.Width, cfg.Height, maxPixels)
+	}
+	return nil
+}
+
+// Resize scales img down to fit in a square of max pixels per side keeping its aspect ratio,
+// images that already fit are returned unchanged.
+//
+// Each pixel of the result is the average of the area of the original it covers.
+func Resize(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+
+	dw, dh := max, max
+	if w > h {
+		dh = h * max / w
+	} else {
+		dw = w * max / h
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	src := toRGBA(img)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, (y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, (x+1)*w/dw
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(src.Pix[i])
+					g += uint64(src.Pix[i+1])
+					b += uint64(src.Pix[i+2])
+					a += uint64(src.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n),
+				G: uint8(g / n),
+				B: uint8(b / n),
+				A: uint8(a / n),
+			})
+		}
+	}
+
+	return dst
+}
+
+// toRGBA returns img as an RGBA image with its bounds starting at the origin.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
+
+// decode returns the image and the content of its thumbnails, keyed by size name.
+func decode(data []byte, contentType string) (image.Image, map[string][]byte, error) {
+	var (
+		img image.Image
+		err error
+	)
+	switch contentType {
+	case "image/gif":
+		// Only the first frame of animated images is used
+		img, err = gif.Decode(bytes.NewReader(data))
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	default:
+		return nil, nil, errors.Errorf("unsupported content type %q", contentType)
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "couldn't decode the image")
+	}
+
+	thumbnails := make(map[string][]byte, len(Sizes))
+	for _, size := range Sizes {
+		buf := new(bytes.Buffer)
+		thumbnail := Resize(img, size.Max)
+		if thumbnailExtension(contentType) == "jpg" {
+			err = jpeg.Encode(buf, thumbnail, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(buf, thumbnail)
+		}
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "couldn't encode the %s thumbnail", size.Name)
+		}
+		thumbnails[size.Name] = buf.Bytes()
+	}
+
+	return img, thumbnails, nil
+}
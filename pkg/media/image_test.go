@@ -0,0 +1,114 @@
+package media_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/GGP1/adak/pkg/media"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResize(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		width, height  int
+		max            int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{desc: "Landscape", width: 800, height: 400, max: 200, expectedWidth: 200, expectedHeight: 100},
+		{desc: "Portrait", width: 300, height: 900, max: 300, expectedWidth: 100, expectedHeight: 300},
+		{desc: "Square", width: 500, height: 500, max: 160, expectedWidth: 160, expectedHeight: 160},
+		{desc: "Thin", width: 1000, height: 2, max: 100, expectedWidth: 100, expectedHeight: 1},
+		{desc: "Fits", width: 100, height: 50, max: 160, expectedWidth: 100, expectedHeight: 50},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, tc.width, tc.height))
+			got := media.Resize(img, tc.max)
+
+			assert.Equal(t, tc.expectedWidth, got.Bounds().Dx())
+			assert.Equal(t, tc.expectedHeight, got.Bounds().Dy())
+		})
+	}
+}
+
+func TestResizeAverage(t *testing.T) {
+	// Vertical stripes of black and white pixels become gray
+	img := image.NewGray(image.Rect(10, 10, 410, 210))
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x += 2 {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	got := media.Resize(img, 100)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), got.Bounds())
+
+	r, g, b, a := got.At(50, 25).RGBA()
+	assert.Equal(t, uint32(127), r>>8)
+	assert.Equal(t, r, g)
+	assert.Equal(t, r, b)
+	assert.Equal(t, uint32(0xffff), a)
+}
+
+func TestSupported(t *testing.T) {
+	for _, contentType := range []string{"image/jpeg", "image/png", "image/gif"} {
+		assert.True(t, media.Supported(contentType), contentType)
+	}
+	for _, contentType := range []string{"image/webp", "image/svg+xml", "text/plain; charset=utf-8", ""} {
+		assert.False(t, media.Supported(contentType), contentType)
+	}
+}
+
+func TestCheckDimensions(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		assert.NoError(t, media.CheckDimensions(encodePNG(t, 64, 32)))
+	})
+
+	t.Run("Too big", func(t *testing.T) {
+		// Only the header is decoded, the data can be truncated
+		data := encodePNG(t, 8000, 8000)
+		assert.Error(t, media.CheckDimensions(data[:64]))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		assert.Error(t, media.CheckDimensions([]byte("not an image")))
+	})
+}
+
+func TestImageKeys(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		original    string
+		thumbnail   string
+	}{
+		{contentType: "image/jpeg", original: "images/1/original.jpg", thumbnail: "images/1/small.jpg"},
+		{contentType: "image/png", original: "images/1/original.png", thumbnail: "images/1/small.png"},
+		{contentType: "image/gif", original: "images/1/original.gif", thumbnail: "images/1/small.png"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.contentType, func(t *testing.T) {
+			img := media.Image{ID: "1", ContentType: tc.contentType}
+			keys := img.Keys()
+
+			assert.Len(t, keys, len(media.Sizes)+1)
+			assert.Equal(t, tc.original, keys[media.Original])
+			assert.Equal(t, tc.thumbnail, keys["small"])
+		})
+	}
+}
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	assert.NoError(t, png.Encode(buf, img))
+	return buf.Bytes()
+}
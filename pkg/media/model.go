@@ -0,0 +1,122 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// URLPrefix is the path the stored files are served under.
+const URLPrefix = "/media/"
+
+// Owner is the kind of entity an image gallery belongs to.
+type Owner string
+
+// Gallery owners.
+const (
+	Products Owner = "products"
+	Shops    Owner = "shops"
+)
+
+// column returns the images column referencing the owner.
+func (o Owner) column() string {
+	if o == Shops {
+		return "shop_id"
+	}
+	return "product_id"
+}
+
+// Image is a picture of a product or shop gallery.
+//
+// Besides the original file, a thumbnail is stored for each one of the Sizes.
+type Image struct {
+	ID        string      `json:"id"`
+	ProductID zero.String `json:"product_id,omitempty" db:"product_id"`
+	ShopID    zero.String `json:"shop_id,omitempty" db:"shop_id"`
+	// Position of the image in the gallery, starting from zero
+	Position    int64     `json:"position"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Width       int64     `json:"width"`
+	Height      int64     `json:"height"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	// URLs of the original file and thumbnails, keyed by size name
+	URLs map[string]string `json:"urls,omitempty" db:"-"`
+}
+
+// Keys returns the storage keys of the image files, keyed by size name.
+func (img *Image) Keys() map[string]string {
+	keys := make(map[string]string, len(Sizes)+1)
+	keys[Original] = fmt.Sprintf("images/%s/%s.%s", img.ID, Original, extensions[img.ContentType])
+
+	ext := thumbnailExtension(img.ContentType)
+	for _, size := range Sizes {
+		keys[size.Name] = fmt.Sprintf("images/%s/%s.%s", img.ID, size.Name, ext)
+	}
+
+	return keys
+}
+
+func (img *Image) setURLs() {
+	keys := img.Keys()
+	img.URLs = make(map[string]string, len(keys))
+	for name, key := range keys {
+		img.URLs[name] = URLPrefix + key
+	}
+}
+
+// Galleries returns the images of the owners specified ordered by position, keyed by owner ID.
+func Galleries(ctx context.Context, db sqlx.QueryerContext, owner Owner, ownerIDs ...string) (map[string][]Image, error) {
+	galleries := make(map[string][]Image, len(ownerIDs))
+	if len(ownerIDs) == 0 {
+		return galleries, nil
+	}
+
+	var images []Image
+	q := fmt.Sprintf("SELECT * FROM images WHERE %s=ANY($1) ORDER BY position", owner.column())
+	if err := sqlx.SelectContext(ctx, db, &images, q, pq.Array(ownerIDs)); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the images")
+	}
+
+	for _, img := range images {
+		img.setURLs()
+		ownerID := img.ProductID.String
+		if owner == Shops {
+			ownerID = img.ShopID.String
+		}
+		galleries[ownerID] = append(galleries[ownerID], img)
+	}
+
+	return galleries, nil
+}
+
+// NotFoundError is returned when the gallery owner or the image requested don't exist.
+type NotFoundError struct {
+	Owner   Owner
+	OwnerID string
+	ImageID string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.ImageID != "" {
+		return fmt.Sprintf("image %q not found in %s %q gallery", e.ImageID, strings.TrimSuffix(string(e.Owner), "s"), e.OwnerID)
+	}
+	return fmt.Sprintf("%s %q not found", strings.TrimSuffix(string(e.Owner), "s"), e.OwnerID)
+}
+
+// OrderError is returned when the order of a gallery doesn't contain each one of its images exactly once.
+type OrderError struct {
+	Images int
+	IDs    []string
+}
+
+func (e *OrderError) Error() string {
+	return fmt.Sprintf("invalid order: expected each one of the %d images of the gallery exactly once, got %d ids",
+		e.Images, len(e.IDs))
+}
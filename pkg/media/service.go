@@ -0,0 +1,221 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/GGP1/adak/pkg/media/storage"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// Service provides media operations.
+type Service interface {
+	Delete(ctx context.Context, owner Owner, ownerID, id string) error
+	Get(ctx context.Context, owner Owner, ownerID string) ([]Image, error)
+	Open(ctx context.Context, key string) (storage.Object, error)
+	Reorder(ctx context.Context, owner Owner, ownerID string, ids []string) error
+	Upload(ctx context.Context, owner Owner, ownerID string, img Image, data []byte) (Image, error)
+}
+
+type service struct {
+	db      *sqlx.DB
+	mc      *memcache.Client
+	storage storage.Storage
+	metrics metrics
+}
+
+// NewService returns a new media service.
+func NewService(db *sqlx.DB, mc *memcache.Client, storage storage.Storage) Service {
+	return &service{db, mc, storage, initMetrics()}
+}
+
+// Delete removes an image from the gallery and its files from the storage.
+func (s *service) Delete(ctx context.Context, owner Owner, ownerID, id string) error {
+	s.metrics.incMethodCalls("Delete")
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var img Image
+	q := fmt.Sprintf("DELETE FROM images WHERE id=$1 AND %s=$2 RETURNING *", owner.column())
+	if err := tx.GetContext(ctx, &img, q, id, ownerID); err != nil {
+		if err == sql.ErrNoRows {
+			return &NotFoundError{Owner: owner, OwnerID: ownerID, ImageID: id}
+		}
+		return errors.Wrap(err, "couldn't delete the image")
+	}
+
+	// Close the gap left in the gallery
+	q = fmt.Sprintf("UPDATE images SET position=position-1 WHERE %s=$1 AND position > $2", owner.column())
+	if _, err := tx.ExecContext(ctx, q, ownerID, img.Position); err != nil {
+		return errors.Wrap(err, "couldn't update the images positions")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	keys := make([]string, 0, len(Sizes)+1)
+	for _, key := range img.Keys() {
+		keys = append(keys, key)
+	}
+	if err := s.storage.Delete(ctx, keys...); err != nil {
+		return errors.Wrap(err, "couldn't delete the image files")
+	}
+
+	return s.deleteCache(ownerID)
+}
+
+// Get returns the gallery of the owner specified.
+func (s *service) Get(ctx context.Context, owner Owner, ownerID string) ([]Image, error) {
+	s.metrics.incMethodCalls("Get")
+
+	galleries, err := Galleries(ctx, s.db, owner, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return galleries[ownerID], nil
+}
+
+// Open returns the stored file with the key provided.
+func (s *service) Open(ctx context.Context, key string) (storage.Object, error) {
+	s.metrics.incMethodCalls("Open")
+	return s.storage.Open(ctx, key)
+}
+
+// Reorder sets the position of the gallery images to the one of their ids in the list.
+func (s *service) Reorder(ctx context.Context, owner Owner, ownerID string, ids []string) error {
+	s.metrics.incMethodCalls("Reorder")
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var current []string
+	q := fmt.Sprintf("SELECT id FROM images WHERE %s=$1 FOR UPDATE", owner.column())
+	if err := tx.SelectContext(ctx, &current, q, ownerID); err != nil {
+		return errors.Wrap(err, "couldn't find the images")
+	}
+
+	if len(ids) != len(current) {
+		return &OrderError{Images: len(current), IDs: ids}
+	}
+	images := make(map[string]bool, len(current))
+	for _, id := range current {
+		images[id] = true
+	}
+	for _, id := range ids {
+		if !images[id] {
+			return &OrderError{Images: len(current), IDs: ids}
+		}
+		// Repeated ids are caught when they are found a second time
+		delete(images, id)
+	}
+
+	q = "UPDATE images SET position=$2 WHERE id=$1"
+	for i, id := range ids {
+		if _, err := tx.ExecContext(ctx, q, id, i); err != nil {
+			return errors.Wrap(err, "couldn't update the image position")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return s.deleteCache(ownerID)
+}
+
+// Upload stores the image and its thumbnails and appends it to the end of the owner gallery.
+func (s *service) Upload(ctx context.Context, owner Owner, ownerID string, img Image, data []byte) (Image, error) {
+	s.metrics.incMethodCalls("Upload")
+
+	var exists bool
+	q := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE id=$1)", owner)
+	if err := s.db.GetContext(ctx, &exists, q, ownerID); err != nil {
+		return Image{}, errors.Wrap(err, "couldn't check the gallery owner")
+	}
+	if !exists {
+		return Image{}, &NotFoundError{Owner: owner, OwnerID: ownerID}
+	}
+
+	img.ContentType = http.DetectContentType(data)
+	decoded, thumbnails, err := decode(data, img.ContentType)
+	if err != nil {
+		return Image{}, err
+	}
+	img.Width = int64(decoded.Bounds().Dx())
+	img.Height = int64(decoded.Bounds().Dy())
+	img.Size = int64(len(data))
+	if owner == Shops {
+		img.ShopID = zero.StringFrom(ownerID)
+	} else {
+		img.ProductID = zero.StringFrom(ownerID)
+	}
+
+	keys := img.Keys()
+	if err := s.store(ctx, keys, data, thumbnails); err != nil {
+		return Image{}, err
+	}
+
+	q = fmt.Sprintf(`INSERT INTO images
+	(id, product_id, shop_id, position, content_type, width, height, size, created_at)
+	SELECT $1, $2, $3, COALESCE(MAX(position) + 1, 0), $4, $5, $6, $7, $8
+	FROM images WHERE %s=$9
+	RETURNING position`, owner.column())
+	err = s.db.GetContext(ctx, &img.Position, q, img.ID, img.ProductID, img.ShopID,
+		img.ContentType, img.Width, img.Height, img.Size, img.CreatedAt, ownerID)
+	if err != nil {
+		for _, key := range keys {
+			s.storage.Delete(ctx, key)
+		}
+		return Image{}, errors.Wrap(err, "couldn't save the image")
+	}
+
+	img.setURLs()
+	if err := s.deleteCache(ownerID); err != nil {
+		return Image{}, err
+	}
+
+	return img, nil
+}
+
+// store saves the original file and the thumbnails, on failure the ones already saved are removed.
+func (s *service) store(ctx context.Context, keys map[string]string, original []byte, thumbnails map[string][]byte) error {
+	files := map[string][]byte{Original: original}
+	for name, content := range thumbnails {
+		files[name] = content
+	}
+
+	stored := make([]string, 0, len(files))
+	for name, content := range files {
+		if err := s.storage.Put(ctx, keys[name], bytes.NewReader(content)); err != nil {
+			s.storage.Delete(ctx, stored...)
+			return errors.Wrapf(err, "couldn't store the %s file", name)
+		}
+		stored = append(stored, keys[name])
+	}
+
+	return nil
+}
+
+// deleteCache removes the owner from the cache so its responses include the changes made to the gallery.
+func (s *service) deleteCache(ownerID string) error {
+	if err := s.mc.Delete(ownerID); err != nil && err != memcache.ErrCacheMiss {
+		return errors.Wrap(err, "deleting cached owner")
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package media_test
+
+import (
+	"context"
+	"image"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/media"
+	"github.com/GGP1/adak/pkg/media/storage"
+	"github.com/GGP1/adak/pkg/media/storage/local"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	productID = "1"
+	shopID    = "2"
+)
+
+func NewMediaService(t *testing.T) (context.Context, media.Service) {
+	t.Helper()
+	logger.Disable()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	db := test.StartPostgres(t)
+	mc := test.StartMemcached(t)
+	service := media.NewService(db, mc, local.NewStorage(t.TempDir()))
+	createRelationships(ctx, t, db)
+
+	t.Cleanup(func() {
+		cancel()
+	})
+
+	return ctx, service
+}
+
+func TestMediaService(t *testing.T) {
+	ctx, s := NewMediaService(t)
+
+	t.Run("Upload", upload(ctx, s))
+	t.Run("Reorder", reorder(ctx, s))
+	t.Run("Delete", deleteImage(ctx, s))
+}
+
+func upload(ctx context.Context, s media.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		img, err := s.Upload(ctx, media.Products, productID, newImage(), encodePNG(t, 1600, 800))
+		assert.NoError(t, err)
+		assert.Equal(t, "image/png", img.ContentType)
+		assert.Equal(t, int64(1600), img.Width)
+		assert.Equal(t, int64(800), img.Height)
+		assert.Equal(t, int64(0), img.Position)
+		assert.Equal(t, productID, img.ProductID.String)
+
+		for name, key := range img.Keys() {
+			assert.Equal(t, media.URLPrefix+key, img.URLs[name])
+
+			obj, err := s.Open(ctx, key)
+			assert.NoError(t, err)
+			if name == "small" {
+				thumbnail, _, err := image.Decode(obj)
+				assert.NoError(t, err)
+				assert.Equal(t, image.Rect(0, 0, 160, 80), thumbnail.Bounds())
+			}
+			obj.Close()
+		}
+
+		img, err = s.Upload(ctx, media.Products, productID, newImage(), encodePNG(t, 10, 10))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), img.Position)
+
+		_, err = s.Upload(ctx, media.Shops, shopID, newImage(), encodePNG(t, 10, 10))
+		assert.NoError(t, err)
+
+		var notFound *media.NotFoundError
+		_, err = s.Upload(ctx, media.Shops, productID, newImage(), encodePNG(t, 10, 10))
+		assert.ErrorAs(t, err, &notFound)
+		_, err = s.Upload(ctx, media.Products, productID, newImage(), []byte("not an image"))
+		assert.Error(t, err)
+
+		images, err := s.Get(ctx, media.Products, productID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(images))
+		images, err = s.Get(ctx, media.Shops, shopID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(images))
+	}
+}
+
+func reorder(ctx context.Context, s media.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		images, err := s.Get(ctx, media.Products, productID)
+		assert.NoError(t, err)
+		first, second := images[0].ID, images[1].ID
+
+		var orderErr *media.OrderError
+		err = s.Reorder(ctx, media.Products, productID, []string{second})
+		assert.ErrorAs(t, err, &orderErr)
+		err = s.Reorder(ctx, media.Products, productID, []string{second, second})
+		assert.ErrorAs(t, err, &orderErr)
+
+		assert.NoError(t, s.Reorder(ctx, media.Products, productID, []string{second, first}))
+
+		images, err = s.Get(ctx, media.Products, productID)
+		assert.NoError(t, err)
+		assert.Equal(t, second, images[0].ID)
+		assert.Equal(t, first, images[1].ID)
+	}
+}
+
+func deleteImage(ctx context.Context, s media.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		images, err := s.Get(ctx, media.Products, productID)
+		assert.NoError(t, err)
+
+		var notFound *media.NotFoundError
+		err = s.Delete(ctx, media.Shops, shopID, images[0].ID)
+		assert.ErrorAs(t, err, &notFound)
+
+		assert.NoError(t, s.Delete(ctx, media.Products, productID, images[0].ID))
+
+		_, err = s.Open(ctx, images[0].Keys()[media.Original])
+		assert.Equal(t, storage.ErrNotFound, err)
+
+		left, err := s.Get(ctx, media.Products, productID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(left))
+		assert.Equal(t, images[1].ID, left[0].ID)
+		assert.Equal(t, int64(0), left[0].Position)
+
+		obj, err := s.Open(ctx, left[0].Keys()[media.Original])
+		assert.NoError(t, err)
+		_, err = io.Copy(io.Discard, obj)
+		assert.NoError(t, err)
+		obj.Close()
+	}
+}
+
+func newImage() media.Image {
+	return media.Image{ID: uuid.NewString(), CreatedAt: time.Now()}
+}
+
+func createRelationships(ctx context.Context, t *testing.T, db *sqlx.DB) {
+	t.Helper()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO shops (id, name) VALUES ($1, 'shop')", shopID)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO products
+	(id, shop_id, stock, brand, category, type, weight, subtotal, total)
+	VALUES ($1, $2, 10, 'brand', 'category', 'type', 1, 1000, 1000)`, productID, shopID)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,134 @@
+// Package local implements a storage backend on top of the local file system.
+package local
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/GGP1/adak/pkg/media/storage"
+
+	"github.com/pkg/errors"
+)
+
+type local struct {
+	dir string
+}
+
+type object struct {
+	*os.File
+	info storage.Info
+}
+
+func (o object) Info() storage.Info {
+	return o.info
+}
+
+// NewStorage returns a storage that keeps the files inside dir, it's created when the first file is saved.
+func NewStorage(dir string) storage.Storage {
+	return &local{dir: filepath.Clean(dir)}
+}
+
+// Delete removes the files stored under the keys, missing ones are ignored.
+func (l *local) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		name, err := l.path(key)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "deleting %q", key)
+		}
+		// Remove the parent directories left empty, errors mean they still have content
+		for dir := filepath.Dir(name); dir != l.dir && strings.HasPrefix(dir, l.dir); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Open returns the file stored under key.
+func (l *local) Open(ctx context.Context, key string) (storage.Object, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "opening %q", key)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "reading %q information", key)
+	}
+	if stat.IsDir() {
+		f.Close()
+		return nil, storage.ErrNotFound
+	}
+
+	info := storage.Info{
+		Key:     key,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}
+	return object{File: f, info: info}, nil
+}
+
+// Put writes the content of r into the file with the key specified, replacing it if it already exists.
+//
+// The content is written to a temporary file first so readers never see a partial file.
+func (l *local) Put(ctx context.Context, key string, r io.Reader) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "creating %q directory", key)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "writing %q", key)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "writing %q", key)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return errors.Wrapf(err, "writing %q", key)
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return errors.Wrapf(err, "saving %q", key)
+	}
+
+	return nil
+}
+
+// path returns the location of the file with the key provided, making sure it is inside the storage directory.
+func (l *local) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "\\") {
+		return "", errors.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(clean)), nil
+}
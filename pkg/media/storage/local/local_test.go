@@ -0,0 +1,79 @@
+package local_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GGP1/adak/pkg/media/storage"
+	"github.com/GGP1/adak/pkg/media/storage/local"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := local.NewStorage(dir)
+
+	key := "images/1/original.png"
+	content := "content"
+
+	t.Run("Put", func(t *testing.T) {
+		assert.NoError(t, s.Put(ctx, key, strings.NewReader(content)))
+		assert.FileExists(t, filepath.Join(dir, "images", "1", "original.png"))
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		obj, err := s.Open(ctx, key)
+		assert.NoError(t, err)
+		defer obj.Close()
+
+		got, err := io.ReadAll(obj)
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(got))
+		assert.Equal(t, key, obj.Info().Key)
+		assert.Equal(t, int64(len(content)), obj.Info().Size)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		assert.NoError(t, s.Put(ctx, key, strings.NewReader("new")))
+
+		obj, err := s.Open(ctx, key)
+		assert.NoError(t, err)
+		defer obj.Close()
+		assert.Equal(t, int64(3), obj.Info().Size)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, s.Delete(ctx, key, "images/1/missing.png"))
+
+		_, err := s.Open(ctx, key)
+		assert.Equal(t, storage.ErrNotFound, err)
+		assert.NoDirExists(t, filepath.Join(dir, "images"))
+		assert.DirExists(t, dir)
+	})
+}
+
+func TestStorageKeys(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "media")
+	s := local.NewStorage(dir)
+
+	// Keys can't escape the storage directory
+	assert.NoError(t, s.Put(ctx, "../../outside.txt", strings.NewReader("content")))
+	assert.FileExists(t, filepath.Join(dir, "outside.txt"))
+	_, err := os.Stat(filepath.Join(parent, "outside.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	for _, key := range []string{"", "/", "..", `images\1`} {
+		assert.Error(t, s.Put(ctx, key, strings.NewReader("content")), key)
+	}
+
+	_, err = s.Open(ctx, "images")
+	assert.Equal(t, storage.ErrNotFound, err)
+}
@@ -0,0 +1,34 @@
+// Package storage defines the operations the media storage backends must support.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when there is no object stored under the key requested.
+var ErrNotFound = errors.New("object not found")
+
+// Storage is a backend where the uploaded files are persisted.
+//
+// Keys are slash-separated paths like "images/<id>/original.png".
+type Storage interface {
+	Delete(ctx context.Context, keys ...string) error
+	Open(ctx context.Context, key string) (Object, error)
+	Put(ctx context.Context, key string, r io.Reader) error
+}
+
+// Object is a stored file opened for reading.
+type Object interface {
+	io.ReadSeekCloser
+	Info() Info
+}
+
+// Info describes a stored object.
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
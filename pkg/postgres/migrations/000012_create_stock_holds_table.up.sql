@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS stock_holds
+(
+    cart_id text NOT NULL,
+    product_id text NOT NULL,
+    quantity integer NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    CONSTRAINT stock_holds_pkey PRIMARY KEY (cart_id, product_id),
+    FOREIGN KEY (cart_id) REFERENCES carts (id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ON stock_holds (expires_at);
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS order_status_history
+(
+    order_id text NOT NULL,
+    from_status integer,
+    to_status integer NOT NULL,
+    changed_by text,
+    changed_at timestamp with time zone DEFAULT NOW(),
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ON order_status_history (order_id);
\ No newline at end of file
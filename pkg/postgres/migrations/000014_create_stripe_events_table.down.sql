@@ -0,0 +1 @@
+DROP TABLE IF EXISTS stripe_events;
\ No newline at end of file
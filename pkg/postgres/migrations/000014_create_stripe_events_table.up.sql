@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS stripe_events
+(
+    id text NOT NULL,
+    type text NOT NULL,
+    received_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT stripe_events_pkey PRIMARY KEY (id)
+);
\ No newline at end of file
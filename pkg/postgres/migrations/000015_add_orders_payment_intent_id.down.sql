@@ -0,0 +1 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS payment_intent_id;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_intent_id text;
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS order_refunds
+(
+    id text NOT NULL,
+    order_id text NOT NULL,
+    refund_id text NOT NULL,
+    product_id text NOT NULL,
+    quantity integer NOT NULL,
+    amount integer NOT NULL,
+    refunded_by text,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT order_refunds_pkey PRIMARY KEY (id),
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ON order_refunds (order_id);
\ No newline at end of file
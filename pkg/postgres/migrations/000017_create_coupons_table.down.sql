@@ -0,0 +1 @@
+DROP TABLE IF EXISTS coupons;
\ No newline at end of file
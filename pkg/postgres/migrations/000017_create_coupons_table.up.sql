@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS coupons
+(
+    code text NOT NULL,
+    kind text NOT NULL,
+    value integer NOT NULL DEFAULT 0,
+    buy_quantity integer,
+    get_quantity integer,
+    shop_id text,
+    category text,
+    starts_at timestamp with time zone,
+    ends_at timestamp with time zone,
+    max_uses integer,
+    max_uses_per_user integer,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT coupons_pkey PRIMARY KEY (code),
+    FOREIGN KEY (shop_id) REFERENCES shops (id) ON DELETE CASCADE
+);
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS cart_coupons
+(
+    cart_id text NOT NULL,
+    code text NOT NULL,
+    CONSTRAINT cart_coupons_pkey PRIMARY KEY (cart_id),
+    FOREIGN KEY (cart_id) REFERENCES carts (id) ON DELETE CASCADE,
+    FOREIGN KEY (code) REFERENCES coupons (code) ON DELETE CASCADE
+);
\ No newline at end of file
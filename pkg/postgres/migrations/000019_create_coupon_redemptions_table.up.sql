@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS coupon_redemptions
+(
+    order_id text NOT NULL,
+    code text NOT NULL,
+    user_id text NOT NULL,
+    amount integer NOT NULL,
+    free_shipping boolean DEFAULT false,
+    redeemed_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT coupon_redemptions_pkey PRIMARY KEY (order_id),
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ON coupon_redemptions (code, user_id);
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS exchange_rates
+(
+    base text NOT NULL,
+    quote text NOT NULL,
+    rate numeric NOT NULL,
+    updated_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT exchange_rates_pkey PRIMARY KEY (base, quote)
+);
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS exchange_rate;
+ALTER TABLE orders DROP COLUMN IF EXISTS base_currency;
+ALTER TABLE products DROP COLUMN IF EXISTS currency;
+ALTER TABLE shops DROP COLUMN IF EXISTS currency;
\ No newline at end of file
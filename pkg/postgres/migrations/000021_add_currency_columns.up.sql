@@ -0,0 +1,4 @@
+ALTER TABLE shops ADD COLUMN IF NOT EXISTS currency text NOT NULL DEFAULT 'USD';
+ALTER TABLE products ADD COLUMN IF NOT EXISTS currency text NOT NULL DEFAULT 'USD';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS base_currency text;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS exchange_rate numeric;
\ No newline at end of file
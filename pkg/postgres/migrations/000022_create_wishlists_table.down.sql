@@ -0,0 +1 @@
+DROP TABLE IF EXISTS wishlists;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS wishlists
+(
+    id text NOT NULL,
+    user_id text NOT NULL,
+    name text NOT NULL,
+    share_token text,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT wishlists_pkey PRIMARY KEY (id),
+    CONSTRAINT wishlists_user_id_name_key UNIQUE (user_id, name),
+    CONSTRAINT wishlists_share_token_key UNIQUE (share_token),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
\ No newline at end of file
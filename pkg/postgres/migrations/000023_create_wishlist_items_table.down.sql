@@ -0,0 +1 @@
+DROP TABLE IF EXISTS wishlist_items;
\ No newline at end of file
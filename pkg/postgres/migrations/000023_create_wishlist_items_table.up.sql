@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS wishlist_items
+(
+    wishlist_id text NOT NULL,
+    product_id text NOT NULL,
+    quantity integer NOT NULL,
+    price integer NOT NULL,
+    currency text NOT NULL,
+    added_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT wishlist_items_pkey PRIMARY KEY (wishlist_id, product_id),
+    FOREIGN KEY (wishlist_id) REFERENCES wishlists (id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ON wishlist_items (product_id);
\ No newline at end of file
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS product_variants
+(
+    id text NOT NULL,
+    product_id text NOT NULL,
+    sku text NOT NULL,
+    options jsonb NOT NULL,
+    stock integer NOT NULL,
+    weight integer,
+    subtotal integer,
+    created_at timestamp with time zone DEFAULT NOW(),
+    updated_at timestamp with time zone,
+    CONSTRAINT product_variants_pkey PRIMARY KEY (id),
+    CONSTRAINT product_variants_sku_key UNIQUE (sku),
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ON product_variants (product_id);
\ No newline at end of file
@@ -0,0 +1,13 @@
+ALTER TABLE order_refunds DROP COLUMN IF EXISTS variant_id;
+ALTER TABLE order_products DROP COLUMN IF EXISTS options;
+ALTER TABLE order_products DROP COLUMN IF EXISTS sku;
+ALTER TABLE order_products DROP COLUMN IF EXISTS variant_id;
+DELETE FROM stock_holds WHERE variant_id<>'';
+ALTER TABLE stock_holds DROP CONSTRAINT stock_holds_pkey,
+    ADD CONSTRAINT stock_holds_pkey PRIMARY KEY (cart_id, product_id);
+ALTER TABLE stock_holds DROP COLUMN IF EXISTS variant_id;
+DELETE FROM cart_products WHERE variant_id<>'';
+ALTER TABLE cart_products DROP CONSTRAINT cart_products_pkey,
+    ADD CONSTRAINT cart_products_pkey PRIMARY KEY (id);
+ALTER TABLE cart_products DROP COLUMN IF EXISTS variant_id;
+ALTER TABLE products DROP COLUMN IF EXISTS options;
\ No newline at end of file
@@ -0,0 +1,11 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS options jsonb NOT NULL DEFAULT '[]';
+ALTER TABLE cart_products ADD COLUMN IF NOT EXISTS variant_id text NOT NULL DEFAULT '';
+ALTER TABLE cart_products DROP CONSTRAINT cart_products_pkey,
+    ADD CONSTRAINT cart_products_pkey PRIMARY KEY (cart_id, id, variant_id);
+ALTER TABLE stock_holds ADD COLUMN IF NOT EXISTS variant_id text NOT NULL DEFAULT '';
+ALTER TABLE stock_holds DROP CONSTRAINT stock_holds_pkey,
+    ADD CONSTRAINT stock_holds_pkey PRIMARY KEY (cart_id, product_id, variant_id);
+ALTER TABLE order_products ADD COLUMN IF NOT EXISTS variant_id text;
+ALTER TABLE order_products ADD COLUMN IF NOT EXISTS sku text;
+ALTER TABLE order_products ADD COLUMN IF NOT EXISTS options jsonb;
+ALTER TABLE order_refunds ADD COLUMN IF NOT EXISTS variant_id text NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS categories
+(
+    id text NOT NULL,
+    parent_id text,
+    name text NOT NULL,
+    slug text NOT NULL,
+    position integer NOT NULL DEFAULT 0,
+    created_at timestamp with time zone DEFAULT NOW(),
+    updated_at timestamp with time zone,
+    CONSTRAINT categories_pkey PRIMARY KEY (id),
+    CONSTRAINT categories_slug_key UNIQUE (slug),
+    FOREIGN KEY (parent_id) REFERENCES categories (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ON categories (parent_id);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS category_id text
+    REFERENCES categories (id) ON DELETE SET NULL;
+CREATE INDEX ON products (category_id);
\ No newline at end of file
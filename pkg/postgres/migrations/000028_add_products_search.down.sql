@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS products_search_trgm_idx;
+DROP INDEX IF EXISTS products_search_idx;
+DROP FUNCTION IF EXISTS product_search;
\ No newline at end of file
@@ -0,0 +1,14 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE OR REPLACE FUNCTION product_search(brand text, type text, category text, description text)
+RETURNS tsvector AS $$
+    SELECT setweight(to_tsvector('english', coalesce(brand, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(type, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(category, '')), 'B') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'C')
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE INDEX IF NOT EXISTS products_search_idx ON products
+    USING GIN (product_search(brand, type, category, description));
+CREATE INDEX IF NOT EXISTS products_search_trgm_idx ON products
+    USING GIN ((brand || ' ' || type || ' ' || category) gin_trgm_ops);
\ No newline at end of file
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS images
+(
+    id text NOT NULL,
+    product_id text,
+    shop_id text,
+    position integer NOT NULL,
+    content_type text NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    size integer NOT NULL,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT images_pkey PRIMARY KEY (id),
+    CONSTRAINT images_owner_check CHECK ((product_id IS NULL) <> (shop_id IS NULL)),
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
+    FOREIGN KEY (shop_id) REFERENCES shops (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ON images (product_id, position);
+CREATE INDEX ON images (shop_id, position);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE products DROP COLUMN IF EXISTS sku;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS sku text;
+ALTER TABLE products ADD CONSTRAINT products_sku_key UNIQUE (sku);
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS import_jobs
+(
+    id text NOT NULL,
+    format text NOT NULL,
+    status text NOT NULL,
+    processed integer NOT NULL DEFAULT 0,
+    created integer NOT NULL DEFAULT 0,
+    updated integer NOT NULL DEFAULT 0,
+    failed integer NOT NULL DEFAULT 0,
+    errors jsonb NOT NULL DEFAULT '[]',
+    error text,
+    created_at timestamp with time zone DEFAULT NOW(),
+    finished_at timestamp with time zone,
+    CONSTRAINT import_jobs_pkey PRIMARY KEY (id)
+);
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE order_products ADD COLUMN IF NOT EXISTS shop_id text;
+UPDATE order_products AS op SET shop_id=p.shop_id FROM products AS p WHERE p.id=op.product_id;
+
+CREATE INDEX ON order_products (shop_id);
\ No newline at end of file
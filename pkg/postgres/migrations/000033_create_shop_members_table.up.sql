@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS shop_members
+(
+    shop_id text NOT NULL,
+    user_id text NOT NULL,
+    role text NOT NULL,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT shop_members_pkey PRIMARY KEY (shop_id, user_id),
+    FOREIGN KEY (shop_id) REFERENCES shops (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS shop_invitations
+(
+    id text NOT NULL,
+    shop_id text NOT NULL,
+    email text NOT NULL,
+    role text NOT NULL,
+    invited_by text NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT shop_invitations_pkey PRIMARY KEY (id),
+    CONSTRAINT shop_invitations_shop_id_email_key UNIQUE (shop_id, email),
+    FOREIGN KEY (shop_id) REFERENCES shops (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ON shop_members (user_id);
\ No newline at end of file
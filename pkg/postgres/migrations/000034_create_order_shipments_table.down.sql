@@ -0,0 +1,2 @@
+ALTER TABLE order_status_history DROP COLUMN IF EXISTS shipment_id;
+DROP TABLE IF EXISTS order_shipments;
\ No newline at end of file
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS order_shipments
+(
+    id text NOT NULL,
+    order_id text NOT NULL,
+    shop_id text NOT NULL,
+    status integer NOT NULL,
+    tracking_number text,
+    delivery_date timestamp with time zone,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT order_shipments_pkey PRIMARY KEY (id),
+    CONSTRAINT order_shipments_order_id_shop_id_key UNIQUE (order_id, shop_id),
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+);
+
+ALTER TABLE order_status_history ADD COLUMN IF NOT EXISTS shipment_id text;
+
+INSERT INTO order_shipments (id, order_id, shop_id, status, delivery_date, created_at)
+SELECT gen_random_uuid()::text, o.id, p.shop_id, COALESCE(o.status, 0), o.delivery_date, o.created_at
+FROM orders AS o
+INNER JOIN (SELECT DISTINCT order_id, shop_id FROM order_products WHERE shop_id IS NOT NULL) AS p
+ON p.order_id=o.id;
+
+CREATE INDEX ON order_shipments (shop_id);
\ No newline at end of file
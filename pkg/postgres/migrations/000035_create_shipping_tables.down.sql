@@ -0,0 +1,4 @@
+ALTER TABLE order_carts DROP COLUMN IF EXISTS shipping_rate_id;
+ALTER TABLE order_carts DROP COLUMN IF EXISTS shipping;
+DROP TABLE IF EXISTS shipping_rates;
+DROP TABLE IF EXISTS shipping_zones;
\ No newline at end of file
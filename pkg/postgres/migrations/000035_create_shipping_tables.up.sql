@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS shipping_zones
+(
+    id text NOT NULL,
+    name text NOT NULL,
+    rules jsonb NOT NULL,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT shipping_zones_pkey PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS shipping_rates
+(
+    id text NOT NULL,
+    zone_id text NOT NULL,
+    name text NOT NULL,
+    tiers jsonb NOT NULL,
+    free_above integer NOT NULL DEFAULT 0,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT shipping_rates_pkey PRIMARY KEY (id),
+    FOREIGN KEY (zone_id) REFERENCES shipping_zones (id) ON DELETE CASCADE
+);
+
+ALTER TABLE order_carts ADD COLUMN IF NOT EXISTS shipping integer;
+ALTER TABLE order_carts ADD COLUMN IF NOT EXISTS shipping_rate_id text;
+
+CREATE INDEX ON shipping_rates (zone_id);
\ No newline at end of file
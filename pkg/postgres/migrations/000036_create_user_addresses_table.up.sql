@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS user_addresses
+(
+    id text NOT NULL,
+    user_id text NOT NULL,
+    name text NOT NULL DEFAULT '',
+    country text NOT NULL,
+    state text NOT NULL DEFAULT '',
+    city text NOT NULL,
+    zip_code text NOT NULL DEFAULT '',
+    address text NOT NULL,
+    default_shipping boolean NOT NULL DEFAULT false,
+    default_billing boolean NOT NULL DEFAULT false,
+    created_at timestamp with time zone DEFAULT NOW(),
+    updated_at timestamp with time zone,
+    CONSTRAINT user_addresses_pkey PRIMARY KEY (id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ON user_addresses (user_id);
+CREATE UNIQUE INDEX ON user_addresses (user_id) WHERE default_shipping;
+CREATE UNIQUE INDEX ON user_addresses (user_id) WHERE default_billing;
\ No newline at end of file
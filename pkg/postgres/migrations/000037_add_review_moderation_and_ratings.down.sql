@@ -0,0 +1,7 @@
+ALTER TABLE shops DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE shops DROP COLUMN IF EXISTS rating_average;
+ALTER TABLE products DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE products DROP COLUMN IF EXISTS rating_average;
+ALTER TABLE reviews DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE reviews DROP COLUMN IF EXISTS status;
+ALTER TABLE reviews DROP COLUMN IF EXISTS verified;
\ No newline at end of file
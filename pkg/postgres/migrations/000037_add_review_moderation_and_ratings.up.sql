@@ -0,0 +1,35 @@
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS verified boolean NOT NULL DEFAULT false;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'approved';
+ALTER TABLE reviews ALTER COLUMN status SET DEFAULT 'pending';
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS updated_at timestamp with time zone;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_average real NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+ALTER TABLE shops ADD COLUMN IF NOT EXISTS rating_average real NOT NULL DEFAULT 0;
+ALTER TABLE shops ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+
+DELETE FROM reviews AS r USING reviews AS d
+WHERE r.user_id=d.user_id AND r.product_id=d.product_id 
+AND (r.created_at, r.id) < (d.created_at, d.id);
+DELETE FROM reviews AS r USING reviews AS d
+WHERE r.user_id=d.user_id AND r.shop_id=d.shop_id 
+AND (r.created_at, r.id) < (d.created_at, d.id);
+
+UPDATE reviews AS r SET verified=true
+WHERE EXISTS(
+    SELECT 1 FROM order_products AS op
+    JOIN orders AS o ON o.id=op.order_id
+    LEFT JOIN order_shipments AS sh ON sh.order_id=op.order_id AND sh.shop_id=op.shop_id
+    WHERE o.user_id=r.user_id AND op.product_id=r.product_id AND COALESCE(sh.status, o.status)=7
+);
+
+UPDATE products AS p SET (rating_average, rating_count) = (
+    SELECT COALESCE(AVG(stars), 0), COUNT(*) FROM reviews WHERE product_id=p.id AND status='approved'
+);
+UPDATE shops AS s SET (rating_average, rating_count) = (
+    SELECT COALESCE(AVG(stars), 0), COUNT(*) FROM reviews WHERE shop_id=s.id AND status='approved'
+);
+
+CREATE INDEX ON reviews (status, created_at);
+CREATE UNIQUE INDEX ON reviews (user_id, product_id) WHERE product_id IS NOT NULL;
+CREATE UNIQUE INDEX ON reviews (user_id, shop_id) WHERE shop_id IS NOT NULL;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS user_identities
+(
+    provider text NOT NULL,
+    subject text NOT NULL,
+    user_id text NOT NULL,
+    email text,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT user_identities_pkey PRIMARY KEY (provider, subject),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ON user_identities (user_id);
\ No newline at end of file
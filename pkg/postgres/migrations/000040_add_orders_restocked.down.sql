@@ -0,0 +1 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS restocked;
\ No newline at end of file
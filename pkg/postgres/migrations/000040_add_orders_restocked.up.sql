@@ -0,0 +1 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS restocked boolean NOT NULL DEFAULT false;
\ No newline at end of file
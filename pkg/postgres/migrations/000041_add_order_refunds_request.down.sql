@@ -0,0 +1,5 @@
+ALTER TABLE order_refunds DROP COLUMN IF EXISTS order_status;
+ALTER TABLE order_refunds DROP COLUMN IF EXISTS status;
+ALTER TABLE order_refunds DROP COLUMN IF EXISTS request_id;
+UPDATE order_refunds SET refund_id='' WHERE refund_id IS NULL;
+ALTER TABLE order_refunds ALTER COLUMN refund_id SET NOT NULL;
\ No newline at end of file
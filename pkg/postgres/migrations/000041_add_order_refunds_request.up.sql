@@ -0,0 +1,4 @@
+ALTER TABLE order_refunds ALTER COLUMN refund_id DROP NOT NULL;
+ALTER TABLE order_refunds ADD COLUMN IF NOT EXISTS request_id text;
+ALTER TABLE order_refunds ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'succeeded';
+ALTER TABLE order_refunds ADD COLUMN IF NOT EXISTS order_status integer;
\ No newline at end of file
@@ -0,0 +1,4 @@
+DELETE FROM wishlist_items WHERE variant_id<>'';
+ALTER TABLE wishlist_items DROP CONSTRAINT wishlist_items_pkey,
+    ADD CONSTRAINT wishlist_items_pkey PRIMARY KEY (wishlist_id, product_id);
+ALTER TABLE wishlist_items DROP COLUMN IF EXISTS variant_id;
\ No newline at end of file
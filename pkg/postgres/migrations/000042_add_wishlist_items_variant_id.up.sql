@@ -0,0 +1,3 @@
+ALTER TABLE wishlist_items ADD COLUMN IF NOT EXISTS variant_id text NOT NULL DEFAULT '';
+ALTER TABLE wishlist_items DROP CONSTRAINT wishlist_items_pkey,
+    ADD CONSTRAINT wishlist_items_pkey PRIMARY KEY (wishlist_id, product_id, variant_id);
\ No newline at end of file
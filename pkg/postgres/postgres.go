@@ -49,6 +49,8 @@ func CreateTables(ctx context.Context, db *sqlx.DB) error {
 // TODO: postgres throws an error when creating
 // index orders (created_at) even when the field is correctly set
 const tables = `
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
 CREATE TABLE IF NOT EXISTS users
 (
     id text NOT NULL,
@@ -59,20 +61,88 @@ CREATE TABLE IF NOT EXISTS users
     verified_email boolean DEFAULT false,
     is_admin boolean DEFAULT false,
     confirmation_code text,
+    totp_secret text,
+    totp_enabled boolean NOT NULL DEFAULT false,
     created_at timestamp with time zone DEFAULT NOW(),
     updated_at timestamp DEFAULT NULL,
     CONSTRAINT users_pkey PRIMARY KEY (id)
 );
 
+CREATE TABLE IF NOT EXISTS user_recovery_codes
+(
+    user_id text NOT NULL,
+    code_hash text NOT NULL,
+    used_at timestamp with time zone,
+    CONSTRAINT user_recovery_codes_pkey PRIMARY KEY (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_identities
+(
+    provider text NOT NULL,
+    subject text NOT NULL,
+    user_id text NOT NULL,
+    email text,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT user_identities_pkey PRIMARY KEY (provider, subject),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_addresses
+(
+    id text NOT NULL,
+    user_id text NOT NULL,
+    name text NOT NULL DEFAULT '',
+    country text NOT NULL,
+    state text NOT NULL DEFAULT '',
+    city text NOT NULL,
+    zip_code text NOT NULL DEFAULT '',
+    address text NOT NULL,
+    default_shipping boolean NOT NULL DEFAULT false,
+    default_billing boolean NOT NULL DEFAULT false,
+    created_at timestamp with time zone DEFAULT NOW(),
+    updated_at timestamp with time zone,
+    CONSTRAINT user_addresses_pkey PRIMARY KEY (id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS shops
 (
     id text NOT NULL,
     name text NOT NULL,
     created_at timestamp with time zone DEFAULT NOW(),
     updated_at timestamp with time zone,
+    currency text NOT NULL DEFAULT 'USD',
+    rating_average real NOT NULL DEFAULT 0,
+    rating_count integer NOT NULL DEFAULT 0,
     CONSTRAINT shops_pkey PRIMARY KEY (id)
 );
 
+CREATE TABLE IF NOT EXISTS shop_members
+(
+    shop_id text NOT NULL,
+    user_id text NOT NULL,
+    role text NOT NULL,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT shop_members_pkey PRIMARY KEY (shop_id, user_id),
+    FOREIGN KEY (shop_id) REFERENCES shops (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS shop_invitations
+(
+    id text NOT NULL,
+    shop_id text NOT NULL,
+    email text NOT NULL,
+    role text NOT NULL,
+    invited_by text NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT shop_invitations_pkey PRIMARY KEY (id),
+    CONSTRAINT shop_invitations_shop_id_email_key UNIQUE (shop_id, email),
+    FOREIGN KEY (shop_id) REFERENCES shops (id) ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS locations
 (
     shop_id text NOT NULL,
@@ -84,6 +154,20 @@ CREATE TABLE IF NOT EXISTS locations
     FOREIGN KEY (shop_id) REFERENCES shops (id) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS categories
+(
+    id text NOT NULL,
+    parent_id text,
+    name text NOT NULL,
+    slug text NOT NULL,
+    position integer NOT NULL DEFAULT 0,
+    created_at timestamp with time zone DEFAULT NOW(),
+    updated_at timestamp with time zone,
+    CONSTRAINT categories_pkey PRIMARY KEY (id),
+    CONSTRAINT categories_slug_key UNIQUE (slug),
+    FOREIGN KEY (parent_id) REFERENCES categories (id) ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS products
 (
     id text NOT NULL,
@@ -100,10 +184,75 @@ CREATE TABLE IF NOT EXISTS products
     total integer NOT NULL,
     created_at timestamp with time zone DEFAULT NOW(),
     updated_at timestamp with time zone,
+    currency text NOT NULL DEFAULT 'USD',
+    options jsonb NOT NULL DEFAULT '[]',
+    category_id text,
+    sku text,
+    rating_average real NOT NULL DEFAULT 0,
+    rating_count integer NOT NULL DEFAULT 0,
     CONSTRAINT products_pkey PRIMARY KEY (id),
+    CONSTRAINT products_sku_key UNIQUE (sku),
+    FOREIGN KEY (shop_id) REFERENCES shops (id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE SET NULL
+);
+
+CREATE OR REPLACE FUNCTION product_search(brand text, type text, category text, description text)
+RETURNS tsvector AS $$
+    SELECT setweight(to_tsvector('english', coalesce(brand, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(type, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(category, '')), 'B') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'C')
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE TABLE IF NOT EXISTS product_variants
+(
+    id text NOT NULL,
+    product_id text NOT NULL,
+    sku text NOT NULL,
+    options jsonb NOT NULL,
+    stock integer NOT NULL,
+    weight integer,
+    subtotal integer,
+    created_at timestamp with time zone DEFAULT NOW(),
+    updated_at timestamp with time zone,
+    CONSTRAINT product_variants_pkey PRIMARY KEY (id),
+    CONSTRAINT product_variants_sku_key UNIQUE (sku),
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS images
+(
+    id text NOT NULL,
+    product_id text,
+    shop_id text,
+    position integer NOT NULL,
+    content_type text NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    size integer NOT NULL,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT images_pkey PRIMARY KEY (id),
+    CONSTRAINT images_owner_check CHECK ((product_id IS NULL) <> (shop_id IS NULL)),
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
     FOREIGN KEY (shop_id) REFERENCES shops (id) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS import_jobs
+(
+    id text NOT NULL,
+    format text NOT NULL,
+    status text NOT NULL,
+    processed integer NOT NULL DEFAULT 0,
+    created integer NOT NULL DEFAULT 0,
+    updated integer NOT NULL DEFAULT 0,
+    failed integer NOT NULL DEFAULT 0,
+    errors jsonb NOT NULL DEFAULT '[]',
+    error text,
+    created_at timestamp with time zone DEFAULT NOW(),
+    finished_at timestamp with time zone,
+    CONSTRAINT import_jobs_pkey PRIMARY KEY (id)
+);
+
 CREATE TABLE IF NOT EXISTS reviews
 (
     id text NOT NULL,
@@ -113,6 +262,9 @@ CREATE TABLE IF NOT EXISTS reviews
     product_id text,
     shop_id text,
     created_at timestamp with time zone DEFAULT NOW(),
+    verified boolean NOT NULL DEFAULT false,
+    status text NOT NULL DEFAULT 'pending',
+    updated_at timestamp with time zone,
     CONSTRAINT reviews_pkey PRIMARY KEY (id),
     FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
     FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
@@ -136,10 +288,23 @@ CREATE TABLE IF NOT EXISTS cart_products
     id text NOT NULL,
     cart_id text NOT NULL,
     quantity integer NOT NULL,
-    CONSTRAINT cart_products_pkey PRIMARY KEY (id),
+    variant_id text NOT NULL DEFAULT '',
+    CONSTRAINT cart_products_pkey PRIMARY KEY (cart_id, id, variant_id),
     FOREIGN KEY (cart_id) REFERENCES carts (id) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS stock_holds
+(
+    cart_id text NOT NULL,
+    product_id text NOT NULL,
+    quantity integer NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    variant_id text NOT NULL DEFAULT '',
+    CONSTRAINT stock_holds_pkey PRIMARY KEY (cart_id, product_id, variant_id),
+    FOREIGN KEY (cart_id) REFERENCES carts (id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS hits
 (
     id text NOT NULL,
@@ -168,6 +333,10 @@ CREATE TABLE IF NOT EXISTS orders
     created_at timestamp with time zone DEFAULT NOW(),
     ordered_at timestamp with time zone,
     delivery_date timestamp with time zone,
+    payment_intent_id text,
+    base_currency text,
+    exchange_rate numeric,
+    restocked boolean NOT NULL DEFAULT false,
     CONSTRAINT orders_pkey PRIMARY KEY (id),
     FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
 );
@@ -181,6 +350,8 @@ CREATE TABLE IF NOT EXISTS order_carts
     taxes integer,
     subtotal integer,
     total integer,
+    shipping integer,
+    shipping_rate_id text,
     FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
 );
 
@@ -198,13 +369,187 @@ CREATE TABLE IF NOT EXISTS order_products
     taxes integer,
     subtotal integer,
     total integer,
+    variant_id text,
+    sku text,
+    options jsonb,
+    shop_id text,
     FOREIGN KEY (order_id) 
         REFERENCES orders (id)
         ON DELETE CASCADE
         DEFERRABLE INITIALLY DEFERRED
 );
 
+CREATE TABLE IF NOT EXISTS order_status_history
+(
+    order_id text NOT NULL,
+    from_status integer,
+    to_status integer NOT NULL,
+    changed_by text,
+    changed_at timestamp with time zone DEFAULT NOW(),
+    shipment_id text,
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS order_shipments
+(
+    id text NOT NULL,
+    order_id text NOT NULL,
+    shop_id text NOT NULL,
+    status integer NOT NULL,
+    tracking_number text,
+    delivery_date timestamp with time zone,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT order_shipments_pkey PRIMARY KEY (id),
+    CONSTRAINT order_shipments_order_id_shop_id_key UNIQUE (order_id, shop_id),
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS order_refunds
+(
+    id text NOT NULL,
+    order_id text NOT NULL,
+    refund_id text,
+    product_id text NOT NULL,
+    quantity integer NOT NULL,
+    amount integer NOT NULL,
+    refunded_by text,
+    created_at timestamp with time zone DEFAULT NOW(),
+    variant_id text NOT NULL DEFAULT '',
+    request_id text,
+    status text NOT NULL DEFAULT 'succeeded',
+    order_status integer,
+    CONSTRAINT order_refunds_pkey PRIMARY KEY (id),
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS shipping_zones
+(
+    id text NOT NULL,
+    name text NOT NULL,
+    rules jsonb NOT NULL,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT shipping_zones_pkey PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS shipping_rates
+(
+    id text NOT NULL,
+    zone_id text NOT NULL,
+    name text NOT NULL,
+    tiers jsonb NOT NULL,
+    free_above integer NOT NULL DEFAULT 0,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT shipping_rates_pkey PRIMARY KEY (id),
+    FOREIGN KEY (zone_id) REFERENCES shipping_zones (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS coupons
+(
+    code text NOT NULL,
+    kind text NOT NULL,
+    value integer NOT NULL DEFAULT 0,
+    buy_quantity integer,
+    get_quantity integer,
+    shop_id text,
+    category text,
+    starts_at timestamp with time zone,
+    ends_at timestamp with time zone,
+    max_uses integer,
+    max_uses_per_user integer,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT coupons_pkey PRIMARY KEY (code),
+    FOREIGN KEY (shop_id) REFERENCES shops (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS cart_coupons
+(
+    cart_id text NOT NULL,
+    code text NOT NULL,
+    CONSTRAINT cart_coupons_pkey PRIMARY KEY (cart_id),
+    FOREIGN KEY (cart_id) REFERENCES carts (id) ON DELETE CASCADE,
+    FOREIGN KEY (code) REFERENCES coupons (code) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions
+(
+    order_id text NOT NULL,
+    code text NOT NULL,
+    user_id text NOT NULL,
+    amount integer NOT NULL,
+    free_shipping boolean DEFAULT false,
+    redeemed_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT coupon_redemptions_pkey PRIMARY KEY (order_id),
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS exchange_rates
+(
+    base text NOT NULL,
+    quote text NOT NULL,
+    rate numeric NOT NULL,
+    updated_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT exchange_rates_pkey PRIMARY KEY (base, quote)
+);
+
+CREATE TABLE IF NOT EXISTS wishlists
+(
+    id text NOT NULL,
+    user_id text NOT NULL,
+    name text NOT NULL,
+    share_token text,
+    created_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT wishlists_pkey PRIMARY KEY (id),
+    CONSTRAINT wishlists_user_id_name_key UNIQUE (user_id, name),
+    CONSTRAINT wishlists_share_token_key UNIQUE (share_token),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS wishlist_items
+(
+    wishlist_id text NOT NULL,
+    product_id text NOT NULL,
+    quantity integer NOT NULL,
+    price integer NOT NULL,
+    currency text NOT NULL,
+    added_at timestamp with time zone DEFAULT NOW(),
+    variant_id text NOT NULL DEFAULT '',
+    CONSTRAINT wishlist_items_pkey PRIMARY KEY (wishlist_id, product_id, variant_id),
+    FOREIGN KEY (wishlist_id) REFERENCES wishlists (id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS stripe_events
+(
+    id text NOT NULL,
+    type text NOT NULL,
+    received_at timestamp with time zone DEFAULT NOW(),
+    CONSTRAINT stripe_events_pkey PRIMARY KEY (id)
+);
+
 CREATE INDEX ON users (created_at);
 CREATE INDEX ON shops (created_at);
 CREATE INDEX ON products (created_at);
-CREATE INDEX ON reviews (created_at);`
+CREATE INDEX ON reviews (created_at);
+CREATE INDEX ON stock_holds (expires_at);
+CREATE INDEX ON order_status_history (order_id);
+CREATE INDEX ON order_refunds (order_id);
+CREATE INDEX ON coupon_redemptions (code, user_id);
+CREATE INDEX ON wishlist_items (product_id);
+CREATE INDEX ON product_variants (product_id);
+CREATE INDEX ON categories (parent_id);
+CREATE INDEX ON products (category_id);
+CREATE INDEX ON products USING GIN (product_search(brand, type, category, description));
+CREATE INDEX ON products USING GIN ((brand || ' ' || type || ' ' || category) gin_trgm_ops);
+CREATE INDEX ON images (product_id, position);
+CREATE INDEX ON images (shop_id, position);
+CREATE INDEX ON shop_members (user_id);
+CREATE INDEX ON order_products (shop_id);
+CREATE INDEX ON order_shipments (shop_id);
+CREATE INDEX ON shipping_rates (zone_id);
+CREATE INDEX ON user_addresses (user_id);
+CREATE UNIQUE INDEX ON user_addresses (user_id) WHERE default_shipping;
+CREATE UNIQUE INDEX ON user_addresses (user_id) WHERE default_billing;
+CREATE INDEX ON reviews (status, created_at);
+CREATE UNIQUE INDEX ON reviews (user_id, product_id) WHERE product_id IS NOT NULL;
+CREATE UNIQUE INDEX ON reviews (user_id, shop_id) WHERE shop_id IS NOT NULL;
+CREATE INDEX ON user_identities (user_id);`
@@ -1,15 +1,22 @@
 package product
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
+	"github.com/GGP1/adak/internal/logger"
 	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/principal"
 	"github.com/GGP1/adak/internal/response"
 	"github.com/GGP1/adak/internal/sanitize"
 	"github.com/GGP1/adak/internal/validate"
+	"github.com/GGP1/adak/pkg/shopping/currency"
 	"github.com/google/uuid"
 
 	"github.com/bradfitz/gomemcache/memcache"
@@ -18,6 +25,13 @@ import (
 	"gopkg.in/guregu/null.v4/zero"
 )
 
+const (
+	// syncImportSize is the maximum size of the files imported while the client waits
+	syncImportSize = 1 << 20
+	// maxImportSize is the maximum size of the files imported in the background
+	maxImportSize = 512 << 20
+)
+
 type cursorResponse struct {
 	NextCursor string    `json:"next_cursor,omitempty"`
 	Products   []Product `json:"products,omitempty"`
@@ -40,7 +54,25 @@ func NewHandler(service Service, cache *memcache.Client) Handler {
 // Create creates a new product and saves it.
 func (h *Handler) Create() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
+		var p Product
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		h.create(w, r, p)
+	}
+}
+
+// CreateInShop creates a new product in the shop of the URL id.
+func (h *Handler) CreateInShop() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		shopID, err := params.URLID(r.Context())
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
 
 		var p Product
 		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
@@ -49,19 +81,71 @@ func (h *Handler) Create() http.HandlerFunc {
 		}
 		defer r.Body.Close()
 
-		if err := validate.Struct(ctx, p); err != nil {
+		// Members can't create products in other shops
+		p.ShopID = zero.StringFrom(shopID)
+		h.create(w, r, p)
+	}
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request, p Product) {
+	ctx := r.Context()
+
+	if err := validate.Struct(ctx, p); err != nil {
+		response.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if p.Currency.Valid {
+		if err := currency.Validate(p.Currency.String); err != nil {
 			response.Error(w, http.StatusBadRequest, err)
 			return
 		}
+		p.Currency = zero.StringFrom(currency.Normalize(p.Currency.String))
+	}
 
-		p.ID = zero.StringFrom(uuid.NewString())
-		p.CreatedAt = zero.TimeFrom(time.Now())
-		if err := h.service.Create(ctx, p); err != nil {
-			response.Error(w, http.StatusInternalServerError, err)
+	p.ID = zero.StringFrom(uuid.NewString())
+	p.Total = unitTotal(p.Subtotal, p.Discount, p.Taxes)
+	p.CreatedAt = zero.TimeFrom(time.Now())
+	if err := h.service.Create(ctx, p); err != nil {
+		response.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response.JSON(w, http.StatusCreated, p)
+}
+
+// CreateVariant creates a new variant of the product.
+func (h *Handler) CreateVariant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		productID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
 			return
 		}
 
-		response.JSON(w, http.StatusCreated, p)
+		var v Variant
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, v); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		v.ID = uuid.NewString()
+		v.ProductID = productID
+		v.CreatedAt = zero.TimeFrom(time.Now())
+		if err := h.service.CreateVariant(ctx, v); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, v)
 	}
 }
 
@@ -85,6 +169,61 @@ func (h *Handler) Delete() http.HandlerFunc {
 	}
 }
 
+// DeleteVariant removes a variant of the product.
+func (h *Handler) DeleteVariant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		productID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		variantID := chi.URLParam(r, "variant_id")
+		if err := validate.UUID(variantID); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.DeleteVariant(ctx, productID, variantID); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, variantID)
+	}
+}
+
+// Export streams the catalog in the format requested with the "format" parameter, CSV by default.
+func (h *Handler) Export() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := r.URL.Query().Get("format")
+		if name == "" {
+			name = string(FormatCSV)
+		}
+		format, err := ParseFormat(name)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"products.%s\"", format))
+		tw := &trackingWriter{Writer: w}
+		if err := h.service.Export(ctx, tw, format); err != nil {
+			if !tw.written {
+				response.Error(w, http.StatusInternalServerError, err)
+				return
+			}
+			// The response is already on its way, it will be truncated
+			logger.Errorf("exporting products: %v", err)
+		}
+	}
+}
+
 // Get lists all the products.
 func (h *Handler) Get() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -144,25 +283,175 @@ func (h *Handler) GetByID() http.HandlerFunc {
 	}
 }
 
-// Search looks for the products with the given value.
+// GetVariants lists the variants of the product.
+func (h *Handler) GetVariants() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		productID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		variants, err := h.service.GetVariants(ctx, productID)
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, variants)
+	}
+}
+
+// Import creates or updates the products of the CSV or JSON Lines file sent in the body,
+// its format is taken from the "format" parameter or the Content-Type header.
+//
+// Small files are imported right away and the report is returned, the rest are imported
+// in the background and the job created is returned so its progress can be polled.
+func (h *Handler) Import() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		defer r.Body.Close()
+
+		name := r.URL.Query().Get("format")
+		if name == "" {
+			name = r.Header.Get("Content-Type")
+		}
+		format, err := ParseFormat(name)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if r.ContentLength >= 0 && r.ContentLength <= syncImportSize {
+			report, err := h.service.Import(ctx, r.Body, format, nil)
+			if err != nil {
+				var fileErr *FileError
+				if errors.As(err, &fileErr) {
+					response.Error(w, http.StatusBadRequest, err)
+					return
+				}
+				response.Error(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			response.JSON(w, http.StatusOK, report)
+			return
+		}
+
+		// Save the body so the import can continue after the response is sent
+		file, err := os.CreateTemp("", "adak-import-*")
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+		tmp := tempFile{file}
+		if _, err := io.Copy(file, http.MaxBytesReader(w, r.Body, maxImportSize)); err != nil {
+			tmp.Close()
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			tmp.Close()
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		job := ImportJob{
+			ID:        uuid.NewString(),
+			Format:    format,
+			Status:    JobRunning,
+			CreatedAt: time.Now(),
+		}
+		if err := h.service.StartImport(ctx, job, tmp); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Location", "/products/import/"+job.ID)
+		response.JSON(w, http.StatusAccepted, job)
+	}
+}
+
+// ImportJob returns the progress of a background import.
+func (h *Handler) ImportJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id := chi.URLParam(r, "job_id")
+		if err := validate.UUID(id); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		job, err := h.service.ImportJob(ctx, id)
+		if err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, job)
+	}
+}
+
+// PriceDrops lists the products the user saved that are cheaper now.
+func (h *Handler) PriceDrops() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := principal.UserID(r.Context())
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		drops, err := h.service.PriceDrops(r.Context(), userID)
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, drops)
+	}
+}
+
+// Search looks for the products that match the query and filters.
+//
+// The query can be passed in the path or in the "q" parameter.
 func (h *Handler) Search() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		query := chi.URLParam(r, "query")
 		ctx := r.Context()
 
-		query = sanitize.Normalize(query)
-		if strings.ContainsAny(query, ";-\\|@#~€¬<>_()[]}{¡^'") {
+		values := r.URL.Query()
+		if query := chi.URLParam(r, "query"); query != "" {
+			values.Set("q", query)
+		}
+
+		search, err := params.ParseSearch(values.Encode())
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		search.Query = sanitize.Normalize(search.Query)
+		if strings.ContainsAny(search.Query, ";-\\|@#~€¬<>_()[]}{¡^'") {
 			response.Error(w, http.StatusBadRequest, errors.Errorf("query contains invalid characters"))
 			return
 		}
 
-		products, err := h.service.Search(ctx, query)
+		products, nextCursor, err := h.service.Search(ctx, search)
 		if err != nil {
 			response.Error(w, http.StatusNotFound, err)
 			return
 		}
 
-		response.JSON(w, http.StatusOK, products)
+		response.JSON(w, http.StatusOK, cursorResponse{
+			NextCursor: nextCursor,
+			Products:   products,
+		})
 	}
 }
 
@@ -189,6 +478,7 @@ func (h *Handler) Update() http.HandlerFunc {
 			return
 		}
 
+		product.Total = unitTotal(product.Subtotal, product.Discount, product.Taxes)
 		if err := h.service.Update(ctx, id, product); err != nil {
 			response.Error(w, http.StatusInternalServerError, err)
 			return
@@ -197,3 +487,65 @@ func (h *Handler) Update() http.HandlerFunc {
 		response.JSON(w, http.StatusOK, product)
 	}
 }
+
+// UpdateVariant updates the variant with the given id.
+func (h *Handler) UpdateVariant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		productID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		variantID := chi.URLParam(r, "variant_id")
+		if err := validate.UUID(variantID); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var v Variant
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, v); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		v.ID = variantID
+		v.ProductID = productID
+		v.UpdatedAt = zero.TimeFrom(time.Now())
+		if err := h.service.UpdateVariant(ctx, productID, variantID, v); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, v)
+	}
+}
+
+// trackingWriter records whether something was written.
+type trackingWriter struct {
+	io.Writer
+	written bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.written = true
+	return t.Writer.Write(p)
+}
+
+// tempFile is a temporary file that is removed when it's closed.
+type tempFile struct {
+	*os.File
+}
+
+func (t tempFile) Close() error {
+	t.File.Close()
+	return os.Remove(t.Name())
+}
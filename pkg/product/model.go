@@ -1,7 +1,9 @@
 package product
 
 import (
+	"github.com/GGP1/adak/pkg/media"
 	"github.com/GGP1/adak/pkg/review"
+	"github.com/GGP1/adak/pkg/shopping/pricing"
 
 	"gopkg.in/guregu/null.v4/zero"
 )
@@ -10,6 +12,9 @@ import (
 //
 // Amounts to be provided in a currency’s smallest unit.
 // 100 = 1 USD.
+//
+// Discount and Taxes are percentages, Total is the price of a single unit
+// with them applied and it's calculated when the product is saved.
 type Product struct {
 	ID          zero.String `json:"id,omitempty"`
 	ShopID      zero.String `json:"shop_id,omitempty" db:"shop_id" validate:"required"`
@@ -20,13 +25,38 @@ type Product struct {
 	Description zero.String `json:"description,omitempty"`
 	// 1000 = 1kg
 	Weight    zero.Int        `json:"weight,omitempty" validate:"required,min=1"`
-	Discount  zero.Int        `json:"discount,omitempty" validate:"min=0"`
+	Discount  zero.Int        `json:"discount,omitempty" validate:"min=0,max=100"`
 	Taxes     zero.Int        `json:"taxes,omitempty" validate:"min=0"`
 	Subtotal  zero.Int        `json:"subtotal,omitempty" validate:"required"`
 	Total     zero.Int        `json:"total,omitempty" validate:"min=0"`
 	Reviews   []review.Review `json:"reviews,omitempty"`
 	CreatedAt zero.Time       `json:"created_at,omitempty" db:"created_at"`
 	UpdatedAt zero.Time       `json:"updated_at,omitempty" db:"updated_at"`
+	// Currency of the amounts, the shop one is used if it's empty
+	Currency zero.String `json:"currency,omitempty"`
+	// Options are the axes the product varies on, each combination of them is a variant
+	Options  Options   `json:"options,omitempty" validate:"dive"`
+	Variants []Variant `json:"variants,omitempty" db:"-"`
+	// CategoryID links the product to a node of the categories tree
+	CategoryID zero.String `json:"category_id,omitempty" db:"category_id" validate:"omitempty,uuid4_rfc4122"`
+	// SKU is the stable identifier used to match the products of the catalog imports
+	SKU zero.String `json:"sku,omitempty" validate:"omitempty,max=64"`
+	// Images is the product gallery, sorted by position
+	Images []media.Image `json:"images,omitempty" db:"-"`
+	// RatingAverage and RatingCount summarize the approved reviews
+	RatingAverage zero.Float `json:"rating_average,omitempty" db:"rating_average"`
+	RatingCount   zero.Int   `json:"rating_count,omitempty" db:"rating_count"`
+}
+
+// PriceDrop is a product saved in a user wishlist that is cheaper than when it was added.
+type PriceDrop struct {
+	ProductID  string `json:"product_id" db:"product_id"`
+	VariantID  string `json:"variant_id,omitempty" db:"variant_id"`
+	WishlistID string `json:"wishlist_id" db:"wishlist_id"`
+	// SavedPrice and Price are the unit totals when the product was saved and now
+	SavedPrice int64  `json:"saved_price" db:"saved_price"`
+	Price      int64  `json:"price"`
+	Currency   string `json:"currency"`
 }
 
 // UpdateProduct is the structure used to update products.
@@ -37,8 +67,21 @@ type UpdateProduct struct {
 	Type        zero.String `json:"type,omitempty" validate:"required"`
 	Description zero.String `json:"description,omitempty"`
 	Weight      zero.Int    `json:"weight,omitempty" validate:"required,min=1"`
-	Discount    zero.Int    `json:"discount,omitempty" validate:"min=0"`
+	Discount    zero.Int    `json:"discount,omitempty" validate:"min=0,max=100"`
 	Taxes       zero.Int    `json:"taxes,omitempty" validate:"min=0"`
 	Subtotal    zero.Int    `json:"subtotal,omitempty" validate:"required"`
 	Total       zero.Int    `json:"total,omitempty" validate:"min=0"`
+	Options     Options     `json:"options,omitempty" validate:"dive"`
+	CategoryID  zero.String `json:"category_id,omitempty" validate:"omitempty,uuid4_rfc4122"`
+}
+
+// unitTotal returns the price of a single unit with the discount and taxes applied.
+func unitTotal(subtotal, discount, taxes zero.Int) zero.Int {
+	line := pricing.Line{
+		UnitPrice:    subtotal.Int64,
+		Quantity:     1,
+		DiscountRate: discount.Int64,
+		TaxRate:      taxes.Int64,
+	}
+	return zero.IntFrom(line.Price().Total)
 }
@@ -2,23 +2,46 @@ package product
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/GGP1/adak/internal/logger"
 	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/validate"
+	"github.com/GGP1/adak/pkg/media"
 	"github.com/GGP1/adak/pkg/postgres"
 	"github.com/GGP1/adak/pkg/review"
+	"github.com/GGP1/adak/pkg/shopping/currency"
 	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
 )
 
 // Service provides product operations.
 type Service interface {
 	Create(ctx context.Context, p Product) error
+	CreateVariant(ctx context.Context, v Variant) error
 	Delete(ctx context.Context, id string) error
+	DeleteVariant(ctx context.Context, productID, variantID string) error
+	Export(ctx context.Context, w io.Writer, format Format) error
 	Get(ctx context.Context, params params.Query) ([]Product, error)
 	GetByID(ctx context.Context, id string) (Product, error)
-	Search(ctx context.Context, query string) ([]Product, error)
+	GetVariants(ctx context.Context, productID string) ([]Variant, error)
+	Import(ctx context.Context, r io.Reader, format Format, progress func(Report)) (Report, error)
+	ImportJob(ctx context.Context, id string) (ImportJob, error)
+	PriceDrops(ctx context.Context, userID string) ([]PriceDrop, error)
+	Search(ctx context.Context, search params.Search) ([]Product, string, error)
+	StartImport(ctx context.Context, job ImportJob, r io.ReadCloser) error
 	Update(ctx context.Context, id string, p UpdateProduct) error
+	UpdateVariant(ctx context.Context, productID, variantID string, v Variant) error
 }
 
 type service struct {
@@ -38,11 +61,13 @@ func (s *service) Create(ctx context.Context, p Product) error {
 
 	q := `INSERT INTO products 
 	(id, shop_id, stock, brand, category, type, description, 
-	weight, discount, taxes, subtotal, total, created_at)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+	weight, discount, taxes, subtotal, total, created_at, currency, options, category_id, sku)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
+	COALESCE(NULLIF($14, ''), (SELECT currency FROM shops WHERE id=$2)), $15, $16, $17)`
 	_, err := s.db.ExecContext(ctx, q, p.ID, p.ShopID, p.Stock, p.Brand,
 		p.Category, p.Type, p.Description, p.Weight, p.Discount, p.Taxes,
-		p.Subtotal, p.Total, p.CreatedAt)
+		p.Subtotal, p.Total, p.CreatedAt, currency.Normalize(p.Currency.String), p.Options,
+		p.CategoryID, p.SKU)
 	if err != nil {
 		return errors.Wrap(err, "couldn't create the product")
 	}
@@ -51,6 +76,41 @@ func (s *service) Create(ctx context.Context, p Product) error {
 	return nil
 }
 
+// CreateVariant adds a variant to the product, its options must be a combination
+// of the product ones that no other variant has.
+func (s *service) CreateVariant(ctx context.Context, v Variant) error {
+	s.metrics.incMethodCalls("CreateVariant")
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := s.checkVariantOptions(ctx, tx, v.ProductID, "", v.Options); err != nil {
+		return err
+	}
+
+	q := `INSERT INTO product_variants
+	(id, product_id, sku, options, stock, weight, subtotal, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = tx.ExecContext(ctx, q, v.ID, v.ProductID, v.SKU, v.Options,
+		v.Stock, v.Weight, v.Subtotal, v.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create the variant")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	if err := s.mc.Delete(v.ProductID); err != nil && err != memcache.ErrCacheMiss {
+		return errors.Wrap(err, "couldn't delete product from cache")
+	}
+
+	return nil
+}
+
 // Delete permanently deletes a product from the database.
 func (s *service) Delete(ctx context.Context, id string) error {
 	s.metrics.incMethodCalls("Delete")
@@ -67,13 +127,65 @@ func (s *service) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
+// DeleteVariant removes a variant from the product.
+func (s *service) DeleteVariant(ctx context.Context, productID, variantID string) error {
+	s.metrics.incMethodCalls("DeleteVariant")
+
+	q := "DELETE FROM product_variants WHERE id=$1 AND product_id=$2"
+	if _, err := s.db.ExecContext(ctx, q, variantID, productID); err != nil {
+		return errors.Wrap(err, "couldn't delete the variant")
+	}
+
+	if err := s.mc.Delete(productID); err != nil && err != memcache.ErrCacheMiss {
+		return errors.Wrap(err, "couldn't delete product from cache")
+	}
+
+	return nil
+}
+
+// Export writes the whole catalog in the format specified.
+func (s *service) Export(ctx context.Context, w io.Writer, format Format) error {
+	s.metrics.incMethodCalls("Export")
+
+	q := `SELECT COALESCE(sku, '') AS sku, shop_id, brand, category, type,
+	COALESCE(description, '') AS description, weight, COALESCE(discount, 0) AS discount,
+	COALESCE(taxes, 0) AS taxes, subtotal, stock, currency, COALESCE(category_id, '') AS category_id
+	FROM products
+	ORDER BY created_at, id`
+	rows, err := s.db.QueryxContext(ctx, q)
+	if err != nil {
+		return errors.Wrap(err, "couldn't find the products")
+	}
+	defer rows.Close()
+
+	writer, err := newRecordWriter(w, format)
+	if err != nil {
+		return errors.Wrap(err, "couldn't write the header")
+	}
+
+	for rows.Next() {
+		var rec Record
+		if err := rows.StructScan(&rec); err != nil {
+			return errors.Wrap(err, "couldn't scan the product")
+		}
+		if err := writer.Write(rec); err != nil {
+			return errors.Wrap(err, "couldn't write the product")
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "couldn't read the products")
+	}
+
+	return writer.Flush()
+}
+
 // Get returns a list with all the products stored in the database.
 func (s *service) Get(ctx context.Context, params params.Query) ([]Product, error) {
 	s.metrics.incMethodCalls("Get")
 
 	q, args := postgres.AddPagination(`SELECT p.*, r.*
 	FROM products AS p
-	LEFT JOIN reviews AS r ON p.id=r.product_id`, params)
+	LEFT JOIN reviews AS r ON p.id=r.product_id AND r.status='approved'`, params)
 
 	rows, err := s.db.QueryContext(ctx, q, args...)
 	if err != nil {
@@ -88,9 +200,10 @@ func (s *service) Get(ctx context.Context, params params.Query) ([]Product, erro
 		err := rows.Scan(
 			&p.ID, &p.ShopID, &p.Stock, &p.Brand, &p.Category, &p.Type,
 			&p.Description, &p.Weight, &p.Discount, &p.Taxes, &p.Subtotal,
-			&p.Total, &p.CreatedAt, &p.UpdatedAt,
+			&p.Total, &p.CreatedAt, &p.UpdatedAt, &p.Currency, &p.Options, &p.CategoryID, &p.SKU,
+			&p.RatingAverage, &p.RatingCount,
 			&r.ID, &r.Stars, &r.Comment, &r.UserID, &r.ProductID, &r.ShopID,
-			&r.CreatedAt,
+			&r.CreatedAt, &r.Verified, &r.Status, &r.UpdatedAt,
 		)
 		if err != nil {
 			return nil, errors.Wrap(err, "couldn't scan product")
@@ -100,6 +213,10 @@ func (s *service) Get(ctx context.Context, params params.Query) ([]Product, erro
 		products = append(products, p)
 	}
 
+	if err := s.withImages(ctx, products); err != nil {
+		return nil, err
+	}
+
 	return products, nil
 }
 
@@ -109,7 +226,7 @@ func (s *service) GetByID(ctx context.Context, id string) (Product, error) {
 
 	q := `SELECT p.*, r.*
 	FROM products p
-	LEFT JOIN reviews r ON p.id=r.product_id
+	LEFT JOIN reviews r ON p.id=r.product_id AND r.status='approved'
 	WHERE p.id=$1`
 	rows, err := s.db.QueryContext(ctx, q, id)
 	if err != nil {
@@ -123,9 +240,10 @@ func (s *service) GetByID(ctx context.Context, id string) (Product, error) {
 		err := rows.Scan(
 			&p.ID, &p.ShopID, &p.Stock, &p.Brand, &p.Category, &p.Type,
 			&p.Description, &p.Weight, &p.Discount, &p.Taxes, &p.Subtotal,
-			&p.Total, &p.CreatedAt, &p.UpdatedAt,
+			&p.Total, &p.CreatedAt, &p.UpdatedAt, &p.Currency, &p.Options, &p.CategoryID, &p.SKU,
+			&p.RatingAverage, &p.RatingCount,
 			&r.ID, &r.Stars, &r.Comment, &r.UserID, &r.ProductID, &r.ShopID,
-			&r.CreatedAt,
+			&r.CreatedAt, &r.Verified, &r.Status, &r.UpdatedAt,
 		)
 		if err != nil {
 			return Product{}, errors.Wrap(err, "couldn't scan product")
@@ -134,40 +252,473 @@ func (s *service) GetByID(ctx context.Context, id string) (Product, error) {
 		p.Reviews = append(p.Reviews, r)
 	}
 
+	if len(p.Options) > 0 {
+		variants, err := s.variants(ctx, id)
+		if err != nil {
+			return Product{}, err
+		}
+		p.Variants = variants
+	}
+
+	galleries, err := media.Galleries(ctx, s.db, media.Products, id)
+	if err != nil {
+		return Product{}, err
+	}
+	p.Images = galleries[id]
+
 	return p, nil
 }
 
-// Search looks for the products that contain the value specified. (Only text fields)
-func (s *service) Search(ctx context.Context, query string) ([]Product, error) {
+// GetVariants returns the variants of the product.
+func (s *service) GetVariants(ctx context.Context, productID string) ([]Variant, error) {
+	s.metrics.incMethodCalls("GetVariants")
+
+	return s.variants(ctx, productID)
+}
+
+// Import creates or updates the products of a catalog file, matching them by SKU.
+//
+// The file is read one record at a time, the ones that can't be imported are skipped
+// and included in the report. If provided, progress is called every importProgressInterval records.
+func (s *service) Import(ctx context.Context, r io.Reader, format Format, progress func(Report)) (Report, error) {
+	s.metrics.incMethodCalls("Import")
+
+	reader, err := newRecordReader(r, format)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var report Report
+	for {
+		rec, row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err == nil {
+			var created bool
+			created, err = s.importRecord(ctx, rec)
+			if err == nil {
+				if created {
+					report.Created++
+				} else {
+					report.Updated++
+				}
+			}
+		}
+
+		if err != nil {
+			var recErr *recordError
+			if !errors.As(err, &recErr) {
+				return report, err
+			}
+			report.fail(row, rec.SKU, err)
+		}
+
+		report.Processed++
+		if progress != nil && report.Processed%importProgressInterval == 0 {
+			progress(report)
+		}
+	}
+
+	return report, nil
+}
+
+// ImportJob returns the background import requested.
+func (s *service) ImportJob(ctx context.Context, id string) (ImportJob, error) {
+	s.metrics.incMethodCalls("ImportJob")
+
+	var job ImportJob
+	if err := s.db.GetContext(ctx, &job, "SELECT * FROM import_jobs WHERE id=$1", id); err != nil {
+		return ImportJob{}, errors.Wrap(err, "couldn't find the import job")
+	}
+
+	return job, nil
+}
+
+// PriceDrops returns the products in the user wishlists whose price is lower than when they were saved.
+//
+// The items saved with a variant are compared against the variant price when it has a subtotal.
+func (s *service) PriceDrops(ctx context.Context, userID string) ([]PriceDrop, error) {
+	s.metrics.incMethodCalls("PriceDrops")
+
+	var items []struct {
+		PriceDrop
+		VariantSubtotal zero.Int `db:"variant_subtotal"`
+		Discount        zero.Int
+		Taxes           zero.Int
+	}
+	q := `SELECT i.product_id, i.variant_id, i.wishlist_id, i.price AS saved_price,
+	p.total AS price, p.currency, v.subtotal AS variant_subtotal, p.discount, p.taxes
+	FROM wishlist_items AS i
+	JOIN wishlists AS w ON w.id=i.wishlist_id
+	JOIN products AS p ON p.id=i.product_id
+	LEFT JOIN product_variants AS v ON v.id=i.variant_id AND v.product_id=i.product_id
+	WHERE w.user_id=$1 AND p.currency=i.currency AND (p.total < i.price OR v.subtotal IS NOT NULL)
+	ORDER BY i.added_at`
+	if err := s.db.SelectContext(ctx, &items, q, userID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the price drops")
+	}
+
+	var drops []PriceDrop
+	for _, item := range items {
+		if item.VariantSubtotal.Valid {
+			item.Price = unitTotal(item.VariantSubtotal, item.Discount, item.Taxes).Int64
+		}
+		if item.Price < item.SavedPrice {
+			drops = append(drops, item.PriceDrop)
+		}
+	}
+
+	return drops, nil
+}
+
+// Search looks for the products that match the query and filters, it returns them along
+// with the cursor to the next page.
+//
+// The brand, type, category and description are matched using full text search, the
+// first three also tolerate typos by comparing their trigrams. The relevance of a product
+// is the sum of both ranks.
+func (s *service) Search(ctx context.Context, search params.Search) ([]Product, string, error) {
 	s.metrics.incMethodCalls("Search")
 
-	var products []Product
-	q := `SELECT * FROM products WHERE
-	to_tsvector(id || ' ' ||  shop_id || ' ' || brand || ' ' || type || ' ' || category)
-	@@ plainto_tsquery($1)`
-	if err := s.db.SelectContext(ctx, &products, q, query); err != nil {
-		return nil, errors.Wrap(err, "couldn't find products")
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
 	}
 
-	return products, nil
+	rank := "0"
+	conditions := []string{"TRUE"}
+	if search.Query != "" {
+		query := arg(search.Query)
+		vector := "product_search(p.brand, p.type, p.category, p.description)"
+		tsquery := "plainto_tsquery('english', " + query + ")"
+		text := "(p.brand || ' ' || p.type || ' ' || p.category)"
+		rank = fmt.Sprintf("ts_rank(%s, %s) + word_similarity(%s, %s)", vector, tsquery, query, text)
+		conditions = append(conditions, fmt.Sprintf("(%s @@ %s OR %s <%% %s)", vector, tsquery, query, text))
+	}
+	if len(search.Brands) > 0 {
+		conditions = append(conditions, "p.brand = ANY("+arg(pq.Array(search.Brands))+")")
+	}
+	if search.ShopID != "" {
+		conditions = append(conditions, "p.shop_id="+arg(search.ShopID))
+	}
+	if search.MinPrice > 0 {
+		conditions = append(conditions, "p.total >= "+arg(search.MinPrice))
+	}
+	if search.MaxPrice > 0 {
+		conditions = append(conditions, "p.total <= "+arg(search.MaxPrice))
+	}
+	if search.InStock {
+		conditions = append(conditions,
+			"(p.stock > 0 OR EXISTS(SELECT 1 FROM product_variants AS v WHERE v.product_id=p.id AND v.stock > 0))")
+	}
+	if search.MinRating > 0 {
+		conditions = append(conditions,
+			"p.rating_average >= "+arg(search.MinRating))
+	}
+
+	var column, cast, order, comparison string
+	switch search.Sort {
+	case params.SortRelevance:
+		column, cast, order, comparison = "rank", "real", "DESC", "<"
+	case params.SortPriceAsc:
+		column, cast, order, comparison = "total", "integer", "ASC", ">"
+	case params.SortPriceDesc:
+		column, cast, order, comparison = "total", "integer", "DESC", "<"
+	default:
+		column, cast, order, comparison = "created_at", "timestamptz", "DESC", "<"
+	}
+
+	q := fmt.Sprintf(`SELECT * FROM (
+		SELECT p.*, %s AS rank FROM products AS p WHERE %s
+	) AS results`, rank, strings.Join(conditions, " AND "))
+	if search.Cursor.Used {
+		value, id := arg(search.Cursor.Value)+"::"+cast, arg(search.Cursor.ID)
+		q += fmt.Sprintf(" WHERE %[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND id %[2]s %[4]s)",
+			column, comparison, value, id)
+	}
+	q += fmt.Sprintf(" ORDER BY %[1]s %[2]s, id %[2]s LIMIT %[3]s", column, order, arg(search.Limit))
+
+	var results []struct {
+		Product
+		Rank float32
+	}
+	if err := s.db.SelectContext(ctx, &results, q, args...); err != nil {
+		return nil, "", errors.Wrap(err, "couldn't find products")
+	}
+
+	if len(results) == 0 {
+		return nil, "", nil
+	}
+
+	products := make([]Product, len(results))
+	for i, r := range results {
+		products[i] = r.Product
+	}
+	if err := s.withImages(ctx, products); err != nil {
+		return nil, "", err
+	}
+
+	last := results[len(results)-1]
+	var value string
+	switch column {
+	case "rank":
+		value = strconv.FormatFloat(float64(last.Rank), 'g', -1, 32)
+	case "total":
+		value = strconv.FormatInt(last.Total.Int64, 10)
+	default:
+		value = last.CreatedAt.Time.Format(time.RFC3339Nano)
+	}
+
+	return products, params.EncodeSearchCursor(value, last.ID.String), nil
+}
+
+// StartImport saves the job and runs the import in the background, r is closed once it's finished.
+func (s *service) StartImport(ctx context.Context, job ImportJob, r io.ReadCloser) error {
+	s.metrics.incMethodCalls("StartImport")
+
+	q := `INSERT INTO import_jobs
+	(id, format, status, created_at)
+	VALUES ($1, $2, $3, $4)`
+	if _, err := s.db.ExecContext(ctx, q, job.ID, job.Format, job.Status, job.CreatedAt); err != nil {
+		r.Close()
+		return errors.Wrap(err, "couldn't create the import job")
+	}
+
+	// The job must outlive the request that started it
+	go s.runImport(job, r)
+	return nil
 }
 
 // Update updates product fields.
+//
+// The options are kept if none are provided, they can't be changed while the product has variants.
 func (s *service) Update(ctx context.Context, id string, p UpdateProduct) error {
 	s.metrics.incMethodCalls("Update")
 
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var options Options
+	if err := tx.GetContext(ctx, &options, "SELECT options FROM products WHERE id=$1 FOR UPDATE", id); err != nil {
+		return errors.Wrap(err, "couldn't find the product")
+	}
+
+	if p.Options == nil {
+		p.Options = options
+	} else if !reflect.DeepEqual(p.Options, options) {
+		var hasVariants bool
+		q := "SELECT EXISTS(SELECT 1 FROM product_variants WHERE product_id=$1)"
+		if err := tx.GetContext(ctx, &hasVariants, q, id); err != nil {
+			return errors.Wrap(err, "couldn't find the variants")
+		}
+		if hasVariants {
+			return errors.New("the options of a product with variants can't be modified, delete its variants first")
+		}
+	}
+
 	q := `UPDATE products SET stock=$2, brand=$3, category=$4, type=$5,
-	description=$6, weight=$7, discount=$8, taxes=$9, subtotal=$10, total=$11
+	description=$6, weight=$7, discount=$8, taxes=$9, subtotal=$10, total=$11, options=$12,
+	category_id=$13
 	WHERE id=$1`
-	_, err := s.db.ExecContext(ctx, q, id, p.Stock, p.Brand, p.Category, p.Type,
-		p.Description, p.Weight, p.Discount, p.Taxes, p.Subtotal, p.Total)
+	_, err = tx.ExecContext(ctx, q, id, p.Stock, p.Brand, p.Category, p.Type,
+		p.Description, p.Weight, p.Discount, p.Taxes, p.Subtotal, p.Total, p.Options, p.CategoryID)
 	if err != nil {
 		return errors.Wrap(err, "couldn't update the product")
 	}
 
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
 	if err := s.mc.Delete(id); err != nil && err != memcache.ErrCacheMiss {
 		return errors.Wrap(err, "couldn't delete product from cache")
 	}
 
 	return nil
 }
+
+// UpdateVariant updates the variant fields.
+func (s *service) UpdateVariant(ctx context.Context, productID, variantID string, v Variant) error {
+	s.metrics.incMethodCalls("UpdateVariant")
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := s.checkVariantOptions(ctx, tx, productID, variantID, v.Options); err != nil {
+		return err
+	}
+
+	q := `UPDATE product_variants SET sku=$3, options=$4, stock=$5, weight=$6, subtotal=$7, updated_at=$8
+	WHERE id=$1 AND product_id=$2`
+	res, err := tx.ExecContext(ctx, q, variantID, productID, v.SKU, v.Options,
+		v.Stock, v.Weight, v.Subtotal, v.UpdatedAt)
+	if err != nil {
+		return errors.Wrap(err, "couldn't update the variant")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.New("variant not found")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	if err := s.mc.Delete(productID); err != nil && err != memcache.ErrCacheMiss {
+		return errors.Wrap(err, "couldn't delete product from cache")
+	}
+
+	return nil
+}
+
+// checkVariantOptions locks the product and verifies that the options are valid for it
+// and that no other variant (except the one excluded) has them.
+func (s *service) checkVariantOptions(ctx context.Context, tx *sqlx.Tx, productID, excludedID string,
+	values VariantOptions) error {
+	var options Options
+	q := "SELECT options FROM products WHERE id=$1 FOR UPDATE"
+	if err := tx.GetContext(ctx, &options, q, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("product not found")
+		}
+		return errors.Wrap(err, "couldn't find the product")
+	}
+
+	if err := options.Validate(values); err != nil {
+		return err
+	}
+
+	var exists bool
+	q = "SELECT EXISTS(SELECT 1 FROM product_variants WHERE product_id=$1 AND options=$2 AND id<>$3)"
+	if err := tx.GetContext(ctx, &exists, q, productID, values, excludedID); err != nil {
+		return errors.Wrap(err, "couldn't find the variants")
+	}
+	if exists {
+		return errors.New("there is already a variant with the same options")
+	}
+
+	return nil
+}
+
+func (s *service) variants(ctx context.Context, productID string) ([]Variant, error) {
+	var variants []Variant
+	q := "SELECT * FROM product_variants WHERE product_id=$1 ORDER BY created_at"
+	if err := s.db.SelectContext(ctx, &variants, q, productID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the variants")
+	}
+
+	return variants, nil
+}
+
+// withImages sets the gallery of each one of the products.
+func (s *service) withImages(ctx context.Context, products []Product) error {
+	ids := make([]string, len(products))
+	for i, p := range products {
+		ids[i] = p.ID.String
+	}
+
+	galleries, err := media.Galleries(ctx, s.db, media.Products, ids...)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range products {
+		products[i].Images = galleries[p.ID.String]
+	}
+
+	return nil
+}
+
+// importRecord creates or updates the product with the record SKU and returns whether it was created.
+//
+// Errors caused by the record content are returned wrapped in a *recordError.
+func (s *service) importRecord(ctx context.Context, rec Record) (bool, error) {
+	if err := validate.Struct(ctx, rec); err != nil {
+		return false, &recordError{err}
+	}
+	if rec.Currency != "" {
+		if err := currency.Validate(rec.Currency); err != nil {
+			return false, &recordError{err}
+		}
+	}
+
+	discount, taxes, subtotal := zero.IntFrom(rec.Discount), zero.IntFrom(rec.Taxes), zero.IntFrom(rec.Subtotal)
+	q := `INSERT INTO products
+	(id, sku, shop_id, stock, brand, category, type, description, weight,
+	discount, taxes, subtotal, total, created_at, currency, category_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $11, $12, $13, $14,
+	COALESCE(NULLIF($15, ''), (SELECT currency FROM shops WHERE id=$3)), NULLIF($16, ''))
+	ON CONFLICT (sku) DO UPDATE SET
+	shop_id=EXCLUDED.shop_id, stock=EXCLUDED.stock, brand=EXCLUDED.brand,
+	category=EXCLUDED.category, type=EXCLUDED.type, description=EXCLUDED.description,
+	weight=EXCLUDED.weight, discount=EXCLUDED.discount, taxes=EXCLUDED.taxes,
+	subtotal=EXCLUDED.subtotal, total=EXCLUDED.total, currency=EXCLUDED.currency,
+	category_id=EXCLUDED.category_id, updated_at=$14
+	RETURNING id, (xmax = 0) AS created`
+
+	var result struct {
+		ID      string
+		Created bool
+	}
+	err := s.db.GetContext(ctx, &result, q, uuid.NewString(), rec.SKU, rec.ShopID, rec.Stock,
+		rec.Brand, rec.Category, rec.Type, rec.Description, rec.Weight, discount, taxes, subtotal,
+		unitTotal(subtotal, discount, taxes), time.Now(), currency.Normalize(rec.Currency), rec.CategoryID)
+	if err != nil {
+		// Constraint violations like unknown shops or categories are caused by the record
+		if _, ok := errors.Cause(err).(*pq.Error); ok {
+			return false, &recordError{err}
+		}
+		return false, errors.Wrap(err, "couldn't save the product")
+	}
+
+	if result.Created {
+		s.metrics.totalProducts.Inc()
+		return true, nil
+	}
+
+	if err := s.mc.Delete(result.ID); err != nil && err != memcache.ErrCacheMiss {
+		return false, errors.Wrap(err, "couldn't delete product from cache")
+	}
+	return false, nil
+}
+
+// runImport executes the import job and keeps its report up to date.
+func (s *service) runImport(job ImportJob, r io.ReadCloser) {
+	defer r.Close()
+	ctx := context.Background()
+
+	report, err := s.Import(ctx, r, job.Format, func(report Report) {
+		if err := s.updateImportJob(ctx, job.ID, JobRunning, report, ""); err != nil {
+			logger.Errorf("import job %s: %v", job.ID, err)
+		}
+	})
+
+	status, reason := JobCompleted, ""
+	if err != nil {
+		status, reason = JobFailed, err.Error()
+	}
+	if err := s.updateImportJob(ctx, job.ID, status, report, reason); err != nil {
+		logger.Errorf("import job %s: %v", job.ID, err)
+	}
+}
+
+func (s *service) updateImportJob(ctx context.Context, id string, status JobStatus, report Report, reason string) error {
+	q := `UPDATE import_jobs SET
+	status=$2, processed=$3, created=$4, updated=$5, failed=$6, errors=$7, error=NULLIF($8, ''),
+	finished_at=(CASE WHEN $2 = 'running' THEN NULL ELSE NOW() END)
+	WHERE id=$1`
+	_, err := s.db.ExecContext(ctx, q, id, status, report.Processed, report.Created,
+		report.Updated, report.Failed, report.Errors, reason)
+	if err != nil {
+		return errors.Wrap(err, "couldn't update the import job")
+	}
+	return nil
+}
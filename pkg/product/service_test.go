@@ -1,7 +1,9 @@
 package product_test
 
 import (
+	"bytes"
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/GGP1/adak/internal/logger"
@@ -54,6 +56,8 @@ func TestProductService(t *testing.T) {
 	t.Run("Get by id", getByID(ctx, s))
 	t.Run("Update", update(ctx, s))
 	t.Run("Search", search(ctx, s))
+	t.Run("Import", importCatalog(ctx, s))
+	t.Run("Export", exportCatalog(ctx, s))
 	t.Run("Delete", delete(ctx, s))
 }
 
@@ -118,7 +122,7 @@ func update(ctx context.Context, s product.Service) func(t *testing.T) {
 
 func search(ctx context.Context, s product.Service) func(t *testing.T) {
 	return func(t *testing.T) {
-		products, err := s.Search(ctx, "brand")
+		products, _, err := s.Search(ctx, params.Search{Query: "brandd", Sort: params.SortRelevance, Limit: "10"})
 		assert.NoError(t, err)
 
 		t.Log(products)
@@ -131,16 +135,87 @@ func search(ctx context.Context, s product.Service) func(t *testing.T) {
 			}
 		}
 		assert.Equal(t, true, found)
+
+		products, _, err = s.Search(ctx, params.Search{Query: "brand", MinPrice: 2, Sort: params.SortPriceAsc, Limit: "10"})
+		assert.NoError(t, err)
+		assert.Empty(t, products)
+	}
+}
+
+func importCatalog(ctx context.Context, s product.Service) func(t *testing.T) {
+	return func(t *testing.T) {
+		file := `sku,shop_id,brand,category,type,weight,subtotal,stock
+SKU-1,6,imported,category,type,100,1500,3
+SKU-2,6,imported,category,type,0,1500,3
+SKU-3,unknown,imported,category,type,100,1500,3
+SKU-4,6,imported,category,type,heavy,1500,3
+SKU-5,6,imported,category,type,100,2000,1`
+		report, err := s.Import(ctx, strings.NewReader(file), product.FormatCSV, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), report.Processed)
+		assert.Equal(t, int64(2), report.Created)
+		assert.Equal(t, int64(3), report.Failed)
+		assert.Equal(t, []int64{3, 4, 5}, []int64{report.Errors[0].Row, report.Errors[1].Row, report.Errors[2].Row})
+		assert.Equal(t, "SKU-2", report.Errors[0].SKU)
+
+		file = `{"sku":"SKU-1","shop_id":"6","brand":"updated","category":"category","type":"type","weight":100,"subtotal":1000,"stock":5}
+
+{"sku":"SKU-6","unknown":true}`
+		report, err = s.Import(ctx, strings.NewReader(file), product.FormatJSONL, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), report.Processed)
+		assert.Equal(t, int64(1), report.Updated)
+		assert.Equal(t, int64(1), report.Failed)
+		assert.Equal(t, int64(3), report.Errors[0].Row)
+
+		_, err = s.Import(ctx, strings.NewReader("sku,color\nSKU-1,red"), product.FormatCSV, nil)
+		var fileErr *product.FileError
+		assert.ErrorAs(t, err, &fileErr)
+	}
+}
+
+func exportCatalog(ctx context.Context, s product.Service) func(t *testing.T) {
+	return func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		assert.NoError(t, s.Export(ctx, buf, product.FormatCSV))
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		assert.Equal(t, "sku,shop_id,brand,category,type,description,weight,discount,taxes,subtotal,stock,currency,category_id", lines[0])
+		var found bool
+		for _, line := range lines {
+			if strings.HasPrefix(line, "SKU-1,6,updated,category,type,,100,0,0,1000,5,") {
+				found = true
+			}
+		}
+		assert.True(t, found)
+
+		// The exported file can be imported back
+		buf.Reset()
+		assert.NoError(t, s.Export(ctx, buf, product.FormatJSONL))
+		var records []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if strings.Contains(line, `"sku":"SKU-`) {
+				records = append(records, line)
+			}
+		}
+		report, err := s.Import(ctx, strings.NewReader(strings.Join(records, "\n")), product.FormatJSONL, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), report.Updated)
+		assert.Equal(t, int64(0), report.Failed)
 	}
 }
 
 func createRelationship(ctx context.Context, t *testing.T, db *sqlx.DB, mc *memcache.Client) {
 	t.Helper()
 
+	q := "INSERT INTO users (id, cart_id, username, email, password) VALUES ('owner', 'owner', 'owner', 'owner@adak.com', 'password')"
+	_, err := db.ExecContext(ctx, q)
+	assert.NoError(t, err)
+
 	shopService := shop.NewService(db, mc)
-	err := shopService.Create(ctx, shop.Shop{
+	err = shopService.Create(ctx, shop.Shop{
 		ID:   "6",
 		Name: "test",
-	})
+	}, "owner")
 	assert.NoError(t, err)
 }
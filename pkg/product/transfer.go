@@ -0,0 +1,371 @@
+package product
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GGP1/adak/internal/jsonb"
+
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// Format of the files used to import and export the catalog.
+type Format string
+
+// Catalog file formats.
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+// JobStatus is the state of a background import.
+type JobStatus string
+
+// Import job statuses.
+const (
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+)
+
+// importProgressInterval is the number of records processed between each progress report.
+const importProgressInterval = 500
+
+// maxReportErrors is the number of row errors kept in a report, the rest are only counted.
+const maxReportErrors = 1000
+
+// maxLineSize is the maximum length of a JSON Lines record.
+const maxLineSize = 1 << 20
+
+// ParseFormat returns the format matching the name or content type provided.
+func ParseFormat(s string) (Format, error) {
+	if mediaType, _, err := mime.ParseMediaType(s); err == nil {
+		s = mediaType
+	}
+
+	switch strings.ToLower(s) {
+	case "csv", "text/csv":
+		return FormatCSV, nil
+	case "jsonl", "ndjson", "application/jsonl", "application/x-ndjson", "application/x-jsonlines":
+		return FormatJSONL, nil
+	}
+	return "", errors.Errorf("unsupported format %q, use csv or jsonl", s)
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == FormatJSONL {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Record is a product in a catalog file, products are matched by their SKU when imported.
+type Record struct {
+	SKU         string `json:"sku" validate:"required,max=64"`
+	ShopID      string `json:"shop_id" db:"shop_id" validate:"required"`
+	Brand       string `json:"brand" validate:"required"`
+	Category    string `json:"category" validate:"required"`
+	Type        string `json:"type" validate:"required"`
+	Description string `json:"description,omitempty"`
+	Weight      int64  `json:"weight" validate:"required,min=1"`
+	Discount    int64  `json:"discount,omitempty" validate:"min=0,max=100"`
+	Taxes       int64  `json:"taxes,omitempty" validate:"min=0"`
+	Subtotal    int64  `json:"subtotal" validate:"required"`
+	Stock       int64  `json:"stock" validate:"min=0"`
+	// Currency of the amounts, the shop one is used if it's empty
+	Currency   string `json:"currency,omitempty" validate:"omitempty,len=3"`
+	CategoryID string `json:"category_id,omitempty" db:"category_id" validate:"omitempty,uuid4_rfc4122"`
+}
+
+// csvColumns are the columns of the catalog CSV files, in the order they are exported.
+var csvColumns = []string{
+	"sku", "shop_id", "brand", "category", "type", "description", "weight",
+	"discount", "taxes", "subtotal", "stock", "currency", "category_id",
+}
+
+// csvRequired are the columns the imported CSV files must include.
+var csvRequired = []string{"sku", "shop_id", "brand", "category", "type", "weight", "subtotal"}
+
+// fields returns the record values in the csvColumns order.
+func (r Record) fields() []string {
+	return []string{
+		r.SKU, r.ShopID, r.Brand, r.Category, r.Type, r.Description,
+		strconv.FormatInt(r.Weight, 10), strconv.FormatInt(r.Discount, 10),
+		strconv.FormatInt(r.Taxes, 10), strconv.FormatInt(r.Subtotal, 10),
+		strconv.FormatInt(r.Stock, 10), r.Currency, r.CategoryID,
+	}
+}
+
+// set assigns the value to the record field matching the column.
+func (r *Record) set(column, value string) error {
+	switch column {
+	case "sku":
+		r.SKU = value
+	case "shop_id":
+		r.ShopID = value
+	case "brand":
+		r.Brand = value
+	case "category":
+		r.Category = value
+	case "type":
+		r.Type = value
+	case "description":
+		r.Description = value
+	case "currency":
+		r.Currency = value
+	case "category_id":
+		r.CategoryID = value
+	default:
+		if value == "" {
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.Errorf("invalid %s %q", column, value)
+		}
+		switch column {
+		case "weight":
+			r.Weight = n
+		case "discount":
+			r.Discount = n
+		case "taxes":
+			r.Taxes = n
+		case "subtotal":
+			r.Subtotal = n
+		case "stock":
+			r.Stock = n
+		}
+	}
+	return nil
+}
+
+// RowError describes why a record couldn't be imported.
+type RowError struct {
+	// Row is the line of the record in the file, the CSV header is the first one
+	Row   int64  `json:"row"`
+	SKU   string `json:"sku,omitempty"`
+	Error string `json:"error"`
+}
+
+// RowErrors is a list of row errors stored as JSON.
+type RowErrors []RowError
+
+// Scan implements the sql.Scanner interface.
+func (e *RowErrors) Scan(src interface{}) error {
+	return jsonb.Scan(src, e)
+}
+
+// Value implements the driver.Valuer interface.
+func (e RowErrors) Value() (driver.Value, error) {
+	if e == nil {
+		return "[]", nil
+	}
+	return jsonb.Value(e)
+}
+
+// Report summarizes the result of an import.
+type Report struct {
+	Processed int64 `json:"processed"`
+	Created   int64 `json:"created"`
+	Updated   int64 `json:"updated"`
+	Failed    int64 `json:"failed"`
+	// Errors contains up to the first thousand row errors
+	Errors RowErrors `json:"errors,omitempty"`
+}
+
+func (r *Report) fail(row int64, sku string, err error) {
+	r.Failed++
+	if len(r.Errors) < maxReportErrors {
+		r.Errors = append(r.Errors, RowError{Row: row, SKU: sku, Error: err.Error()})
+	}
+}
+
+// ImportJob is an import running in the background, its report is updated as it progresses.
+type ImportJob struct {
+	ID     string    `json:"id"`
+	Format Format    `json:"format"`
+	Status JobStatus `json:"status"`
+	Report
+	// Error is the reason the import stopped when it failed
+	Error      zero.String `json:"error,omitempty"`
+	CreatedAt  time.Time   `json:"created_at" db:"created_at"`
+	FinishedAt zero.Time   `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+// FileError is returned when the catalog file can't be read in the format specified.
+type FileError struct {
+	Err error
+}
+
+func (e *FileError) Error() string {
+	return "invalid file: " + e.Err.Error()
+}
+
+// recordReader reads the records of a catalog file one at a time.
+//
+// Read returns io.EOF when there are no more records, errors wrapped in a *recordError
+// affect only the record read and the following ones can still be read.
+type recordReader interface {
+	Read() (Record, int64, error)
+}
+
+type recordError struct {
+	err error
+}
+
+func (e *recordError) Error() string {
+	return e.err.Error()
+}
+
+func newRecordReader(r io.Reader, format Format) (recordReader, error) {
+	if format == FormatJSONL {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+		return &jsonlReader{scanner: scanner}, nil
+	}
+	return newCSVReader(r)
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns []string
+	row     int64
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, &FileError{errors.New("the file is empty")}
+		}
+		return nil, &FileError{errors.Wrap(err, "reading the header")}
+	}
+
+	columns := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !contains(csvColumns, name) {
+			return nil, &FileError{errors.Errorf("unknown column %q", name)}
+		}
+		if seen[name] {
+			return nil, &FileError{errors.Errorf("duplicated column %q", name)}
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+	for _, name := range csvRequired {
+		if !seen[name] {
+			return nil, &FileError{errors.Errorf("missing column %q", name)}
+		}
+	}
+
+	return &csvReader{reader: reader, columns: columns, row: 1}, nil
+}
+
+func (c *csvReader) Read() (Record, int64, error) {
+	fields, err := c.reader.Read()
+	c.row++
+	if err != nil {
+		if err == io.EOF {
+			return Record{}, c.row, err
+		}
+		if _, ok := err.(*csv.ParseError); ok {
+			return Record{}, c.row, &recordError{err}
+		}
+		return Record{}, c.row, &FileError{err}
+	}
+
+	var rec Record
+	for i, value := range fields {
+		if err := rec.set(c.columns[i], strings.TrimSpace(value)); err != nil {
+			return rec, c.row, &recordError{err}
+		}
+	}
+	return rec, c.row, nil
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	row     int64
+}
+
+func (j *jsonlReader) Read() (Record, int64, error) {
+	for j.scanner.Scan() {
+		j.row++
+		line := bytes.TrimSpace(j.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec Record
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&rec); err != nil {
+			return rec, j.row, &recordError{err}
+		}
+		return rec, j.row, nil
+	}
+
+	if err := j.scanner.Err(); err != nil {
+		return Record{}, j.row + 1, &FileError{errors.Wrapf(err, "reading line %d", j.row+1)}
+	}
+	return Record{}, j.row, io.EOF
+}
+
+// recordWriter writes the records of a catalog file.
+type recordWriter interface {
+	Write(rec Record) error
+	Flush() error
+}
+
+func newRecordWriter(w io.Writer, format Format) (recordWriter, error) {
+	if format == FormatJSONL {
+		return jsonlWriter{json.NewEncoder(w)}, nil
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvColumns); err != nil {
+		return nil, err
+	}
+	return csvWriter{writer}, nil
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func (c csvWriter) Write(rec Record) error {
+	return c.writer.Write(rec.fields())
+}
+
+func (c csvWriter) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (j jsonlWriter) Write(rec Record) error {
+	return j.encoder.Encode(rec)
+}
+
+func (j jsonlWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,37 @@
+package product_test
+
+import (
+	"testing"
+
+	"github.com/GGP1/adak/pkg/product"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormat(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected product.Format
+	}{
+		{input: "csv", expected: product.FormatCSV},
+		{input: "CSV", expected: product.FormatCSV},
+		{input: "text/csv; charset=utf-8", expected: product.FormatCSV},
+		{input: "jsonl", expected: product.FormatJSONL},
+		{input: "ndjson", expected: product.FormatJSONL},
+		{input: "application/x-ndjson", expected: product.FormatJSONL},
+		{input: "application/jsonl", expected: product.FormatJSONL},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := product.ParseFormat(tc.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+
+	for _, input := range []string{"", "json", "application/json", "text/plain"} {
+		_, err := product.ParseFormat(input)
+		assert.Error(t, err, input)
+	}
+}
@@ -0,0 +1,97 @@
+package product
+
+import (
+	"database/sql/driver"
+
+	"github.com/GGP1/adak/internal/jsonb"
+
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// Option is an axis the product varies on, like the size or the color.
+type Option struct {
+	Name   string   `json:"name" validate:"required"`
+	Values []string `json:"values" validate:"required,min=1"`
+}
+
+// Options are the axes of a product, they are stored as a JSON array.
+type Options []Option
+
+// Scan implements the sql.Scanner interface.
+func (o *Options) Scan(src interface{}) error {
+	return jsonb.Scan(src, o)
+}
+
+// Value implements the driver.Valuer interface.
+func (o Options) Value() (driver.Value, error) {
+	if o == nil {
+		return "[]", nil
+	}
+	return jsonb.Value(o)
+}
+
+// Validate checks that the variant has a valid value for each one of the options, and nothing else.
+func (o Options) Validate(values VariantOptions) error {
+	if len(o) == 0 {
+		return errors.New("the product has no options to vary on")
+	}
+	if len(values) != len(o) {
+		return errors.Errorf("expected a value for each of the %d options, got %d", len(o), len(values))
+	}
+
+	for _, option := range o {
+		value, ok := values[option.Name]
+		if !ok {
+			return errors.Errorf("missing value for option %q", option.Name)
+		}
+		if !contains(option.Values, value) {
+			return errors.Errorf("invalid value %q for option %q", value, option.Name)
+		}
+	}
+
+	return nil
+}
+
+// VariantOptions contains the value of each option of a variant, {"size": "M", "color": "red"}.
+type VariantOptions map[string]string
+
+// Scan implements the sql.Scanner interface.
+func (v *VariantOptions) Scan(src interface{}) error {
+	return jsonb.Scan(src, v)
+}
+
+// Value implements the driver.Valuer interface.
+func (v VariantOptions) Value() (driver.Value, error) {
+	if v == nil {
+		return "{}", nil
+	}
+	return jsonb.Value(v)
+}
+
+// Variant is a combination of the product options that has its own SKU and stock,
+// like a T-shirt in size M and red color.
+//
+// Weight and Subtotal override the product ones when they are set, the product discount
+// and taxes apply to all the variants.
+type Variant struct {
+	ID        string         `json:"id,omitempty"`
+	ProductID string         `json:"product_id,omitempty" db:"product_id"`
+	SKU       string         `json:"sku,omitempty" validate:"required,max=64"`
+	Options   VariantOptions `json:"options,omitempty" validate:"required"`
+	Stock     int64          `json:"stock" validate:"min=0"`
+	// 1000 = 1kg
+	Weight    zero.Int  `json:"weight,omitempty" validate:"omitempty,min=1"`
+	Subtotal  zero.Int  `json:"subtotal,omitempty" validate:"omitempty,min=0"`
+	CreatedAt zero.Time `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt zero.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package product_test
+
+import (
+	"testing"
+
+	"github.com/GGP1/adak/pkg/product"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	options := product.Options{
+		{Name: "size", Values: []string{"S", "M", "L"}},
+		{Name: "color", Values: []string{"red", "blue"}},
+	}
+
+	cases := []struct {
+		desc   string
+		values product.VariantOptions
+		valid  bool
+	}{
+		{desc: "valid", values: product.VariantOptions{"size": "M", "color": "red"}, valid: true},
+		{desc: "missing option", values: product.VariantOptions{"size": "M"}},
+		{desc: "unknown option", values: product.VariantOptions{"size": "M", "fabric": "cotton"}},
+		{desc: "invalid value", values: product.VariantOptions{"size": "XL", "color": "red"}},
+		{desc: "extra option", values: product.VariantOptions{"size": "M", "color": "red", "fabric": "cotton"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := options.Validate(tc.values)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	assert.Error(t, product.Options{}.Validate(product.VariantOptions{"size": "M"}))
+}
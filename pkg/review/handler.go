@@ -1,17 +1,19 @@
 package review
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 
-	"github.com/GGP1/adak/internal/cookie"
 	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/principal"
 	"github.com/GGP1/adak/internal/response"
 	"github.com/GGP1/adak/internal/token"
 	"github.com/GGP1/adak/internal/validate"
 	"github.com/google/uuid"
 
 	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/pkg/errors"
 	"gopkg.in/guregu/null.v4/zero"
 )
 
@@ -20,6 +22,10 @@ type cursorResponse struct {
 	Reviews    []Review `json:"reviews,omitempty"`
 }
 
+type moderateRequest struct {
+	Status string `json:"status"`
+}
+
 // Handler handles reviews endpoints.
 type Handler struct {
 	service Service
@@ -39,17 +45,12 @@ func (h *Handler) Create() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		userID, err := cookie.GetValue(r, "UID")
+		userID, err := principal.UserID(ctx)
 		if err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
 		}
 
-		if err := token.CheckPermits(r, userID); err != nil {
-			response.Error(w, http.StatusUnauthorized, err)
-			return
-		}
-
 		var review Review
 		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
 			response.Error(w, http.StatusBadRequest, err)
@@ -62,8 +63,19 @@ func (h *Handler) Create() http.HandlerFunc {
 			return
 		}
 
+		if err := review.Validate(); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
 		review.ID = zero.StringFrom(uuid.NewString())
-		if err := h.service.Create(ctx, review); err != nil {
+		review.UserID = zero.StringFrom(userID)
+		review, err = h.service.Create(ctx, review)
+		if err != nil {
+			if err == ErrAlreadyReviewed {
+				response.Error(w, http.StatusConflict, err)
+				return
+			}
 			response.Error(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -72,7 +84,7 @@ func (h *Handler) Create() http.HandlerFunc {
 	}
 }
 
-// Delete removes a review.
+// Delete removes a review, only its author can do it.
 func (h *Handler) Delete() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -83,6 +95,17 @@ func (h *Handler) Delete() http.HandlerFunc {
 			return
 		}
 
+		review, err := h.service.GetByID(ctx, id)
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		if err := token.CheckPermits(ctx, review.UserID.String); err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
 		if err := h.service.Delete(ctx, id); err != nil {
 			response.Error(w, http.StatusInternalServerError, err)
 			return
@@ -150,3 +173,147 @@ func (h *Handler) GetByID() http.HandlerFunc {
 		response.JSONAndCache(h.cache, w, id, review)
 	}
 }
+
+// Moderate sets the status of a review.
+func (h *Handler) Moderate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var req moderateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		status, err := ParseStatus(req.Status)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.Moderate(ctx, id, status); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, id)
+	}
+}
+
+// Queue lists the reviews with the status requested, pending by default.
+func (h *Handler) Queue() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		urlParams, err := params.ParseQuery(r.URL.RawQuery, params.Review)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		status := Pending
+		if s := r.URL.Query().Get("status"); s != "" {
+			status, err = ParseStatus(s)
+			if err != nil {
+				response.Error(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		reviews, err := h.service.Queue(ctx, status, urlParams)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		var nextCursor string
+		if len(reviews) > 0 {
+			nextCursor = params.EncodeCursor(
+				reviews[len(reviews)-1].CreatedAt.Time,
+				reviews[len(reviews)-1].ID.String,
+			)
+		}
+
+		response.JSON(w, http.StatusOK, cursorResponse{
+			NextCursor: nextCursor,
+			Reviews:    reviews,
+		})
+	}
+}
+
+// Remove deletes any review, it's reserved to admins.
+func (h *Handler) Remove() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.Delete(ctx, id); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, id)
+	}
+}
+
+// Update edits a review, only its author can do it.
+func (h *Handler) Update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		var review UpdateReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := review.Validate(); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.Update(ctx, id, userID, review); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, id)
+	}
+}
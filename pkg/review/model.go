@@ -1,16 +1,75 @@
 package review
 
 import (
+	"github.com/pkg/errors"
 	"gopkg.in/guregu/null.v4/zero"
 )
 
+const maxCommentLength = 2000
+
+// Status is the moderation state of a review.
+type Status string
+
+// Review statuses
+const (
+	// Pending reviews are waiting for an admin to moderate them
+	Pending Status = "pending"
+	// Approved reviews are public and count towards the ratings
+	Approved Status = "approved"
+	// Rejected reviews are only visible to admins
+	Rejected Status = "rejected"
+)
+
+// ErrAlreadyReviewed is returned when the user has already reviewed the product or shop.
+var ErrAlreadyReviewed = errors.New("the product or shop was already reviewed by the user")
+
 // Review represents users critics over a shop or product.
 type Review struct {
 	ID        zero.String `json:"id,omitempty"`
 	Stars     zero.Int    `json:"stars,omitempty" validate:"min=0,max=5"`
 	Comment   zero.String `json:"comment,omitempty"`
-	UserID    zero.String `json:"user_id,omitempty" db:"user_id" validate:"required"`
+	UserID    zero.String `json:"user_id,omitempty" db:"user_id"`
 	ProductID zero.String `json:"product_id,omitempty" db:"product_id" validate:"required_without=ShopID"`
 	ShopID    zero.String `json:"shop_id,omitempty" db:"shop_id" validate:"required_without=ProductID"`
 	CreatedAt zero.Time   `json:"created_at,omitempty" db:"created_at"`
+	// Verified is set when the user received the product reviewed
+	Verified  zero.Bool   `json:"verified,omitempty"`
+	Status    zero.String `json:"status,omitempty"`
+	UpdatedAt zero.Time   `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// UpdateReview is the structure used to edit reviews.
+type UpdateReview struct {
+	Stars   zero.Int    `json:"stars,omitempty"`
+	Comment zero.String `json:"comment,omitempty"`
+}
+
+// ParseStatus returns the status with the name provided.
+func ParseStatus(s string) (Status, error) {
+	switch status := Status(s); status {
+	case Pending, Approved, Rejected:
+		return status, nil
+	default:
+		return "", errors.Errorf("invalid status %q", s)
+	}
+}
+
+// Validate checks the rating and the comment length.
+func (r Review) Validate() error {
+	return checkRating(r.Stars, r.Comment)
+}
+
+// Validate checks the rating and the comment length.
+func (u UpdateReview) Validate() error {
+	return checkRating(u.Stars, u.Comment)
+}
+
+func checkRating(stars zero.Int, comment zero.String) error {
+	if stars.Int64 < 1 || stars.Int64 > 5 {
+		return errors.New("stars must be between 1 and 5")
+	}
+	if len(comment.String) > maxCommentLength {
+		return errors.Errorf("comments can't be longer than %d characters", maxCommentLength)
+	}
+	return nil
 }
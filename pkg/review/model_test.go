@@ -0,0 +1,43 @@
+package review_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GGP1/adak/pkg/review"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+func TestParseStatus(t *testing.T) {
+	for _, s := range []string{"pending", "approved", "rejected"} {
+		status, err := review.ParseStatus(s)
+		assert.NoError(t, err)
+		assert.Equal(t, review.Status(s), status)
+	}
+
+	_, err := review.ParseStatus("published")
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		desc   string
+		review review.Review
+		valid  bool
+	}{
+		{desc: "Valid", review: review.Review{Stars: zero.IntFrom(4), Comment: zero.StringFrom("good")}, valid: true},
+		{desc: "No comment", review: review.Review{Stars: zero.IntFrom(1)}, valid: true},
+		{desc: "No stars", review: review.Review{Comment: zero.StringFrom("good")}},
+		{desc: "Too many stars", review: review.Review{Stars: zero.IntFrom(6)}},
+		{desc: "Long comment", review: review.Review{Stars: zero.IntFrom(5), Comment: zero.StringFrom(strings.Repeat("a", 2001))}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.review.Validate()
+			assert.Equal(t, tc.valid, err == nil, err)
+		})
+	}
+}
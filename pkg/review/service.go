@@ -5,7 +5,7 @@ import (
 	"time"
 
 	"github.com/GGP1/adak/internal/params"
-	"github.com/GGP1/adak/pkg/postgres"
+
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -14,62 +14,197 @@ import (
 
 // Service provides review operations.
 type Service interface {
-	Create(ctx context.Context, r Review) error
+	Create(ctx context.Context, r Review) (Review, error)
 	Delete(ctx context.Context, id string) error
 	Get(ctx context.Context, params params.Query) ([]Review, error)
 	GetByID(ctx context.Context, id string) (Review, error)
+	Moderate(ctx context.Context, id string, status Status) error
+	Queue(ctx context.Context, status Status, params params.Query) ([]Review, error)
+	Update(ctx context.Context, id, userID string, review UpdateReview) error
+}
+
+// Purchases tells if a user has received a product.
+type Purchases interface {
+	Delivered(ctx context.Context, userID, productID string) (bool, error)
 }
 
 type service struct {
-	db      *sqlx.DB
-	mc      *memcache.Client
-	metrics metrics
+	db        *sqlx.DB
+	mc        *memcache.Client
+	purchases Purchases
+	metrics   metrics
 }
 
 // NewService returns a new review service.
-func NewService(db *sqlx.DB, mc *memcache.Client) Service {
-	return &service{db, mc, initMetrics()}
+func NewService(db *sqlx.DB, mc *memcache.Client, purchases Purchases) Service {
+	return &service{db, mc, purchases, initMetrics()}
 }
 
-// Create a review.
-func (s *service) Create(ctx context.Context, r Review) error {
+// Create a review, it's pending until an admin moderates it.
+func (s *service) Create(ctx context.Context, r Review) (Review, error) {
 	s.metrics.incMethodCalls("Create")
 
+	if err := r.Validate(); err != nil {
+		return Review{}, err
+	}
+
+	var verified bool
+	if r.ProductID.Valid {
+		delivered, err := s.purchases.Delivered(ctx, r.UserID.String, r.ProductID.String)
+		if err != nil {
+			return Review{}, err
+		}
+		verified = delivered
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Review{}, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var reviewed bool
+	existsQ := "SELECT EXISTS(SELECT 1 FROM reviews WHERE user_id=$1 AND (product_id=$2 OR shop_id=$3))"
+	if err := tx.GetContext(ctx, &reviewed, existsQ, r.UserID, r.ProductID, r.ShopID); err != nil {
+		return Review{}, errors.Wrap(err, "couldn't check the user reviews")
+	}
+	if reviewed {
+		return Review{}, ErrAlreadyReviewed
+	}
+
+	r.Verified = zero.BoolFrom(verified)
+	r.Status = zero.StringFrom(string(Pending))
+	r.CreatedAt = zero.TimeFrom(time.Now())
 	q := `INSERT INTO reviews
-	(id, stars, comment, user_id, product_id, shop_id, created_at)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err := s.db.ExecContext(ctx, q, r.ID, r.Stars, r.Comment, r.UserID, r.ProductID,
-		r.ShopID, zero.TimeFrom(time.Now()))
+	(id, stars, comment, user_id, product_id, shop_id, created_at, verified, status)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err = tx.ExecContext(ctx, q, r.ID, r.Stars, r.Comment, r.UserID, r.ProductID,
+		r.ShopID, r.CreatedAt, r.Verified, r.Status)
 	if err != nil {
-		return errors.Wrap(err, "couldn't create the review")
+		return Review{}, errors.Wrap(err, "couldn't create the review")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Review{}, errors.Wrap(err, "committing transaction")
 	}
 
 	s.metrics.totalReviews.Inc()
-	return nil
+	return r, nil
 }
 
 // Delete permanently deletes a review from the database.
 func (s *service) Delete(ctx context.Context, id string) error {
 	s.metrics.incMethodCalls("Delete")
-	_, err := s.db.ExecContext(ctx, "DELETE FROM reviews WHERE id=$1", id)
-	if err != nil {
+
+	q := "DELETE FROM reviews WHERE id=$1 RETURNING product_id, shop_id"
+	if err := s.change(ctx, id, q, id); err != nil {
 		return errors.Wrap(err, "couldn't delete the review")
 	}
 	s.metrics.totalReviews.Dec()
 
-	if err := s.mc.Delete(id); err != nil && err != memcache.ErrCacheMiss {
-		return errors.Wrap(err, "deleting review from cache")
-	}
-
 	return nil
 }
 
-// Get returns a list with all the reviews stored in the database.
+// Get returns a list with the approved reviews.
 func (s *service) Get(ctx context.Context, params params.Query) ([]Review, error) {
 	s.metrics.incMethodCalls("Get")
+	return s.list(ctx, Approved, params)
+}
+
+// GetByID retrieves the review requested from the database.
+func (s *service) GetByID(ctx context.Context, id string) (Review, error) {
+	s.metrics.incMethodCalls("GetByID")
+
+	var review Review
+	if err := s.db.GetContext(ctx, &review, "SELECT * FROM reviews WHERE id=$1", id); err != nil {
+		return Review{}, errors.Wrap(err, "couldn't find the review")
+	}
+
+	return review, nil
+}
+
+// Moderate sets the status of a review.
+func (s *service) Moderate(ctx context.Context, id string, status Status) error {
+	s.metrics.incMethodCalls("Moderate")
+
+	q := "UPDATE reviews SET status=$2 WHERE id=$1 RETURNING product_id, shop_id"
+	if err := s.change(ctx, id, q, id, status); err != nil {
+		return errors.Wrap(err, "couldn't moderate the review")
+	}
+
+	return nil
+}
+
+// Queue returns the reviews with the status provided, it is used by admins to moderate them.
+func (s *service) Queue(ctx context.Context, status Status, params params.Query) ([]Review, error) {
+	s.metrics.incMethodCalls("Queue")
+	return s.list(ctx, status, params)
+}
+
+// Update edits the rating and comment of a review, only its author can do it.
+// The review goes back to pending.
+func (s *service) Update(ctx context.Context, id, userID string, review UpdateReview) error {
+	s.metrics.incMethodCalls("Update")
+
+	if err := review.Validate(); err != nil {
+		return err
+	}
+
+	q := `UPDATE reviews SET stars=$3, comment=$4, status=$5, updated_at=$6 
+	WHERE id=$1 AND user_id=$2 
+	RETURNING product_id, shop_id`
+	err := s.change(ctx, id, q, id, userID, review.Stars, review.Comment, Pending, zero.TimeFrom(time.Now()))
+	if err != nil {
+		return errors.Wrap(err, "couldn't update the review")
+	}
+
+	return nil
+}
+
+// change executes a query that modifies a review and returns its product and shop ids,
+// the ratings of both are updated in the same transaction.
+func (s *service) change(ctx context.Context, id, query string, args ...interface{}) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var productID, shopID zero.String
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&productID, &shopID); err != nil {
+		return err
+	}
+
+	if err := updateRatings(ctx, tx, productID, shopID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	for _, key := range []zero.String{zero.StringFrom(id), productID, shopID} {
+		if !key.Valid {
+			continue
+		}
+		if err := s.mc.Delete(key.String); err != nil && err != memcache.ErrCacheMiss {
+			return errors.Wrap(err, "deleting from cache")
+		}
+	}
+
+	return nil
+}
+
+func (s *service) list(ctx context.Context, status Status, params params.Query) ([]Review, error) {
+	q := "SELECT * FROM reviews WHERE status=$2"
+	args := []interface{}{params.Limit, status}
+	if params.Cursor.Used {
+		q += " AND (created_at < $3 OR (created_at = $3 AND id < $4))"
+		args = append(args, params.Cursor.CreatedAt, params.Cursor.ID)
+	}
+	q += " ORDER BY created_at DESC, id DESC LIMIT $1"
 
 	var reviews []Review
-	q, args := postgres.AddPagination("SELECT * FROM reviews", params)
 	if err := s.db.SelectContext(ctx, &reviews, q, args...); err != nil {
 		return nil, errors.Wrap(err, "couldn't find the reviews")
 	}
@@ -77,19 +212,26 @@ func (s *service) Get(ctx context.Context, params params.Query) ([]Review, error
 	return reviews, nil
 }
 
-// GetByID retrieves the review requested from the database.
-func (s *service) GetByID(ctx context.Context, id string) (Review, error) {
-	s.metrics.incMethodCalls("GetByID")
+// updateRatings recalculates the average rating and the number of approved reviews
+// of the product and shop.
+func updateRatings(ctx context.Context, tx *sqlx.Tx, productID, shopID zero.String) error {
+	if productID.Valid {
+		q := `UPDATE products SET (rating_average, rating_count) = (
+			SELECT COALESCE(AVG(stars), 0), COUNT(*) FROM reviews WHERE product_id=$1 AND status=$2
+		) WHERE id=$1`
+		if _, err := tx.ExecContext(ctx, q, productID, Approved); err != nil {
+			return errors.Wrap(err, "couldn't update the product rating")
+		}
+	}
 
-	var review Review
-	row := s.db.QueryRowContext(ctx, "SELECT * FROM reviews WHERE id=$1", id)
-	err := row.Scan(
-		&review.ID, &review.Stars, &review.Comment, &review.UserID,
-		&review.ShopID, &review.ProductID, &review.CreatedAt,
-	)
-	if err != nil {
-		return Review{}, errors.Wrap(err, "couldn't scan review")
+	if shopID.Valid {
+		q := `UPDATE shops SET (rating_average, rating_count) = (
+			SELECT COALESCE(AVG(stars), 0), COUNT(*) FROM reviews WHERE shop_id=$1 AND status=$2
+		) WHERE id=$1`
+		if _, err := tx.ExecContext(ctx, q, shopID, Approved); err != nil {
+			return errors.Wrap(err, "couldn't update the shop rating")
+		}
 	}
 
-	return review, nil
+	return nil
 }
@@ -27,50 +27,104 @@ var r = review.Review{
 	ProductID: zero.StringFrom("3"),
 }
 
+type purchases struct{}
+
+func (purchases) Delivered(ctx context.Context, userID, productID string) (bool, error) {
+	return userID == r.UserID.String, nil
+}
+
 // TestMain failed when creating the review service.
-func NewReviewService(t *testing.T) (context.Context, review.Service) {
+func NewReviewService(t *testing.T) (context.Context, *sqlx.DB, review.Service) {
 	t.Helper()
 	logger.Disable()
 	ctx, cancel := context.WithCancel(context.Background())
 
 	db := test.StartPostgres(t)
 	mc := test.StartMemcached(t)
-	service := review.NewService(db, mc)
+	service := review.NewService(db, mc, purchases{})
 	createRelations(ctx, t, db, mc)
 
 	t.Cleanup(func() {
 		cancel()
 	})
 
-	return ctx, service
+	return ctx, db, service
 }
 
 func TestReviewService(t *testing.T) {
-	ctx, s := NewReviewService(t)
+	ctx, db, s := NewReviewService(t)
 
 	t.Run("Create", create(ctx, s))
+	t.Run("Moderate", moderate(ctx, db, s))
 	t.Run("Get", get(ctx, s))
 	t.Run("Get by id", getByID(ctx, s))
-	t.Run("Delete", delete(ctx, s))
+	t.Run("Update", update(ctx, db, s))
+	t.Run("Delete", delete(ctx, db, s))
 }
 
 func create(ctx context.Context, s review.Service) func(t *testing.T) {
 	return func(t *testing.T) {
-		assert.NoError(t, s.Create(ctx, r))
+		created, err := s.Create(ctx, r)
+		assert.NoError(t, err)
+		assert.True(t, created.Verified.Bool)
+		assert.Equal(t, string(review.Pending), created.Status.String)
 
-		review, err := s.GetByID(ctx, r.ID.String)
+		got, err := s.GetByID(ctx, r.ID.String)
+		assert.NoError(t, err)
+
+		assert.Equal(t, r.Comment, got.Comment)
+		assert.Equal(t, r.ProductID, got.ProductID)
+		assert.Equal(t, r.ShopID, got.ShopID)
+
+		// Pending reviews are not listed
+		reviews, err := s.Get(ctx, params.Query{})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(reviews))
+
+		duplicate := r
+		duplicate.ID = zero.StringFrom("duplicate")
+		_, err = s.Create(ctx, duplicate)
+		assert.Equal(t, review.ErrAlreadyReviewed, err)
+	}
+}
+
+func moderate(ctx context.Context, db *sqlx.DB, s review.Service) func(t *testing.T) {
+	return func(t *testing.T) {
+		queue, err := s.Queue(ctx, review.Pending, params.Query{})
 		assert.NoError(t, err)
+		assert.Equal(t, 1, len(queue))
 
-		assert.Equal(t, r.Comment, review.Comment)
+		assert.NoError(t, s.Moderate(ctx, r.ID.String, review.Approved))
+		assertRating(ctx, t, db, 5, 1)
 	}
 }
 
-func delete(ctx context.Context, s review.Service) func(t *testing.T) {
+func update(ctx context.Context, db *sqlx.DB, s review.Service) func(t *testing.T) {
 	return func(t *testing.T) {
+		u := review.UpdateReview{Stars: zero.IntFrom(3), Comment: zero.StringFrom("updated")}
+		assert.Error(t, s.Update(ctx, r.ID.String, "2", u))
+		assert.NoError(t, s.Update(ctx, r.ID.String, r.UserID.String, u))
+
+		got, err := s.GetByID(ctx, r.ID.String)
+		assert.NoError(t, err)
+		assert.Equal(t, u.Comment, got.Comment)
+		assert.Equal(t, string(review.Pending), got.Status.String)
+
+		// The edited review waits for moderation again
+		assertRating(ctx, t, db, 0, 0)
+	}
+}
+
+func delete(ctx context.Context, db *sqlx.DB, s review.Service) func(t *testing.T) {
+	return func(t *testing.T) {
+		assert.NoError(t, s.Moderate(ctx, r.ID.String, review.Approved))
+		assertRating(ctx, t, db, 3, 1)
+
 		assert.NoError(t, s.Delete(ctx, r.ID.String))
 
 		_, err := s.GetByID(ctx, r.ID.String)
 		assert.Error(t, err)
+		assertRating(ctx, t, db, 0, 0)
 	}
 }
 
@@ -91,6 +145,20 @@ func getByID(ctx context.Context, s review.Service) func(t *testing.T) {
 	}
 }
 
+func assertRating(ctx context.Context, t *testing.T, db *sqlx.DB, average float64, count int64) {
+	t.Helper()
+	for _, table := range []string{"products", "shops"} {
+		var rating struct {
+			Average float64 `db:"rating_average"`
+			Count   int64   `db:"rating_count"`
+		}
+		q := "SELECT rating_average, rating_count FROM " + table + " WHERE id IN ($1, $2)"
+		assert.NoError(t, db.GetContext(ctx, &rating, q, r.ProductID, r.ShopID))
+		assert.Equal(t, average, rating.Average, table)
+		assert.Equal(t, count, rating.Count, table)
+	}
+}
+
 func createRelations(ctx context.Context, t *testing.T, db *sqlx.DB, mc *memcache.Client) {
 	t.Helper()
 	userService := user.NewService(db, mc)
@@ -107,7 +175,7 @@ func createRelations(ctx context.Context, t *testing.T, db *sqlx.DB, mc *memcach
 	err = shopService.Create(ctx, shop.Shop{
 		ID:   "5",
 		Name: "test",
-	})
+	}, "1")
 	assert.NoError(t, err)
 
 	productService := product.NewService(db, mc)
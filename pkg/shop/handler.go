@@ -1,14 +1,20 @@
 package shop
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/GGP1/adak/internal/email"
 	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/principal"
 	"github.com/GGP1/adak/internal/response"
 	"github.com/GGP1/adak/internal/sanitize"
 	"github.com/GGP1/adak/internal/validate"
+	"github.com/GGP1/adak/pkg/address"
+	"github.com/GGP1/adak/pkg/shopping/currency"
 	"github.com/google/uuid"
 
 	"github.com/bradfitz/gomemcache/memcache"
@@ -21,17 +27,66 @@ type cursorResponse struct {
 	Shops      []Shop `json:"shops,omitempty"`
 }
 
+type inviteRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required"`
+}
+
+type roleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
 // Handler handles shop endpoints.
 type Handler struct {
-	service Service
-	cache   *memcache.Client
+	development   bool
+	service       Service
+	memberService MemberService
+	emailer       email.Emailer
+	cache         *memcache.Client
 }
 
 // NewHandler returns a new shop handler.
-func NewHandler(service Service, cache *memcache.Client) Handler {
+func NewHandler(development bool, service Service, memberService MemberService, emailer email.Emailer, cache *memcache.Client) Handler {
 	return Handler{
-		service: service,
-		cache:   cache,
+		development:   development,
+		service:       service,
+		memberService: memberService,
+		emailer:       emailer,
+		cache:         cache,
+	}
+}
+
+// AcceptInvitation makes the logged in user a member of the shop that invited them.
+func (h *Handler) AcceptInvitation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		token := chi.URLParam(r, "token")
+		if err := validate.UUID(token); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		member, err := h.memberService.Accept(ctx, token, userID)
+		if err != nil {
+			switch errors.Cause(err) {
+			case sql.ErrNoRows:
+				response.Error(w, http.StatusNotFound, errors.New("invitation not found or expired"))
+			case ErrInvitationEmail:
+				response.Error(w, http.StatusForbidden, err)
+			default:
+				response.Error(w, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		response.JSON(w, http.StatusOK, member)
 	}
 }
 
@@ -52,8 +107,26 @@ func (h *Handler) Create() http.HandlerFunc {
 			return
 		}
 
+		if err := currency.Validate(shop.Currency); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		shop.Location.Normalize()
+		if err := shop.Location.Validate(); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
 		shop.ID = uuid.NewString()
-		if err := h.service.Create(ctx, shop); err != nil {
+		shop.Currency = currency.Normalize(shop.Currency)
+		if err := h.service.Create(ctx, shop, userID); err != nil {
 			response.Error(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -138,6 +211,123 @@ func (h *Handler) GetByID() http.HandlerFunc {
 	}
 }
 
+// Invite sends an email inviting the user to become a member of the shop.
+//
+// During development the email is not sent and the invitation token is returned instead.
+func (h *Handler) Invite() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		var req inviteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, req); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		role, err := ParseRole(req.Role)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		invitation, err := h.memberService.Invite(ctx, Invitation{
+			ID:        uuid.NewString(),
+			ShopID:    id,
+			Email:     sanitize.Normalize(req.Email),
+			Role:      role,
+			InvitedBy: userID,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if h.development {
+			response.JSON(w, http.StatusCreated, invitation)
+			return
+		}
+
+		err = h.emailer.SendInvitation(ctx, invitation.Email, invitation.ShopName, string(invitation.Role), invitation.ID)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// Do not return the token, only the invited user should be able to accept it
+		invitation.ID = ""
+		response.JSON(w, http.StatusCreated, invitation)
+	}
+}
+
+// Members lists the members of the shop.
+func (h *Handler) Members() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		members, err := h.memberService.Get(ctx, id)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, members)
+	}
+}
+
+// RemoveMember removes a user from the shop members.
+func (h *Handler) RemoveMember() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		userID := chi.URLParam(r, "user_id")
+		if err := validate.UUID(userID); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.memberService.Remove(ctx, id, userID); err != nil {
+			memberError(w, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, userID)
+	}
+}
+
 // Search looks for the products with the given value.
 func (h *Handler) Search() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -191,3 +381,95 @@ func (h *Handler) Update() http.HandlerFunc {
 		response.JSONText(w, http.StatusOK, id)
 	}
 }
+
+// UpdateLocation replaces the shop location.
+func (h *Handler) UpdateLocation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var location address.Address
+		if err := json.NewDecoder(r.Body).Decode(&location); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, location); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		location.Normalize()
+		if err := location.Validate(); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.UpdateLocation(ctx, id, location); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, id)
+	}
+}
+
+// UpdateMemberRole changes the role of a shop member.
+func (h *Handler) UpdateMemberRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		userID := chi.URLParam(r, "user_id")
+		if err := validate.UUID(userID); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var req roleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		role, err := ParseRole(req.Role)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.memberService.UpdateRole(ctx, id, userID, role); err != nil {
+			memberError(w, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, userID)
+	}
+}
+
+// memberError responds with the status code corresponding to the member service error.
+func memberError(w http.ResponseWriter, err error) {
+	switch errors.Cause(err) {
+	case sql.ErrNoRows:
+		response.Error(w, http.StatusNotFound, err)
+	case ErrLastOwner:
+		response.Error(w, http.StatusConflict, err)
+	default:
+		response.Error(w, http.StatusInternalServerError, err)
+	}
+}
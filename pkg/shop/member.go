@@ -0,0 +1,279 @@
+package shop
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Role of a shop member, each one has the permissions of the previous ones.
+//
+// Staff members manage the products and fulfil the orders, managers can also edit
+// the shop and owners manage its members.
+type Role string
+
+// Shop member roles.
+const (
+	RoleStaff   Role = "staff"
+	RoleManager Role = "manager"
+	RoleOwner   Role = "owner"
+)
+
+var roleLevels = map[Role]int{
+	RoleStaff:   1,
+	RoleManager: 2,
+	RoleOwner:   3,
+}
+
+// ParseRole returns the role with the name provided.
+func ParseRole(name string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(name)))
+	if _, ok := roleLevels[role]; !ok {
+		return "", errors.Errorf("invalid role %q, use staff, manager or owner", name)
+	}
+	return role, nil
+}
+
+// Includes returns whether r has the permissions of role.
+func (r Role) Includes(role Role) bool {
+	level, ok := roleLevels[r]
+	return ok && level >= roleLevels[role]
+}
+
+// Invitations are valid for a week.
+const invitationTTL = 7 * 24 * time.Hour
+
+// ErrLastOwner is returned when a change would leave the shop without owners.
+var ErrLastOwner = errors.New("the shop must have at least one owner")
+
+// ErrInvitationEmail is returned when a user tries to accept an invitation sent to another email.
+var ErrInvitationEmail = errors.New("the invitation was sent to a different email")
+
+// Member is a user that takes part in the administration of a shop.
+type Member struct {
+	ShopID    string    `json:"shop_id" db:"shop_id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	Username  string    `json:"username,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Invitation to become a shop member, its id is the token sent by email to accept it.
+type Invitation struct {
+	ID        string    `json:"id,omitempty"`
+	ShopID    string    `json:"shop_id" db:"shop_id"`
+	ShopName  string    `json:"shop_name,omitempty" db:"-"`
+	Email     string    `json:"email" validate:"required,email"`
+	Role      Role      `json:"role" validate:"required"`
+	InvitedBy string    `json:"invited_by" db:"invited_by"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// MemberService manages the members of the shops.
+type MemberService interface {
+	Accept(ctx context.Context, invitationID, userID string) (Member, error)
+	Add(ctx context.Context, shopID, userID string, role Role) error
+	Get(ctx context.Context, shopID string) ([]Member, error)
+	Invite(ctx context.Context, invitation Invitation) (Invitation, error)
+	Remove(ctx context.Context, shopID, userID string) error
+	Role(ctx context.Context, shopID, userID string) (Role, error)
+	UpdateRole(ctx context.Context, shopID, userID string, role Role) error
+}
+
+type memberService struct {
+	db *sqlx.DB
+}
+
+// NewMemberService returns a new shop members service.
+func NewMemberService(db *sqlx.DB) MemberService {
+	return &memberService{db: db}
+}
+
+// Accept makes the user a member of the shop with the invitation role and deletes the invitation.
+//
+// It returns sql.ErrNoRows if the invitation doesn't exist or expired.
+func (s *memberService) Accept(ctx context.Context, invitationID, userID string) (Member, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Member{}, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var inv Invitation
+	q := "SELECT * FROM shop_invitations WHERE id=$1 AND expires_at > NOW() FOR UPDATE"
+	if err := tx.GetContext(ctx, &inv, q, invitationID); err != nil {
+		return Member{}, errors.Wrap(err, "couldn't find the invitation")
+	}
+
+	var email string
+	if err := tx.GetContext(ctx, &email, "SELECT email FROM users WHERE id=$1", userID); err != nil {
+		return Member{}, errors.Wrap(err, "couldn't find the user")
+	}
+	if !strings.EqualFold(email, inv.Email) {
+		return Member{}, ErrInvitationEmail
+	}
+
+	member := Member{
+		ShopID:    inv.ShopID,
+		UserID:    userID,
+		Email:     email,
+		Role:      inv.Role,
+		CreatedAt: time.Now(),
+	}
+	// Members that already belong to the shop take the role of the invitation
+	q = `INSERT INTO shop_members (shop_id, user_id, role, created_at) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (shop_id, user_id) DO UPDATE SET role=EXCLUDED.role`
+	if _, err := tx.ExecContext(ctx, q, member.ShopID, member.UserID, member.Role, member.CreatedAt); err != nil {
+		return Member{}, errors.Wrap(err, "couldn't add the member")
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM shop_invitations WHERE id=$1", inv.ID); err != nil {
+		return Member{}, errors.Wrap(err, "couldn't delete the invitation")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Member{}, errors.Wrap(err, "committing transaction")
+	}
+
+	return member, nil
+}
+
+// Add makes the user a member of the shop.
+func (s *memberService) Add(ctx context.Context, shopID, userID string, role Role) error {
+	q := "INSERT INTO shop_members (shop_id, user_id, role, created_at) VALUES ($1, $2, $3, $4)"
+	if _, err := s.db.ExecContext(ctx, q, shopID, userID, role, time.Now()); err != nil {
+		return errors.Wrap(err, "couldn't add the member")
+	}
+	return nil
+}
+
+// Get returns the members of the shop.
+func (s *memberService) Get(ctx context.Context, shopID string) ([]Member, error) {
+	var members []Member
+	q := `SELECT m.shop_id, m.user_id, u.username, u.email, m.role, m.created_at
+	FROM shop_members AS m
+	INNER JOIN users AS u ON u.id=m.user_id
+	WHERE m.shop_id=$1
+	ORDER BY m.created_at`
+	if err := s.db.SelectContext(ctx, &members, q, shopID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the members")
+	}
+
+	return members, nil
+}
+
+// Invite saves an invitation to join the shop, it replaces any previous one sent to the same email.
+func (s *memberService) Invite(ctx context.Context, inv Invitation) (Invitation, error) {
+	if err := s.db.GetContext(ctx, &inv.ShopName, "SELECT name FROM shops WHERE id=$1", inv.ShopID); err != nil {
+		return Invitation{}, errors.Wrap(err, "couldn't find the shop")
+	}
+
+	inv.Email = strings.ToLower(inv.Email)
+	inv.ExpiresAt = inv.CreatedAt.Add(invitationTTL)
+	q := `INSERT INTO shop_invitations
+	(id, shop_id, email, role, invited_by, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (shop_id, email) DO UPDATE SET
+	id=EXCLUDED.id, role=EXCLUDED.role, invited_by=EXCLUDED.invited_by,
+	expires_at=EXCLUDED.expires_at, created_at=EXCLUDED.created_at`
+	_, err := s.db.ExecContext(ctx, q, inv.ID, inv.ShopID, inv.Email, inv.Role,
+		inv.InvitedBy, inv.ExpiresAt, inv.CreatedAt)
+	if err != nil {
+		return Invitation{}, errors.Wrap(err, "couldn't save the invitation")
+	}
+
+	return inv, nil
+}
+
+// Remove removes the user from the shop members.
+func (s *memberService) Remove(ctx context.Context, shopID, userID string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := checkOwners(ctx, tx, shopID, userID); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM shop_members WHERE shop_id=$1 AND user_id=$2", shopID, userID)
+	if err != nil {
+		return errors.Wrap(err, "couldn't remove the member")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.Wrap(sql.ErrNoRows, "couldn't find the member")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// Role returns the role of the user in the shop, it's empty if they aren't a member.
+func (s *memberService) Role(ctx context.Context, shopID, userID string) (Role, error) {
+	var role Role
+	q := "SELECT role FROM shop_members WHERE shop_id=$1 AND user_id=$2"
+	if err := s.db.GetContext(ctx, &role, q, shopID, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "couldn't find the member role")
+	}
+
+	return role, nil
+}
+
+// UpdateRole changes the role of a shop member.
+func (s *memberService) UpdateRole(ctx context.Context, shopID, userID string, role Role) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if role != RoleOwner {
+		if err := checkOwners(ctx, tx, shopID, userID); err != nil {
+			return err
+		}
+	}
+
+	q := "UPDATE shop_members SET role=$3 WHERE shop_id=$1 AND user_id=$2"
+	res, err := tx.ExecContext(ctx, q, shopID, userID, role)
+	if err != nil {
+		return errors.Wrap(err, "couldn't update the member role")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.Wrap(sql.ErrNoRows, "couldn't find the member")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// checkOwners returns ErrLastOwner if the user is the only owner of the shop.
+//
+// The owners rows are locked so concurrent changes can't remove all of them.
+func checkOwners(ctx context.Context, tx *sqlx.Tx, shopID, userID string) error {
+	var owners []string
+	q := "SELECT user_id FROM shop_members WHERE shop_id=$1 AND role=$2 FOR UPDATE"
+	if err := tx.SelectContext(ctx, &owners, q, shopID, RoleOwner); err != nil {
+		return errors.Wrap(err, "couldn't find the shop owners")
+	}
+
+	if len(owners) == 1 && owners[0] == userID {
+		return ErrLastOwner
+	}
+	return nil
+}
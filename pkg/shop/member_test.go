@@ -0,0 +1,128 @@
+package shop_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/shop"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRole(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected shop.Role
+		fail     bool
+	}{
+		{name: "owner", expected: shop.RoleOwner},
+		{name: " Manager", expected: shop.RoleManager},
+		{name: "STAFF", expected: shop.RoleStaff},
+		{name: "admin", fail: true},
+		{name: "", fail: true},
+	}
+
+	for _, tc := range cases {
+		role, err := shop.ParseRole(tc.name)
+		if tc.fail {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expected, role)
+	}
+}
+
+func TestRoleIncludes(t *testing.T) {
+	assert.True(t, shop.RoleOwner.Includes(shop.RoleStaff))
+	assert.True(t, shop.RoleManager.Includes(shop.RoleManager))
+	assert.False(t, shop.RoleStaff.Includes(shop.RoleManager))
+	assert.False(t, shop.RoleManager.Includes(shop.RoleOwner))
+	assert.False(t, shop.Role("").Includes(shop.RoleStaff))
+}
+
+func TestMemberService(t *testing.T) {
+	logger.Disable()
+	ctx := context.Background()
+	db := test.StartPostgres(t)
+	s := shop.NewMemberService(db)
+
+	shopID := uuid.NewString()
+	ownerID := uuid.NewString()
+	userID := uuid.NewString()
+	createMemberRelationships(ctx, t, db, shopID, ownerID, userID)
+
+	assert.NoError(t, s.Add(ctx, shopID, ownerID, shop.RoleOwner))
+
+	t.Run("Invite and accept", func(t *testing.T) {
+		inv, err := s.Invite(ctx, shop.Invitation{
+			ID:        uuid.NewString(),
+			ShopID:    shopID,
+			Email:     "Staff@adak.com",
+			Role:      shop.RoleStaff,
+			InvitedBy: ownerID,
+			CreatedAt: time.Now(),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "members", inv.ShopName)
+
+		_, err = s.Accept(ctx, inv.ID, ownerID)
+		assert.Equal(t, shop.ErrInvitationEmail, errors.Cause(err))
+
+		member, err := s.Accept(ctx, inv.ID, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, shop.RoleStaff, member.Role)
+
+		// Invitations can be used only once
+		_, err = s.Accept(ctx, inv.ID, userID)
+		assert.Equal(t, sql.ErrNoRows, errors.Cause(err))
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		members, err := s.Get(ctx, shopID)
+		assert.NoError(t, err)
+		assert.Len(t, members, 2)
+	})
+
+	t.Run("Update role", func(t *testing.T) {
+		assert.NoError(t, s.UpdateRole(ctx, shopID, userID, shop.RoleManager))
+		role, err := s.Role(ctx, shopID, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, shop.RoleManager, role)
+
+		err = s.UpdateRole(ctx, shopID, ownerID, shop.RoleStaff)
+		assert.Equal(t, shop.ErrLastOwner, errors.Cause(err))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		err := s.Remove(ctx, shopID, ownerID)
+		assert.Equal(t, shop.ErrLastOwner, errors.Cause(err))
+
+		assert.NoError(t, s.Remove(ctx, shopID, userID))
+		role, err := s.Role(ctx, shopID, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, shop.Role(""), role)
+	})
+}
+
+func createMemberRelationships(ctx context.Context, t *testing.T, db *sqlx.DB, shopID, ownerID, userID string) {
+	t.Helper()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO shops (id, name) VALUES ($1, 'members')", shopID)
+	assert.NoError(t, err)
+	q := `INSERT INTO carts (id) VALUES ($1), ($2)`
+	ownerCart, userCart := uuid.NewString(), uuid.NewString()
+	_, err = db.ExecContext(ctx, q, ownerCart, userCart)
+	assert.NoError(t, err)
+	q = `INSERT INTO users (id, cart_id, username, email, password)
+	VALUES ($1, $2, 'owner', 'owner@adak.com', 'password'), ($3, $4, 'staff', 'staff@adak.com', 'password')`
+	_, err = db.ExecContext(ctx, q, ownerID, ownerCart, userID, userCart)
+	assert.NoError(t, err)
+}
@@ -3,6 +3,8 @@ package shop
 import (
 	"time"
 
+	"github.com/GGP1/adak/pkg/address"
+	"github.com/GGP1/adak/pkg/media"
 	"github.com/GGP1/adak/pkg/product"
 	"github.com/GGP1/adak/pkg/review"
 	"gopkg.in/guregu/null.v4/zero"
@@ -13,11 +15,16 @@ import (
 type Shop struct {
 	ID        string            `json:"id,omitempty"`
 	Name      string            `json:"name,omitempty" validate:"required"`
+	Currency  string            `json:"currency,omitempty" validate:"required,len=3"` // ISO-4217 code
 	Location  Location          `json:"location,omitempty"`
 	Reviews   []review.Review   `json:"reviews,omitempty"`
 	Products  []product.Product `json:"products,omitempty"`
+	Images    []media.Image     `json:"images,omitempty"`
 	CreatedAt time.Time         `json:"created_at,omitempty" db:"created_at"`
 	UpdatedAt zero.Time         `json:"updated_at,omitempty" db:"updated_at"`
+	// RatingAverage and RatingCount summarize the approved reviews
+	RatingAverage float64 `json:"rating_average,omitempty" db:"rating_average"`
+	RatingCount   int64   `json:"rating_count,omitempty" db:"rating_count"`
 }
 
 // UpdateShop is the structure used to update shops.
@@ -27,10 +34,6 @@ type UpdateShop struct {
 
 // Location of the shop.
 type Location struct {
-	ShopID  string `json:"shop_id,omitempty" db:"shop_id"`
-	Country string `json:"country,omitempty" validate:"required"`
-	State   string `json:"state,omitempty"`
-	ZipCode string `json:"zip_code,omitempty" db:"zip_code"`
-	City    string `json:"city,omitempty" validate:"required"`
-	Address string `json:"address,omitempty" validate:"required"`
+	ShopID string `json:"shop_id,omitempty" db:"shop_id"`
+	address.Address
 }
@@ -2,12 +2,16 @@ package shop
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/pkg/address"
+	"github.com/GGP1/adak/pkg/media"
 	"github.com/GGP1/adak/pkg/postgres"
 	"github.com/GGP1/adak/pkg/product"
 	"github.com/GGP1/adak/pkg/review"
+	"github.com/GGP1/adak/pkg/shopping/currency"
 	"gopkg.in/guregu/null.v4/zero"
 
 	"github.com/bradfitz/gomemcache/memcache"
@@ -17,12 +21,13 @@ import (
 
 // Service provides shop operations.
 type Service interface {
-	Create(ctx context.Context, shop Shop) error
+	Create(ctx context.Context, shop Shop, ownerID string) error
 	Delete(ctx context.Context, id string) error
 	Get(ctx context.Context, params params.Query) ([]Shop, error)
 	GetByID(ctx context.Context, id string) (Shop, error)
 	Search(ctx context.Context, query string) ([]Shop, error)
 	Update(ctx context.Context, id string, shop UpdateShop) error
+	UpdateLocation(ctx context.Context, shopID string, location address.Address) error
 }
 
 type service struct {
@@ -36,20 +41,20 @@ func NewService(db *sqlx.DB, mc *memcache.Client) Service {
 	return &service{db, mc, initMetrics()}
 }
 
-// Create a shop.
-func (s *service) Create(ctx context.Context, shop Shop) error {
+// Create a shop, the user creating it becomes its owner.
+func (s *service) Create(ctx context.Context, shop Shop, ownerID string) error {
 	s.metrics.incMethodCalls("Create")
 
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return errors.Wrap(err, "starting trasaction")
 	}
-	defer tx.Commit()
+	defer tx.Rollback()
 
 	sQuery := `INSERT INTO shops
-	(id, name, created_at)
-	VALUES ($1, $2, $3)`
-	_, err = tx.ExecContext(ctx, sQuery, shop.ID, shop.Name, time.Now())
+	(id, name, created_at, currency)
+	VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, sQuery, shop.ID, shop.Name, time.Now(), currency.Normalize(shop.Currency))
 	if err != nil {
 		return errors.Wrap(err, "couldn't create the shop")
 	}
@@ -58,11 +63,20 @@ func (s *service) Create(ctx context.Context, shop Shop) error {
 	(shop_id, country, state, zip_code, city, address)
 	VALUES ($1, $2, $3, $4, $5, $6)`
 	_, err = tx.ExecContext(ctx, lQuery, shop.ID, shop.Location.Country, shop.Location.State,
-		shop.Location.ZipCode, shop.Location.City, shop.Location.Address)
+		shop.Location.ZipCode, shop.Location.City, shop.Location.Street)
 	if err != nil {
 		return errors.Wrap(err, "couldn't create the location")
 	}
 
+	mQuery := "INSERT INTO shop_members (shop_id, user_id, role, created_at) VALUES ($1, $2, $3, $4)"
+	if _, err := tx.ExecContext(ctx, mQuery, shop.ID, ownerID, RoleOwner, time.Now()); err != nil {
+		return errors.Wrap(err, "couldn't add the owner")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
 	s.metrics.registeredShops.Inc()
 	return nil
 }
@@ -103,7 +117,7 @@ func (s *service) GetByID(ctx context.Context, id string) (Shop, error) {
 	q := `SELECT s.*, l.*, r.*, p.* 
 	FROM shops s
 	LEFT JOIN locations l ON s.id=l.shop_id
-	LEFT JOIN reviews r ON s.id=r.shop_id
+	LEFT JOIN reviews r ON s.id=r.shop_id AND r.status='approved'
 	LEFT JOIN products p ON s.id=p.shop_id
 	WHERE s.id=$1`
 	rows, err := s.db.QueryContext(ctx, q, id)
@@ -118,11 +132,14 @@ func (s *service) GetByID(ctx context.Context, id string) (Shop, error) {
 		r := review.Review{}
 		p := product.Product{}
 		err := rows.Scan(
-			&shop.ID, &shop.Name, &shop.CreatedAt, &shop.UpdatedAt,
-			&l.ShopID, &l.Country, &l.State, &l.ZipCode, &l.City, &l.Address,
+			&shop.ID, &shop.Name, &shop.CreatedAt, &shop.UpdatedAt, &shop.Currency,
+			&shop.RatingAverage, &shop.RatingCount,
+			&l.ShopID, &l.Country, &l.State, &l.ZipCode, &l.City, &l.Street,
 			&r.ID, &r.Stars, &r.Comment, &r.UserID, &r.ProductID, &r.ShopID, &r.CreatedAt,
+			&r.Verified, &r.Status, &r.UpdatedAt,
 			&p.ID, &p.ShopID, &p.Stock, &p.Brand, &p.Category, &p.Type, &p.Description, &p.Weight,
-			&p.Discount, &p.Taxes, &p.Subtotal, &p.Total, &p.CreatedAt, &p.UpdatedAt,
+			&p.Discount, &p.Taxes, &p.Subtotal, &p.Total, &p.CreatedAt, &p.UpdatedAt, &p.Currency,
+			&p.Options, &p.CategoryID, &p.SKU, &p.RatingAverage, &p.RatingCount,
 		)
 		if err != nil {
 			return Shop{}, errors.Wrap(err, "couldn't scan shop")
@@ -133,6 +150,12 @@ func (s *service) GetByID(ctx context.Context, id string) (Shop, error) {
 		shop.Products = append(shop.Products, p)
 	}
 
+	galleries, err := media.Galleries(ctx, s.db, media.Shops, id)
+	if err != nil {
+		return Shop{}, err
+	}
+	shop.Images = galleries[id]
+
 	return shop, nil
 }
 
@@ -166,8 +189,28 @@ func (s *service) Update(ctx context.Context, id string, shop UpdateShop) error
 	return nil
 }
 
-// TODO: finish and add to Service interface
-func (s *service) UpdateLocation(ctx context.Context, shopID string, location Location) error {
+// UpdateLocation replaces the shop location.
+func (s *service) UpdateLocation(ctx context.Context, shopID string, location address.Address) error {
 	s.metrics.incMethodCalls("UpdateLocation")
+
+	q := `UPDATE locations SET country=$2, state=$3, zip_code=$4, city=$5, address=$6 
+	WHERE shop_id=$1`
+	res, err := s.db.ExecContext(ctx, q, shopID, location.Country, location.State,
+		location.ZipCode, location.City, location.Street)
+	if err != nil {
+		return errors.Wrap(err, "couldn't update the location")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.Wrap(sql.ErrNoRows, "couldn't find the location")
+	}
+
+	if _, err := s.db.ExecContext(ctx, "UPDATE shops SET updated_at=$2 WHERE id=$1", shopID, zero.TimeFrom(time.Now())); err != nil {
+		return errors.Wrap(err, "couldn't update the shop")
+	}
+
+	if err := s.mc.Delete(shopID); err != nil && err != memcache.ErrCacheMiss {
+		return errors.Wrap(err, "couldn't delete shop from cache")
+	}
+
 	return nil
 }
@@ -7,21 +7,26 @@ import (
 	"github.com/GGP1/adak/internal/logger"
 	"github.com/GGP1/adak/internal/params"
 	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/address"
 	"github.com/GGP1/adak/pkg/shop"
 
 	"github.com/stretchr/testify/assert"
 )
 
+const ownerID = "owner"
+
 var sh = shop.Shop{
 	ID:   "test",
 	Name: "Adak",
 	Location: shop.Location{
-		ShopID:  "test",
-		Country: "New Zealand",
-		State:   "Auckland",
-		ZipCode: "1023",
-		City:    "Auckland",
-		Address: "8 Hopetoun St",
+		ShopID: "test",
+		Address: address.Address{
+			Country: "New Zealand",
+			State:   "Auckland",
+			ZipCode: "1023",
+			City:    "Auckland",
+			Street:  "8 Hopetoun St",
+		},
 	},
 }
 
@@ -35,6 +40,10 @@ func NewShopService(t *testing.T) (context.Context, shop.Service) {
 	mc := test.StartMemcached(t)
 	service := shop.NewService(db, mc)
 
+	q := "INSERT INTO users (id, cart_id, username, email, password) VALUES ($1, 'owner', 'owner', 'owner@adak.com', 'password')"
+	_, err := db.ExecContext(ctx, q, ownerID)
+	assert.NoError(t, err)
+
 	t.Cleanup(func() {
 		cancel()
 	})
@@ -49,13 +58,14 @@ func TestShopService(t *testing.T) {
 	t.Run("Get", get(ctx, s))
 	t.Run("Get by id", getByID(ctx, s))
 	t.Run("Update", update(ctx, s))
+	t.Run("Update location", updateLocation(ctx, s))
 	t.Run("Search", search(ctx, s))
 	t.Run("Delete", delete(ctx, s))
 }
 
 func create(ctx context.Context, s shop.Service) func(t *testing.T) {
 	return func(t *testing.T) {
-		assert.NoError(t, s.Create(ctx, sh))
+		assert.NoError(t, s.Create(ctx, sh, ownerID))
 
 		shop, err := s.GetByID(ctx, sh.ID)
 		assert.NoError(t, err)
@@ -104,6 +114,25 @@ func update(ctx context.Context, s shop.Service) func(t *testing.T) {
 	}
 }
 
+func updateLocation(ctx context.Context, s shop.Service) func(t *testing.T) {
+	return func(t *testing.T) {
+		location := address.Address{
+			Country: "New Zealand",
+			State:   "Wellington",
+			ZipCode: "6011",
+			City:    "Wellington",
+			Street:  "101 Lambton Quay",
+		}
+		assert.NoError(t, s.UpdateLocation(ctx, sh.ID, location))
+
+		uptShop, err := s.GetByID(ctx, sh.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, location, uptShop.Location.Address)
+
+		assert.Error(t, s.UpdateLocation(ctx, "unknown", location))
+	}
+}
+
 func search(ctx context.Context, s shop.Service) func(t *testing.T) {
 	return func(t *testing.T) {
 		shops, err := s.Search(ctx, sh.ID)
@@ -0,0 +1,308 @@
+package cart
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GGP1/adak/internal/config"
+	"github.com/GGP1/adak/pkg/product"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// guestPrefix is the prefix of the redis keys that store the guest carts.
+const guestPrefix = "guest_cart:"
+
+// The fields of the guest carts hashes are the product ids, followed by
+// ":<variant_id>" for products with variants.
+func guestField(productID, variantID string) string {
+	if variantID == "" {
+		return productID
+	}
+	return productID + ":" + variantID
+}
+
+func parseGuestField(field string) (productID, variantID string) {
+	parts := strings.SplitN(field, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// GuestService contains the functionalities of the carts of the visitors that aren't logged in.
+//
+// Guest carts are stored in redis and expire once they weren't modified for the configured TTL,
+// their products don't hold stock until they are merged into a user cart.
+type GuestService interface {
+	Add(ctx context.Context, guestID string, cartProduct Product) error
+	Delete(ctx context.Context, guestID string) error
+	Get(ctx context.Context, guestID string) (Cart, error)
+	Merge(ctx context.Context, guestID, cartID string) error
+	Remove(ctx context.Context, guestID, productID, variantID string, quantity int64) error
+}
+
+type guestService struct {
+	db         *sqlx.DB
+	rdb        *redis.Client
+	carts      Service
+	currencies currency.Service
+	ttl        time.Duration
+}
+
+// NewGuestService returns a new guest carts service.
+func NewGuestService(db *sqlx.DB, rdb *redis.Client, carts Service, currencies currency.Service,
+	config config.Cart) GuestService {
+	return &guestService{
+		db:         db,
+		rdb:        rdb,
+		carts:      carts,
+		currencies: currencies,
+		ttl:        time.Duration(config.GuestTTL) * time.Hour,
+	}
+}
+
+// Add adds a product to the guest cart and extends its expiration.
+func (s *guestService) Add(ctx context.Context, guestID string, cartProduct Product) error {
+	key := guestPrefix + guestID
+	productID := cartProduct.ID.String
+	variantID := cartProduct.VariantID.String
+
+	var p product.Product
+	if err := s.db.GetContext(ctx, &p, "SELECT * FROM products WHERE id=$1", productID); err != nil {
+		return errors.Wrap(err, "couldn't find product")
+	}
+
+	if err := checkVariant(ctx, s.db, p, variantID); err != nil {
+		return err
+	}
+
+	stock := p.Stock.Int64
+	if variantID != "" {
+		q := "SELECT stock FROM product_variants WHERE id=$1"
+		if err := s.db.GetContext(ctx, &stock, q, variantID); err != nil {
+			return errors.Wrap(err, "couldn't find the variant")
+		}
+	}
+
+	field := guestField(productID, variantID)
+	inCart, err := s.rdb.HGet(ctx, key, field).Int64()
+	if err != nil && err != redis.Nil {
+		return errors.Wrap(err, "couldn't find cart product")
+	}
+
+	quantity := inCart + cartProduct.Quantity.Int64
+	if quantity > stock {
+		return &inventory.OutOfStockError{
+			ProductID: productID,
+			VariantID: variantID,
+			Requested: quantity,
+			Available: stock,
+		}
+	}
+
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, field, quantity)
+		pipe.Expire(ctx, key, s.ttl)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldn't add the product")
+	}
+
+	return nil
+}
+
+// Delete removes the guest cart.
+func (s *guestService) Delete(ctx context.Context, guestID string) error {
+	if err := s.rdb.Del(ctx, guestPrefix+guestID).Err(); err != nil {
+		return errors.Wrap(err, "couldn't delete the cart")
+	}
+
+	return nil
+}
+
+// Get returns the guest cart with its amounts in the base currency.
+//
+// Products or variants that no longer exist are left out.
+func (s *guestService) Get(ctx context.Context, guestID string) (Cart, error) {
+	items, err := s.rdb.HGetAll(ctx, guestPrefix+guestID).Result()
+	if err != nil {
+		return Cart{}, errors.Wrap(err, "couldn't find the cart")
+	}
+
+	cart := New(guestID)
+	if len(items) == 0 {
+		return *cart, nil
+	}
+
+	fields := make([]string, 0, len(items))
+	ids := make([]string, 0, len(items))
+	variantIDs := []string{}
+	for field := range items {
+		fields = append(fields, field)
+		productID, variantID := parseGuestField(field)
+		ids = append(ids, productID)
+		if variantID != "" {
+			variantIDs = append(variantIDs, variantID)
+		}
+	}
+	sort.Strings(fields)
+
+	rows, err := s.pricedProducts(ctx, ids)
+	if err != nil {
+		return Cart{}, err
+	}
+	variants, err := s.pricedVariants(ctx, variantIDs)
+	if err != nil {
+		return Cart{}, err
+	}
+
+	products := make([]pricedProduct, 0, len(fields))
+	for _, field := range fields {
+		productID, variantID := parseGuestField(field)
+		p, ok := rows[productID]
+		if !ok {
+			continue
+		}
+		if variantID != "" {
+			v, ok := variants[variantID]
+			if !ok {
+				continue
+			}
+			if v.Weight.Valid {
+				p.Weight = v.Weight.Int64
+			}
+			if v.Subtotal.Valid {
+				p.Subtotal = v.Subtotal.Int64
+			}
+		}
+
+		quantity, err := strconv.ParseInt(items[field], 10, 64)
+		if err != nil {
+			return Cart{}, errors.Wrap(err, "parsing product quantity")
+		}
+
+		p.Quantity = quantity
+		products = append(products, p)
+		cart.Products = append(cart.Products, Product{
+			ID:        zero.StringFrom(productID),
+			CartID:    zero.StringFrom(guestID),
+			Quantity:  zero.IntFrom(quantity),
+			VariantID: zero.StringFrom(variantID),
+		})
+	}
+
+	counter, weight, amounts, err := calculate(ctx, s.currencies, products, s.currencies.Base())
+	if err != nil {
+		return Cart{}, err
+	}
+
+	cart.Counter = zero.IntFrom(counter)
+	cart.Weight = zero.IntFrom(weight)
+	cart.Discount = zero.IntFrom(amounts.Discount)
+	cart.Taxes = zero.IntFrom(amounts.Taxes)
+	cart.Subtotal = zero.IntFrom(amounts.Subtotal)
+	cart.Total = zero.IntFrom(amounts.Total)
+
+	return *cart, nil
+}
+
+// Merge moves the guest cart products into the user cart and deletes the guest cart.
+func (s *guestService) Merge(ctx context.Context, guestID, cartID string) error {
+	guest, err := s.Get(ctx, guestID)
+	if err != nil {
+		return err
+	}
+
+	if len(guest.Products) > 0 {
+		if err := s.carts.Merge(ctx, cartID, guest.Products); err != nil {
+			return err
+		}
+	}
+
+	return s.Delete(ctx, guestID)
+}
+
+// Remove takes away the specified quantity of the product from the guest cart.
+func (s *guestService) Remove(ctx context.Context, guestID, productID, variantID string, quantity int64) error {
+	key := guestPrefix + guestID
+	field := guestField(productID, variantID)
+
+	inCart, err := s.rdb.HGet(ctx, key, field).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return errors.Errorf("product %q isn't in the cart", productID)
+		}
+		return errors.Wrap(err, "couldn't find cart product")
+	}
+
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if quantity >= inCart {
+			pipe.HDel(ctx, key, field)
+		} else {
+			pipe.HSet(ctx, key, field, inCart-quantity)
+		}
+		pipe.Expire(ctx, key, s.ttl)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldn't remove the product")
+	}
+
+	return nil
+}
+
+// pricedProducts returns the products fields used to calculate the amounts, by id.
+func (s *guestService) pricedProducts(ctx context.Context, ids []string) (map[string]pricedProduct, error) {
+	var rows []struct {
+		ID string
+		pricedProduct
+	}
+	q, args, err := sqlx.In(`SELECT id, weight, subtotal, discount, taxes, currency
+	FROM products WHERE id IN (?)`, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "building query")
+	}
+	if err := s.db.SelectContext(ctx, &rows, s.db.Rebind(q), args...); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the cart products")
+	}
+
+	products := make(map[string]pricedProduct, len(rows))
+	for _, row := range rows {
+		products[row.ID] = row.pricedProduct
+	}
+
+	return products, nil
+}
+
+// pricedVariants returns the variants weight and subtotal, by id.
+func (s *guestService) pricedVariants(ctx context.Context, ids []string) (map[string]product.Variant, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var rows []product.Variant
+	q, args, err := sqlx.In("SELECT id, weight, subtotal FROM product_variants WHERE id IN (?)", ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "building query")
+	}
+	if err := s.db.SelectContext(ctx, &rows, s.db.Rebind(q), args...); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the cart variants")
+	}
+
+	variants := make(map[string]product.Variant, len(rows))
+	for _, row := range rows {
+		variants[row.ID] = row
+	}
+
+	return variants, nil
+}
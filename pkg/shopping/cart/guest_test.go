@@ -0,0 +1,82 @@
+package cart_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GGP1/adak/internal/config"
+	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/shopping/cart"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+func TestGuestService(t *testing.T) {
+	ctx := context.Background()
+	rdb := test.StartRedis(t)
+	guests := cart.NewGuestService(db, rdb, service, currencyService, config.Cart{GuestTTL: 1})
+
+	guestID := "guest"
+	userCartID := "guest_merge"
+	productID := "guest_product"
+
+	_, err := db.ExecContext(ctx, "INSERT INTO shops (id, name) VALUES ('guest_shop', 'shop')")
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO products
+	(id, shop_id, stock, brand, category, type, weight, subtotal, total)
+	VALUES ($1, 'guest_shop', 5, 'brand', 'category', 'type', 1, 1000, 1000)`, productID)
+	assert.NoError(t, err)
+	assert.NoError(t, service.Create(ctx, userCartID))
+
+	product := cart.Product{ID: zero.StringFrom(productID), Quantity: zero.IntFrom(3)}
+
+	t.Run("Add", func(t *testing.T) {
+		assert.NoError(t, guests.Add(ctx, guestID, product))
+
+		guest, err := guests.Get(ctx, guestID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), guest.Counter.Int64)
+		assert.Equal(t, int64(3000), guest.Total.Int64)
+
+		ttl, err := rdb.TTL(ctx, "guest_cart:"+guestID).Result()
+		assert.NoError(t, err)
+		assert.Greater(t, int64(ttl), int64(0))
+	})
+
+	t.Run("Out of stock", func(t *testing.T) {
+		err := guests.Add(ctx, guestID, product)
+		var outOfStock *inventory.OutOfStockError
+		assert.True(t, errors.As(err, &outOfStock))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		assert.NoError(t, guests.Remove(ctx, guestID, productID, "", 1))
+
+		guest, err := guests.Get(ctx, guestID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), guest.Counter.Int64)
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		// The user cart already has 4 units, only 1 more is available
+		userProduct := cart.Product{
+			ID:       zero.StringFrom(productID),
+			CartID:   zero.StringFrom(userCartID),
+			Quantity: zero.IntFrom(4),
+		}
+		assert.NoError(t, service.Add(ctx, userProduct))
+
+		assert.NoError(t, guests.Merge(ctx, guestID, userCartID))
+
+		p, err := service.CartProduct(ctx, userCartID, productID, "")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), p.Quantity.Int64)
+
+		guest, err := guests.Get(ctx, guestID)
+		assert.NoError(t, err)
+		assert.Empty(t, guest.Products)
+	})
+}
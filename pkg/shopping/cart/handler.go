@@ -6,31 +6,51 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/GGP1/adak/internal/config"
 	"github.com/GGP1/adak/internal/cookie"
 	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/principal"
 	"github.com/GGP1/adak/internal/response"
 	"github.com/GGP1/adak/internal/sanitize"
 	"github.com/GGP1/adak/internal/validate"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
+	"github.com/GGP1/adak/pkg/shopping/promotion"
+	"github.com/GGP1/adak/pkg/shopping/shipping"
 
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	"gopkg.in/guregu/null.v4/zero"
 )
 
+// guestCookie is the name of the cookie that identifies the cart of the visitors that aren't logged in.
+const guestCookie = "GCID"
+
 // Handler manages cart endpoints.
+//
+// The endpoints that don't require login use the guest cart when the user cart cookie isn't set.
 type Handler struct {
-	service Service
-	db      *sqlx.DB
-	cache   *memcache.Client
+	service  Service
+	guests   GuestService
+	shipping shipping.Service
+	guestAge int
+	db       *sqlx.DB
+	cache    *memcache.Client
 }
 
 // NewHandler returns a new cart handler.
-func NewHandler(service Service, db *sqlx.DB, cache *memcache.Client) Handler {
+func NewHandler(service Service, guests GuestService, shipping shipping.Service, config config.Cart,
+	db *sqlx.DB, cache *memcache.Client) Handler {
 	return Handler{
-		service: service,
-		db:      db,
-		cache:   cache,
+		service:  service,
+		guests:   guests,
+		shipping: shipping,
+		guestAge: int(config.GuestTTL * 3600),
+		db:       db,
+		cache:    cache,
 	}
 }
 
@@ -39,12 +59,6 @@ func (h *Handler) Add() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		cartID, err := cookie.GetValue(r, "CID")
-		if err != nil {
-			response.Error(w, http.StatusForbidden, err)
-			return
-		}
-
 		var product Product
 		if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
 			response.Error(w, http.StatusBadRequest, err)
@@ -57,8 +71,28 @@ func (h *Handler) Add() http.HandlerFunc {
 			return
 		}
 
+		cartID, err := principal.CartID(ctx)
+		guest := err != nil
+		if guest {
+			cartID, err = h.guestID(w, r)
+			if err != nil {
+				response.Error(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
 		product.CartID = zero.StringFrom(cartID)
-		if err := h.service.Add(ctx, product); err != nil {
+		if guest {
+			err = h.guests.Add(ctx, cartID, product)
+		} else {
+			err = h.service.Add(ctx, product)
+		}
+		if err != nil {
+			var outOfStock *inventory.OutOfStockError
+			if errors.As(err, &outOfStock) {
+				response.Error(w, http.StatusConflict, err)
+				return
+			}
 			response.Error(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -67,18 +101,80 @@ func (h *Handler) Add() http.HandlerFunc {
 	}
 }
 
+// ApplyCoupon applies a coupon code to the cart.
+func (h *Handler) ApplyCoupon() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		cartID, err := principal.CartID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		var params CouponParams
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, params); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		discount, err := h.service.ApplyCoupon(ctx, cartID, userID, params.Code)
+		if err != nil {
+			var invalid *promotion.InvalidCouponError
+			if errors.As(err, &invalid) {
+				response.Error(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, discount)
+	}
+}
+
 // Checkout returns the final purchase.
 func (h *Handler) Checkout() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		cartID, err := cookie.GetValue(r, "CID")
+		cartID, err := principal.CartID(ctx)
 		if err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
 		}
 
-		checkout, err := h.service.Checkout(ctx, cartID)
+		userID, err := principal.UserID(ctx)
 		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		// The amounts are expressed in the base currency if it's not specified
+		checkoutCurrency := r.URL.Query().Get("currency")
+		checkout, err := h.service.Checkout(ctx, cartID, userID, checkoutCurrency)
+		if err != nil {
+			var invalid *promotion.InvalidCouponError
+			if errors.As(err, &invalid) {
+				response.Error(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			var invalidCurrency *currency.InvalidError
+			if errors.As(err, &invalidCurrency) {
+				response.Error(w, http.StatusBadRequest, err)
+				return
+			}
 			response.Error(w, http.StatusNotFound, err)
 			return
 		}
@@ -90,7 +186,7 @@ func (h *Handler) Checkout() http.HandlerFunc {
 // FilterBy returns the products filtered by the field provided.
 func (h *Handler) FilterBy() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		cartID, err := cookie.GetValue(r, "CID")
+		cartID, err := principal.CartID(r.Context())
 		if err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
@@ -114,9 +210,9 @@ func (h *Handler) FilterBy() http.HandlerFunc {
 func (h *Handler) Get() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		cartID, err := cookie.GetValue(r, "CID")
+		cartID, err := principal.CartID(ctx)
 		if err != nil {
-			response.Error(w, http.StatusForbidden, err)
+			h.guestCart(w, r, func(cart Cart) interface{} { return cart })
 			return
 		}
 
@@ -140,9 +236,9 @@ func (h *Handler) Get() http.HandlerFunc {
 func (h *Handler) Products() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		cartID, err := cookie.GetValue(r, "CID")
+		cartID, err := principal.CartID(ctx)
 		if err != nil {
-			response.Error(w, http.StatusForbidden, err)
+			h.guestCart(w, r, func(cart Cart) interface{} { return cart.Products })
 			return
 		}
 
@@ -159,10 +255,14 @@ func (h *Handler) Products() http.HandlerFunc {
 // Remove takes out a product from the shopping cart.
 func (h *Handler) Remove() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		cartID, err := cookie.GetValue(r, "CID")
-		if err != nil {
-			response.Error(w, http.StatusForbidden, err)
-			return
+		cartID, err := principal.CartID(r.Context())
+		guest := err != nil
+		if guest {
+			cartID, err = h.guestID(w, r)
+			if err != nil {
+				response.Error(w, http.StatusInternalServerError, err)
+				return
+			}
 		}
 
 		ctx := r.Context()
@@ -179,7 +279,20 @@ func (h *Handler) Remove() http.HandlerFunc {
 			return
 		}
 
-		if err := h.service.Remove(ctx, cartID, id, int64(quantity)); err != nil {
+		variantID := r.URL.Query().Get("variant")
+		if variantID != "" {
+			if err := validate.UUID(variantID); err != nil {
+				response.Error(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		if guest {
+			err = h.guests.Remove(ctx, cartID, id, variantID, int64(quantity))
+		} else {
+			err = h.service.Remove(ctx, cartID, id, variantID, int64(quantity))
+		}
+		if err != nil {
 			response.Error(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -188,17 +301,41 @@ func (h *Handler) Remove() http.HandlerFunc {
 	}
 }
 
-// Reset resets the cart to its default state.
-func (h *Handler) Reset() http.HandlerFunc {
+// RemoveCoupon removes the coupon applied to the cart.
+func (h *Handler) RemoveCoupon() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		cartID, err := cookie.GetValue(r, "CID")
+		cartID, err := principal.CartID(ctx)
 		if err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
 		}
 
-		if err := h.service.Reset(ctx, cartID); err != nil {
+		if err := h.service.RemoveCoupon(ctx, cartID); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, fmt.Sprintf("coupon removed from cart %q", cartID))
+	}
+}
+
+// Reset resets the cart to its default state.
+func (h *Handler) Reset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cartID, err := principal.CartID(ctx)
+		if err != nil {
+			cartID, err = h.guestID(w, r)
+			if err != nil {
+				response.Error(w, http.StatusInternalServerError, err)
+				return
+			}
+			err = h.guests.Delete(ctx, cartID)
+		} else {
+			err = h.service.Reset(ctx, cartID)
+		}
+		if err != nil {
 			response.Error(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -207,13 +344,77 @@ func (h *Handler) Reset() http.HandlerFunc {
 	}
 }
 
+// ShippingOptions lists the options available to ship the cart to the destination provided.
+//
+// The prices are expressed in the base currency if it's not specified.
+func (h *Handler) ShippingOptions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		query := r.URL.Query()
+		dest := shipping.Destination{
+			Country: query.Get("country"),
+			State:   query.Get("state"),
+			ZipCode: query.Get("zip"),
+		}
+		if err := validate.Struct(ctx, dest); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var cart Cart
+		cartID, err := principal.CartID(ctx)
+		if err != nil {
+			guestID, err := h.guestID(w, r)
+			if err != nil {
+				response.Error(w, http.StatusInternalServerError, err)
+				return
+			}
+			cart, err = h.guests.Get(ctx, guestID)
+			if err != nil {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+		} else {
+			cart, err = h.service.Get(ctx, cartID)
+			if err != nil {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
+		}
+
+		// Only users can apply coupons, the free shipping thresholds are compared with the total after the discount
+		total := cart.Total.Int64
+		if userID, err := principal.UserID(ctx); err == nil {
+			total, err = h.service.Checkout(ctx, cartID, userID, "")
+			if err != nil {
+				response.Error(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		options, err := h.shipping.Options(ctx, dest, cart.Weight.Int64, total, query.Get("currency"))
+		if err != nil {
+			var invalidCurrency *currency.InvalidError
+			if errors.As(err, &invalidCurrency) {
+				response.Error(w, http.StatusBadRequest, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, options)
+	}
+}
+
 // Size returns the size of the shopping cart.
 func (h *Handler) Size() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		cartID, err := cookie.GetValue(r, "CID")
+		cartID, err := principal.CartID(ctx)
 		if err != nil {
-			response.Error(w, http.StatusForbidden, err)
+			h.guestCart(w, r, func(cart Cart) interface{} { return cart.Counter.Int64 })
 			return
 		}
 
@@ -226,3 +427,35 @@ func (h *Handler) Size() http.HandlerFunc {
 		response.JSON(w, http.StatusOK, size)
 	}
 }
+
+// guestCart responds with the part of the guest cart returned by field.
+func (h *Handler) guestCart(w http.ResponseWriter, r *http.Request, field func(cart Cart) interface{}) {
+	guestID, err := h.guestID(w, r)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	cart, err := h.guests.Get(r.Context(), guestID)
+	if err != nil {
+		response.Error(w, http.StatusNotFound, err)
+		return
+	}
+
+	response.JSON(w, http.StatusOK, field(cart))
+}
+
+// guestID returns the id of the visitor's guest cart, a new one is assigned if it has none.
+func (h *Handler) guestID(w http.ResponseWriter, r *http.Request) (string, error) {
+	guestID, err := cookie.GetValue(r, guestCookie)
+	if err == nil {
+		return guestID, nil
+	}
+
+	guestID = uuid.NewString()
+	if err := cookie.Set(w, guestCookie, guestID, "/", h.guestAge); err != nil {
+		return "", err
+	}
+
+	return guestID, nil
+}
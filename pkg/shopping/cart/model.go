@@ -14,11 +14,11 @@ type Cart struct {
 	Counter zero.Int `json:"counter,omitempty"`
 	// 1000 = 1kg
 	Weight zero.Int `json:"weight,omitempty"`
-	// This field should be used as a percentage
+	// Discount and Taxes are the sum of the products amounts, calculated from their rates
 	Discount zero.Int `json:"discount,omitempty"`
-	// This field should be used as a percentage
-	Taxes    zero.Int  `json:"taxes,omitempty"`
-	Subtotal zero.Int  `json:"subtotal,omitempty"`
+	Taxes    zero.Int `json:"taxes,omitempty"`
+	Subtotal zero.Int `json:"subtotal,omitempty"`
+	// Total is Subtotal - Discount + Taxes
 	Total    zero.Int  `json:"total,omitempty"`
 	Products []Product `json:"products,omitempty"`
 }
@@ -28,4 +28,11 @@ type Product struct {
 	ID       zero.String `json:"id,omitempty" validate:"uuid4_rfc4122"`
 	CartID   zero.String `json:"cart_id,omitempty" db:"cart_id"`
 	Quantity zero.Int    `json:"quantity,omitempty" validate:"required,min=1"`
+	// VariantID is required when the product has options
+	VariantID zero.String `json:"variant_id,omitempty" db:"variant_id" validate:"omitempty,uuid4_rfc4122"`
+}
+
+// CouponParams holds the coupon code the customer wants to apply.
+type CouponParams struct {
+	Code string `json:"code" validate:"required,max=32"`
 }
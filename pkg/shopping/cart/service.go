@@ -2,8 +2,14 @@ package cart
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/GGP1/adak/pkg/product"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
+	"github.com/GGP1/adak/pkg/shopping/pricing"
+	"github.com/GGP1/adak/pkg/shopping/promotion"
+
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -23,27 +29,34 @@ var fields = map[string]string{
 // Service contains order functionalities.
 type Service interface {
 	Add(ctx context.Context, cartProduct Product) error
-	Checkout(ctx context.Context, cartID string) (int64, error)
+	ApplyCoupon(ctx context.Context, cartID, userID, code string) (promotion.Discount, error)
+	Checkout(ctx context.Context, cartID, userID, currency string) (int64, error)
 	Create(ctx context.Context, cartID string) error
 	Delete(ctx context.Context, cartID string) error
 	FilterBy(ctx context.Context, cartID, field, args string) ([]product.Product, error)
 	Get(ctx context.Context, cartID string) (Cart, error)
-	CartProduct(ctx context.Context, cartID, productID string) (Product, error)
+	CartProduct(ctx context.Context, cartID, productID, variantID string) (Product, error)
 	CartProducts(ctx context.Context, cartID string) ([]Product, error)
-	Remove(ctx context.Context, cartID string, pID string, quantity int64) error
+	Merge(ctx context.Context, cartID string, products []Product) error
+	Remove(ctx context.Context, cartID, pID, variantID string, quantity int64) error
+	RemoveCoupon(ctx context.Context, cartID string) error
 	Reset(ctx context.Context, cartID string) error
 	Size(ctx context.Context, cartID string) (int64, error)
 }
 
 type service struct {
-	db      *sqlx.DB
-	mc      *memcache.Client
-	metrics metrics
+	db         *sqlx.DB
+	mc         *memcache.Client
+	inventory  inventory.Service
+	promotions promotion.Service
+	currencies currency.Service
+	metrics    metrics
 }
 
 // NewService returns a new cart service.
-func NewService(db *sqlx.DB, mc *memcache.Client) Service {
-	return &service{db, mc, initMetrics()}
+func NewService(db *sqlx.DB, mc *memcache.Client, inventory inventory.Service,
+	promotions promotion.Service, currencies currency.Service) Service {
+	return &service{db, mc, inventory, promotions, currencies, initMetrics()}
 }
 
 // New returns a cart with the default values.
@@ -60,34 +73,47 @@ func New(id string) *Cart {
 	}
 }
 
-// Add adds a product to the cart.
+// Add adds a product to the cart and holds its stock.
 func (s *service) Add(ctx context.Context, cartProduct Product) error {
 	s.metrics.incMethodCalls("Add")
 
-	tx, err := s.db.Beginx()
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return errors.Wrap(err, "starting transaction")
 	}
-	defer tx.Commit()
+	defer tx.Rollback()
 
 	var p product.Product
 	if err := tx.GetContext(ctx, &p, "SELECT * FROM products WHERE id=$1", cartProduct.ID); err != nil {
 		return errors.Wrap(err, "couldn't find product")
 	}
 
-	if err := s.createOrUpdateProduct(ctx, tx, cartProduct); err != nil {
+	if err := checkVariant(ctx, tx, p, cartProduct.VariantID.String); err != nil {
 		return err
 	}
 
-	q := `UPDATE carts SET 
-	counter=counter+$2, weight=weight+$3, 
-	discount=discount+$4, taxes=taxes+$5, 
-	subtotal=subtotal+$6, total=total+$7 
-	WHERE id=$1`
-	_, err = tx.ExecContext(ctx, q, cartProduct.CartID, cartProduct.Quantity,
-		p.Weight, p.Discount, p.Taxes, p.Subtotal, p.Total)
+	inCart, err := quantityInCart(ctx, tx, cartProduct.CartID.String, cartProduct)
 	if err != nil {
-		return errors.Wrap(err, "updating cart")
+		return err
+	}
+
+	quantity := inCart + cartProduct.Quantity.Int64
+	err = s.inventory.Hold(ctx, tx, cartProduct.CartID.String, cartProduct.ID.String,
+		cartProduct.VariantID.String, quantity)
+	if err != nil {
+		return err
+	}
+
+	if err := s.createOrUpdateProduct(ctx, tx, cartProduct); err != nil {
+		return err
+	}
+
+	if err := s.updateTotals(ctx, tx, cartProduct.CartID.String); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
 	}
 
 	if err := s.mc.Delete(cartProduct.CartID.String); err != nil && err != memcache.ErrCacheMiss {
@@ -97,16 +123,45 @@ func (s *service) Add(ctx context.Context, cartProduct Product) error {
 	return nil
 }
 
-// Checkout returns the cart total.
-func (s *service) Checkout(ctx context.Context, cartID string) (int64, error) {
+// ApplyCoupon applies the coupon to the cart and returns the discount it grants.
+func (s *service) ApplyCoupon(ctx context.Context, cartID, userID, code string) (promotion.Discount, error) {
+	s.metrics.incMethodCalls("ApplyCoupon")
+
+	return s.promotions.ApplyToCart(ctx, cartID, userID, code)
+}
+
+// Checkout returns the cart total in the currency given, the base one is used if it's empty.
+// The coupon discount is subtracted from it.
+func (s *service) Checkout(ctx context.Context, cartID, userID, currency string) (int64, error) {
 	s.metrics.incMethodCalls("Checkout")
 
+	if currency == "" {
+		currency = s.currencies.Base()
+	}
+
 	var cart Cart
 	if err := s.db.GetContext(ctx, &cart, "SELECT * FROM carts WHERE id=$1", cartID); err != nil {
 		return 0, errors.Wrap(err, "couldn't find the cart")
 	}
 
-	total := cart.Total.Int64 + cart.Taxes.Int64 - cart.Discount.Int64
+	_, _, amounts, err := s.price(ctx, s.db, cartID, currency)
+	if err != nil {
+		return 0, err
+	}
+
+	discount, err := s.promotions.CartDiscount(ctx, cartID, userID)
+	if err != nil {
+		return 0, err
+	}
+	discountAmount, err := s.currencies.Convert(ctx, discount.Amount, s.currencies.Base(), currency)
+	if err != nil {
+		return 0, err
+	}
+
+	total := amounts.Total - discountAmount
+	if total < 0 {
+		total = 0
+	}
 	return total, nil
 }
 
@@ -166,7 +221,7 @@ func (s *service) FilterBy(ctx context.Context, cartID, field, args string) ([]p
 func (s *service) Get(ctx context.Context, cartID string) (Cart, error) {
 	s.metrics.incMethodCalls("Get")
 
-	q := `SELECT c.*, cp.*
+	q := `SELECT c.*, cp.id, cp.cart_id, cp.quantity, cp.variant_id
 	FROM carts AS c
 	LEFT JOIN cart_products AS cp ON c.id=cp.cart_id
 	WHERE c.id=$1`
@@ -183,7 +238,7 @@ func (s *service) Get(ctx context.Context, cartID string) (Cart, error) {
 		err := rows.Scan(
 			&cart.ID, &cart.Counter, &cart.Weight, &cart.Discount,
 			&cart.Taxes, &cart.Subtotal, &cart.Total,
-			&p.ID, &p.CartID, &p.Quantity,
+			&p.ID, &p.CartID, &p.Quantity, &p.VariantID,
 		)
 		if err != nil {
 			return Cart{}, errors.Wrap(err, "couldn't scan cart")
@@ -195,13 +250,13 @@ func (s *service) Get(ctx context.Context, cartID string) (Cart, error) {
 	return cart, nil
 }
 
-// CartProduct returns a cart product.
-func (s *service) CartProduct(ctx context.Context, cartID, productID string) (Product, error) {
+// CartProduct returns a cart product, the variant id is empty for products without options.
+func (s *service) CartProduct(ctx context.Context, cartID, productID, variantID string) (Product, error) {
 	s.metrics.incMethodCalls("Product")
 
 	var product Product
-	q := "SELECT * FROM cart_products WHERE id=$1 AND cart_id=$2"
-	if err := s.db.GetContext(ctx, &product, q, productID, cartID); err != nil {
+	q := "SELECT * FROM cart_products WHERE id=$1 AND cart_id=$2 AND variant_id=$3"
+	if err := s.db.GetContext(ctx, &product, q, productID, cartID, variantID); err != nil {
 		return Product{}, errors.Wrap(err, "couldn't find cart product")
 	}
 
@@ -224,19 +279,79 @@ func (s *service) CartProducts(ctx context.Context, cartID string) ([]Product, e
 	return products, nil
 }
 
-// Remove takes away the specified quantity of products from the cart.
-func (s *service) Remove(ctx context.Context, cartID string, pID string, quantity int64) error {
+// Merge adds the products to the cart, holding their stock.
+//
+// If the stock isn't enough for the quantity in the cart plus the one added, the cart
+// takes the units available, never less than the ones it already had.
+func (s *service) Merge(ctx context.Context, cartID string, products []Product) error {
+	s.metrics.incMethodCalls("Merge")
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	for _, p := range products {
+		inCart, err := quantityInCart(ctx, tx, cartID, p)
+		if err != nil {
+			return err
+		}
+
+		quantity := inCart + p.Quantity.Int64
+		if err := s.inventory.Hold(ctx, tx, cartID, p.ID.String, p.VariantID.String, quantity); err != nil {
+			var outOfStock *inventory.OutOfStockError
+			if !errors.As(err, &outOfStock) {
+				return err
+			}
+			if outOfStock.Available <= inCart {
+				continue
+			}
+			quantity = outOfStock.Available
+			if err := s.inventory.Hold(ctx, tx, cartID, p.ID.String, p.VariantID.String, quantity); err != nil {
+				return err
+			}
+		}
+
+		added := Product{
+			ID:        p.ID,
+			CartID:    zero.StringFrom(cartID),
+			Quantity:  zero.IntFrom(quantity - inCart),
+			VariantID: p.VariantID,
+		}
+		if err := s.createOrUpdateProduct(ctx, tx, added); err != nil {
+			return err
+		}
+	}
+
+	if err := s.updateTotals(ctx, tx, cartID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	if err := s.mc.Delete(cartID); err != nil && err != memcache.ErrCacheMiss {
+		return errors.Wrap(err, "deleting cart from cache")
+	}
+
+	return nil
+}
+
+// Remove takes away the specified quantity of products from the cart and releases their stock.
+func (s *service) Remove(ctx context.Context, cartID, pID, variantID string, quantity int64) error {
 	s.metrics.incMethodCalls("Remove")
 
-	tx, err := s.db.Beginx()
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return errors.Wrap(err, "starting transaction")
 	}
-	defer tx.Commit()
+	defer tx.Rollback()
 
 	var cartProduct Product
-	cpQ := "SELECT * FROM cart_products WHERE id=$1 AND cart_id=$2"
-	if err := tx.GetContext(ctx, &cartProduct, cpQ, pID, cartID); err != nil {
+	cpQ := "SELECT * FROM cart_products WHERE id=$1 AND cart_id=$2 AND variant_id=$3"
+	if err := tx.GetContext(ctx, &cartProduct, cpQ, pID, cartID, variantID); err != nil {
 		return errors.Wrap(err, "couldn't find cart product")
 	}
 
@@ -246,25 +361,32 @@ func (s *service) Remove(ctx context.Context, cartID string, pID string, quantit
 	}
 
 	if quantity == cartProduct.Quantity.Int64 {
-		_, err := s.db.ExecContext(ctx, "DELETE FROM cart_products WHERE id=$1 AND cart_id=$2", pID, cartID)
-		if err != nil {
+		q := "DELETE FROM cart_products WHERE id=$1 AND cart_id=$2 AND variant_id=$3"
+		if _, err := tx.ExecContext(ctx, q, pID, cartID, variantID); err != nil {
 			return errors.Wrap(err, "couldn't delete the product")
 		}
+
+		if err := s.inventory.Release(ctx, tx, cartID, pID, variantID); err != nil {
+			return err
+		}
+	} else {
+		q := "UPDATE cart_products SET quantity=quantity-$4 WHERE id=$1 AND cart_id=$2 AND variant_id=$3"
+		if _, err := tx.ExecContext(ctx, q, pID, cartID, variantID, quantity); err != nil {
+			return errors.Wrap(err, "couldn't update the product quantity")
+		}
+
+		remaining := cartProduct.Quantity.Int64 - quantity
+		if err := s.inventory.Hold(ctx, tx, cartID, pID, variantID, remaining); err != nil {
+			return err
+		}
 	}
 
-	var product product.Product
-	if err := tx.GetContext(ctx, &product, "SELECT * FROM products WHERE id = $1", pID); err != nil {
-		return errors.New("couldn't find the product")
+	if err := s.updateTotals(ctx, tx, cartID); err != nil {
+		return err
 	}
 
-	uptQ := `UPDATE carts SET 
-	counter=counter-$2, weight=weight-$3, discount=discount-$4, 
-	taxes=taxes-$5, subtotal=subtotal-$6, total=total-$7 
-	WHERE id=$1`
-	_, err = tx.ExecContext(ctx, uptQ, cartID, quantity, product.Weight,
-		product.Discount, product.Taxes, product.Subtotal, product.Total)
-	if err != nil {
-		return errors.Wrap(err, "updating cart")
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
 	}
 
 	if err := s.mc.Delete(cartID); err != nil && err != memcache.ErrCacheMiss {
@@ -274,21 +396,36 @@ func (s *service) Remove(ctx context.Context, cartID string, pID string, quantit
 	return nil
 }
 
-// Reset sets cart values to default.
+// RemoveCoupon removes the coupon applied to the cart.
+func (s *service) RemoveCoupon(ctx context.Context, cartID string) error {
+	s.metrics.incMethodCalls("RemoveCoupon")
+
+	return s.promotions.RemoveFromCart(ctx, cartID)
+}
+
+// Reset sets cart values to default and releases the stock held.
 func (s *service) Reset(ctx context.Context, cartID string) error {
 	s.metrics.incMethodCalls("Reset")
 
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return errors.Wrap(err, "starting transaction")
 	}
-	defer tx.Commit()
+	defer tx.Rollback()
 
 	del := "DELETE FROM cart_products WHERE cart_id=$1"
 	if _, err := tx.ExecContext(ctx, del, cartID); err != nil {
 		return errors.Wrap(err, "couldn't delete cart products")
 	}
 
+	if err := s.inventory.ReleaseCart(ctx, tx, cartID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM cart_coupons WHERE cart_id=$1", cartID); err != nil {
+		return errors.Wrap(err, "couldn't remove the cart coupon")
+	}
+
 	upt := `UPDATE carts SET 
 	counter=$2, weight=$3, discount=$4, taxes=$5, subtotal=$6, total=$7 
 	WHERE id=$1`
@@ -296,6 +433,10 @@ func (s *service) Reset(ctx context.Context, cartID string) error {
 		return errors.Wrap(err, "updating cart")
 	}
 
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
 	if err := s.mc.Delete(cartID); err != nil && err != memcache.ErrCacheMiss {
 		return errors.Wrap(err, "deleting cart from cache")
 	}
@@ -315,16 +456,126 @@ func (s *service) Size(ctx context.Context, cartID string) (int64, error) {
 	return size, nil
 }
 
+// updateTotals recalculates the cart amounts from its products, in the base currency.
+func (s *service) updateTotals(ctx context.Context, tx *sqlx.Tx, cartID string) error {
+	counter, weight, amounts, err := s.price(ctx, tx, cartID, s.currencies.Base())
+	if err != nil {
+		return err
+	}
+
+	upt := `UPDATE carts SET 
+	counter=$2, weight=$3, discount=$4, taxes=$5, subtotal=$6, total=$7 
+	WHERE id=$1`
+	_, err = tx.ExecContext(ctx, upt, cartID, counter, weight,
+		amounts.Discount, amounts.Taxes, amounts.Subtotal, amounts.Total)
+	if err != nil {
+		return errors.Wrap(err, "updating cart")
+	}
+
+	return nil
+}
+
+// price calculates the cart amounts converting the products prices to the currency given.
+//
+// The weight and subtotal of the variants replace the product ones when they are set.
+func (s *service) price(ctx context.Context, q sqlx.QueryerContext, cartID, to string) (counter, weight int64, amounts pricing.Amounts, err error) {
+	var products []pricedProduct
+	productsQ := `SELECT cp.quantity,
+	COALESCE(v.weight, p.weight) AS weight, COALESCE(v.subtotal, p.subtotal) AS subtotal,
+	p.discount, p.taxes, p.currency
+	FROM cart_products AS cp
+	JOIN products AS p ON p.id=cp.id
+	LEFT JOIN product_variants AS v ON v.id=cp.variant_id AND v.product_id=cp.id
+	WHERE cp.cart_id=$1`
+	if err := sqlx.SelectContext(ctx, q, &products, productsQ, cartID); err != nil {
+		return 0, 0, pricing.Amounts{}, errors.Wrap(err, "couldn't find the cart products")
+	}
+
+	return calculate(ctx, s.currencies, products, to)
+}
+
+// pricedProduct contains the fields used to calculate the amounts of a cart product.
+type pricedProduct struct {
+	Quantity int64
+	Weight   int64
+	Subtotal int64
+	Discount zero.Int
+	Taxes    zero.Int
+	Currency string
+}
+
+// calculate returns the number of units, the weight and the amounts of the products,
+// with their prices converted to the currency given.
+func calculate(ctx context.Context, currencies currency.Service, products []pricedProduct,
+	to string) (counter, weight int64, amounts pricing.Amounts, err error) {
+	lines := make([]pricing.Line, 0, len(products))
+	for _, p := range products {
+		unitPrice, err := currencies.Convert(ctx, p.Subtotal, p.Currency, to)
+		if err != nil {
+			return 0, 0, pricing.Amounts{}, err
+		}
+
+		counter += p.Quantity
+		weight += p.Weight * p.Quantity
+		lines = append(lines, pricing.Line{
+			UnitPrice:    unitPrice,
+			Quantity:     p.Quantity,
+			DiscountRate: p.Discount.Int64,
+			TaxRate:      p.Taxes.Int64,
+		})
+	}
+
+	return counter, weight, pricing.Calculate(lines), nil
+}
+
 func (s *service) createOrUpdateProduct(ctx context.Context, tx *sqlx.Tx, cartProduct Product) error {
 	productsQ := `INSERT INTO cart_products
-	(id, cart_id, quantity)
-	VALUES ($1, $2, $3)
-	ON CONFLICT (id) DO UPDATE SET 
-	quantity=EXCLUDED.quantity+$3`
-	_, err := tx.ExecContext(ctx, productsQ, cartProduct.ID, cartProduct.CartID, cartProduct.Quantity)
+	(id, cart_id, quantity, variant_id)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (cart_id, id, variant_id) DO UPDATE SET 
+	quantity=cart_products.quantity+EXCLUDED.quantity`
+	_, err := tx.ExecContext(ctx, productsQ, cartProduct.ID, cartProduct.CartID,
+		cartProduct.Quantity, cartProduct.VariantID.String)
 	if err != nil {
 		return errors.Wrap(err, "couldn't create the product")
 	}
 
 	return nil
 }
+
+// checkVariant verifies that the variant is one of the product's, products without options take no variant.
+func checkVariant(ctx context.Context, q sqlx.QueryerContext, p product.Product, variantID string) error {
+	if len(p.Options) == 0 {
+		if variantID != "" {
+			return errors.Errorf("product %q has no variants", p.ID.String)
+		}
+		return nil
+	}
+
+	if variantID == "" {
+		return errors.Errorf("product %q requires a variant", p.ID.String)
+	}
+
+	var exists bool
+	existsQ := "SELECT EXISTS(SELECT 1 FROM product_variants WHERE id=$1 AND product_id=$2)"
+	if err := sqlx.GetContext(ctx, q, &exists, existsQ, variantID, p.ID); err != nil {
+		return errors.Wrap(err, "couldn't find the variant")
+	}
+	if !exists {
+		return errors.Errorf("variant %q not found", variantID)
+	}
+
+	return nil
+}
+
+// quantityInCart returns the units of the product (and variant) that the cart already contains.
+func quantityInCart(ctx context.Context, tx *sqlx.Tx, cartID string, p Product) (int64, error) {
+	var inCart int64
+	q := "SELECT quantity FROM cart_products WHERE id=$1 AND cart_id=$2 AND variant_id=$3"
+	err := tx.GetContext(ctx, &inCart, q, p.ID, cartID, p.VariantID.String)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, errors.Wrap(err, "couldn't find cart product")
+	}
+
+	return inCart, nil
+}
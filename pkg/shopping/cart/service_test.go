@@ -5,29 +5,41 @@ import (
 	"os"
 	"testing"
 
+	"github.com/GGP1/adak/internal/config"
 	"github.com/GGP1/adak/internal/logger"
 	"github.com/GGP1/adak/internal/test"
 	"github.com/GGP1/adak/pkg/shopping/cart"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
+	"github.com/GGP1/adak/pkg/shopping/promotion"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/guregu/null.v4/zero"
 )
 
 const cartID = "1234"
 
-var service cart.Service
+var (
+	service         cart.Service
+	currencyService currency.Service
+	db              *sqlx.DB
+)
 
 func TestMain(m *testing.M) {
 	poolMc, resourceMc, mc, err := test.RunMemcached()
 	if err != nil {
 		logger.Fatal(err)
 	}
-	poolPg, resourcePg, db, err := test.RunPostgres()
+	poolPg, resourcePg, sqlxDB, err := test.RunPostgres()
 	if err != nil {
 		logger.Fatal(err)
 	}
+	db = sqlxDB
 
-	service = cart.NewService(db, mc)
+	inventoryService := inventory.NewService(db, config.Inventory{HoldTTL: 15})
+	currencyService = currency.NewService(db, "USD")
+	service = cart.NewService(db, mc, inventoryService, promotion.NewService(db, currencyService), currencyService)
 	if err := service.Create(context.Background(), cartID); err != nil {
 		logger.Fatal(err)
 	}
@@ -56,14 +68,14 @@ func TestAdd(t *testing.T) {
 	err := service.Add(ctx, product)
 	assert.NoError(t, err)
 
-	p, err := service.CartProduct(ctx, cartID, pID)
+	p, err := service.CartProduct(ctx, cartID, pID, "")
 	assert.NoError(t, err)
 
 	assert.Equal(t, quantity, p.Quantity)
 }
 
 func TestCheckout(t *testing.T) {
-	total, err := service.Checkout(context.Background(), cartID)
+	total, err := service.Checkout(context.Background(), cartID, "", "")
 	assert.NoError(t, err)
 
 	assert.Equal(t, int64(0), total)
@@ -98,7 +110,7 @@ func TestRemove(t *testing.T) {
 	err := service.Add(ctx, product)
 	assert.NoError(t, err)
 
-	err = service.Remove(ctx, cartID, "2", 1)
+	err = service.Remove(ctx, cartID, "2", "", 1)
 	assert.NoError(t, err)
 
 	c, _ := service.Get(ctx, cartID)
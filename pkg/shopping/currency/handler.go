@@ -0,0 +1,64 @@
+package currency
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/GGP1/adak/internal/response"
+	"github.com/GGP1/adak/internal/validate"
+)
+
+// Handler handles exchange rates endpoints.
+type Handler struct {
+	service Service
+}
+
+// NewHandler returns a new currency handler.
+func NewHandler(service Service) Handler {
+	return Handler{service: service}
+}
+
+// GetRates lists the exchange rates.
+func (h *Handler) GetRates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rates, err := h.service.GetRates(r.Context())
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, rates)
+	}
+}
+
+// UploadRates creates or updates the exchange rates received.
+func (h *Handler) UploadRates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var rates []Rate
+		if err := json.NewDecoder(r.Body).Decode(&rates); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		for _, rate := range rates {
+			if err := validate.Struct(ctx, rate); err != nil {
+				response.Error(w, http.StatusBadRequest, err)
+				return
+			}
+			if err := rate.Validate(); err != nil {
+				response.Error(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		if err := h.service.SetRates(ctx, rates); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, rates)
+	}
+}
@@ -0,0 +1,145 @@
+package currency
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// precision is the number of decimals of the rates that are calculated (inverse and cross rates).
+const precision = 12
+
+// Rate is the price of the base currency expressed in the quote currency.
+type Rate struct {
+	Base  string `json:"base" validate:"required,len=3"`
+	Quote string `json:"quote" validate:"required,len=3"`
+	// Value is a decimal number, 0.92 means that one unit of the base currency is worth 0.92
+	// units of the quote currency
+	Value     string    `json:"rate" db:"rate" validate:"required"`
+	UpdatedAt zero.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// Validate checks the currencies and the value of the rate.
+func (r Rate) Validate() error {
+	if err := Validate(r.Base); err != nil {
+		return err
+	}
+	if err := Validate(r.Quote); err != nil {
+		return err
+	}
+	if Normalize(r.Base) == Normalize(r.Quote) {
+		return errors.Errorf("the base and quote currencies must be different, got %s", r.Base)
+	}
+
+	if _, err := r.value(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Convert converts an amount of the base currency into the quote currency, both in
+// their smallest unit. The result is rounded half away from zero.
+func (r Rate) Convert(amount int64) (int64, error) {
+	value, err := r.value()
+	if err != nil {
+		return 0, err
+	}
+
+	baseExp, err := Exponent(r.Base)
+	if err != nil {
+		return 0, err
+	}
+	quoteExp, err := Exponent(r.Quote)
+	if err != nil {
+		return 0, err
+	}
+
+	// Move the amount to major units, apply the rate and go back to the quote minor units
+	result := new(big.Rat).SetInt64(amount)
+	result.Mul(result, value)
+	result.Mul(result, new(big.Rat).SetFrac(pow10(quoteExp), pow10(baseExp)))
+
+	return round(result), nil
+}
+
+// Inverse returns the rate from the quote currency to the base one.
+func (r Rate) Inverse() (Rate, error) {
+	value, err := r.value()
+	if err != nil {
+		return Rate{}, err
+	}
+
+	return Rate{
+		Base:      r.Quote,
+		Quote:     r.Base,
+		Value:     formatRat(value.Inv(value)),
+		UpdatedAt: r.UpdatedAt,
+	}, nil
+}
+
+// Cross returns the rate from the base currency of r to the quote currency of next,
+// the quote currency of r must be the base of next.
+func (r Rate) Cross(next Rate) (Rate, error) {
+	if Normalize(r.Quote) != Normalize(next.Base) {
+		return Rate{}, errors.Errorf("can't cross %s/%s with %s/%s", r.Base, r.Quote, next.Base, next.Quote)
+	}
+
+	v1, err := r.value()
+	if err != nil {
+		return Rate{}, err
+	}
+	v2, err := next.value()
+	if err != nil {
+		return Rate{}, err
+	}
+
+	updatedAt := r.UpdatedAt
+	if next.UpdatedAt.Time.Before(updatedAt.Time) {
+		updatedAt = next.UpdatedAt
+	}
+
+	return Rate{
+		Base:      r.Base,
+		Quote:     next.Quote,
+		Value:     formatRat(v1.Mul(v1, v2)),
+		UpdatedAt: updatedAt,
+	}, nil
+}
+
+func (r Rate) value() (*big.Rat, error) {
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(r.Value))
+	if !ok || value.Sign() <= 0 {
+		return nil, errors.Errorf("invalid rate %q, it must be a positive decimal number", r.Value)
+	}
+	return value, nil
+}
+
+// formatRat returns r as a decimal number with the trailing zeros removed.
+func formatRat(r *big.Rat) string {
+	s := r.FloatString(precision)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func pow10(exp int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)
+}
+
+// round returns the integer closest to r, rounding half away from zero.
+func round(r *big.Rat) int64 {
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		quo.Neg(quo)
+	}
+
+	return quo.Int64()
+}
@@ -0,0 +1,133 @@
+package currency_test
+
+import (
+	"testing"
+
+	"github.com/GGP1/adak/pkg/shopping/currency"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponent(t *testing.T) {
+	cases := []struct {
+		code     string
+		expected int
+	}{
+		{code: "USD", expected: 2},
+		{code: "eur", expected: 2},
+		{code: "JPY", expected: 0},
+		{code: "KWD", expected: 3},
+	}
+
+	for _, tc := range cases {
+		exp, err := currency.Exponent(tc.code)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, exp, tc.code)
+	}
+
+	_, err := currency.Exponent("XYZ")
+	var invalid *currency.InvalidError
+	assert.True(t, errors.As(err, &invalid))
+}
+
+func TestConvert(t *testing.T) {
+	cases := []struct {
+		desc     string
+		rate     currency.Rate
+		amount   int64
+		expected int64
+	}{
+		{
+			desc:     "Same exponent",
+			rate:     currency.Rate{Base: "USD", Quote: "EUR", Value: "0.92"},
+			amount:   1050,
+			expected: 966,
+		},
+		{
+			desc: "Rounded half up",
+			// 12.34 * 0.925 = 11.4145
+			rate:     currency.Rate{Base: "USD", Quote: "EUR", Value: "0.925"},
+			amount:   1234,
+			expected: 1141,
+		},
+		{
+			desc: "To zero-decimal currency",
+			// 10.50 USD = 1575.525 JPY
+			rate:     currency.Rate{Base: "USD", Quote: "JPY", Value: "150.05"},
+			amount:   1050,
+			expected: 1576,
+		},
+		{
+			desc: "From zero-decimal currency",
+			// 1576 JPY = 10.5077 USD
+			rate:     currency.Rate{Base: "JPY", Quote: "USD", Value: "0.006667"},
+			amount:   1576,
+			expected: 1051,
+		},
+		{
+			desc: "To three-decimal currency",
+			// 10.00 USD = 3.07 KWD
+			rate:     currency.Rate{Base: "USD", Quote: "KWD", Value: "0.307"},
+			amount:   1000,
+			expected: 3070,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := tc.rate.Convert(tc.amount)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestInverse(t *testing.T) {
+	rate := currency.Rate{Base: "USD", Quote: "EUR", Value: "0.8"}
+
+	inverse, err := rate.Inverse()
+	assert.NoError(t, err)
+	assert.Equal(t, currency.Rate{Base: "EUR", Quote: "USD", Value: "1.25"}, inverse)
+}
+
+func TestCross(t *testing.T) {
+	eurUSD := currency.Rate{Base: "EUR", Quote: "USD", Value: "1.25"}
+	usdJPY := currency.Rate{Base: "USD", Quote: "JPY", Value: "150"}
+
+	eurJPY, err := eurUSD.Cross(usdJPY)
+	assert.NoError(t, err)
+	assert.Equal(t, "187.5", eurJPY.Value)
+
+	amount, err := eurJPY.Convert(1000)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1875), amount)
+
+	_, err = usdJPY.Cross(eurUSD)
+	assert.Error(t, err)
+}
+
+func TestValidateRate(t *testing.T) {
+	cases := []struct {
+		desc  string
+		rate  currency.Rate
+		valid bool
+	}{
+		{desc: "Valid", rate: currency.Rate{Base: "USD", Quote: "EUR", Value: "0.92"}, valid: true},
+		{desc: "Unknown currency", rate: currency.Rate{Base: "USD", Quote: "ABC", Value: "1"}},
+		{desc: "Same currency", rate: currency.Rate{Base: "USD", Quote: "usd", Value: "1"}},
+		{desc: "Negative value", rate: currency.Rate{Base: "USD", Quote: "EUR", Value: "-0.92"}},
+		{desc: "Not a number", rate: currency.Rate{Base: "USD", Quote: "EUR", Value: "0,92"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.rate.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
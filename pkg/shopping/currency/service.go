@@ -0,0 +1,152 @@
+package currency
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Service contains exchange rates functionalities.
+type Service interface {
+	// Base returns the currency the carts amounts are expressed in.
+	Base() string
+	Convert(ctx context.Context, amount int64, from, to string) (int64, error)
+	GetRate(ctx context.Context, from, to string) (Rate, error)
+	GetRates(ctx context.Context) ([]Rate, error)
+	SetRates(ctx context.Context, rates []Rate) error
+}
+
+type service struct {
+	db      *sqlx.DB
+	base    string
+	metrics metrics
+}
+
+// NewService returns a new currency service.
+func NewService(db *sqlx.DB, base string) Service {
+	return &service{db, Normalize(base), initMetrics()}
+}
+
+// Base returns the currency the carts amounts are expressed in.
+func (s *service) Base() string {
+	return s.base
+}
+
+// Convert converts the amount from one currency to another using the current rate.
+func (s *service) Convert(ctx context.Context, amount int64, from, to string) (int64, error) {
+	s.metrics.incMethodCalls("Convert")
+
+	rate, err := s.GetRate(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	return rate.Convert(amount)
+}
+
+// GetRate returns the rate from one currency to another.
+//
+// If it wasn't uploaded, the rate is calculated from the inverse one or
+// crossing the rates of both currencies with the base currency.
+func (s *service) GetRate(ctx context.Context, from, to string) (Rate, error) {
+	s.metrics.incMethodCalls("GetRate")
+
+	from, to = Normalize(from), Normalize(to)
+	if err := Validate(from); err != nil {
+		return Rate{}, err
+	}
+	if err := Validate(to); err != nil {
+		return Rate{}, err
+	}
+
+	if from == to {
+		return Rate{Base: from, Quote: to, Value: "1"}, nil
+	}
+
+	rate, err := s.lookup(ctx, from, to)
+	if err == nil || from == s.base || to == s.base {
+		return rate, err
+	}
+
+	toBase, err := s.lookup(ctx, from, s.base)
+	if err != nil {
+		return Rate{}, err
+	}
+	fromBase, err := s.lookup(ctx, s.base, to)
+	if err != nil {
+		return Rate{}, err
+	}
+
+	return toBase.Cross(fromBase)
+}
+
+// GetRates returns all the rates uploaded.
+func (s *service) GetRates(ctx context.Context) ([]Rate, error) {
+	s.metrics.incMethodCalls("GetRates")
+
+	var rates []Rate
+	if err := s.db.SelectContext(ctx, &rates, "SELECT * FROM exchange_rates ORDER BY base, quote"); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the exchange rates")
+	}
+
+	return rates, nil
+}
+
+// SetRates creates or updates the rates provided.
+func (s *service) SetRates(ctx context.Context, rates []Rate) error {
+	s.metrics.incMethodCalls("SetRates")
+
+	for _, r := range rates {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	q := `INSERT INTO exchange_rates (base, quote, rate, updated_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (base, quote) DO UPDATE SET
+	rate=EXCLUDED.rate, updated_at=EXCLUDED.updated_at`
+	now := time.Now()
+	for _, r := range rates {
+		if _, err := tx.ExecContext(ctx, q, Normalize(r.Base), Normalize(r.Quote), r.Value, now); err != nil {
+			return errors.Wrap(err, "couldn't save the exchange rate")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// lookup returns the rate stored or the inverse of the opposite one.
+func (s *service) lookup(ctx context.Context, from, to string) (Rate, error) {
+	var rate Rate
+	q := "SELECT * FROM exchange_rates WHERE base=$1 AND quote=$2"
+	err := s.db.GetContext(ctx, &rate, q, from, to)
+	if err == nil {
+		return rate, nil
+	}
+	if err != sql.ErrNoRows {
+		return Rate{}, errors.Wrap(err, "couldn't find the exchange rate")
+	}
+
+	if err := s.db.GetContext(ctx, &rate, q, to, from); err != nil {
+		if err == sql.ErrNoRows {
+			return Rate{}, errors.Errorf("there is no exchange rate from %s to %s", from, to)
+		}
+		return Rate{}, errors.Wrap(err, "couldn't find the exchange rate")
+	}
+
+	return rate.Inverse()
+}
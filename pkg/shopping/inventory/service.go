@@ -0,0 +1,245 @@
+// Package inventory keeps track of the products' stock and the reservations made over it.
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/GGP1/adak/internal/config"
+	"github.com/GGP1/adak/internal/logger"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// OutOfStockError is returned when the units requested of a product exceed the ones available.
+type OutOfStockError struct {
+	ProductID string
+	VariantID string
+	Requested int64
+	Available int64
+}
+
+func (e *OutOfStockError) Error() string {
+	if e.VariantID != "" {
+		return fmt.Sprintf("variant %q of product %q is out of stock: requested %d, available %d",
+			e.VariantID, e.ProductID, e.Requested, e.Available)
+	}
+	return fmt.Sprintf("product %q is out of stock: requested %d, available %d",
+		e.ProductID, e.Requested, e.Available)
+}
+
+// Service contains stock reservation functionalities.
+//
+// Methods receiving a transaction lock the products' rows, they must be called
+// inside the transaction that modifies the cart or the order.
+//
+// The stock of the products with variants is the one of each variant, an empty
+// variant id refers to the product stock.
+type Service interface {
+	Commit(ctx context.Context, tx *sqlx.Tx, cartID string) error
+	Hold(ctx context.Context, tx *sqlx.Tx, cartID, productID, variantID string, quantity int64) error
+	Release(ctx context.Context, tx *sqlx.Tx, cartID, productID, variantID string) error
+	ReleaseCart(ctx context.Context, tx *sqlx.Tx, cartID string) error
+	ReleaseExpired(ctx context.Context) (int64, error)
+	Restock(ctx context.Context, tx *sqlx.Tx, productID, variantID string, quantity int64) error
+	Sweep(ctx context.Context)
+}
+
+type service struct {
+	db            *sqlx.DB
+	holdTTL       time.Duration
+	sweepInterval time.Duration
+}
+
+// NewService returns a new inventory service.
+func NewService(db *sqlx.DB, config config.Inventory) Service {
+	return &service{
+		db:            db,
+		holdTTL:       time.Duration(config.HoldTTL) * time.Minute,
+		sweepInterval: time.Duration(config.SweepInterval) * time.Minute,
+	}
+}
+
+// Commit converts the cart holds into stock decrements.
+//
+// The quantities are taken from the cart products and checked again against the stock,
+// as the cart holds could have expired.
+func (s *service) Commit(ctx context.Context, tx *sqlx.Tx, cartID string) error {
+	var items []struct {
+		ID        string
+		VariantID string `db:"variant_id"`
+		Quantity  int64
+	}
+	// Lock the products always in the same order to avoid deadlocks
+	q := "SELECT id, variant_id, quantity FROM cart_products WHERE cart_id=$1 ORDER BY id, variant_id"
+	if err := tx.SelectContext(ctx, &items, q, cartID); err != nil {
+		return errors.Wrap(err, "couldn't find the cart products")
+	}
+
+	for _, item := range items {
+		available, err := s.available(ctx, tx, cartID, item.ID, item.VariantID)
+		if err != nil {
+			return err
+		}
+
+		if item.Quantity > available {
+			return &OutOfStockError{
+				ProductID: item.ID,
+				VariantID: item.VariantID,
+				Requested: item.Quantity,
+				Available: available,
+			}
+		}
+
+		if err := s.addStock(ctx, tx, item.ID, item.VariantID, -item.Quantity); err != nil {
+			return errors.Wrap(err, "couldn't decrement the product stock")
+		}
+	}
+
+	return s.ReleaseCart(ctx, tx, cartID)
+}
+
+// Hold reserves quantity units of the product for the cart, replacing the previous
+// reservation and extending its expiration.
+//
+// Reducing an active reservation always succeeds, even if the stock was lowered meanwhile.
+func (s *service) Hold(ctx context.Context, tx *sqlx.Tx, cartID, productID, variantID string, quantity int64) error {
+	available, err := s.available(ctx, tx, cartID, productID, variantID)
+	if err != nil {
+		return err
+	}
+
+	var held int64
+	q := `SELECT quantity FROM stock_holds
+	WHERE cart_id=$1 AND product_id=$2 AND variant_id=$3 AND expires_at > $4`
+	err = tx.GetContext(ctx, &held, q, cartID, productID, variantID, time.Now())
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "couldn't get the cart hold")
+	}
+
+	if quantity > held && quantity > available {
+		return &OutOfStockError{
+			ProductID: productID,
+			VariantID: variantID,
+			Requested: quantity,
+			Available: available,
+		}
+	}
+
+	q = `INSERT INTO stock_holds
+	(cart_id, product_id, variant_id, quantity, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (cart_id, product_id, variant_id) DO UPDATE SET
+	quantity=EXCLUDED.quantity, expires_at=EXCLUDED.expires_at`
+	_, err = tx.ExecContext(ctx, q, cartID, productID, variantID, quantity, time.Now().Add(s.holdTTL))
+	if err != nil {
+		return errors.Wrap(err, "couldn't hold the product stock")
+	}
+
+	return nil
+}
+
+// Release removes the cart reservation over the product.
+func (s *service) Release(ctx context.Context, tx *sqlx.Tx, cartID, productID, variantID string) error {
+	q := "DELETE FROM stock_holds WHERE cart_id=$1 AND product_id=$2 AND variant_id=$3"
+	if _, err := tx.ExecContext(ctx, q, cartID, productID, variantID); err != nil {
+		return errors.Wrap(err, "couldn't release the product stock")
+	}
+
+	return nil
+}
+
+// ReleaseCart removes all the cart reservations.
+func (s *service) ReleaseCart(ctx context.Context, tx *sqlx.Tx, cartID string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM stock_holds WHERE cart_id=$1", cartID); err != nil {
+		return errors.Wrap(err, "couldn't release the cart stock")
+	}
+
+	return nil
+}
+
+// ReleaseExpired removes the expired reservations and returns how many were deleted.
+func (s *service) ReleaseExpired(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM stock_holds WHERE expires_at <= $1", time.Now())
+	if err != nil {
+		return 0, errors.Wrap(err, "couldn't release the expired holds")
+	}
+
+	return res.RowsAffected()
+}
+
+// Restock returns quantity units of the product to the stock.
+func (s *service) Restock(ctx context.Context, tx *sqlx.Tx, productID, variantID string, quantity int64) error {
+	if err := s.addStock(ctx, tx, productID, variantID, quantity); err != nil {
+		return errors.Wrap(err, "couldn't restock the product")
+	}
+
+	return nil
+}
+
+// Sweep releases the expired reservations periodically until the context is cancelled.
+//
+// It returns immediately if the sweep interval is zero.
+func (s *service) Sweep(ctx context.Context) {
+	if s.sweepInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.ReleaseExpired(ctx)
+			if err != nil {
+				logger.Errorf("failed releasing expired holds: %v", err)
+				continue
+			}
+			if n > 0 {
+				logger.Debugf("Released %d expired holds", n)
+			}
+		}
+	}
+}
+
+// addStock adds quantity units to the stock of the product or its variant, a negative quantity decrements it.
+func (s *service) addStock(ctx context.Context, tx *sqlx.Tx, productID, variantID string, quantity int64) error {
+	q := "UPDATE products SET stock=stock+$2 WHERE id=$1"
+	args := []interface{}{productID, quantity}
+	if variantID != "" {
+		q = "UPDATE product_variants SET stock=stock+$3 WHERE id=$1 AND product_id=$2"
+		args = []interface{}{variantID, productID, quantity}
+	}
+
+	_, err := tx.ExecContext(ctx, q, args...)
+	return err
+}
+
+// available locks the product (or variant) row and returns the units that are not held by other carts.
+func (s *service) available(ctx context.Context, tx *sqlx.Tx, cartID, productID, variantID string) (int64, error) {
+	var stock int64
+	q := "SELECT stock FROM products WHERE id=$1 FOR UPDATE"
+	args := []interface{}{productID}
+	if variantID != "" {
+		q = "SELECT stock FROM product_variants WHERE id=$1 AND product_id=$2 FOR UPDATE"
+		args = []interface{}{variantID, productID}
+	}
+	if err := tx.GetContext(ctx, &stock, q, args...); err != nil {
+		return 0, errors.Wrap(err, "couldn't find the product")
+	}
+
+	var held int64
+	q = `SELECT COALESCE(SUM(quantity), 0) FROM stock_holds
+	WHERE product_id=$1 AND variant_id=$2 AND cart_id<>$3 AND expires_at > $4`
+	if err := tx.GetContext(ctx, &held, q, productID, variantID, cartID, time.Now()); err != nil {
+		return 0, errors.Wrap(err, "couldn't get the product holds")
+	}
+
+	return stock - held, nil
+}
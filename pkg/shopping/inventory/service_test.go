@@ -0,0 +1,121 @@
+package inventory_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GGP1/adak/internal/config"
+	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	cartID      = "1"
+	otherCartID = "2"
+	productID   = "3"
+)
+
+func NewInventoryService(t *testing.T) (context.Context, *sqlx.DB, inventory.Service) {
+	t.Helper()
+	logger.Disable()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	db := test.StartPostgres(t)
+	service := inventory.NewService(db, config.Inventory{HoldTTL: 15})
+	createRelationships(ctx, t, db)
+
+	t.Cleanup(func() {
+		cancel()
+	})
+
+	return ctx, db, service
+}
+
+func TestInventoryService(t *testing.T) {
+	ctx, db, s := NewInventoryService(t)
+
+	t.Run("Hold", hold(ctx, db, s))
+	t.Run("Out of stock", outOfStock(ctx, db, s))
+	t.Run("Commit", commit(ctx, db, s))
+	t.Run("Release expired", releaseExpired(ctx, db, s))
+}
+
+func hold(ctx context.Context, db *sqlx.DB, s inventory.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		tx := db.MustBeginTx(ctx, nil)
+		assert.NoError(t, s.Hold(ctx, tx, cartID, productID, "", 3))
+		assert.NoError(t, tx.Commit())
+
+		var held int64
+		q := "SELECT quantity FROM stock_holds WHERE cart_id=$1 AND product_id=$2"
+		assert.NoError(t, db.GetContext(ctx, &held, q, cartID, productID))
+		assert.Equal(t, int64(3), held)
+	}
+}
+
+func outOfStock(ctx context.Context, db *sqlx.DB, s inventory.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		tx := db.MustBeginTx(ctx, nil)
+		defer tx.Rollback()
+
+		// 3 of the 5 units are held by the first cart
+		err := s.Hold(ctx, tx, otherCartID, productID, "", 3)
+		var outOfStock *inventory.OutOfStockError
+		assert.True(t, errors.As(err, &outOfStock))
+		assert.Equal(t, int64(2), outOfStock.Available)
+	}
+}
+
+func commit(ctx context.Context, db *sqlx.DB, s inventory.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		_, err := db.ExecContext(ctx, "INSERT INTO cart_products (id, cart_id, quantity) VALUES ($1, $2, $3)",
+			productID, cartID, 3)
+		assert.NoError(t, err)
+
+		tx := db.MustBeginTx(ctx, nil)
+		assert.NoError(t, s.Commit(ctx, tx, cartID))
+		assert.NoError(t, tx.Commit())
+
+		var stock int64
+		assert.NoError(t, db.GetContext(ctx, &stock, "SELECT stock FROM products WHERE id=$1", productID))
+		assert.Equal(t, int64(2), stock)
+
+		var holds int64
+		assert.NoError(t, db.GetContext(ctx, &holds, "SELECT COUNT(*) FROM stock_holds WHERE cart_id=$1", cartID))
+		assert.Equal(t, int64(0), holds)
+	}
+}
+
+func releaseExpired(ctx context.Context, db *sqlx.DB, s inventory.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		q := `INSERT INTO stock_holds (cart_id, product_id, quantity, expires_at)
+		VALUES ($1, $2, $3, NOW() - INTERVAL '1 minute')`
+		_, err := db.ExecContext(ctx, q, otherCartID, productID, 1)
+		assert.NoError(t, err)
+
+		n, err := s.ReleaseExpired(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+	}
+}
+
+func createRelationships(ctx context.Context, t *testing.T, db *sqlx.DB) {
+	t.Helper()
+
+	queries := []string{
+		"INSERT INTO shops (id, name) VALUES ('1', 'shop')",
+		`INSERT INTO products (id, shop_id, stock, brand, category, type, weight, subtotal, total)
+		VALUES ('3', '1', 5, 'brand', 'category', 'type', 1, 1, 1)`,
+		"INSERT INTO carts (id, counter, weight, discount, taxes, subtotal, total) VALUES ('1', 0, 0, 0, 0, 0, 0)",
+		"INSERT INTO carts (id, counter, weight, discount, taxes, subtotal, total) VALUES ('2', 0, 0, 0, 0, 0, 0)",
+	}
+	for _, q := range queries {
+		_, err := db.ExecContext(ctx, q)
+		assert.NoError(t, err)
+	}
+}
@@ -2,21 +2,31 @@ package ordering
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 
-	"github.com/GGP1/adak/internal/cookie"
 	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/principal"
 	"github.com/GGP1/adak/internal/response"
 	"github.com/GGP1/adak/internal/sanitize"
 	"github.com/GGP1/adak/internal/token"
 	"github.com/GGP1/adak/internal/validate"
+	"github.com/GGP1/adak/pkg/address"
 	"github.com/GGP1/adak/pkg/shopping/cart"
-	"github.com/GGP1/adak/pkg/shopping/payment/stripe"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
+	"github.com/GGP1/adak/pkg/shopping/payment"
+	"github.com/GGP1/adak/pkg/shopping/promotion"
+	"github.com/GGP1/adak/pkg/shopping/shipping"
 	"github.com/google/uuid"
 
 	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-chi/chi/v5"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	"gopkg.in/guregu/null.v4/zero"
 )
 
@@ -25,16 +35,37 @@ type cursorResponse struct {
 	Orders     []Order `json:"orders,omitempty"`
 }
 
+type newOrderResponse struct {
+	Order
+	Payment payment.Intent `json:"payment"`
+}
+
 // OrderParams holds the parameters for creating a order.
 type OrderParams struct {
-	Currency string      `json:"currency" validate:"required"`
-	Address  string      `json:"address" validate:"required"`
-	City     string      `json:"city" validate:"required"`
-	Country  string      `json:"country" validate:"required"`
-	State    string      `json:"state" validate:"required"`
-	ZipCode  string      `json:"zip_code" validate:"required"`
-	Date     Date        `json:"date" validate:"required"`
-	Card     stripe.Card `json:"card" validate:"required"`
+	// Currency is the ISO-4217 code of the currency the buyer pays in
+	Currency string `json:"currency" validate:"required,len=3"`
+	// AddressID is an address of the user's book, it replaces the address fields below
+	AddressID string       `json:"address_id,omitempty" validate:"omitempty,uuid4_rfc4122"`
+	Address   string       `json:"address" validate:"required_without=AddressID"`
+	City      string       `json:"city" validate:"required_without=AddressID"`
+	Country   string       `json:"country" validate:"required_without=AddressID"`
+	State     string       `json:"state" validate:"required_without=AddressID"`
+	ZipCode   string       `json:"zip_code" validate:"required_without=AddressID"`
+	Date      Date         `json:"date" validate:"required"`
+	Card      payment.Card `json:"card" validate:"required"`
+	// ShippingRateID is one of the shipping options of the cart, it's required only if
+	// there are options for the destination
+	ShippingRateID string `json:"shipping_rate_id,omitempty" validate:"omitempty,uuid4_rfc4122"`
+}
+
+// RefundParams holds the parameters for refunding an order, no items means a full refund.
+type RefundParams struct {
+	Items []RefundItem `json:"items" validate:"dive"`
+}
+
+// UpdateStatusParams holds the parameters for updating an order status.
+type UpdateStatusParams struct {
+	Status string `json:"status" validate:"required"`
 }
 
 // Date of the order.
@@ -49,23 +80,69 @@ type Date struct {
 // Handler handles ordering endpoints.
 type Handler struct {
 	orderingService Service
-	development     bool
 	db              *sqlx.DB
 	cache           *memcache.Client
 	cartService     cart.Service
+	payments        payment.Provider
 }
 
 // NewHandler returns a new ordering handler.
-func NewHandler(dev bool, orderingS Service, cartS cart.Service, db *sqlx.DB, cache *memcache.Client) Handler {
+func NewHandler(orderingS Service, cartS cart.Service, payments payment.Provider,
+	db *sqlx.DB, cache *memcache.Client) Handler {
 	return Handler{
-		development:     dev,
 		orderingService: orderingS,
 		cartService:     cartS,
+		payments:        payments,
 		db:              db,
 		cache:           cache,
 	}
 }
 
+// Cancel cancels an order of the user and refunds the payment.
+func (h *Handler) Cancel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		order, err := h.orderingService.GetByID(ctx, id)
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+		if order.ID.String == "" {
+			response.Error(w, http.StatusNotFound, errors.Errorf("order %q not found", id))
+			return
+		}
+
+		if err := token.CheckPermits(ctx, order.UserID.String); err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		if err := h.orderingService.Cancel(ctx, id, order.UserID.String, h.payments); err != nil {
+			var transitionErr *TransitionError
+			if errors.As(err, &transitionErr) {
+				response.Error(w, http.StatusConflict, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := h.cache.Delete(order.UserID.String); err != nil && err != memcache.ErrCacheMiss {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, fmt.Sprintf("order %q cancelled", id))
+	}
+}
+
 // Delete deletes an order.
 func (h *Handler) Delete() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -139,6 +216,69 @@ func (h *Handler) GetByID() http.HandlerFunc {
 	}
 }
 
+// GetRefunds lists the refunds made over an order.
+func (h *Handler) GetRefunds() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		refunds, err := h.orderingService.GetRefunds(ctx, id)
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, refunds)
+	}
+}
+
+// GetStatusHistory lists the status changes of an order.
+func (h *Handler) GetStatusHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		history, err := h.orderingService.GetStatusHistory(ctx, id)
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, history)
+	}
+}
+
+// GetByShopID lists the orders containing products of the shop.
+func (h *Handler) GetByShopID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		shopID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		orders, err := h.orderingService.GetByShopID(ctx, shopID)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, orders)
+	}
+}
+
 // GetByUserID retrieves all the orders from the user.
 func (h *Handler) GetByUserID() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -150,7 +290,7 @@ func (h *Handler) GetByUserID() http.HandlerFunc {
 			return
 		}
 
-		if err := token.CheckPermits(r, id); err != nil {
+		if err := token.CheckPermits(ctx, id); err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
 		}
@@ -176,12 +316,12 @@ func (h *Handler) GetByUserID() http.HandlerFunc {
 func (h *Handler) New() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		cartID, err := cookie.GetValue(r, "CID")
+		cartID, err := principal.CartID(ctx)
 		if err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
 		}
-		userID, err := cookie.GetValue(r, "UID")
+		userID, err := principal.UserID(ctx)
 		if err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
@@ -199,35 +339,259 @@ func (h *Handler) New() http.HandlerFunc {
 		}
 
 		id := uuid.NewString()
-		order, err := h.orderingService.New(ctx, id, userID, cartID, orderParams, h.cartService)
+		order, err := h.orderingService.New(ctx, id, userID, cartID, orderParams, h.cartService, h.payments)
 		if err != nil {
+			var outOfStock *inventory.OutOfStockError
+			if errors.As(err, &outOfStock) {
+				response.Error(w, http.StatusConflict, err)
+				return
+			}
+			var invalidCoupon *promotion.InvalidCouponError
+			if errors.As(err, &invalidCoupon) {
+				response.Error(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			var unavailable *shipping.UnavailableError
+			if errors.As(err, &unavailable) {
+				response.Error(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			var amountErr *payment.AmountError
+			if errors.As(err, &amountErr) {
+				response.Error(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			if errors.Cause(err) == sql.ErrNoRows {
+				response.Error(w, http.StatusNotFound, err)
+				return
+			}
 			response.Error(w, http.StatusInternalServerError, err)
 			return
 		}
 
-		if !h.development {
-			// Create payment intent and update the order status
-			_, err = stripe.CreateIntent(order.ID.String, order.CartID.String,
-				order.Currency.String, order.Cart.Total.Int64, orderParams.Card)
-			if err != nil {
+		// If the payment requires further actions (3D Secure), the order status
+		// is updated when the provider notifies the result
+		intent, err := h.payments.CreateIntent(ctx, payment.IntentParams{
+			OrderID:  order.ID.String,
+			CartID:   order.CartID.String,
+			Currency: order.Currency.String,
+			Amount:   order.Cart.Total.Int64,
+			Card:     orderParams.Card,
+		})
+		if err != nil {
+			// The order was already created, return its stock and coupon so the cart can be ordered again
+			if err := h.orderingService.FailPayment(ctx, order.ID.String, userID); err != nil {
 				response.Error(w, http.StatusInternalServerError, err)
 				return
 			}
+			var declined *payment.DeclineError
+			if errors.As(err, &declined) {
+				response.Error(w, http.StatusPaymentRequired, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
 		}
 
-		if err := h.orderingService.UpdateStatus(ctx, order.ID.String, Paid); err != nil {
+		if err := h.orderingService.SetPaymentIntent(ctx, order.ID.String, intent.ID); err != nil {
 			response.Error(w, http.StatusInternalServerError, err)
 			return
 		}
-		order.Status = zero.IntFrom(int64(Paid))
+		order.PaymentIntentID = zero.StringFrom(intent.ID)
+
+		if intent.Status == payment.StatusSucceeded {
+			if err := h.setStatus(ctx, order.ID.String, Paid, userID); err != nil {
+				response.Error(w, http.StatusInternalServerError, err)
+				return
+			}
+			order.Status = zero.IntFrom(int64(Paid))
+		}
 
 		if err := h.cartService.Reset(ctx, cartID); err != nil {
 			response.Error(w, http.StatusInternalServerError, err)
 			return
 		}
 
-		response.JSON(w, http.StatusCreated, order)
+		response.JSON(w, http.StatusCreated, newOrderResponse{Order: order, Payment: intent})
+	}
+}
+
+// Refund returns some or all the products of an order.
+func (h *Handler) Refund() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		adminID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		var refundParams RefundParams
+		// An empty body refunds the whole order
+		if err := json.NewDecoder(r.Body).Decode(&refundParams); err != nil && err != io.EOF {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, refundParams); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		refunds, err := h.orderingService.Refund(ctx, id, refundParams.Items, adminID, h.payments)
+		if err != nil {
+			var (
+				transitionErr *TransitionError
+				refundErr     *RefundError
+			)
+			switch {
+			case errors.As(err, &transitionErr):
+				response.Error(w, http.StatusConflict, err)
+			case errors.As(err, &refundErr):
+				response.Error(w, http.StatusBadRequest, err)
+			default:
+				response.Error(w, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		response.JSON(w, http.StatusOK, refunds)
+	}
+}
+
+// UpdateStatus moves an order to a new status.
+func (h *Handler) UpdateStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		adminID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		var statusParams UpdateStatusParams
+		if err := json.NewDecoder(r.Body).Decode(&statusParams); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, statusParams); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		status, err := ParseStatus(sanitize.Normalize(statusParams.Status))
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.orderingService.UpdateStatus(ctx, id, status, adminID); err != nil {
+			var transitionErr *TransitionError
+			if errors.As(err, &transitionErr) {
+				response.Error(w, http.StatusConflict, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, fmt.Sprintf("order %q moved to %q", id, status))
+	}
+}
+
+// UpdateShipment lets the shop members update the shipment of their products, without
+// affecting the ones of other shops in the same order.
+func (h *Handler) UpdateShipment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		shopID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		shipmentID := chi.URLParam(r, "shipment_id")
+		if err := validate.UUID(shipmentID); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		var update ShipmentUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, update); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if update.Status != "" {
+			update.Status = sanitize.Normalize(update.Status)
+			status, err := ParseStatus(update.Status)
+			if err != nil {
+				response.Error(w, http.StatusBadRequest, err)
+				return
+			}
+			if !status.IsFulfillment() {
+				response.Error(w, http.StatusForbidden,
+					errors.Errorf("shops can only move shipments to %q, %q or %q", Shipping, Shipped, Delivered))
+				return
+			}
+		}
+
+		if err := h.orderingService.UpdateShipment(ctx, shopID, shipmentID, update, userID); err != nil {
+			var transitionErr *TransitionError
+			switch {
+			case errors.As(err, &transitionErr):
+				response.Error(w, http.StatusConflict, err)
+			case errors.Cause(err) == sql.ErrNoRows:
+				response.Error(w, http.StatusNotFound, errors.New("shipment not found"))
+			default:
+				response.Error(w, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, shipmentID)
+	}
+}
+
+// setStatus is like UpdateStatus but ignores the transitions made previously
+// by the payment events.
+func (h *Handler) setStatus(ctx context.Context, orderID string, status Status, changedBy string) error {
+	err := h.orderingService.UpdateStatus(ctx, orderID, status, changedBy)
+	var transitionErr *TransitionError
+	if err != nil && !errors.As(err, &transitionErr) {
+		return err
 	}
+
+	return nil
 }
 
 func validateOrderParams(ctx context.Context, oParams *OrderParams) error {
@@ -237,9 +601,18 @@ func validateOrderParams(ctx context.Context, oParams *OrderParams) error {
 	oParams.Address = sanitize.Normalize(oParams.Address)
 	oParams.City = sanitize.Normalize(oParams.City)
 	oParams.Country = sanitize.Normalize(oParams.Country)
-	oParams.Currency = sanitize.Normalize(oParams.Currency)
+	oParams.Currency = currency.Normalize(oParams.Currency)
+	if err := currency.Validate(oParams.Currency); err != nil {
+		return err
+	}
 	oParams.State = sanitize.Normalize(oParams.State)
 	oParams.ZipCode = sanitize.Normalize(oParams.ZipCode)
 
-	return nil
+	if oParams.AddressID != "" {
+		return nil
+	}
+	return address.Address{
+		Country: oParams.Country,
+		ZipCode: oParams.ZipCode,
+	}.Validate()
 }
@@ -0,0 +1,213 @@
+package ordering_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/principal"
+	"github.com/GGP1/adak/pkg/shopping/cart"
+	"github.com/GGP1/adak/pkg/shopping/ordering"
+	"github.com/GGP1/adak/pkg/shopping/payment"
+	"github.com/GGP1/adak/pkg/shopping/payment/fake"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// orderingService keeps the orders status in memory.
+type orderingService struct {
+	ordering.Service
+	status map[string]ordering.Status
+}
+
+func (s *orderingService) New(ctx context.Context, id, userID, cartID string,
+	oParams ordering.OrderParams, cartService cart.Service, payments payment.Provider) (ordering.Order, error) {
+	s.status[id] = ordering.Pending
+	return ordering.Order{
+		ID:       zero.StringFrom(id),
+		UserID:   zero.StringFrom(userID),
+		CartID:   zero.StringFrom(cartID),
+		Currency: zero.StringFrom(oParams.Currency),
+		Cart:     ordering.OrderCart{Total: zero.IntFrom(2500)},
+	}, nil
+}
+
+func (s *orderingService) UpdateStatus(ctx context.Context, orderID string, status ordering.Status, changedBy string) error {
+	if !s.status[orderID].CanTransition(status) {
+		return &ordering.TransitionError{From: s.status[orderID], To: status}
+	}
+	s.status[orderID] = status
+	return nil
+}
+
+func (s *orderingService) FailPayment(ctx context.Context, orderID, changedBy string) error {
+	s.status[orderID] = ordering.Failed
+	return nil
+}
+
+func (s *orderingService) SetPaymentIntent(ctx context.Context, orderID, intentID string) error {
+	return nil
+}
+
+type cartService struct {
+	cart.Service
+}
+
+func (c cartService) Reset(ctx context.Context, cartID string) error {
+	return nil
+}
+
+// unavailableProvider fails to create the intents.
+type unavailableProvider struct {
+	payment.Provider
+}
+
+func (p unavailableProvider) CreateIntent(ctx context.Context, params payment.IntentParams) (payment.Intent, error) {
+	return payment.Intent{}, errors.New("connection reset by peer")
+}
+
+type newOrderResponse struct {
+	ID      string         `json:"id"`
+	Payment payment.Intent `json:"payment"`
+}
+
+func TestNewHandler(t *testing.T) {
+	logger.Disable()
+
+	cases := []struct {
+		desc           string
+		card           string
+		expectedCode   int
+		expectedStatus ordering.Status
+		expectedIntent payment.Status
+		expectedEvents []string
+		// unavailable makes the provider fail without declining the payment
+		unavailable bool
+	}{
+		{
+			desc:           "Success",
+			card:           fake.CardSuccess,
+			expectedCode:   http.StatusCreated,
+			expectedStatus: ordering.Paid,
+			expectedIntent: payment.StatusSucceeded,
+			expectedEvents: []string{payment.EventPaymentSucceeded},
+		},
+		{
+			desc:           "Declined",
+			card:           fake.CardDeclined,
+			expectedCode:   http.StatusPaymentRequired,
+			expectedStatus: ordering.Failed,
+			expectedEvents: []string{payment.EventPaymentFailed},
+		},
+		{
+			desc:           "Authentication required",
+			card:           fake.CardAuthenticationRequired,
+			expectedCode:   http.StatusCreated,
+			expectedStatus: ordering.Pending,
+			expectedIntent: payment.StatusRequiresAction,
+		},
+		{
+			desc:           "Provider unavailable",
+			card:           fake.CardSuccess,
+			expectedCode:   http.StatusInternalServerError,
+			expectedStatus: ordering.Failed,
+			unavailable:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			events := make(chan string, 2)
+			provider := fake.NewProvider(func(ctx context.Context, event payment.Event) error {
+				events <- event.Type
+				return nil
+			})
+			if tc.unavailable {
+				provider = unavailableProvider{provider}
+			}
+			s := &orderingService{status: make(map[string]ordering.Status)}
+			handler := ordering.NewHandler(s, cartService{}, provider, nil, nil)
+
+			rec := httptest.NewRecorder()
+			req := newOrderRequest(t, tc.card)
+			handler.New()(rec, req)
+			assert.Equal(t, tc.expectedCode, rec.Code)
+
+			var res newOrderResponse
+			if rec.Code == http.StatusCreated {
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+				assert.Equal(t, tc.expectedIntent, res.Payment.Status)
+			}
+
+			for _, status := range s.status {
+				assert.Equal(t, tc.expectedStatus, status)
+			}
+			for _, expected := range tc.expectedEvents {
+				assert.Equal(t, expected, receive(t, events))
+			}
+
+			if tc.expectedIntent == payment.StatusRequiresAction {
+				// The customer completes the authentication
+				intent, err := provider.ConfirmIntent(context.Background(), res.Payment.ID)
+				assert.NoError(t, err)
+				assert.Equal(t, payment.StatusSucceeded, intent.Status)
+				assert.Equal(t, res.ID, intent.OrderID)
+				assert.Equal(t, payment.EventPaymentSucceeded, receive(t, events))
+			}
+		})
+	}
+}
+
+func newOrderRequest(t *testing.T, cardNumber string) *http.Request {
+	t.Helper()
+
+	params := ordering.OrderParams{
+		Currency: "usd",
+		Address:  "address",
+		City:     "city",
+		Country:  "country",
+		State:    "state",
+		ZipCode:  "1234",
+		Date: ordering.Date{
+			Year:    2150,
+			Month:   8,
+			Day:     14,
+			Hour:    1,
+			Minutes: 1,
+		},
+		Card: payment.Card{
+			Number:   cardNumber,
+			ExpMonth: "12",
+			ExpYear:  "2150",
+			CVC:      "123",
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, json.NewEncoder(&buf).Encode(params))
+
+	req := httptest.NewRequest(http.MethodPost, "/orders/new", &buf)
+	p := principal.Principal{UserID: "user", CartID: "cart"}
+	req = req.WithContext(principal.NewContext(req.Context(), p))
+
+	return req
+}
+
+func receive(t *testing.T, events <-chan string) string {
+	t.Helper()
+
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the payment event")
+		return ""
+	}
+}
@@ -1,24 +1,28 @@
 package ordering
 
 import (
+	"github.com/GGP1/adak/internal/logger"
+
+	"github.com/jmoiron/sqlx"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type metrics struct {
-	totalOrders *prometheus.CounterVec
 	methodCalls *prometheus.CounterVec
 }
 
-func initMetrics() metrics {
+func initMetrics(db *sqlx.DB) metrics {
 	const ns, sub = "adak", "ordering"
+	prometheus.MustRegister(&statusCollector{
+		db: db,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, sub, "orders_total"),
+			"Total number of orders per status",
+			[]string{"status"}, nil,
+		),
+	})
 	return metrics{
-		totalOrders: promauto.NewCounterVec(prometheus.CounterOpts{
-			Namespace: ns,
-			Subsystem: sub,
-			Name:      "orders_total",
-			Help:      "Total number of orders",
-		}, []string{"status"}),
 		methodCalls: promauto.NewCounterVec(prometheus.CounterOpts{
 			Namespace: ns,
 			Subsystem: sub,
@@ -31,3 +35,35 @@ func initMetrics() metrics {
 func (m metrics) incMethodCalls(method string) {
 	m.methodCalls.With(prometheus.Labels{"method": method}).Inc()
 }
+
+// statusCollector counts the orders in each status every time the metrics are gathered,
+// this way the values are the current ones even after a restart.
+type statusCollector struct {
+	db   *sqlx.DB
+	desc *prometheus.Desc
+}
+
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	rows, err := c.db.Query("SELECT status, COUNT(*) FROM orders GROUP BY status")
+	if err != nil {
+		logger.Errorf("failed counting orders: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			status Status
+			count  float64
+		)
+		if err := rows.Scan(&status, &count); err != nil {
+			logger.Errorf("failed scanning orders count: %v", err)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, count, status.String())
+	}
+}
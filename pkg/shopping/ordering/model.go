@@ -1,20 +1,172 @@
 package ordering
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GGP1/adak/pkg/product"
+	"github.com/GGP1/adak/pkg/shopping/pricing"
+
 	"gopkg.in/guregu/null.v4/zero"
 )
 
-type status int64
+// Status represents the state of an order.
+type Status int64
 
 // Order statuses
 const (
-	Pending status = iota
+	Pending Status = iota
 	Paid
 	Shipping
 	Shipped
 	Failed
+	Cancelled
+	Refunded
+	Delivered
 )
 
+var statusNames = map[Status]string{
+	Pending:   "pending",
+	Paid:      "paid",
+	Shipping:  "shipping",
+	Shipped:   "shipped",
+	Failed:    "failed",
+	Cancelled: "cancelled",
+	Refunded:  "refunded",
+	Delivered: "delivered",
+}
+
+// transitions contains the statuses an order can be moved to from each status.
+// Cancelled and Refunded are final, failed payments can be retried.
+var transitions = map[Status][]Status{
+	Pending:   {Paid, Failed, Cancelled},
+	Paid:      {Shipping, Cancelled, Refunded},
+	Shipping:  {Shipped, Refunded},
+	Shipped:   {Delivered, Refunded},
+	Delivered: {Refunded},
+	Failed:    {Pending, Paid, Cancelled},
+}
+
+// fulfillmentRanks contains the statuses each shipment goes through once the order is paid, in order.
+var fulfillmentRanks = map[Status]int{
+	Paid:      0,
+	Shipping:  1,
+	Shipped:   2,
+	Delivered: 3,
+}
+
+// DeriveStatus returns the status of an order from the one of its shipments: delivered when
+// all of them were delivered, shipped when all left the shops and shipping once any of them
+// started. The current status is kept otherwise.
+func DeriveStatus(current Status, shipments []Status) Status {
+	if len(shipments) == 0 {
+		return current
+	}
+
+	started := false
+	slowest := Delivered
+	for _, status := range shipments {
+		if _, ok := fulfillmentRanks[status]; !ok {
+			return current
+		}
+		if status.reached(Shipping) {
+			started = true
+		}
+		if !status.reached(slowest) {
+			slowest = status
+		}
+	}
+
+	switch {
+	case slowest == Delivered, slowest == Shipped:
+		return slowest
+	case started:
+		return Shipping
+	}
+	return current
+}
+
+// ParseStatus returns the status with the name provided.
+func ParseStatus(name string) (Status, error) {
+	name = strings.ToLower(name)
+	for status, n := range statusNames {
+		if n == name {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid status %q", name)
+}
+
+// CanTransition returns whether the order can be moved from s to next.
+func (s Status) CanTransition(next Status) bool {
+	for _, status := range transitions[s] {
+		if status == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFulfillment returns whether the status is set by the shops when delivering their products.
+func (s Status) IsFulfillment() bool {
+	return s == Shipping || s == Shipped || s == Delivered
+}
+
+// reached returns whether s is the fulfillment status target or a later one.
+func (s Status) reached(target Status) bool {
+	rank, ok := fulfillmentRanks[s]
+	return ok && rank >= fulfillmentRanks[target]
+}
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("status(%d)", s)
+}
+
+// TransitionError is returned when trying to move an order to a status that is not
+// reachable from the current one.
+type TransitionError struct {
+	From Status
+	To   Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("invalid status transition from %q to %q", e.From, e.To)
+}
+
+// RefundError is returned when the units of a product requested to refund exceed
+// the ones ordered and not refunded yet.
+type RefundError struct {
+	ProductID  string
+	VariantID  string
+	Requested  int64
+	Refundable int64
+}
+
+func (e *RefundError) Error() string {
+	if e.VariantID != "" {
+		return fmt.Sprintf("variant %q of product %q can't be refunded: requested %d, refundable %d",
+			e.VariantID, e.ProductID, e.Requested, e.Refundable)
+	}
+	return fmt.Sprintf("product %q can't be refunded: requested %d, refundable %d",
+		e.ProductID, e.Requested, e.Refundable)
+}
+
+// StatusChange is a record of an order status modification.
+type StatusChange struct {
+	OrderID zero.String `json:"order_id,omitempty" db:"order_id"`
+	// From is null when the order is created
+	From      zero.Int    `json:"from,omitempty" db:"from_status"`
+	To        zero.Int    `json:"to" db:"to_status"`
+	ChangedBy zero.String `json:"changed_by,omitempty" db:"changed_by"`
+	ChangedAt time.Time   `json:"changed_at,omitempty" db:"changed_at"`
+	// ShipmentID is null when the change was made to the whole order
+	ShipmentID zero.String `json:"shipment_id,omitempty" db:"shipment_id"`
+}
+
 // Order represents a user purchase request.
 type Order struct {
 	ID           zero.String    `json:"id,omitempty"`
@@ -31,7 +183,16 @@ type Order struct {
 	CartID       zero.String    `json:"cart_id,omitempty" db:"cart_id"`
 	Cart         OrderCart      `json:"cart,omitempty"`
 	Products     []OrderProduct `json:"products,omitempty"`
+	Shipments    []Shipment     `json:"shipments,omitempty"`
 	CreatedAt    zero.Time      `json:"created_at,omitempty" db:"created_at"`
+	// ID of the intent used to charge the order
+	PaymentIntentID zero.String `json:"payment_intent_id,omitempty" db:"payment_intent_id"`
+	// BaseCurrency and ExchangeRate are the snapshot of the rate used to convert
+	// the cart amounts into the order currency
+	BaseCurrency zero.String `json:"base_currency,omitempty" db:"base_currency"`
+	ExchangeRate zero.String `json:"exchange_rate,omitempty" db:"exchange_rate"`
+	// Restocked is true once the products not refunded were returned to the stock
+	Restocked zero.Bool `json:"restocked,omitempty"`
 }
 
 // OrderCart represents the cart ordered by the user.
@@ -45,7 +206,11 @@ type OrderCart struct {
 	Discount zero.Int    `json:"discount,omitempty"`
 	Taxes    zero.Int    `json:"taxes,omitempty"`
 	Subtotal zero.Int    `json:"subtotal,omitempty"`
-	Total    zero.Int    `json:"total,omitempty"`
+	// Total includes the shipping price
+	Total zero.Int `json:"total,omitempty"`
+	// Shipping is the price of the rate chosen, zero if a coupon waived it
+	Shipping       zero.Int    `json:"shipping,omitempty"`
+	ShippingRateID zero.String `json:"shipping_rate_id,omitempty" db:"shipping_rate_id"`
 }
 
 // OrderProduct represents a product placed into the cart ordered by the user.
@@ -65,4 +230,86 @@ type OrderProduct struct {
 	Taxes       zero.Int    `json:"taxes,omitempty"`
 	Subtotal    zero.Int    `json:"subtotal,omitempty"`
 	Total       zero.Int    `json:"total,omitempty"`
+	// VariantID, SKU and Options are the snapshot of the variant ordered, if any
+	VariantID zero.String            `json:"variant_id,omitempty" db:"variant_id"`
+	SKU       zero.String            `json:"sku,omitempty"`
+	Options   product.VariantOptions `json:"options,omitempty"`
+	// ShopID is the shop that sold the product
+	ShopID zero.String `json:"shop_id,omitempty" db:"shop_id"`
+}
+
+// line returns the pricing line of the product units.
+func (p OrderProduct) line() pricing.Line {
+	return pricing.Line{
+		UnitPrice:    p.Subtotal.Int64,
+		Quantity:     p.Quantity.Int64,
+		DiscountRate: p.Discount.Int64,
+		TaxRate:      p.Taxes.Int64,
+	}
+}
+
+// Shipment is the part of an order fulfilled by a single shop, it contains the order
+// products with the same shop id.
+//
+// The order status is derived from the one of its shipments once it's paid.
+type Shipment struct {
+	ID             zero.String `json:"id,omitempty"`
+	OrderID        zero.String `json:"order_id,omitempty" db:"order_id"`
+	ShopID         zero.String `json:"shop_id,omitempty" db:"shop_id"`
+	Status         zero.Int    `json:"status,omitempty"`
+	TrackingNumber zero.String `json:"tracking_number,omitempty" db:"tracking_number"`
+	DeliveryDate   zero.Time   `json:"delivery_date,omitempty" db:"delivery_date"`
+	CreatedAt      zero.Time   `json:"created_at,omitempty" db:"created_at"`
+}
+
+// ShipmentUpdate contains the shipment fields the shops can modify, the empty ones are left untouched.
+type ShipmentUpdate struct {
+	Status         string    `json:"status,omitempty"`
+	TrackingNumber string    `json:"tracking_number,omitempty" validate:"max=100"`
+	DeliveryDate   zero.Time `json:"delivery_date,omitempty"`
+}
+
+// Refund represents the units of an order product returned to the customer.
+//
+// Amounts to be provided in a currency’s smallest unit.
+// 100 = 1 USD.
+type Refund struct {
+	ID         zero.String `json:"id,omitempty"`
+	OrderID    zero.String `json:"order_id,omitempty" db:"order_id"`
+	RefundID   zero.String `json:"refund_id,omitempty" db:"refund_id"`
+	ProductID  zero.String `json:"product_id,omitempty" db:"product_id"`
+	Quantity   zero.Int    `json:"quantity,omitempty"`
+	Amount     zero.Int    `json:"amount,omitempty"`
+	RefundedBy zero.String `json:"refunded_by,omitempty" db:"refunded_by"`
+	CreatedAt  zero.Time   `json:"created_at,omitempty" db:"created_at"`
+	VariantID  zero.String `json:"variant_id,omitempty" db:"variant_id"`
+	// RequestID groups the products refunded together, it's the idempotency key used with the provider
+	RequestID zero.String `json:"request_id,omitempty" db:"request_id"`
+	// Status is pending until the payment provider confirms the refund
+	Status string `json:"status,omitempty"`
+	// OrderStatus is the status the order is moved to once the refund succeeds, if any
+	OrderStatus zero.Int `json:"-" db:"order_status"`
+}
+
+// Refund statuses.
+const (
+	refundPending   = "pending"
+	refundSucceeded = "succeeded"
+)
+
+// refundRequest is a refund recorded before asking the payment provider for it.
+type refundRequest struct {
+	ID       string `db:"request_id"`
+	OrderID  string `db:"order_id"`
+	IntentID string `db:"payment_intent_id"`
+	// Amount is the sum of the products refunded
+	Amount      int64
+	OrderStatus zero.Int `db:"order_status"`
+}
+
+// RefundItem is the quantity of an order product to refund.
+type RefundItem struct {
+	ProductID string `json:"product_id" validate:"required"`
+	VariantID string `json:"variant_id,omitempty"`
+	Quantity  int64  `json:"quantity" validate:"required,min=1"`
 }
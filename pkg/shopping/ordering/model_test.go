@@ -0,0 +1,68 @@
+package ordering_test
+
+import (
+	"testing"
+
+	"github.com/GGP1/adak/pkg/shopping/ordering"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		desc     string
+		from     ordering.Status
+		to       ordering.Status
+		expected bool
+	}{
+		{desc: "Pending to paid", from: ordering.Pending, to: ordering.Paid, expected: true},
+		{desc: "Pending to shipped", from: ordering.Pending, to: ordering.Shipped, expected: false},
+		{desc: "Paid to cancelled", from: ordering.Paid, to: ordering.Cancelled, expected: true},
+		{desc: "Failed payment retried", from: ordering.Failed, to: ordering.Paid, expected: true},
+		{desc: "Shipped to delivered", from: ordering.Shipped, to: ordering.Delivered, expected: true},
+		{desc: "Shipped to cancelled", from: ordering.Shipped, to: ordering.Cancelled, expected: false},
+		{desc: "Delivered to refunded", from: ordering.Delivered, to: ordering.Refunded, expected: true},
+		{desc: "Cancelled is final", from: ordering.Cancelled, to: ordering.Pending, expected: false},
+		{desc: "Refunded is final", from: ordering.Refunded, to: ordering.Paid, expected: false},
+		{desc: "Same status", from: ordering.Paid, to: ordering.Paid, expected: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.from.CanTransition(tc.to))
+		})
+	}
+}
+
+func TestDeriveStatus(t *testing.T) {
+	cases := []struct {
+		desc      string
+		current   ordering.Status
+		shipments []ordering.Status
+		expected  ordering.Status
+	}{
+		{desc: "No shipments", current: ordering.Paid, expected: ordering.Paid},
+		{desc: "None started", current: ordering.Paid, shipments: []ordering.Status{ordering.Paid, ordering.Paid}, expected: ordering.Paid},
+		{desc: "One started", current: ordering.Paid, shipments: []ordering.Status{ordering.Shipping, ordering.Paid}, expected: ordering.Shipping},
+		{desc: "One shipped", current: ordering.Shipping, shipments: []ordering.Status{ordering.Shipped, ordering.Paid}, expected: ordering.Shipping},
+		{desc: "All shipped", current: ordering.Shipping, shipments: []ordering.Status{ordering.Shipped, ordering.Delivered}, expected: ordering.Shipped},
+		{desc: "All delivered", current: ordering.Shipped, shipments: []ordering.Status{ordering.Delivered, ordering.Delivered}, expected: ordering.Delivered},
+		{desc: "Refunded", current: ordering.Refunded, shipments: []ordering.Status{ordering.Refunded, ordering.Shipped}, expected: ordering.Refunded},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ordering.DeriveStatus(tc.current, tc.shipments))
+		})
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	status, err := ordering.ParseStatus("Shipping")
+	assert.NoError(t, err)
+	assert.Equal(t, ordering.Shipping, status)
+	assert.Equal(t, "shipping", status.String())
+
+	_, err = ordering.ParseStatus("lost")
+	assert.Error(t, err)
+}
@@ -2,45 +2,79 @@ package ordering
 
 import (
 	"context"
-	"strconv"
+	"database/sql"
 	"time"
 
+	"github.com/GGP1/adak/internal/logger"
 	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/pkg/address"
 	"github.com/GGP1/adak/pkg/postgres"
 	"github.com/GGP1/adak/pkg/product"
 	"github.com/GGP1/adak/pkg/shopping/cart"
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
+	"github.com/GGP1/adak/pkg/shopping/payment"
+	"github.com/GGP1/adak/pkg/shopping/pricing"
+	"github.com/GGP1/adak/pkg/shopping/promotion"
+	"github.com/GGP1/adak/pkg/shopping/shipping"
 
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"gopkg.in/guregu/null.v4/zero"
 )
 
 // Service contains order functionalities.
 type Service interface {
-	New(ctx context.Context, id, userID string, cartID string, oParams OrderParams, cartService cart.Service) (Order, error)
+	New(ctx context.Context, id, userID string, cartID string, oParams OrderParams,
+		cartService cart.Service, payments payment.Provider) (Order, error)
+	Cancel(ctx context.Context, orderID, changedBy string, payments payment.Provider) error
 	Delete(ctx context.Context, orderID string) error
+	Delivered(ctx context.Context, userID, productID string) (bool, error)
+	FailPayment(ctx context.Context, orderID, changedBy string) error
 	Get(ctx context.Context, params params.Query) ([]Order, error)
 	GetByID(ctx context.Context, orderID string) (Order, error)
+	GetByShopID(ctx context.Context, shopID string) ([]Order, error)
 	GetByUserID(ctx context.Context, userID string) ([]Order, error)
 	GetCartByID(ctx context.Context, orderID string) (OrderCart, error)
 	GetProductsByID(ctx context.Context, orderID string) ([]OrderProduct, error)
-	UpdateStatus(ctx context.Context, orderID string, status status) error
+	GetRefunds(ctx context.Context, orderID string) ([]Refund, error)
+	GetStatusHistory(ctx context.Context, orderID string) ([]StatusChange, error)
+	ProcessPaymentEvent(ctx context.Context, event payment.Event) error
+	Refund(ctx context.Context, orderID string, items []RefundItem, refundedBy string, payments payment.Provider) ([]Refund, error)
+	SetPaymentIntent(ctx context.Context, orderID, intentID string) error
+	UpdateShipment(ctx context.Context, shopID, shipmentID string, update ShipmentUpdate, changedBy string) error
+	UpdateStatus(ctx context.Context, orderID string, status Status, changedBy string) error
 }
 
 type service struct {
-	db      *sqlx.DB
-	metrics metrics
+	db         *sqlx.DB
+	inventory  inventory.Service
+	promotions promotion.Service
+	currencies currency.Service
+	shipping   shipping.Service
+	addresses  address.Service
+	metrics    metrics
 }
 
 // NewService returns a new ordering service.
-func NewService(db *sqlx.DB) Service {
-	return &service{db, initMetrics()}
+func NewService(db *sqlx.DB, inventory inventory.Service, promotions promotion.Service,
+	currencies currency.Service, shipping shipping.Service, addresses address.Service) Service {
+	return &service{db, inventory, promotions, currencies, shipping, addresses, initMetrics(db)}
 }
 
-// New creates an order.
+// New creates an order and decrements the stock of the products purchased.
+//
+// The order is split into a shipment for each shop selling the products.
+//
+// The amounts are converted into the order currency with the rate from the base currency,
+// which is saved with it. The coupon applied to the cart is redeemed and its discount subtracted
+// from the order total, the price of the shipping option chosen is added to it.
+//
+// Orders whose total is lower than the minimum the payment provider charges are rejected.
 func (s *service) New(ctx context.Context, id, userID, cartID string,
-	oParams OrderParams, cartService cart.Service) (Order, error) {
+	oParams OrderParams, cartService cart.Service, payments payment.Provider) (Order, error) {
 	s.metrics.incMethodCalls("New")
 
 	cart, err := cartService.Get(ctx, cartID)
@@ -52,6 +86,15 @@ func (s *service) New(ctx context.Context, id, userID, cartID string,
 		return Order{}, errors.New("ordering zero products is not permitted")
 	}
 
+	if oParams.AddressID != "" {
+		addr, err := s.addresses.GetByID(ctx, userID, oParams.AddressID)
+		if err != nil {
+			return Order{}, err
+		}
+		oParams.Address, oParams.City, oParams.Country = addr.Street, addr.City, addr.Country
+		oParams.State, oParams.ZipCode = addr.State, addr.ZipCode
+	}
+
 	// Format delivery date
 	deliveryDate := time.Date(oParams.Date.Year, time.Month(oParams.Date.Month), oParams.Date.Day,
 		oParams.Date.Hour, oParams.Date.Minutes, 0, 0, time.Local)
@@ -59,32 +102,101 @@ func (s *service) New(ctx context.Context, id, userID, cartID string,
 		return Order{}, errors.New("past dates are not valid")
 	}
 
-	tx, err := s.db.Beginx()
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return Order{}, errors.Wrap(err, "starting transaction")
 	}
-	defer tx.Commit()
+	defer tx.Rollback()
+
+	rate, err := s.currencies.GetRate(ctx, s.currencies.Base(), oParams.Currency)
+	if err != nil {
+		return Order{}, err
+	}
 
 	orderQ := `INSERT INTO orders
 	(id, user_id, currency, address, city, country, state, zip_code, 
-	status, ordered_at, delivery_date, cart_id)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	status, ordered_at, delivery_date, cart_id, base_currency, exchange_rate)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
 	_, err = tx.ExecContext(ctx, orderQ, id, userID, oParams.Currency,
 		oParams.Address, oParams.City, oParams.Country, oParams.State, oParams.ZipCode,
 		zero.IntFrom(int64(Pending)), zero.TimeFrom(time.Now()),
-		zero.TimeFrom(deliveryDate), cart.ID)
+		zero.TimeFrom(deliveryDate), cart.ID, rate.Base, rate.Value)
 	if err != nil {
 		return Order{}, errors.Wrap(err, "couldn't create the order")
 	}
 
-	if err := s.saveOrderCart(ctx, tx, id, cart); err != nil {
+	amounts, err := s.saveOrderProducts(ctx, tx, id, rate, cart.Products)
+	if err != nil {
+		return Order{}, err
+	}
+
+	shipments, err := s.saveShipments(ctx, tx, id, deliveryDate, userID)
+	if err != nil {
+		return Order{}, err
+	}
+
+	discount, err := s.promotions.Redeem(ctx, tx, cart.ID, userID, id)
+	if err != nil {
+		return Order{}, err
+	}
+
+	// The free shipping thresholds are compared against the cart total in the base currency,
+	// after the coupon discount
+	dest := shipping.Destination{Country: oParams.Country, State: oParams.State, ZipCode: oParams.ZipCode}
+	shippingOption, err := s.shipping.Quote(ctx, dest, cart.Weight.Int64, cart.Total.Int64-discount.Amount,
+		rate.Base, oParams.ShippingRateID)
+	if err != nil {
+		return Order{}, err
+	}
+	shippingOption.Price, err = rate.Convert(shippingOption.Price)
+	if err != nil {
+		return Order{}, err
+	}
+	discountAmount, err := rate.Convert(discount.Amount)
+	if err != nil {
+		return Order{}, err
+	}
+	amounts.Total -= discountAmount
+	if amounts.Total < 0 {
+		amounts.Total = 0
+	}
+	if discount.FreeShipping {
+		shippingOption.Price = 0
+	}
+	amounts.Total += shippingOption.Price
+
+	if min := payments.MinimumAmount(oParams.Currency); amounts.Total < min {
+		return Order{}, &payment.AmountError{Amount: amounts.Total, Minimum: min, Currency: oParams.Currency}
+	}
+
+	orderCart := OrderCart{
+		OrderID:  zero.StringFrom(id),
+		Counter:  cart.Counter,
+		Weight:   cart.Weight,
+		Discount: zero.IntFrom(amounts.Discount),
+		Taxes:    zero.IntFrom(amounts.Taxes),
+		Subtotal: zero.IntFrom(amounts.Subtotal),
+		Total:    zero.IntFrom(amounts.Total),
+		Shipping: zero.IntFrom(shippingOption.Price),
+		// Empty when there were no shipping options for the destination
+		ShippingRateID: zero.StringFrom(shippingOption.RateID),
+	}
+	if err := s.saveOrderCart(ctx, tx, orderCart); err != nil {
+		return Order{}, err
+	}
+
+	if err := s.inventory.Commit(ctx, tx, cart.ID); err != nil {
 		return Order{}, err
 	}
 
-	if err := s.saveOrderProducts(ctx, tx, id, cart.Products); err != nil {
+	if err := s.saveStatusChange(ctx, tx, id, "", zero.Int{}, Pending, userID); err != nil {
 		return Order{}, err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return Order{}, errors.Wrap(err, "committing transaction")
+	}
+
 	order := Order{
 		ID:           zero.StringFrom(id),
 		UserID:       zero.StringFrom(userID),
@@ -97,21 +209,79 @@ func (s *service) New(ctx context.Context, id, userID, cartID string,
 		OrderedAt:    zero.TimeFrom(time.Now()),
 		DeliveryDate: zero.TimeFrom(deliveryDate),
 		CartID:       zero.StringFrom(cart.ID),
-		Cart: OrderCart{
-			OrderID:  zero.StringFrom(id),
-			Counter:  cart.Counter,
-			Weight:   cart.Weight,
-			Discount: cart.Discount,
-			Taxes:    cart.Taxes,
-			Subtotal: cart.Subtotal,
-			Total:    cart.Total,
-		},
-	}
-
-	s.metrics.totalOrders.With(prometheus.Labels{"status": strconv.FormatInt(int64(Pending), 10)}).Inc()
+		Cart:         orderCart,
+		Shipments:    shipments,
+		BaseCurrency: zero.StringFrom(rate.Base),
+		ExchangeRate: zero.StringFrom(rate.Value),
+	}
+
 	return order, nil
 }
 
+// Cancel cancels a pending, failed or paid order, it returns its products to the stock and releases
+// the coupon redeemed with it.
+//
+// Paid orders are refunded and the payment intent of pending ones is cancelled, failed
+// orders didn't charge the customer so the payment provider isn't contacted.
+func (s *service) Cancel(ctx context.Context, orderID, changedBy string, payments payment.Provider) error {
+	s.metrics.incMethodCalls("Cancel")
+
+	// Complete the refunds interrupted before, they may have cancelled the order already
+	if err := s.resumeRefunds(ctx, orderID, changedBy, payments); err != nil {
+		return err
+	}
+
+	var order Order
+	q := "SELECT status, payment_intent_id FROM orders WHERE id=$1"
+	if err := s.db.GetContext(ctx, &order, q, orderID); err != nil {
+		return errors.Wrap(err, "couldn't find the order")
+	}
+
+	switch status := Status(order.Status.Int64); status {
+	case Paid:
+		_, err := s.refund(ctx, orderID, nil, Cancelled, changedBy, payments)
+		return err
+
+	case Pending, Failed:
+		if status == Pending && order.PaymentIntentID.Valid {
+			_, err := payments.CancelIntent(ctx, order.PaymentIntentID.String, "cancel_"+orderID)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := s.cancelOrder(ctx, orderID, changedBy)
+		// The payment event may have cancelled the order meanwhile
+		var transitionErr *TransitionError
+		if errors.As(err, &transitionErr) && transitionErr.From == Cancelled {
+			return nil
+		}
+		return err
+
+	default:
+		return &TransitionError{From: status, To: Cancelled}
+	}
+}
+
+// Delivered returns whether the user has received the product in any of their orders.
+func (s *service) Delivered(ctx context.Context, userID, productID string) (bool, error) {
+	s.metrics.incMethodCalls("Delivered")
+
+	// Orders created before the shipments were introduced may not have one
+	q := `SELECT EXISTS(
+		SELECT 1 FROM order_products AS op
+		JOIN orders AS o ON o.id=op.order_id
+		LEFT JOIN order_shipments AS sh ON sh.order_id=op.order_id AND sh.shop_id=op.shop_id
+		WHERE o.user_id=$1 AND op.product_id=$2 AND COALESCE(sh.status, o.status)=$3
+	)`
+	var delivered bool
+	if err := s.db.GetContext(ctx, &delivered, q, userID, productID, int64(Delivered)); err != nil {
+		return false, errors.Wrap(err, "couldn't check the user purchases")
+	}
+
+	return delivered, nil
+}
+
 // Delete removes an order.
 func (s *service) Delete(ctx context.Context, orderID string) error {
 	s.metrics.incMethodCalls("Delete")
@@ -122,6 +292,44 @@ func (s *service) Delete(ctx context.Context, orderID string) error {
 	return nil
 }
 
+// FailPayment marks the order as failed after its payment was declined, its products are
+// returned to the stock and the coupon redeemed to the cart so the purchase can be retried.
+func (s *service) FailPayment(ctx context.Context, orderID, changedBy string) error {
+	s.metrics.incMethodCalls("FailPayment")
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	order, err := s.lockOrder(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	// The payment event may have marked the order as failed already
+	if status := Status(order.Status.Int64); status != Failed {
+		if err := s.setStatus(ctx, tx, orderID, status, Failed, changedBy); err != nil {
+			return err
+		}
+	}
+
+	if err := s.restock(ctx, tx, orderID); err != nil {
+		return err
+	}
+
+	if err := s.promotions.Release(ctx, tx, orderID, order.CartID.String); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
 // Get retrieves all the orders.
 func (s *service) Get(ctx context.Context, params params.Query) ([]Order, error) {
 	s.metrics.incMethodCalls("Get")
@@ -139,7 +347,13 @@ func (s *service) Get(ctx context.Context, params params.Query) ([]Order, error)
 func (s *service) GetByID(ctx context.Context, orderID string) (Order, error) {
 	s.metrics.incMethodCalls("GetByID")
 
-	q := `SELECT o.*, c.*, p.*
+	q := `SELECT o.id, o.user_id, o.currency, o.address, o.city, o.state, o.zip_code, o.country,
+	o.status, o.ordered_at, o.delivery_date, o.cart_id, o.payment_intent_id, o.created_at,
+	o.base_currency, o.exchange_rate,
+	c.order_id, c.counter, c.weight, c.discount, c.taxes, c.subtotal, c.total,
+	c.shipping, c.shipping_rate_id,
+	p.product_id, p.order_id, p.quantity, p.brand, p.category, p.type, p.description,
+	p.weight, p.discount, p.taxes, p.subtotal, p.total, p.variant_id, p.sku, p.options, p.shop_id
 	FROM orders AS o
 	LEFT JOIN order_carts AS c ON o.id=c.order_id
 	LEFT JOIN order_products AS p ON o.id=p.order_id
@@ -157,10 +371,13 @@ func (s *service) GetByID(ctx context.Context, orderID string) (Order, error) {
 		err := rows.Scan(
 			&order.ID, &order.UserID, &order.Currency, &order.Address, &order.City,
 			&order.State, &order.ZipCode, &order.Country, &order.Status, &order.OrderedAt,
-			&order.DeliveryDate, &order.CartID,
+			&order.DeliveryDate, &order.CartID, &order.PaymentIntentID, &order.CreatedAt,
+			&order.BaseCurrency, &order.ExchangeRate,
 			&c.OrderID, &c.Counter, &c.Weight, &c.Discount, &c.Taxes, &c.Subtotal, &c.Total,
+			&c.Shipping, &c.ShippingRateID,
 			&p.ProductID, &p.OrderID, &p.Quantity, &p.Brand, &p.Category, &p.Type, &p.Description,
 			&p.Weight, &p.Discount, &p.Taxes, &p.Subtotal, &p.Total,
+			&p.VariantID, &p.SKU, &p.Options, &p.ShopID,
 		)
 		if err != nil {
 			return Order{}, errors.Wrap(err, "couldn't scan order")
@@ -170,14 +387,80 @@ func (s *service) GetByID(ctx context.Context, orderID string) (Order, error) {
 		order.Products = append(order.Products, p)
 	}
 
+	if !order.ID.Valid {
+		return order, nil
+	}
+
+	shipments, err := s.shipments(ctx, order.ID.String)
+	if err != nil {
+		return Order{}, err
+	}
+	order.Shipments = shipments[order.ID.String]
+
 	return order, nil
 }
 
+// GetByShopID returns the orders that contain products of the shop, from the newest to the oldest.
+//
+// Only the products and the shipment of the shop are included in the orders.
+func (s *service) GetByShopID(ctx context.Context, shopID string) ([]Order, error) {
+	s.metrics.incMethodCalls("GetByShopID")
+
+	var orders []Order
+	q := `SELECT * FROM orders
+	WHERE id IN (SELECT order_id FROM order_products WHERE shop_id=$1)
+	ORDER BY created_at DESC`
+	if err := s.db.SelectContext(ctx, &orders, q, shopID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the orders")
+	}
+
+	if len(orders) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(orders))
+	indexes := make(map[string]int, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID.String
+		indexes[o.ID.String] = i
+	}
+
+	var products []OrderProduct
+	q = "SELECT * FROM order_products WHERE shop_id=$1 AND order_id=ANY($2)"
+	if err := s.db.SelectContext(ctx, &products, q, shopID, pq.Array(ids)); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the order products")
+	}
+
+	for _, p := range products {
+		i := indexes[p.OrderID.String]
+		orders[i].Products = append(orders[i].Products, p)
+	}
+
+	var shipments []Shipment
+	q = "SELECT * FROM order_shipments WHERE shop_id=$1 AND order_id=ANY($2)"
+	if err := s.db.SelectContext(ctx, &shipments, q, shopID, pq.Array(ids)); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the order shipments")
+	}
+
+	for _, sh := range shipments {
+		i := indexes[sh.OrderID.String]
+		orders[i].Shipments = append(orders[i].Shipments, sh)
+	}
+
+	return orders, nil
+}
+
 // GetByUserID retrieves orders depending on the user requested.
 func (s *service) GetByUserID(ctx context.Context, userID string) ([]Order, error) {
 	s.metrics.incMethodCalls("GetByUserID")
 
-	q := `SELECT o.*, c.*, p.*
+	q := `SELECT o.id, o.user_id, o.currency, o.address, o.city, o.state, o.zip_code, o.country,
+	o.status, o.ordered_at, o.delivery_date, o.cart_id, o.payment_intent_id, o.created_at,
+	o.base_currency, o.exchange_rate,
+	c.order_id, c.counter, c.weight, c.discount, c.taxes, c.subtotal, c.total,
+	c.shipping, c.shipping_rate_id,
+	p.product_id, p.order_id, p.quantity, p.brand, p.category, p.type, p.description,
+	p.weight, p.discount, p.taxes, p.subtotal, p.total, p.variant_id, p.sku, p.options, p.shop_id
 	FROM orders AS o
 	LEFT JOIN order_carts AS c ON o.id=c.order_id
 	LEFT JOIN order_products AS p ON o.id=p.order_id
@@ -196,10 +479,13 @@ func (s *service) GetByUserID(ctx context.Context, userID string) ([]Order, erro
 		err := rows.Scan(
 			&o.ID, &o.UserID, &o.Currency, &o.Address, &o.City,
 			&o.State, &o.ZipCode, &o.Country, &o.Status, &o.OrderedAt,
-			&o.DeliveryDate, &o.CartID,
+			&o.DeliveryDate, &o.CartID, &o.PaymentIntentID, &o.CreatedAt,
+			&o.BaseCurrency, &o.ExchangeRate,
 			&c.OrderID, &c.Counter, &c.Weight, &c.Discount, &c.Taxes, &c.Subtotal, &c.Total,
+			&c.Shipping, &c.ShippingRateID,
 			&p.ProductID, &p.OrderID, &p.Quantity, &p.Brand, &p.Category, &p.Type,
 			&p.Description, &p.Weight, &p.Discount, &p.Taxes, &p.Subtotal, &p.Total,
+			&p.VariantID, &p.SKU, &p.Options, &p.ShopID,
 		)
 		if err != nil {
 			return nil, errors.Wrap(err, "couldn't scan order")
@@ -210,6 +496,18 @@ func (s *service) GetByUserID(ctx context.Context, userID string) ([]Order, erro
 		orders = append(orders, o)
 	}
 
+	ids := make([]string, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID.String
+	}
+	shipments, err := s.shipments(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	for i, o := range orders {
+		orders[i].Shipments = shipments[o.ID.String]
+	}
+
 	return orders, nil
 }
 
@@ -237,25 +535,654 @@ func (s *service) GetProductsByID(ctx context.Context, orderID string) ([]OrderP
 	return products, nil
 }
 
-// UpdateStatus updates the order status.
-func (s *service) UpdateStatus(ctx context.Context, orderID string, status status) error {
+// GetRefunds returns the refunds made over the order, from the oldest to the newest.
+func (s *service) GetRefunds(ctx context.Context, orderID string) ([]Refund, error) {
+	s.metrics.incMethodCalls("GetRefunds")
+
+	var refunds []Refund
+	q := "SELECT * FROM order_refunds WHERE order_id=$1 ORDER BY created_at"
+	if err := s.db.SelectContext(ctx, &refunds, q, orderID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the order refunds")
+	}
+
+	return refunds, nil
+}
+
+// GetStatusHistory returns the status changes of the order, from the oldest to the newest.
+func (s *service) GetStatusHistory(ctx context.Context, orderID string) ([]StatusChange, error) {
+	s.metrics.incMethodCalls("GetStatusHistory")
+
+	var history []StatusChange
+	q := "SELECT * FROM order_status_history WHERE order_id=$1 ORDER BY changed_at"
+	if err := s.db.SelectContext(ctx, &history, q, orderID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the order status history")
+	}
+
+	return history, nil
+}
+
+// UpdateStatus moves the order to the status provided and records the change.
+//
+// It returns a *TransitionError if the status is not reachable from the current one.
+func (s *service) UpdateStatus(ctx context.Context, orderID string, status Status, changedBy string) error {
 	s.metrics.incMethodCalls("UpdateStatus")
-	s.metrics.totalOrders.With(prometheus.Labels{"status": strconv.FormatInt(int64(status), 10)}).Inc()
-	_, err := s.db.ExecContext(ctx, "UPDATE orders SET status=$2 WHERE id=$1", orderID, status)
+
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return errors.Wrap(err, "couldn't update the order status")
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	order, err := s.lockOrder(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.setStatus(ctx, tx, orderID, Status(order.Status.Int64), status, changedBy); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// UpdateShipment modifies the shipment of the shop, the order takes the status derived
+// from all its shipments.
+//
+// It returns a *TransitionError if the status is not reachable from the shipment one.
+func (s *service) UpdateShipment(ctx context.Context, shopID, shipmentID string,
+	update ShipmentUpdate, changedBy string) error {
+	s.metrics.incMethodCalls("UpdateShipment")
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var orderID string
+	q := "SELECT order_id FROM order_shipments WHERE id=$1 AND shop_id=$2"
+	if err := tx.GetContext(ctx, &orderID, q, shipmentID, shopID); err != nil {
+		return errors.Wrap(err, "couldn't find the shipment")
+	}
+
+	// Lock the order before its shipments, like the changes made to the whole order
+	order, err := s.lockOrder(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	if update.Status != "" {
+		to, err := ParseStatus(update.Status)
+		if err != nil {
+			return err
+		}
+		if !to.IsFulfillment() {
+			return errors.Errorf("shipments can only be moved to %q, %q or %q", Shipping, Shipped, Delivered)
+		}
+
+		shipments, err := s.lockShipments(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+
+		statuses := make([]Status, len(shipments))
+		for i, sh := range shipments {
+			statuses[i] = Status(sh.Status.Int64)
+			if sh.ID.String != shipmentID {
+				continue
+			}
+			if !statuses[i].CanTransition(to) {
+				return &TransitionError{From: statuses[i], To: to}
+			}
+			if err := s.setShipmentStatus(ctx, tx, sh, to, changedBy); err != nil {
+				return err
+			}
+			statuses[i] = to
+		}
+
+		from := Status(order.Status.Int64)
+		if next := DeriveStatus(from, statuses); next != from {
+			if err := s.updateOrderStatus(ctx, tx, orderID, from, next, changedBy); err != nil {
+				return err
+			}
+		}
+	}
+
+	q = `UPDATE order_shipments SET
+	tracking_number=COALESCE($2, tracking_number), delivery_date=COALESCE($3, delivery_date)
+	WHERE id=$1`
+	_, err = tx.ExecContext(ctx, q, shipmentID, zero.StringFrom(update.TrackingNumber), update.DeliveryDate)
+	if err != nil {
+		return errors.Wrap(err, "couldn't update the shipment")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// Refund returns the items specified to the customer and puts them back in stock,
+// if no items are specified all the products not refunded yet are.
+//
+// The order is moved to Refunded once all its products were refunded.
+func (s *service) Refund(ctx context.Context, orderID string, items []RefundItem,
+	refundedBy string, payments payment.Provider) ([]Refund, error) {
+	s.metrics.incMethodCalls("Refund")
+
+	// Otherwise the products of the interrupted refunds would be refundable again
+	if err := s.resumeRefunds(ctx, orderID, refundedBy, payments); err != nil {
+		return nil, err
+	}
+
+	return s.refund(ctx, orderID, items, Refunded, refundedBy, payments)
+}
+
+// SetPaymentIntent saves the id of the intent used to charge the order.
+func (s *service) SetPaymentIntent(ctx context.Context, orderID, intentID string) error {
+	s.metrics.incMethodCalls("SetPaymentIntent")
+
+	q := "UPDATE orders SET payment_intent_id=$2 WHERE id=$1"
+	if _, err := s.db.ExecContext(ctx, q, orderID, intentID); err != nil {
+		return errors.Wrap(err, "couldn't save the payment intent")
+	}
+
+	return nil
+}
+
+// ProcessPaymentEvent updates the status of the order the payment event belongs to.
+//
+// The delivery order of the events is not guaranteed, those that are no longer
+// applicable to the order status are ignored.
+func (s *service) ProcessPaymentEvent(ctx context.Context, event payment.Event) error {
+	s.metrics.incMethodCalls("ProcessPaymentEvent")
+
+	if event.OrderID == "" {
+		logger.Debugf("Ignoring event %q: it's not related to an order", event.ID)
+		return nil
+	}
+
+	var status Status
+	switch event.Type {
+	case payment.EventPaymentSucceeded:
+		status = Paid
+	case payment.EventPaymentFailed:
+		status = Failed
+	case payment.EventPaymentCanceled:
+		status = Cancelled
+	case payment.EventChargeRefunded:
+		if !event.Refunded {
+			// Partial refunds don't change the order status
+			return nil
+		}
+		status = Refunded
+	default:
+		return nil
+	}
+
+	// The changes made by the events must be the same as the ones of the operations triggering them
+	var err error
+	switch status {
+	case Failed:
+		err = s.FailPayment(ctx, event.OrderID, "payments")
+	case Cancelled:
+		err = s.cancelOrder(ctx, event.OrderID, "payments")
+	case Refunded:
+		err = s.refunded(ctx, event.OrderID)
+	default:
+		err = s.UpdateStatus(ctx, event.OrderID, status, "payments")
+	}
+	if err != nil {
+		var transitionErr *TransitionError
+		if errors.As(err, &transitionErr) || errors.Is(err, sql.ErrNoRows) {
+			logger.Debugf("Ignoring event %q: %v", event.ID, err)
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// lockShipments returns the order shipments, locking their rows until the transaction ends.
+func (s *service) lockShipments(ctx context.Context, tx *sqlx.Tx, orderID string) ([]Shipment, error) {
+	var shipments []Shipment
+	q := "SELECT * FROM order_shipments WHERE order_id=$1 ORDER BY shop_id FOR UPDATE"
+	if err := tx.SelectContext(ctx, &shipments, q, orderID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the order shipments")
+	}
+
+	return shipments, nil
+}
+
+// cancelOrder cancels an order that wasn't paid, returns its products to the stock and releases
+// the coupon redeemed with it.
+func (s *service) cancelOrder(ctx context.Context, orderID, changedBy string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	order, err := s.lockOrder(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	// Paid orders are cancelled by refunding them
+	status := Status(order.Status.Int64)
+	if status == Paid {
+		return &TransitionError{From: status, To: Cancelled}
+	}
+
+	if err := s.setStatus(ctx, tx, orderID, status, Cancelled, changedBy); err != nil {
+		return err
+	}
+
+	if err := s.restock(ctx, tx, orderID); err != nil {
+		return err
+	}
+
+	if err := s.promotions.Release(ctx, tx, orderID, order.CartID.String); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// completeRefunds marks the pending refunds of the request as succeeded, returns their products
+// to the stock and moves the order to the status requested with them. All the pending refunds
+// of the order are completed if the request is empty.
+//
+// The order must be locked by the transaction, its new status is returned.
+func (s *service) completeRefunds(ctx context.Context, tx *sqlx.Tx, order Order,
+	requestID, refundID, changedBy string) (Status, error) {
+	var refunds []Refund
+	q := `UPDATE order_refunds SET status=$3
+	WHERE order_id=$1 AND status=$2 AND ($4='' OR request_id=$4)
+	RETURNING *`
+	err := tx.SelectContext(ctx, &refunds, q, order.ID, refundPending, refundSucceeded, requestID)
+	if err != nil {
+		return 0, errors.Wrap(err, "couldn't update the refunds")
+	}
+
+	// The payment event may have completed the request before
+	if refundID != "" {
+		q := "UPDATE order_refunds SET refund_id=$2 WHERE request_id=$1"
+		if _, err := tx.ExecContext(ctx, q, requestID, refundID); err != nil {
+			return 0, errors.Wrap(err, "couldn't save the refund id")
+		}
+	}
+
+	status := Status(order.Status.Int64)
+	to := status
+	for _, r := range refunds {
+		err := s.inventory.Restock(ctx, tx, r.ProductID.String, r.VariantID.String, r.Quantity.Int64)
+		if err != nil {
+			return 0, err
+		}
+		if r.OrderStatus.Valid {
+			to = Status(r.OrderStatus.Int64)
+		}
+	}
+
+	if to == status {
+		return status, nil
+	}
+	// The payment was already returned, an order that started shipping can't be cancelled anymore
+	if to == Cancelled && !status.CanTransition(Cancelled) {
+		to = Refunded
+	}
+	if err := s.setStatus(ctx, tx, order.ID.String, status, to, changedBy); err != nil {
+		return 0, err
+	}
+
+	return to, nil
+}
+
+// lockOrder returns the order status, cart and payment intent, locking its row until the
+// transaction ends.
+func (s *service) lockOrder(ctx context.Context, tx *sqlx.Tx, orderID string) (Order, error) {
+	var order Order
+	q := "SELECT id, status, cart_id, payment_intent_id FROM orders WHERE id=$1 FOR UPDATE"
+	if err := tx.GetContext(ctx, &order, q, orderID); err != nil {
+		return Order{}, errors.Wrap(err, "couldn't find the order")
+	}
+
+	return order, nil
+}
+
+// processRefund asks the payment provider for the refund request and completes it.
+//
+// The request is left pending if the provider fails, it's sent again with the same idempotency
+// key the next time the order is refunded or cancelled.
+func (s *service) processRefund(ctx context.Context, req refundRequest, changedBy string, payments payment.Provider) error {
+	amount := req.Amount
+	// Refunding all the products returns also the amounts that are not part of them (taxes, shipping)
+	if req.OrderStatus.Valid {
+		amount = 0
+	}
+
+	refund, err := payments.Refund(ctx, req.IntentID, amount, req.ID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	order, err := s.lockOrder(ctx, tx, req.OrderID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.completeRefunds(ctx, tx, order, req.ID, refund.ID, changedBy); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// refund records the refund of the items as pending and then asks the payment provider for it,
+// the order is moved to the status given if all its products are refunded.
+func (s *service) refund(ctx context.Context, orderID string, items []RefundItem, to Status,
+	refundedBy string, payments payment.Provider) ([]Refund, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	order, err := s.lockOrder(ctx, tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := Status(order.Status.Int64)
+	// Only paid orders have a payment to return when they are cancelled
+	if !status.CanTransition(to) || (to == Cancelled && status != Paid) {
+		return nil, &TransitionError{From: status, To: to}
+	}
+	if !order.PaymentIntentID.Valid {
+		return nil, errors.New("the order has no payment to refund")
+	}
+
+	refundable, err := s.refundableProducts(ctx, tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	products := refundable
+	if len(items) > 0 {
+		products, err = refundItems(refundable, items)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req := refundRequest{
+		ID:       uuid.NewString(),
+		OrderID:  orderID,
+		IntentID: order.PaymentIntentID.String,
+	}
+	var pending, quantity int64
+	for _, p := range refundable {
+		pending += p.Quantity.Int64
+	}
+	for _, p := range products {
+		quantity += p.Quantity.Int64
+		req.Amount += p.line().Price().Total
+	}
+	if quantity == 0 {
+		return nil, errors.New("there are no products left to refund")
+	}
+	if quantity == pending {
+		req.OrderStatus = zero.IntFrom(int64(to))
+	}
+
+	if err := s.saveRefunds(ctx, tx, req, refundPending, products, refundedBy); err != nil {
+		return nil, err
+	}
+
+	// Save the request before contacting the provider, so it isn't lost if the refund succeeds
+	// but it can't be recorded
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing transaction")
+	}
+
+	if err := s.processRefund(ctx, req, refundedBy, payments); err != nil {
+		return nil, err
+	}
+
+	var refunds []Refund
+	q := "SELECT * FROM order_refunds WHERE request_id=$1 ORDER BY product_id, variant_id"
+	if err := s.db.SelectContext(ctx, &refunds, q, req.ID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the refunds")
+	}
+
+	return refunds, nil
+}
+
+// refunded records the complete refund of the order notified by the payment provider.
+//
+// The refunds requested by the store are completed, if the order isn't finished by them
+// the payment was returned from outside the store and its remaining products are refunded.
+func (s *service) refunded(ctx context.Context, orderID string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	order, err := s.lockOrder(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	status, err := s.completeRefunds(ctx, tx, order, "", "", "payments")
+	if err != nil {
+		return err
+	}
+
+	if status != Cancelled && status != Refunded {
+		if !status.CanTransition(Refunded) {
+			return &TransitionError{From: status, To: Refunded}
+		}
+
+		products, err := s.refundableProducts(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+
+		req := refundRequest{OrderID: orderID, OrderStatus: zero.IntFrom(int64(Refunded))}
+		if err := s.saveRefunds(ctx, tx, req, refundSucceeded, products, "payments"); err != nil {
+			return err
+		}
+
+		for _, p := range products {
+			if p.Quantity.Int64 == 0 {
+				continue
+			}
+			if err := s.inventory.Restock(ctx, tx, p.ProductID.String, p.VariantID.String, p.Quantity.Int64); err != nil {
+				return err
+			}
+		}
+
+		if err := s.setStatus(ctx, tx, orderID, status, Refunded, "payments"); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
 	}
 
 	return nil
 }
 
+// refundableProducts returns the order products with the units that weren't refunded yet.
+func (s *service) refundableProducts(ctx context.Context, tx *sqlx.Tx, orderID string) ([]OrderProduct, error) {
+	var products []OrderProduct
+	q := `SELECT p.product_id, p.variant_id, p.quantity - COALESCE(SUM(r.quantity), 0) AS quantity,
+	p.discount, p.taxes, p.subtotal, p.total
+	FROM order_products AS p
+	LEFT JOIN order_refunds AS r ON r.order_id=p.order_id AND r.product_id=p.product_id
+	AND r.variant_id=COALESCE(p.variant_id, '')
+	WHERE p.order_id=$1
+	GROUP BY p.product_id, p.variant_id, p.quantity, p.discount, p.taxes, p.subtotal, p.total
+	ORDER BY p.product_id, p.variant_id`
+	if err := tx.SelectContext(ctx, &products, q, orderID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the order products")
+	}
+
+	return products, nil
+}
+
+// restock returns the order products that weren't refunded to the stock, it does nothing
+// if they were already returned.
+func (s *service) restock(ctx context.Context, tx *sqlx.Tx, orderID string) error {
+	res, err := tx.ExecContext(ctx, "UPDATE orders SET restocked=true WHERE id=$1 AND NOT restocked", orderID)
+	if err != nil {
+		return errors.Wrap(err, "couldn't update the order")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	products, err := s.refundableProducts(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range products {
+		if p.Quantity.Int64 == 0 {
+			continue
+		}
+		if err := s.inventory.Restock(ctx, tx, p.ProductID.String, p.VariantID.String, p.Quantity.Int64); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resumeRefunds sends again the refund requests of the order that were left pending.
+func (s *service) resumeRefunds(ctx context.Context, orderID, changedBy string, payments payment.Provider) error {
+	var requests []refundRequest
+	q := `SELECT r.request_id, r.order_id, o.payment_intent_id, SUM(r.amount) AS amount,
+	MAX(r.order_status) AS order_status
+	FROM order_refunds AS r
+	JOIN orders AS o ON o.id=r.order_id
+	WHERE r.order_id=$1 AND r.status=$2
+	GROUP BY r.request_id, r.order_id, o.payment_intent_id`
+	if err := s.db.SelectContext(ctx, &requests, q, orderID, refundPending); err != nil {
+		return errors.Wrap(err, "couldn't find the pending refunds")
+	}
+
+	for _, req := range requests {
+		if err := s.processRefund(ctx, req, changedBy, payments); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveRefunds records the refund of each product as part of the request.
+func (s *service) saveRefunds(ctx context.Context, tx *sqlx.Tx, req refundRequest, status string,
+	products []OrderProduct, refundedBy string) error {
+	q := `INSERT INTO order_refunds
+	(id, order_id, request_id, status, order_status, product_id, variant_id, quantity, amount, refunded_by, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	for _, p := range products {
+		if p.Quantity.Int64 == 0 {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, q, uuid.NewString(), req.OrderID, zero.StringFrom(req.ID), status,
+			req.OrderStatus, p.ProductID, p.VariantID.String, p.Quantity, p.line().Price().Total,
+			zero.StringFrom(refundedBy), time.Now())
+		if err != nil {
+			return errors.Wrap(err, "couldn't save the refund")
+		}
+	}
+
+	return nil
+}
+
+// setStatus moves the order to a new status and records the change.
+//
+// Fulfillment statuses are applied to the shipments that didn't reach them yet, the
+// rest are copied to all the shipments.
+func (s *service) setStatus(ctx context.Context, tx *sqlx.Tx, orderID string, from, to Status, changedBy string) error {
+	if !from.CanTransition(to) {
+		return &TransitionError{From: from, To: to}
+	}
+
+	shipments, err := s.lockShipments(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	for _, sh := range shipments {
+		status := Status(sh.Status.Int64)
+		if to.IsFulfillment() {
+			if status.reached(to) {
+				continue
+			}
+			if !status.CanTransition(to) {
+				return &TransitionError{From: status, To: to}
+			}
+		}
+		if err := s.setShipmentStatus(ctx, tx, sh, to, changedBy); err != nil {
+			return err
+		}
+	}
+
+	return s.updateOrderStatus(ctx, tx, orderID, from, to, changedBy)
+}
+
+// setShipmentStatus moves the shipment to a new status and records the change.
+func (s *service) setShipmentStatus(ctx context.Context, tx *sqlx.Tx, shipment Shipment, to Status, changedBy string) error {
+	q := "UPDATE order_shipments SET status=$2 WHERE id=$1"
+	if _, err := tx.ExecContext(ctx, q, shipment.ID, to); err != nil {
+		return errors.Wrap(err, "couldn't update the shipment status")
+	}
+
+	return s.saveStatusChange(ctx, tx, shipment.OrderID.String, shipment.ID.String, shipment.Status, to, changedBy)
+}
+
+// updateOrderStatus sets the order status and records the change, the transition is not validated.
+func (s *service) updateOrderStatus(ctx context.Context, tx *sqlx.Tx, orderID string, from, to Status, changedBy string) error {
+	if _, err := tx.ExecContext(ctx, "UPDATE orders SET status=$2 WHERE id=$1", orderID, to); err != nil {
+		return errors.Wrap(err, "couldn't update the order status")
+	}
+
+	return s.saveStatusChange(ctx, tx, orderID, "", zero.IntFrom(int64(from)), to, changedBy)
+}
+
 // saveOrderCart saves the current user cart to the database.
-func (s *service) saveOrderCart(ctx context.Context, tx *sqlx.Tx, id string, cart cart.Cart) error {
+func (s *service) saveOrderCart(ctx context.Context, tx *sqlx.Tx, cart OrderCart) error {
 	q := `INSERT INTO order_carts
-	(order_id, counter, weight, discount, taxes, subtotal, total)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err := tx.ExecContext(ctx, q, id, cart.Counter, cart.Weight,
-		cart.Discount, cart.Taxes, cart.Subtotal, cart.Total)
+	(order_id, counter, weight, discount, taxes, subtotal, total, shipping, shipping_rate_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := tx.ExecContext(ctx, q, cart.OrderID, cart.Counter, cart.Weight,
+		cart.Discount, cart.Taxes, cart.Subtotal, cart.Total, cart.Shipping, cart.ShippingRateID)
 	if err != nil {
 		return errors.Wrap(err, "couldn't save the order cart")
 	}
@@ -263,39 +1190,190 @@ func (s *service) saveOrderCart(ctx context.Context, tx *sqlx.Tx, id string, car
 	return nil
 }
 
-// saveOrderProducts saves cart products to the database using batch insert.
-func (s *service) saveOrderProducts(ctx context.Context, tx *sqlx.Tx, id string, cartProducts []cart.Product) error {
+// saveStatusChange records a modification of the order status, or the one of its shipment if
+// the id is not empty.
+func (s *service) saveStatusChange(ctx context.Context, tx *sqlx.Tx, orderID, shipmentID string,
+	from zero.Int, to Status, changedBy string) error {
+	q := `INSERT INTO order_status_history
+	(order_id, shipment_id, from_status, to_status, changed_by, changed_at)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := tx.ExecContext(ctx, q, orderID, zero.StringFrom(shipmentID), from, to,
+		zero.StringFrom(changedBy), time.Now())
+	if err != nil {
+		return errors.Wrap(err, "couldn't save the status change")
+	}
+
+	return nil
+}
+
+// saveShipments splits the order into a shipment for each shop selling its products.
+func (s *service) saveShipments(ctx context.Context, tx *sqlx.Tx, orderID string,
+	deliveryDate time.Time, changedBy string) ([]Shipment, error) {
+	var shopIDs []string
+	q := "SELECT DISTINCT shop_id FROM order_products WHERE order_id=$1 AND shop_id IS NOT NULL ORDER BY shop_id"
+	if err := tx.SelectContext(ctx, &shopIDs, q, orderID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the order shops")
+	}
+
+	shipments := make([]Shipment, len(shopIDs))
+	q = `INSERT INTO order_shipments
+	(id, order_id, shop_id, status, delivery_date, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+	for i, shopID := range shopIDs {
+		sh := Shipment{
+			ID:           zero.StringFrom(uuid.NewString()),
+			OrderID:      zero.StringFrom(orderID),
+			ShopID:       zero.StringFrom(shopID),
+			Status:       zero.IntFrom(int64(Pending)),
+			DeliveryDate: zero.TimeFrom(deliveryDate),
+			CreatedAt:    zero.TimeFrom(time.Now()),
+		}
+		_, err := tx.ExecContext(ctx, q, sh.ID, sh.OrderID, sh.ShopID, sh.Status, sh.DeliveryDate, sh.CreatedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't save the shipment")
+		}
+
+		if err := s.saveStatusChange(ctx, tx, orderID, sh.ID.String, zero.Int{}, Pending, changedBy); err != nil {
+			return nil, err
+		}
+		shipments[i] = sh
+	}
+
+	return shipments, nil
+}
+
+// saveOrderProducts saves cart products to the database using batch insert, with their prices
+// converted to the order currency using the order rate. It returns the amounts of the products.
+func (s *service) saveOrderProducts(ctx context.Context, tx *sqlx.Tx, id string, rate currency.Rate,
+	cartProducts []cart.Product) (pricing.Amounts, error) {
+	var amounts pricing.Amounts
 	orderProducts := make([]OrderProduct, len(cartProducts))
 	for i, cp := range cartProducts {
 		var p product.Product
 		if err := tx.GetContext(ctx, &p, "SELECT * FROM products WHERE id=$1", cp.ID); err != nil {
-			return errors.Wrap(err, "couldn't find product")
+			return pricing.Amounts{}, errors.Wrap(err, "couldn't find product")
 		}
 
-		orderProducts[i] = OrderProduct{
+		var v product.Variant
+		if cp.VariantID.String != "" {
+			q := "SELECT * FROM product_variants WHERE id=$1 AND product_id=$2"
+			if err := tx.GetContext(ctx, &v, q, cp.VariantID, cp.ID); err != nil {
+				return pricing.Amounts{}, errors.Wrap(err, "couldn't find the variant")
+			}
+			if v.Weight.Valid {
+				p.Weight = v.Weight
+			}
+			if v.Subtotal.Valid {
+				p.Subtotal = v.Subtotal
+			}
+		}
+
+		// Like in the cart, the prices of the products listed in other currencies are taken
+		// to the base one first
+		basePrice := p.Subtotal.Int64
+		if currency.Normalize(p.Currency.String) != rate.Base {
+			price, err := s.currencies.Convert(ctx, basePrice, p.Currency.String, rate.Base)
+			if err != nil {
+				return pricing.Amounts{}, err
+			}
+			basePrice = price
+		}
+		unitPrice, err := rate.Convert(basePrice)
+		if err != nil {
+			return pricing.Amounts{}, err
+		}
+
+		op := OrderProduct{
 			ProductID:   cp.ID,
 			OrderID:     zero.StringFrom(id),
 			Quantity:    cp.Quantity,
 			Brand:       p.Brand,
 			Category:    p.Category,
 			Description: p.Description,
+			Weight:      p.Weight,
 			Discount:    p.Discount,
 			Taxes:       p.Taxes,
 			Type:        p.Type,
-			Subtotal:    p.Subtotal,
-			Total:       p.Total,
+			Subtotal:    zero.IntFrom(unitPrice),
+			VariantID:   zero.StringFrom(v.ID),
+			SKU:         zero.StringFrom(v.SKU),
+			Options:     v.Options,
+			ShopID:      p.ShopID,
 		}
+		unit := op.line()
+		unit.Quantity = 1
+		op.Total = zero.IntFrom(unit.Price().Total)
+
+		orderProducts[i] = op
+		amounts = amounts.Add(op.line().Price())
 	}
 
 	q := `INSERT INTO order_products
 	(order_id, product_id, quantity, brand, category, type, description, weight, 
-	discount, taxes, subtotal, total)
+	discount, taxes, subtotal, total, variant_id, sku, options, shop_id)
 	VALUES 
 	(:order_id, :product_id, :quantity, :brand, :category, :type, :description, 
-	:weight, :discount, :taxes, :subtotal, :total)`
+	:weight, :discount, :taxes, :subtotal, :total, :variant_id, :sku, :options, :shop_id)`
 	if _, err := tx.NamedExecContext(ctx, q, orderProducts); err != nil {
-		return errors.Wrap(err, "couldn't save order products")
+		return pricing.Amounts{}, errors.Wrap(err, "couldn't save order products")
 	}
 
-	return nil
+	return amounts, nil
+}
+
+// shipments returns the shipments of the orders grouped by order id.
+func (s *service) shipments(ctx context.Context, orderIDs ...string) (map[string][]Shipment, error) {
+	if len(orderIDs) == 0 {
+		return nil, nil
+	}
+
+	var shipments []Shipment
+	q := "SELECT * FROM order_shipments WHERE order_id=ANY($1) ORDER BY shop_id"
+	if err := s.db.SelectContext(ctx, &shipments, q, pq.Array(orderIDs)); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the order shipments")
+	}
+
+	grouped := make(map[string][]Shipment, len(orderIDs))
+	for _, sh := range shipments {
+		grouped[sh.OrderID.String] = append(grouped[sh.OrderID.String], sh)
+	}
+
+	return grouped, nil
+}
+
+// refundItems returns the products with the quantities requested to refund.
+func refundItems(refundable []OrderProduct, items []RefundItem) ([]OrderProduct, error) {
+	type key struct{ productID, variantID string }
+	requested := make(map[key]int64, len(items))
+	for _, item := range items {
+		requested[key{item.ProductID, item.VariantID}] += item.Quantity
+	}
+
+	products := make([]OrderProduct, 0, len(requested))
+	for _, p := range refundable {
+		k := key{p.ProductID.String, p.VariantID.String}
+		quantity, ok := requested[k]
+		if !ok {
+			continue
+		}
+		delete(requested, k)
+
+		if quantity > p.Quantity.Int64 {
+			return nil, &RefundError{
+				ProductID:  k.productID,
+				VariantID:  k.variantID,
+				Requested:  quantity,
+				Refundable: p.Quantity.Int64,
+			}
+		}
+		p.Quantity = zero.IntFrom(quantity)
+		products = append(products, p)
+	}
+
+	// The remaining products weren't ordered
+	for k, quantity := range requested {
+		return nil, &RefundError{ProductID: k.productID, VariantID: k.variantID, Requested: quantity}
+	}
+
+	return products, nil
 }
@@ -2,14 +2,26 @@ package ordering_test
 
 import (
 	"context"
+	"database/sql"
 	"testing"
 
+	"github.com/GGP1/adak/internal/config"
 	"github.com/GGP1/adak/internal/logger"
 	"github.com/GGP1/adak/internal/params"
 	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/address"
 	"github.com/GGP1/adak/pkg/shopping/cart"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
 	"github.com/GGP1/adak/pkg/shopping/ordering"
+	"github.com/GGP1/adak/pkg/shopping/payment"
+	"github.com/GGP1/adak/pkg/shopping/payment/fake"
+	"github.com/GGP1/adak/pkg/shopping/promotion"
+	"github.com/GGP1/adak/pkg/shopping/shipping"
 	"github.com/GGP1/adak/pkg/user"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/guregu/null.v4/zero"
 )
@@ -20,16 +32,21 @@ const (
 	userID  = "95"
 )
 
-func NewOrderingService(t *testing.T) (context.Context, ordering.Service, cart.Service) {
+func NewOrderingService(t *testing.T) (context.Context, *sqlx.DB, ordering.Service, cart.Service) {
 	t.Helper()
 	logger.Disable()
 	ctx, cancel := context.WithCancel(context.Background())
 
 	db := test.StartPostgres(t)
-	service := ordering.NewService(db)
+	inventoryService := inventory.NewService(db, config.Inventory{HoldTTL: 15})
+	currencyService := currency.NewService(db, "USD")
+	promotionService := promotion.NewService(db, currencyService)
+	shippingService := shipping.NewService(db, currencyService)
+	service := ordering.NewService(db, inventoryService, promotionService, currencyService,
+		shippingService, address.NewService(db))
 
 	mc := test.StartMemcached(t)
-	cartService := cart.NewService(db, mc)
+	cartService := cart.NewService(db, mc, inventoryService, promotionService, currencyService)
 	err := cartService.Create(ctx, cartID)
 	assert.NoError(t, err)
 	userService := user.NewService(db, mc)
@@ -40,11 +57,11 @@ func NewOrderingService(t *testing.T) (context.Context, ordering.Service, cart.S
 		cancel()
 	})
 
-	return ctx, service, cartService
+	return ctx, db, service, cartService
 }
 
 func TestOrderingService(t *testing.T) {
-	ctx, s, cartService := NewOrderingService(t)
+	ctx, db, s, cartService := NewOrderingService(t)
 
 	t.Run("New", new(ctx, s, cartService))
 	t.Run("Get", get(ctx, s))
@@ -53,6 +70,15 @@ func TestOrderingService(t *testing.T) {
 	t.Run("Get cart by ID", getCartByID(ctx, s))
 	t.Run("Get products by ID", getProductsByID(ctx, s))
 	t.Run("Update status", updateStatus(ctx, s))
+	t.Run("Update shipment", updateShipment(ctx, db, s))
+	t.Run("Process payment event", processPaymentEvent(ctx, s))
+	t.Run("Refund", refund(ctx, db, s))
+	t.Run("Refund resumed", refundResumed(ctx, db, s))
+	t.Run("Refunded by the provider", refundedByProvider(ctx, db, s))
+	t.Run("Cancel", cancel(ctx, db, s))
+	t.Run("Cancel failed", cancelFailed(ctx, db, s))
+	t.Run("Cancel paid", cancelPaid(ctx, db, s))
+	t.Run("Decline and retry", declineAndRetry(ctx, db, s, cartService))
 	t.Run("Delete", delete(ctx, s))
 }
 
@@ -71,7 +97,7 @@ func new(ctx context.Context, s ordering.Service, cartService cart.Service) func
 				Minutes: 0,
 			},
 		}
-		_, err = s.New(ctx, orderID, userID, cartID, params, cartService)
+		_, err = s.New(ctx, orderID, userID, cartID, params, cartService, fake.NewProvider(nil))
 		assert.NoError(t, err)
 	}
 }
@@ -129,13 +155,382 @@ func getProductsByID(ctx context.Context, s ordering.Service) func(*testing.T) {
 
 func updateStatus(ctx context.Context, s ordering.Service) func(*testing.T) {
 	return func(t *testing.T) {
-		status := ordering.Shipped
-		err := s.UpdateStatus(ctx, orderID, status)
+		for _, status := range []ordering.Status{ordering.Paid, ordering.Shipping, ordering.Shipped} {
+			err := s.UpdateStatus(ctx, orderID, status, userID)
+			assert.NoError(t, err)
+		}
+
+		order, err := s.GetByID(ctx, orderID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(ordering.Shipped), order.Status.Int64)
+
+		err = s.UpdateStatus(ctx, orderID, ordering.Pending, userID)
+		var transitionErr *ordering.TransitionError
+		assert.True(t, errors.As(err, &transitionErr))
+
+		history, err := s.GetStatusHistory(ctx, orderID)
+		assert.NoError(t, err)
+		var orderHistory []ordering.StatusChange
+		for _, change := range history {
+			// Skip the changes made to the shipments
+			if !change.ShipmentID.Valid {
+				orderHistory = append(orderHistory, change)
+			}
+		}
+		// Creation plus the three changes
+		assert.Equal(t, 4, len(orderHistory))
+		assert.Equal(t, int64(ordering.Shipped), orderHistory[3].To.Int64)
+	}
+}
+
+func updateShipment(ctx context.Context, db *sqlx.DB, s ordering.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		const id = "shipments"
+		_, err := db.ExecContext(ctx, "INSERT INTO orders (id, user_id, status) VALUES ($1, $2, $3)",
+			id, userID, ordering.Paid)
 		assert.NoError(t, err)
+		shipmentIDs := []string{uuid.NewString(), uuid.NewString()}
+		shopIDs := []string{"shop_a", "shop_b"}
+		for i, shipmentID := range shipmentIDs {
+			_, err := db.ExecContext(ctx, `INSERT INTO order_shipments (id, order_id, shop_id, status)
+			VALUES ($1, $2, $3, $4)`, shipmentID, id, shopIDs[i], ordering.Paid)
+			assert.NoError(t, err)
+		}
+
+		// Shipments of other shops can't be modified
+		err = s.UpdateShipment(ctx, shopIDs[1], shipmentIDs[0], ordering.ShipmentUpdate{Status: "shipping"}, userID)
+		assert.Equal(t, sql.ErrNoRows, errors.Cause(err))
+
+		update := ordering.ShipmentUpdate{Status: "shipped"}
+		err = s.UpdateShipment(ctx, shopIDs[0], shipmentIDs[0], update, userID)
+		var transitionErr *ordering.TransitionError
+		assert.True(t, errors.As(err, &transitionErr))
+
+		for _, status := range []string{"shipping", "shipped"} {
+			update := ordering.ShipmentUpdate{Status: status, TrackingNumber: "TRK123"}
+			assert.NoError(t, s.UpdateShipment(ctx, shopIDs[0], shipmentIDs[0], update, userID))
+		}
+
+		order, err := s.GetByID(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(ordering.Shipping), order.Status.Int64)
+		assert.Equal(t, 2, len(order.Shipments))
+		assert.Equal(t, "TRK123", order.Shipments[0].TrackingNumber.String)
+		assert.Equal(t, int64(ordering.Paid), order.Shipments[1].Status.Int64)
+
+		for _, status := range []string{"shipping", "shipped"} {
+			update := ordering.ShipmentUpdate{Status: status}
+			assert.NoError(t, s.UpdateShipment(ctx, shopIDs[1], shipmentIDs[1], update, userID))
+		}
+
+		order, err = s.GetByID(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(ordering.Shipped), order.Status.Int64)
+	}
+}
+
+func processPaymentEvent(ctx context.Context, s ordering.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		// Partial refunds and events arriving out of order are ignored
+		events := []payment.Event{
+			{ID: "evt_1", Type: payment.EventChargeRefunded, OrderID: orderID, Refunded: false},
+			{ID: "evt_2", Type: payment.EventPaymentSucceeded, OrderID: orderID},
+			{ID: "evt_3", Type: payment.EventChargeRefunded, OrderID: orderID, Refunded: true},
+		}
+		for _, e := range events {
+			assert.NoError(t, s.ProcessPaymentEvent(ctx, e))
+		}
 
 		order, err := s.GetByID(ctx, orderID)
 		assert.NoError(t, err)
+		assert.Equal(t, int64(ordering.Refunded), order.Status.Int64)
+	}
+}
+
+func refund(ctx context.Context, db *sqlx.DB, s ordering.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		const id, productID = "refund", "refund_product"
+		provider := fake.NewProvider(nil)
+		intent := createPaidOrder(ctx, t, db, provider, id, productID, fake.CardSuccess)
+		assert.NoError(t, s.SetPaymentIntent(ctx, id, intent.ID))
+
+		refunds, err := s.Refund(ctx, id, []ordering.RefundItem{{ProductID: productID, Quantity: 1}}, userID, provider)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(refunds))
+		assert.Equal(t, int64(500), refunds[0].Amount.Int64)
 
-		assert.Equal(t, int64(status), order.Status.Int64)
+		_, err = s.Refund(ctx, id, []ordering.RefundItem{{ProductID: productID, Quantity: 3}}, userID, provider)
+		var refundErr *ordering.RefundError
+		assert.True(t, errors.As(err, &refundErr))
+		assert.Equal(t, int64(2), refundErr.Refundable)
+
+		// Refund the remaining units
+		_, err = s.Refund(ctx, id, nil, userID, provider)
+		assert.NoError(t, err)
+
+		order, err := s.GetByID(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(ordering.Refunded), order.Status.Int64)
+
+		var stock int64
+		assert.NoError(t, db.GetContext(ctx, &stock, "SELECT stock FROM products WHERE id=$1", productID))
+		assert.Equal(t, int64(5), stock)
+	}
+}
+
+func refundResumed(ctx context.Context, db *sqlx.DB, s ordering.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		const id, productID = "refund_resumed", "refund_resumed_product"
+		provider := &flakyProvider{Provider: fake.NewProvider(nil)}
+		intent := createPaidOrder(ctx, t, db, provider, id, productID, fake.CardSuccess)
+		assert.NoError(t, s.SetPaymentIntent(ctx, id, intent.ID))
+
+		items := []ordering.RefundItem{{ProductID: productID, Quantity: 1}}
+		_, err := s.Refund(ctx, id, items, userID, provider)
+		assert.Error(t, err)
+
+		refunds, err := s.GetRefunds(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(refunds))
+		assert.Equal(t, "pending", refunds[0].Status)
+
+		// The pending refund is sent again with the same key before the new one
+		_, err = s.Refund(ctx, id, items, userID, provider)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(provider.keys))
+		assert.Equal(t, provider.keys[0], provider.keys[1])
+		assert.NotEqual(t, provider.keys[0], provider.keys[2])
+
+		refunds, err = s.GetRefunds(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(refunds))
+		for _, r := range refunds {
+			assert.Equal(t, "succeeded", r.Status)
+			assert.True(t, r.RefundID.Valid)
+		}
+
+		var stock int64
+		assert.NoError(t, db.GetContext(ctx, &stock, "SELECT stock FROM products WHERE id=$1", productID))
+		assert.Equal(t, int64(4), stock)
 	}
 }
+
+func refundedByProvider(ctx context.Context, db *sqlx.DB, s ordering.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		const id, productID = "refunded_by_provider", "refunded_by_provider_product"
+		provider := fake.NewProvider(nil)
+		intent := createPaidOrder(ctx, t, db, provider, id, productID, fake.CardSuccess)
+		assert.NoError(t, s.SetPaymentIntent(ctx, id, intent.ID))
+
+		event := payment.Event{ID: "evt_refunded", Type: payment.EventChargeRefunded, OrderID: id, Refunded: true}
+		assert.NoError(t, s.ProcessPaymentEvent(ctx, event))
+		// Events may be delivered more than once
+		assert.NoError(t, s.ProcessPaymentEvent(ctx, event))
+
+		order, err := s.GetByID(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(ordering.Refunded), order.Status.Int64)
+
+		refunds, err := s.GetRefunds(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(refunds))
+		assert.Equal(t, int64(3), refunds[0].Quantity.Int64)
+
+		var stock int64
+		assert.NoError(t, db.GetContext(ctx, &stock, "SELECT stock FROM products WHERE id=$1", productID))
+		assert.Equal(t, int64(5), stock)
+	}
+}
+
+func cancel(ctx context.Context, db *sqlx.DB, s ordering.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		const id, productID = "cancel", "cancel_product"
+		provider := fake.NewProvider(nil)
+		intent := createPaidOrder(ctx, t, db, provider, id, productID, fake.CardAuthenticationRequired)
+		assert.NoError(t, s.SetPaymentIntent(ctx, id, intent.ID))
+		// The customer never completed the authentication
+		_, err := db.ExecContext(ctx, "UPDATE orders SET status=$2, cart_id=$3 WHERE id=$1", id, ordering.Pending, cartID)
+		assert.NoError(t, err)
+		_, err = db.ExecContext(ctx, "INSERT INTO coupons (code, kind, value) VALUES ('CANCEL', 'percentage', 10)")
+		assert.NoError(t, err)
+		_, err = db.ExecContext(ctx, `INSERT INTO coupon_redemptions (order_id, code, user_id, amount)
+		VALUES ($1, 'CANCEL', $2, 150)`, id, userID)
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.Cancel(ctx, id, userID, provider))
+
+		var redemptions int64
+		q := "SELECT COUNT(*) FROM coupon_redemptions WHERE order_id=$1"
+		assert.NoError(t, db.GetContext(ctx, &redemptions, q, id))
+		assert.Equal(t, int64(0), redemptions)
+
+		order, err := s.GetByID(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(ordering.Cancelled), order.Status.Int64)
+
+		intents, err := provider.ListIntents(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, payment.StatusCanceled, intents[0].Status)
+
+		err = s.Cancel(ctx, id, userID, provider)
+		var transitionErr *ordering.TransitionError
+		assert.True(t, errors.As(err, &transitionErr))
+	}
+}
+
+func cancelFailed(ctx context.Context, db *sqlx.DB, s ordering.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		const id, productID = "cancel_failed", "cancel_failed_product"
+		intent := createPaidOrder(ctx, t, db, fake.NewProvider(nil), id, productID, fake.CardSuccess)
+		assert.NoError(t, s.SetPaymentIntent(ctx, id, intent.ID))
+		_, err := db.ExecContext(ctx, "UPDATE orders SET status=$2 WHERE id=$1", id, ordering.Failed)
+		assert.NoError(t, err)
+
+		// The customer wasn't charged, the provider must not be used
+		assert.NoError(t, s.Cancel(ctx, id, userID, nil))
+
+		order, err := s.GetByID(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(ordering.Cancelled), order.Status.Int64)
+
+		var stock int64
+		assert.NoError(t, db.GetContext(ctx, &stock, "SELECT stock FROM products WHERE id=$1", productID))
+		assert.Equal(t, int64(5), stock)
+	}
+}
+
+func cancelPaid(ctx context.Context, db *sqlx.DB, s ordering.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		const id, productID = "cancel_paid", "cancel_paid_product"
+		provider := fake.NewProvider(nil)
+		intent := createPaidOrder(ctx, t, db, provider, id, productID, fake.CardSuccess)
+		assert.NoError(t, s.SetPaymentIntent(ctx, id, intent.ID))
+
+		assert.NoError(t, s.Cancel(ctx, id, userID, provider))
+
+		order, err := s.GetByID(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(ordering.Cancelled), order.Status.Int64)
+
+		refunds, err := s.GetRefunds(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(refunds))
+		assert.Equal(t, "succeeded", refunds[0].Status)
+
+		// The payment was returned completely
+		_, err = provider.Refund(ctx, intent.ID, 0, "after_cancel")
+		assert.Error(t, err)
+
+		var stock int64
+		assert.NoError(t, db.GetContext(ctx, &stock, "SELECT stock FROM products WHERE id=$1", productID))
+		assert.Equal(t, int64(5), stock)
+	}
+}
+
+func declineAndRetry(ctx context.Context, db *sqlx.DB, s ordering.Service, cartService cart.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		const id, retryID, cartID, productID = "declined", "retried", "decline_cart", "decline_product"
+		assert.NoError(t, cartService.Create(ctx, cartID))
+		_, err := db.ExecContext(ctx, `INSERT INTO products (id, stock, brand, category, type, weight, subtotal, total)
+		VALUES ($1, 2, 'brand', 'category', 'type', 1, 500, 500)`, productID)
+		assert.NoError(t, err)
+		_, err = db.ExecContext(ctx, `INSERT INTO coupons (code, kind, value, max_uses_per_user)
+		VALUES ('ONCE', 'percentage', 10, 1)`)
+		assert.NoError(t, err)
+
+		p := cart.Product{CartID: zero.StringFrom(cartID), ID: zero.StringFrom(productID), Quantity: zero.IntFrom(2)}
+		assert.NoError(t, cartService.Add(ctx, p))
+		_, err = cartService.ApplyCoupon(ctx, cartID, userID, "ONCE")
+		assert.NoError(t, err)
+
+		params := ordering.OrderParams{Date: ordering.Date{Year: 2150, Month: 8, Day: 14}}
+		// The order can't be paid, nothing is taken from the stock
+		_, err = s.New(ctx, id, userID, cartID, params, cartService, minimumProvider{fake.NewProvider(nil)})
+		var amountErr *payment.AmountError
+		assert.True(t, errors.As(err, &amountErr))
+		var stock int64
+		assert.NoError(t, db.GetContext(ctx, &stock, "SELECT stock FROM products WHERE id=$1", productID))
+		assert.Equal(t, int64(2), stock)
+
+		_, err = s.New(ctx, id, userID, cartID, params, cartService, fake.NewProvider(nil))
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.FailPayment(ctx, id, userID))
+		// Calling it again, as the payment event does, must not return the products twice
+		assert.NoError(t, s.FailPayment(ctx, id, "payments"))
+
+		order, err := s.GetByID(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(ordering.Failed), order.Status.Int64)
+
+		assert.NoError(t, db.GetContext(ctx, &stock, "SELECT stock FROM products WHERE id=$1", productID))
+		assert.Equal(t, int64(2), stock)
+
+		var code string
+		assert.NoError(t, db.GetContext(ctx, &code, "SELECT code FROM cart_coupons WHERE cart_id=$1", cartID))
+		assert.Equal(t, "ONCE", code)
+
+		_, err = s.New(ctx, retryID, userID, cartID, params, cartService, fake.NewProvider(nil))
+		assert.NoError(t, err)
+
+		assert.NoError(t, db.GetContext(ctx, &stock, "SELECT stock FROM products WHERE id=$1", productID))
+		assert.Equal(t, int64(0), stock)
+
+		var redemptions int64
+		q := "SELECT COUNT(*) FROM coupon_redemptions WHERE code='ONCE'"
+		assert.NoError(t, db.GetContext(ctx, &redemptions, q))
+		assert.Equal(t, int64(1), redemptions)
+	}
+}
+
+// minimumProvider charges only amounts higher than any order of the tests.
+type minimumProvider struct {
+	payment.Provider
+}
+
+func (p minimumProvider) MinimumAmount(currency string) int64 {
+	return 1_000_000
+}
+
+// flakyProvider loses the response of the first refund, after the provider made it.
+type flakyProvider struct {
+	payment.Provider
+	failed bool
+	keys   []string
+}
+
+func (p *flakyProvider) Refund(ctx context.Context, intentID string, amount int64, idempotencyKey string) (payment.Refund, error) {
+	p.keys = append(p.keys, idempotencyKey)
+	refund, err := p.Provider.Refund(ctx, intentID, amount, idempotencyKey)
+	if err == nil && !p.failed {
+		p.failed = true
+		return payment.Refund{}, errors.New("connection reset")
+	}
+	return refund, err
+}
+
+// createPaidOrder creates an order with 3 units of a product and charges it.
+func createPaidOrder(ctx context.Context, t *testing.T, db *sqlx.DB, provider payment.Provider,
+	id, productID, card string) payment.Intent {
+	t.Helper()
+
+	_, err := db.ExecContext(ctx, `INSERT INTO products (id, stock, brand, category, type, weight, subtotal, total)
+	VALUES ($1, 2, 'brand', 'category', 'type', 1, 500, 500)`, productID)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO orders (id, user_id, status) VALUES ($1, $2, $3)",
+		id, userID, ordering.Paid)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO order_products (order_id, product_id, quantity, total)
+	VALUES ($1, $2, 3, 500)`, id, productID)
+	assert.NoError(t, err)
+
+	intent, err := provider.CreateIntent(ctx, payment.IntentParams{
+		OrderID:  id,
+		Currency: "usd",
+		Amount:   1500,
+		Card:     payment.Card{Number: card},
+	})
+	assert.NoError(t, err)
+
+	return intent
+}
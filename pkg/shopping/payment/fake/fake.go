@@ -0,0 +1,269 @@
+// Package fake implements an in-memory payment provider with a deterministic behavior,
+// it's meant to be used in development and tests.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/pkg/shopping/payment"
+
+	"github.com/pkg/errors"
+)
+
+// Test card numbers, they match the ones used by Stripe in test mode.
+// Any other number is charged successfully.
+const (
+	CardSuccess                = "4242424242424242"
+	CardDeclined               = "4000000000000002"
+	CardInsufficientFunds      = "4000000000009995"
+	CardAuthenticationRequired = "4000002760003184"
+)
+
+type intent struct {
+	payment.Intent
+	refunded int64
+}
+
+type provider struct {
+	sync.Mutex
+
+	handle  payment.EventHandler
+	intents map[string]*intent
+	// cancels and refunds keep the result of the requests by their idempotency key
+	cancels map[string]payment.Intent
+	refunds map[string]payment.Refund
+	// ids keeps the creation order of the intents
+	ids []string
+	seq int
+}
+
+// NewProvider returns an in-memory payment provider.
+//
+// The events are passed to the handler asynchronously, as a webhook would do,
+// a nil handler discards them.
+func NewProvider(handle payment.EventHandler) payment.Provider {
+	return &provider{
+		handle:  handle,
+		intents: make(map[string]*intent),
+		cancels: make(map[string]payment.Intent),
+		refunds: make(map[string]payment.Refund),
+	}
+}
+
+// CancelIntent cancels an intent that hasn't succeeded yet.
+func (p *provider) CancelIntent(ctx context.Context, intentID, idempotencyKey string) (payment.Intent, error) {
+	p.Lock()
+	if res, ok := p.cancels[idempotencyKey]; ok {
+		p.Unlock()
+		return res, nil
+	}
+
+	in, err := p.get(intentID)
+	if err != nil {
+		p.Unlock()
+		return payment.Intent{}, err
+	}
+
+	if in.Status == payment.StatusSucceeded || in.Status == payment.StatusCanceled {
+		p.Unlock()
+		return payment.Intent{}, errors.Errorf("fake: intent %q is already %s", intentID, in.Status)
+	}
+	in.Status = payment.StatusCanceled
+	res := in.Intent
+	p.cancels[idempotencyKey] = res
+	p.Unlock()
+
+	p.emit(payment.Event{Type: payment.EventPaymentCanceled, OrderID: res.OrderID, IntentID: res.ID})
+	return res, nil
+}
+
+// CaptureIntent captures the funds of an intent with status requires_capture.
+func (p *provider) CaptureIntent(ctx context.Context, intentID string) (payment.Intent, error) {
+	p.Lock()
+	in, err := p.get(intentID)
+	if err != nil {
+		p.Unlock()
+		return payment.Intent{}, err
+	}
+
+	if in.Status != payment.StatusRequiresCapture {
+		p.Unlock()
+		return payment.Intent{}, errors.Errorf("fake: intent %q can't be captured, status: %s", intentID, in.Status)
+	}
+	in.Status = payment.StatusSucceeded
+	res := in.Intent
+	p.Unlock()
+
+	p.emit(payment.Event{Type: payment.EventPaymentSucceeded, OrderID: res.OrderID, IntentID: res.ID})
+	return res, nil
+}
+
+// ConfirmIntent completes the authentication of an intent with status requires_action.
+func (p *provider) ConfirmIntent(ctx context.Context, intentID string) (payment.Intent, error) {
+	p.Lock()
+	in, err := p.get(intentID)
+	if err != nil {
+		p.Unlock()
+		return payment.Intent{}, err
+	}
+
+	if in.Status != payment.StatusRequiresAction && in.Status != payment.StatusRequiresConfirmation {
+		p.Unlock()
+		return payment.Intent{}, errors.Errorf("fake: intent %q can't be confirmed, status: %s", intentID, in.Status)
+	}
+	in.Status = payment.StatusSucceeded
+	res := in.Intent
+	p.Unlock()
+
+	p.emit(payment.Event{Type: payment.EventPaymentSucceeded, OrderID: res.OrderID, IntentID: res.ID})
+	return res, nil
+}
+
+// CreateIntent creates an intent and charges the card depending on its number.
+func (p *provider) CreateIntent(ctx context.Context, params payment.IntentParams) (payment.Intent, error) {
+	if min := p.MinimumAmount(params.Currency); params.Amount < min {
+		return payment.Intent{}, &payment.AmountError{Amount: params.Amount, Minimum: min, Currency: params.Currency}
+	}
+
+	p.Lock()
+	p.seq++
+	id := fmt.Sprintf("pi_fake_%d", p.seq)
+	in := &intent{
+		Intent: payment.Intent{
+			ID:           id,
+			OrderID:      params.OrderID,
+			Currency:     params.Currency,
+			Amount:       params.Amount,
+			ClientSecret: id + "_secret",
+		},
+	}
+
+	var declined *payment.DeclineError
+	switch params.Card.Number {
+	case CardDeclined:
+		declined = &payment.DeclineError{Code: "generic_decline", Message: "Your card was declined."}
+	case CardInsufficientFunds:
+		declined = &payment.DeclineError{Code: "insufficient_funds", Message: "Your card has insufficient funds."}
+	case CardAuthenticationRequired:
+		in.Status = payment.StatusRequiresAction
+	default:
+		in.Status = payment.StatusSucceeded
+	}
+	if declined != nil {
+		in.Status = payment.StatusRequiresPaymentMethod
+	}
+
+	p.intents[id] = in
+	p.ids = append(p.ids, id)
+	res := in.Intent
+	p.Unlock()
+
+	switch {
+	case declined != nil:
+		p.emit(payment.Event{Type: payment.EventPaymentFailed, OrderID: res.OrderID, IntentID: id})
+		return payment.Intent{}, declined
+	case res.Status == payment.StatusSucceeded:
+		p.emit(payment.Event{Type: payment.EventPaymentSucceeded, OrderID: res.OrderID, IntentID: id})
+	}
+
+	return res, nil
+}
+
+// ListIntents returns the intents in the order they were created.
+func (p *provider) ListIntents(ctx context.Context) ([]payment.Intent, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	list := make([]payment.Intent, 0, len(p.ids))
+	for _, id := range p.ids {
+		list = append(list, p.intents[id].Intent)
+	}
+
+	return list, nil
+}
+
+// MinimumAmount returns the lowest amount the fake provider charges, any positive one.
+func (p *provider) MinimumAmount(currency string) int64 {
+	return 1
+}
+
+// Refund returns the amount of a succeeded intent that wasn't refunded yet.
+func (p *provider) Refund(ctx context.Context, intentID string, amount int64, idempotencyKey string) (payment.Refund, error) {
+	p.Lock()
+	if refund, ok := p.refunds[idempotencyKey]; ok {
+		p.Unlock()
+		return refund, nil
+	}
+
+	in, err := p.get(intentID)
+	if err != nil {
+		p.Unlock()
+		return payment.Refund{}, err
+	}
+
+	if in.Status != payment.StatusSucceeded {
+		p.Unlock()
+		return payment.Refund{}, errors.Errorf("fake: intent %q can't be refunded, status: %s", intentID, in.Status)
+	}
+
+	remaining := in.Amount - in.refunded
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		p.Unlock()
+		return payment.Refund{}, errors.Errorf("fake: invalid refund amount %d, remaining: %d", amount, remaining)
+	}
+
+	in.refunded += amount
+	p.seq++
+	refund := payment.Refund{
+		ID:       fmt.Sprintf("re_fake_%d", p.seq),
+		IntentID: intentID,
+		Amount:   amount,
+		Status:   "succeeded",
+	}
+	p.refunds[idempotencyKey] = refund
+	event := payment.Event{
+		Type:     payment.EventChargeRefunded,
+		OrderID:  in.OrderID,
+		IntentID: intentID,
+		Refunded: in.refunded == in.Amount,
+	}
+	p.Unlock()
+
+	p.emit(event)
+	return refund, nil
+}
+
+// get returns the intent with the id given, the provider must be locked.
+func (p *provider) get(intentID string) (*intent, error) {
+	in, ok := p.intents[intentID]
+	if !ok {
+		return nil, errors.Errorf("fake: intent %q not found", intentID)
+	}
+
+	return in, nil
+}
+
+// emit passes the event to the handler in the background, as the event may be
+// delivered while the caller holds locks on the order.
+func (p *provider) emit(event payment.Event) {
+	if p.handle == nil {
+		return
+	}
+
+	p.Lock()
+	p.seq++
+	event.ID = fmt.Sprintf("evt_fake_%d", p.seq)
+	p.Unlock()
+
+	go func() {
+		if err := p.handle(context.Background(), event); err != nil {
+			logger.Errorf("failed handling payment event %q: %v", event.ID, err)
+		}
+	}()
+}
@@ -0,0 +1,133 @@
+package fake_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GGP1/adak/pkg/shopping/payment"
+	"github.com/GGP1/adak/pkg/shopping/payment/fake"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateIntent(t *testing.T) {
+	cases := []struct {
+		desc     string
+		card     string
+		expected payment.Status
+		declined bool
+	}{
+		{desc: "Success", card: fake.CardSuccess, expected: payment.StatusSucceeded},
+		{desc: "Unknown card", card: "5555555555554444", expected: payment.StatusSucceeded},
+		{desc: "Authentication required", card: fake.CardAuthenticationRequired, expected: payment.StatusRequiresAction},
+		{desc: "Declined", card: fake.CardDeclined, declined: true},
+		{desc: "Insufficient funds", card: fake.CardInsufficientFunds, declined: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := fake.NewProvider(nil)
+			intent, err := p.CreateIntent(context.Background(), newParams(tc.card))
+
+			if tc.declined {
+				var declined *payment.DeclineError
+				assert.True(t, errors.As(err, &declined))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, intent.Status)
+			assert.Equal(t, "pi_fake_1", intent.ID)
+		})
+	}
+}
+
+func TestCreateIntentMinimum(t *testing.T) {
+	p := fake.NewProvider(nil)
+	params := newParams(fake.CardSuccess)
+	params.Amount = 0
+
+	_, err := p.CreateIntent(context.Background(), params)
+	var amountErr *payment.AmountError
+	assert.True(t, errors.As(err, &amountErr))
+	assert.Equal(t, p.MinimumAmount(params.Currency), amountErr.Minimum)
+}
+
+func TestRefund(t *testing.T) {
+	ctx := context.Background()
+	events := make(chan payment.Event, 1)
+	p := fake.NewProvider(func(ctx context.Context, event payment.Event) error {
+		events <- event
+		return nil
+	})
+
+	intent, err := p.CreateIntent(ctx, newParams(fake.CardSuccess))
+	assert.NoError(t, err)
+	assert.Equal(t, payment.EventPaymentSucceeded, receive(t, events).Type)
+
+	refund, err := p.Refund(ctx, intent.ID, 1000, "first")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), refund.Amount)
+	assert.False(t, receive(t, events).Refunded)
+
+	// Retrying the request doesn't refund the amount again
+	retried, err := p.Refund(ctx, intent.ID, 1000, "first")
+	assert.NoError(t, err)
+	assert.Equal(t, refund, retried)
+
+	// Zero refunds the remaining amount
+	refund, err = p.Refund(ctx, intent.ID, 0, "second")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1500), refund.Amount)
+	assert.True(t, receive(t, events).Refunded)
+
+	_, err = p.Refund(ctx, intent.ID, 1, "third")
+	assert.Error(t, err)
+}
+
+func TestCancelIntent(t *testing.T) {
+	ctx := context.Background()
+	p := fake.NewProvider(nil)
+
+	intent, err := p.CreateIntent(ctx, newParams(fake.CardAuthenticationRequired))
+	assert.NoError(t, err)
+
+	intent, err = p.CancelIntent(ctx, intent.ID, "cancel")
+	assert.NoError(t, err)
+	assert.Equal(t, payment.StatusCanceled, intent.Status)
+
+	_, err = p.CancelIntent(ctx, intent.ID, "cancel")
+	assert.NoError(t, err)
+	_, err = p.CancelIntent(ctx, intent.ID, "other")
+	assert.Error(t, err)
+
+	_, err = p.ConfirmIntent(ctx, intent.ID)
+	assert.Error(t, err)
+
+	list, err := p.ListIntents(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+}
+
+func newParams(card string) payment.IntentParams {
+	return payment.IntentParams{
+		OrderID:  "order",
+		CartID:   "cart",
+		Currency: "usd",
+		Amount:   2500,
+		Card:     payment.Card{Number: card},
+	}
+}
+
+func receive(t *testing.T, events <-chan payment.Event) payment.Event {
+	t.Helper()
+
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the event")
+		return payment.Event{}
+	}
+}
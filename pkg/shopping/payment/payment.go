@@ -0,0 +1,119 @@
+// Package payment defines the operations the payment providers must support.
+package payment
+
+import (
+	"context"
+	"fmt"
+)
+
+// Status of a payment intent.
+type Status string
+
+// Payment intent statuses.
+const (
+	StatusRequiresPaymentMethod Status = "requires_payment_method"
+	StatusRequiresConfirmation  Status = "requires_confirmation"
+	StatusRequiresAction        Status = "requires_action"
+	StatusRequiresCapture       Status = "requires_capture"
+	StatusProcessing            Status = "processing"
+	StatusSucceeded             Status = "succeeded"
+	StatusCanceled              Status = "canceled"
+)
+
+// Payment event types, they are named after Stripe's.
+const (
+	EventPaymentSucceeded = "payment_intent.succeeded"
+	EventPaymentFailed    = "payment_intent.payment_failed"
+	EventPaymentCanceled  = "payment_intent.canceled"
+	EventChargeRefunded   = "charge.refunded"
+)
+
+// Provider is a payment processor.
+//
+// The requests made with an idempotency key that was already used return the result
+// of the first one instead of being processed again, so they can be retried safely.
+type Provider interface {
+	CancelIntent(ctx context.Context, intentID, idempotencyKey string) (Intent, error)
+	CaptureIntent(ctx context.Context, intentID string) (Intent, error)
+	ConfirmIntent(ctx context.Context, intentID string) (Intent, error)
+	CreateIntent(ctx context.Context, params IntentParams) (Intent, error)
+	ListIntents(ctx context.Context) ([]Intent, error)
+	// MinimumAmount returns the lowest amount an intent can be created with, in the currency's smallest unit.
+	MinimumAmount(currency string) int64
+	// Refund returns the amount specified to the customer, zero means all the amount not refunded yet.
+	Refund(ctx context.Context, intentID string, amount int64, idempotencyKey string) (Refund, error)
+}
+
+// Card symbolizes a user card.
+type Card struct {
+	Number   string `json:"number"`
+	ExpMonth string `json:"exp_month"`
+	ExpYear  string `json:"exp_year" validate:"len=4"`
+	CVC      string `json:"cvc" validate:"len=3"`
+}
+
+// IntentParams holds the parameters for creating a payment intent.
+type IntentParams struct {
+	OrderID  string
+	CartID   string
+	Currency string
+	// Amount in the currency's smallest unit
+	Amount int64
+	Card   Card
+}
+
+// Intent represents the process of collecting a payment.
+type Intent struct {
+	ID       string `json:"id"`
+	OrderID  string `json:"order_id"`
+	Currency string `json:"currency"`
+	Amount   int64  `json:"amount"`
+	Status   Status `json:"status"`
+	// ClientSecret is used by the client to complete the actions required, like 3D Secure
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// Refund is the return of a payment to the customer.
+type Refund struct {
+	ID       string `json:"id"`
+	IntentID string `json:"intent_id"`
+	Amount   int64  `json:"amount"`
+	Status   string `json:"status"`
+}
+
+// Event is a notification sent by the payment provider.
+type Event struct {
+	ID       string
+	Type     string
+	OrderID  string
+	IntentID string
+	// Refunded is true only when the payment was refunded completely
+	Refunded bool
+}
+
+// EventHandler processes the payment events.
+//
+// Events may be delivered more than once, handling one again must not repeat its effects.
+type EventHandler func(ctx context.Context, event Event) error
+
+// AmountError is returned when the amount is lower than the minimum the provider can charge.
+type AmountError struct {
+	Amount   int64
+	Minimum  int64
+	Currency string
+}
+
+func (e *AmountError) Error() string {
+	return fmt.Sprintf("the amount %d is lower than the minimum that can be charged: %d %s",
+		e.Amount, e.Minimum, e.Currency)
+}
+
+// DeclineError is returned when the card issuer declines the payment.
+type DeclineError struct {
+	Code    string
+	Message string
+}
+
+func (e *DeclineError) Error() string {
+	return fmt.Sprintf("payment declined (%s): %s", e.Code, e.Message)
+}
@@ -6,14 +6,21 @@ import (
 	"github.com/GGP1/adak/internal/response"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
 )
 
 // Handler manages stripe endpoints.
-type Handler struct{}
+type Handler struct {
+	db            *sqlx.DB
+	webhookSecret string
+}
 
 // NewHandler returns a new stripe handler.
-func NewHandler() Handler {
-	return Handler{}
+func NewHandler(db *sqlx.DB, webhookSecret string) Handler {
+	return Handler{
+		db:            db,
+		webhookSecret: webhookSecret,
+	}
 }
 
 // GetBalance responds with the account balance.
@@ -1,130 +1,179 @@
 package stripe
 
 import (
+	"context"
+	"strings"
+
+	"github.com/GGP1/adak/pkg/shopping/payment"
+
 	"github.com/pkg/errors"
-	"github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/paymentintent"
+	stripe "github.com/stripe/stripe-go/v72"
 )
 
 // CancelIntent cancels the purchase.
-func CancelIntent(intentID string) error {
-	_, err := paymentintent.Cancel(intentID, nil)
+func (p *provider) CancelIntent(ctx context.Context, intentID, idempotencyKey string) (payment.Intent, error) {
+	params := &stripe.PaymentIntentCancelParams{}
+	params.Context = ctx
+	params.SetIdempotencyKey(idempotencyKey)
+
+	pi, err := p.api.PaymentIntents.Cancel(intentID, params)
 	if err != nil {
-		return errors.Wrap(err, "stripe: PaymentIntent")
+		return payment.Intent{}, stripeErr(err, "PaymentIntent")
 	}
 
-	return nil
+	return newIntent(pi), nil
 }
 
 // CaptureIntent captures the funds of an existing uncaptured PaymentIntent
-//  when its status is requires_capture.
-func CaptureIntent(intentID string) error {
-	_, err := paymentintent.Capture(intentID, nil)
+// when its status is requires_capture.
+func (p *provider) CaptureIntent(ctx context.Context, intentID string) (payment.Intent, error) {
+	params := &stripe.PaymentIntentCaptureParams{}
+	params.Context = ctx
+
+	pi, err := p.api.PaymentIntents.Capture(intentID, params)
 	if err != nil {
-		return errors.Wrap(err, "stripe: PaymentIntent")
+		return payment.Intent{}, stripeErr(err, "PaymentIntent")
 	}
 
-	return nil
+	return newIntent(pi), nil
 }
 
-// ConfirmIntent confirms that your customer intends to pay with current
-// or provided payment method.
-func ConfirmIntent(intentID string, source *stripe.Source) error {
-	pi, err := paymentintent.Get(intentID, nil)
-	if err != nil {
-		return errors.Wrap(err, "stripe: PaymentIntent")
-	}
-
-	if pi.Status != "requires_payment_method" {
-		return errors.Errorf("stripe: paymentIntent already has a status of %s", pi.Status)
-	}
+// ConfirmIntent confirms that the customer intends to pay with the payment method provided.
+func (p *provider) ConfirmIntent(ctx context.Context, intentID string) (payment.Intent, error) {
+	params := &stripe.PaymentIntentConfirmParams{}
+	params.Context = ctx
 
-	_, err = paymentintent.Confirm(pi.ID, &stripe.PaymentIntentConfirmParams{})
+	pi, err := p.api.PaymentIntents.Confirm(intentID, params)
 	if err != nil {
-		return errors.Wrap(err, "stripe: PaymentIntent")
+		return payment.Intent{}, stripeErr(err, "PaymentIntent")
 	}
 
-	return nil
+	return newIntent(pi), nil
 }
 
-// CreateIntent creates a payment intent object.
-func CreateIntent(id, cartID, currency string, total int64, card Card) (*stripe.PaymentIntent, error) {
-	pMethodID, err := CreateMethod(card)
+// CreateIntent creates a payment intent object and confirms it.
+func (p *provider) CreateIntent(ctx context.Context, params payment.IntentParams) (payment.Intent, error) {
+	// Amounts to be provided in a currency’s smallest unit
+	// 100 = 1 USD, 1 = 1 JPY
+	// maximum: $999,999.99
+	amount, err := minorUnits(params.Amount, params.Currency)
 	if err != nil {
-		return nil, err
+		return payment.Intent{}, err
+	}
+	if min := p.MinimumAmount(params.Currency); amount < min {
+		return payment.Intent{}, &payment.AmountError{Amount: amount, Minimum: min, Currency: params.Currency}
 	}
 
-	if total < 50 {
-		return nil, errors.New("stripe: the order total should be higher than $0.50")
+	pMethodID, err := p.createMethod(ctx, params.Card)
+	if err != nil {
+		return payment.Intent{}, err
 	}
 
-	// Amounts to be provided in a currency’s smallest unit
-	// 100 = 1 USD
-	// minimum: $0.50 / maximum: $999,999.99
-	params := &stripe.PaymentIntentParams{
+	piParams := &stripe.PaymentIntentParams{
 		PaymentMethod: stripe.String(pMethodID),
-		Amount:        stripe.Int64(total),
-		Currency:      stripe.String(currency),
+		Amount:        stripe.Int64(amount),
+		Currency:      stripe.String(strings.ToLower(params.Currency)),
 		ConfirmationMethod: stripe.String(string(
 			stripe.PaymentIntentConfirmationMethodManual,
 		)),
 		Confirm: stripe.Bool(true),
 		Params: stripe.Params{
+			Context: ctx,
 			Metadata: map[string]string{
-				"order_id": id,
-				"cart_id":  cartID,
+				"order_id": params.OrderID,
+				"cart_id":  params.CartID,
 			},
 		},
 	}
 
-	pi, err := paymentintent.New(params)
+	pi, err := p.api.PaymentIntents.New(piParams)
 	if err != nil {
-		return nil, errors.Wrap(err, "stripe: PaymentIntent")
+		return payment.Intent{}, stripeErr(err, "PaymentIntent")
 	}
 
 	if pi.Status == stripe.PaymentIntentStatusCanceled {
-		return nil, errors.Wrapf(err, "stripe: invalid PaymentIntent status: %s", pi.Status)
+		return payment.Intent{}, errors.Errorf("stripe: invalid PaymentIntent status: %s", pi.Status)
 	}
 
-	return pi, nil
+	return newIntent(pi), nil
 }
 
 // ListIntents returns a list of PaymentIntents.
-func ListIntents() []*stripe.PaymentIntent {
-	var list []*stripe.PaymentIntent
+func (p *provider) ListIntents(ctx context.Context) ([]payment.Intent, error) {
+	var list []payment.Intent
 
 	params := &stripe.PaymentIntentListParams{}
-	params.Filters.AddFilter("limit", "", "3")
-
-	i := paymentintent.List(params)
+	params.Context = ctx
 
+	i := p.api.PaymentIntents.List(params)
 	for i.Next() {
-		list = append(list, i.PaymentIntent())
+		list = append(list, newIntent(i.PaymentIntent()))
+	}
+	if err := i.Err(); err != nil {
+		return nil, stripeErr(err, "PaymentIntent")
 	}
 
-	return list
+	return list, nil
+}
+
+// MinimumAmount returns the lowest amount Stripe charges, the equivalent of $0.50 in most currencies.
+func (p *provider) MinimumAmount(currency string) int64 {
+	return 50
 }
 
-// RetrieveIntent lists the details of a PaymentIntent that has previously been created.
-func RetrieveIntent(intentID string) (*stripe.PaymentIntent, error) {
-	pi, err := paymentintent.Get(intentID, nil)
+// Refund will refund a charge that has previously been created.
+// Funds will be refunded to the credit or debit card that was originally charged.
+func (p *provider) Refund(ctx context.Context, intentID string, amount int64, idempotencyKey string) (payment.Refund, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(intentID),
+	}
+	params.Context = ctx
+	params.SetIdempotencyKey(idempotencyKey)
+	if amount > 0 {
+		params.Amount = stripe.Int64(amount)
+	}
+
+	r, err := p.api.Refunds.New(params)
 	if err != nil {
-		return nil, errors.Wrap(err, "stripe: PaymentIntent")
+		return payment.Refund{}, stripeErr(err, "Refund")
 	}
 
-	return pi, nil
+	return payment.Refund{
+		ID:       r.ID,
+		IntentID: intentID,
+		Amount:   r.Amount,
+		Status:   string(r.Status),
+	}, nil
 }
 
-// UpdateIntent sets new properties on a PaymentIntent object without confirming.
-func UpdateIntent(intentID string, total int64) (*stripe.PaymentIntent, error) {
-	params := &stripe.PaymentIntentParams{
-		Amount: stripe.Int64(total),
+// createMethod creates a new card payment method.
+func (p *provider) createMethod(ctx context.Context, card payment.Card) (string, error) {
+	params := &stripe.PaymentMethodParams{
+		Card: &stripe.PaymentMethodCardParams{
+			Number:   stripe.String(card.Number),
+			ExpMonth: stripe.String(card.ExpMonth),
+			ExpYear:  stripe.String(card.ExpYear),
+			CVC:      stripe.String(card.CVC),
+		},
+		Type: stripe.String("card"),
 	}
+	params.Context = ctx
 
-	pi, err := paymentintent.Update(intentID, params)
+	pm, err := p.api.PaymentMethods.New(params)
 	if err != nil {
-		return nil, errors.Wrap(err, "stripe: PaymentIntent")
+		return "", stripeErr(err, "PaymentMethod")
 	}
 
-	return pi, nil
+	return pm.ID, nil
+}
+
+func newIntent(pi *stripe.PaymentIntent) payment.Intent {
+	return payment.Intent{
+		ID:           pi.ID,
+		OrderID:      pi.Metadata["order_id"],
+		Currency:     pi.Currency,
+		Amount:       pi.Amount,
+		Status:       payment.Status(pi.Status),
+		ClientSecret: pi.ClientSecret,
+	}
 }
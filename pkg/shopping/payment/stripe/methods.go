@@ -20,26 +20,6 @@ func AttachMethod(customerID, methodID string) (*stripe.PaymentMethod, error) {
 	return pm, nil
 }
 
-// CreateMethod creates a new payment method.
-func CreateMethod(card Card) (string, error) {
-	params := &stripe.PaymentMethodParams{
-		Card: &stripe.PaymentMethodCardParams{
-			Number:   stripe.String(card.Number),
-			ExpMonth: stripe.String(card.ExpMonth),
-			ExpYear:  stripe.String(card.ExpYear),
-			CVC:      stripe.String(card.CVC),
-		},
-		Type: stripe.String("card"),
-	}
-
-	pm, err := paymentmethod.New(params)
-	if err != nil {
-		return "", errors.Wrap(err, "stripe: PaymentMethod")
-	}
-
-	return pm.ID, nil
-}
-
 // DetachMethod detaches a PaymentMethod object from a Customer.
 func DetachMethod(methodID string) (*stripe.PaymentMethod, error) {
 	pm, err := paymentmethod.Detach(methodID, nil)
@@ -1,6 +1,8 @@
 package stripe
 
 import (
+	"strings"
+
 	"github.com/pkg/errors"
 	stripe "github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/payout"
@@ -16,11 +18,17 @@ func CancelPayout(payoutID string) (*stripe.Payout, error) {
 	return p, nil
 }
 
-// CreatePayout sends funds to the bank account.
-func CreatePayout(amount int) (*stripe.Payout, error) {
+// CreatePayout sends funds to the bank account, the amount is expressed in the
+// currency's smallest unit.
+func CreatePayout(amount int64, currency string) (*stripe.Payout, error) {
+	amount, err := minorUnits(amount, currency)
+	if err != nil {
+		return nil, err
+	}
+
 	params := &stripe.PayoutParams{
-		Amount:   stripe.Int64(int64(amount)),
-		Currency: stripe.String(string(stripe.CurrencyUSD)),
+		Amount:   stripe.Int64(amount),
+		Currency: stripe.String(strings.ToLower(currency)),
 	}
 
 	p, err := payout.New(params)
@@ -6,22 +6,6 @@ import (
 	"github.com/stripe/stripe-go/v72/refund"
 )
 
-// CreateRefund will refund a charge that has previously been created but not yet
-// refunded.
-// Funds will be refunded to the credit or debit card that was originally charged.
-func CreateRefund(intentID string) (*stripe.Refund, error) {
-	params := &stripe.RefundParams{
-		PaymentIntent: stripe.String(intentID),
-	}
-
-	r, err := refund.New(params)
-	if err != nil {
-		return nil, errors.Wrap(err, "stripe: Refund")
-	}
-
-	return r, nil
-}
-
 // GetRefund retrieves the details of an existing refund.
 func GetRefund(refundID string) (*stripe.Refund, error) {
 	r, err := refund.Get(refundID, nil)
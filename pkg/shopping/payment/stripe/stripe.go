@@ -1,9 +1,50 @@
 package stripe
 
-// Card symbolizes a user card.
-type Card struct {
-	Number   string `json:"number"`
-	ExpMonth string `json:"exp_month"`
-	ExpYear  string `json:"exp_year" validate:"len=4"`
-	CVC      string `json:"cvc" validate:"len=3"`
+import (
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/payment"
+
+	"github.com/pkg/errors"
+	stripe "github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/client"
+)
+
+// provider is the Stripe implementation of payment.Provider.
+type provider struct {
+	api *client.API
+}
+
+// NewProvider returns a payment provider that uses the Stripe account of the key given.
+func NewProvider(secretKey string) payment.Provider {
+	return &provider{api: client.New(secretKey, nil)}
+}
+
+// stripeErr converts the card errors into payment declines.
+func stripeErr(err error, object string) error {
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeCard {
+		code := string(stripeErr.DeclineCode)
+		if code == "" {
+			code = string(stripeErr.Code)
+		}
+		return &payment.DeclineError{Code: code, Message: stripeErr.Msg}
+	}
+
+	return errors.Wrap(err, "stripe: "+object)
+}
+
+// minorUnits validates the currency and returns the amount accepted by Stripe.
+//
+// Three-decimal currencies must be multiples of 10, so the last digit is rounded.
+func minorUnits(amount int64, code string) (int64, error) {
+	exp, err := currency.Exponent(code)
+	if err != nil {
+		return 0, err
+	}
+
+	if exp == 3 {
+		amount = (amount + 5) / 10 * 10
+	}
+
+	return amount, nil
 }
@@ -0,0 +1,111 @@
+package stripe
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/GGP1/adak/internal/response"
+	"github.com/GGP1/adak/pkg/shopping/payment"
+
+	"github.com/pkg/errors"
+	stripe "github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+// maxPayloadSize is the maximum size in bytes of the webhook payloads accepted.
+const maxPayloadSize = 65536
+
+// ParseEvent verifies the payload signature and extracts the order information from it.
+func ParseEvent(payload []byte, signature, secret string) (payment.Event, error) {
+	e, err := webhook.ConstructEvent(payload, signature, secret)
+	if err != nil {
+		return payment.Event{}, errors.Wrap(err, "stripe: webhook event")
+	}
+
+	event := payment.Event{ID: e.ID, Type: e.Type}
+	if e.Data == nil {
+		return event, nil
+	}
+
+	switch {
+	case strings.HasPrefix(e.Type, "payment_intent."):
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(e.Data.Raw, &pi); err != nil {
+			return payment.Event{}, errors.Wrap(err, "stripe: invalid PaymentIntent")
+		}
+		event.OrderID = pi.Metadata["order_id"]
+		event.IntentID = pi.ID
+
+	case strings.HasPrefix(e.Type, "charge."):
+		var charge stripe.Charge
+		if err := json.Unmarshal(e.Data.Raw, &charge); err != nil {
+			return payment.Event{}, errors.Wrap(err, "stripe: invalid Charge")
+		}
+		event.OrderID = charge.Metadata["order_id"]
+		event.Refunded = charge.Refunded
+		if charge.PaymentIntent != nil {
+			event.IntentID = charge.PaymentIntent.ID
+		}
+	}
+
+	return event, nil
+}
+
+// Webhook receives the events sent by Stripe, verifies their signature and passes
+// them to the event handler once.
+//
+// The event is recorded once the handler succeeds, if it fails the record is discarded and
+// Stripe will deliver the event again later. The handler runs its own transactions, so if
+// the record can't be saved after it succeeded the event is processed twice: the design
+// relies on the handlers being idempotent.
+func (h *Handler) Webhook(handle payment.EventHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		event, err := ParseEvent(payload, r.Header.Get("Stripe-Signature"), h.webhookSecret)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		tx, err := h.db.BeginTxx(ctx, nil)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, errors.Wrap(err, "starting transaction"))
+			return
+		}
+		defer tx.Rollback()
+
+		q := "INSERT INTO stripe_events (id, type) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING"
+		res, err := tx.ExecContext(ctx, q, event.ID, event.Type)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, errors.Wrap(err, "couldn't save the event"))
+			return
+		}
+
+		if n, _ := res.RowsAffected(); n == 0 {
+			response.JSONText(w, http.StatusOK, "event already processed")
+			return
+		}
+
+		if err := handle(ctx, event); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			response.Error(w, http.StatusInternalServerError, errors.Wrap(err, "committing transaction"))
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, "event processed")
+	}
+}
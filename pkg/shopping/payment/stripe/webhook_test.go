@@ -0,0 +1,125 @@
+package stripe_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/shopping/payment"
+	"github.com/GGP1/adak/pkg/shopping/payment/stripe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+const secret = "whsec_test"
+
+func TestParseEvent(t *testing.T) {
+	cases := []struct {
+		desc     string
+		fixture  string
+		expected payment.Event
+	}{
+		{
+			desc:    "Payment succeeded",
+			fixture: "payment_intent_succeeded.json",
+			expected: payment.Event{
+				ID:       "evt_1JqR2sFkB6Y1w2x3",
+				Type:     payment.EventPaymentSucceeded,
+				OrderID:  "order_1",
+				IntentID: "pi_1JqR2rFkB6Y1w2x3",
+			},
+		},
+		{
+			desc:    "Charge refunded",
+			fixture: "charge_refunded.json",
+			expected: payment.Event{
+				ID:       "evt_1JqR5aFkB6Y1w2x3",
+				Type:     payment.EventChargeRefunded,
+				OrderID:  "order_1",
+				IntentID: "pi_1JqR2rFkB6Y1w2x3",
+				Refunded: true,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			payload := readFixture(t, tc.fixture)
+
+			event, err := stripe.ParseEvent(payload, sign(payload, secret), secret)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, event)
+		})
+	}
+
+	t.Run("Invalid signature", func(t *testing.T) {
+		payload := readFixture(t, "payment_intent_succeeded.json")
+
+		_, err := stripe.ParseEvent(payload, sign(payload, "whsec_other"), secret)
+		assert.Error(t, err)
+	})
+
+	t.Run("Tampered payload", func(t *testing.T) {
+		payload := readFixture(t, "payment_intent_succeeded.json")
+		signature := sign(payload, secret)
+		payload = bytes.Replace(payload, []byte("order_1"), []byte("order_2"), 1)
+
+		_, err := stripe.ParseEvent(payload, signature, secret)
+		assert.Error(t, err)
+	})
+}
+
+func TestWebhook(t *testing.T) {
+	db := test.StartPostgres(t)
+	h := stripe.NewHandler(db, secret)
+
+	var received []payment.Event
+	handle := func(ctx context.Context, event payment.Event) error {
+		received = append(received, event)
+		return nil
+	}
+
+	payload := readFixture(t, "payment_intent_succeeded.json")
+	// Stripe may deliver the same event more than once
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/stripe/webhook", bytes.NewReader(payload))
+		req.Header.Set("Stripe-Signature", sign(payload, secret))
+		rec := httptest.NewRecorder()
+
+		h.Webhook(handle)(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	assert.Equal(t, 1, len(received))
+	assert.Equal(t, "order_1", received[0].OrderID)
+
+	req := httptest.NewRequest(http.MethodPost, "/stripe/webhook", bytes.NewReader(payload))
+	rec := httptest.NewRecorder()
+	h.Webhook(handle)(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	payload, err := os.ReadFile(filepath.Join("testdata", name))
+	assert.NoError(t, err)
+
+	return payload
+}
+
+// sign returns the Stripe-Signature header value for the payload.
+func sign(payload []byte, secret string) string {
+	now := time.Now()
+	signature := webhook.ComputeSignature(now, payload, secret)
+	return fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(signature))
+}
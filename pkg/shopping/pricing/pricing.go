@@ -0,0 +1,72 @@
+// Package pricing calculates the amounts of the carts and orders.
+//
+// Amounts are expressed in a currency’s smallest unit (100 = 1 USD) and rates
+// in percentages (21 = 21%). Every calculation that yields a fraction of the
+// smallest unit is rounded half away from zero, the rounding is done per line
+// so the totals are always the sum of the lines.
+package pricing
+
+// Line is a product placed in a cart or order.
+type Line struct {
+	// UnitPrice is the price of a single unit before discounts and taxes
+	UnitPrice int64
+	Quantity  int64
+	// DiscountRate is subtracted from the line subtotal
+	DiscountRate int64
+	// TaxRate is applied over the line subtotal once discounted
+	TaxRate int64
+}
+
+// Amounts contains the result of pricing one or more lines.
+type Amounts struct {
+	Subtotal int64 `json:"subtotal"`
+	Discount int64 `json:"discount"`
+	Taxes    int64 `json:"taxes"`
+	Total    int64 `json:"total"`
+}
+
+// Add returns the sum of a and b.
+func (a Amounts) Add(b Amounts) Amounts {
+	return Amounts{
+		Subtotal: a.Subtotal + b.Subtotal,
+		Discount: a.Discount + b.Discount,
+		Taxes:    a.Taxes + b.Taxes,
+		Total:    a.Total + b.Total,
+	}
+}
+
+// Price calculates the amounts of a single line.
+func (l Line) Price() Amounts {
+	subtotal := l.UnitPrice * l.Quantity
+	discount := Percent(subtotal, l.DiscountRate)
+	taxes := Percent(subtotal-discount, l.TaxRate)
+
+	return Amounts{
+		Subtotal: subtotal,
+		Discount: discount,
+		Taxes:    taxes,
+		Total:    subtotal - discount + taxes,
+	}
+}
+
+// Calculate returns the sum of the lines amounts.
+func Calculate(lines []Line) Amounts {
+	var amounts Amounts
+	for _, l := range lines {
+		amounts = amounts.Add(l.Price())
+	}
+	return amounts
+}
+
+// Percent returns the rate percentage of the amount, rounded half away from zero.
+func Percent(amount, rate int64) int64 {
+	return Round(amount*rate, 100)
+}
+
+// Round divides n by d rounding half away from zero. d must be positive.
+func Round(n, d int64) int64 {
+	if n < 0 {
+		return -Round(-n, d)
+	}
+	return (n + d/2) / d
+}
@@ -0,0 +1,119 @@
+package pricing_test
+
+import (
+	"testing"
+
+	"github.com/GGP1/adak/pkg/shopping/pricing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrice(t *testing.T) {
+	cases := []struct {
+		desc     string
+		line     pricing.Line
+		expected pricing.Amounts
+	}{
+		{
+			desc:     "Single unit",
+			line:     pricing.Line{UnitPrice: 1000, Quantity: 1},
+			expected: pricing.Amounts{Subtotal: 1000, Total: 1000},
+		},
+		{
+			desc:     "Multiple units",
+			line:     pricing.Line{UnitPrice: 1000, Quantity: 3, DiscountRate: 10, TaxRate: 21},
+			expected: pricing.Amounts{Subtotal: 3000, Discount: 300, Taxes: 567, Total: 3267},
+		},
+		{
+			desc: "Taxes rounded half up",
+			// 21% of 250 = 52.5
+			line:     pricing.Line{UnitPrice: 250, Quantity: 1, TaxRate: 21},
+			expected: pricing.Amounts{Subtotal: 250, Taxes: 53, Total: 303},
+		},
+		{
+			desc: "Rounded per line, not per unit",
+			// 21% of 33 = 6.93 per unit, 20.79 for the line
+			line:     pricing.Line{UnitPrice: 33, Quantity: 3, TaxRate: 21},
+			expected: pricing.Amounts{Subtotal: 99, Taxes: 21, Total: 120},
+		},
+		{
+			desc: "Discount rounded half up",
+			// 15% of 2550 = 382.5
+			line:     pricing.Line{UnitPrice: 2550, Quantity: 1, DiscountRate: 15},
+			expected: pricing.Amounts{Subtotal: 2550, Discount: 383, Total: 2167},
+		},
+		{
+			desc:     "Full discount",
+			line:     pricing.Line{UnitPrice: 999, Quantity: 2, DiscountRate: 100, TaxRate: 10},
+			expected: pricing.Amounts{Subtotal: 1998, Discount: 1998, Total: 0},
+		},
+		{
+			desc:     "Zero quantity",
+			line:     pricing.Line{UnitPrice: 999, Quantity: 0, DiscountRate: 5, TaxRate: 10},
+			expected: pricing.Amounts{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.line.Price())
+		})
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	cases := []struct {
+		desc     string
+		lines    []pricing.Line
+		expected pricing.Amounts
+	}{
+		{
+			desc:     "Empty cart",
+			expected: pricing.Amounts{},
+		},
+		{
+			desc: "Mixed rates",
+			lines: []pricing.Line{
+				{UnitPrice: 1000, Quantity: 2, TaxRate: 21},
+				{UnitPrice: 450, Quantity: 3, DiscountRate: 20, TaxRate: 10},
+				{UnitPrice: 199, Quantity: 5},
+			},
+			// 2000 + 420 taxes
+			// 1350 - 270 discount + 108 taxes
+			// 995
+			expected: pricing.Amounts{Subtotal: 4345, Discount: 270, Taxes: 528, Total: 4603},
+		},
+		{
+			desc: "Each line rounded on its own",
+			lines: []pricing.Line{
+				{UnitPrice: 250, Quantity: 1, TaxRate: 21},
+				{UnitPrice: 250, Quantity: 1, TaxRate: 21},
+			},
+			// 52.5 is rounded to 53 in both lines
+			expected: pricing.Amounts{Subtotal: 500, Taxes: 106, Total: 606},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, pricing.Calculate(tc.lines))
+		})
+	}
+}
+
+func TestRound(t *testing.T) {
+	cases := []struct {
+		n, d, expected int64
+	}{
+		{n: 149, d: 100, expected: 1},
+		{n: 150, d: 100, expected: 2},
+		{n: 151, d: 100, expected: 2},
+		{n: -150, d: 100, expected: -2},
+		{n: -149, d: 100, expected: -1},
+		{n: 0, d: 100, expected: 0},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, pricing.Round(tc.n, tc.d), "%d/%d", tc.n, tc.d)
+	}
+}
@@ -0,0 +1,106 @@
+package promotion
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/GGP1/adak/internal/response"
+	"github.com/GGP1/adak/internal/validate"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Handler handles coupon endpoints.
+type Handler struct {
+	service Service
+}
+
+// NewHandler returns a new promotion handler.
+func NewHandler(service Service) Handler {
+	return Handler{service: service}
+}
+
+// Create creates a new coupon.
+func (h *Handler) Create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var coupon Coupon
+		if err := json.NewDecoder(r.Body).Decode(&coupon); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, coupon); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := coupon.Validate(); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.Create(ctx, coupon); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		coupon.Code = normalize(coupon.Code)
+		response.JSON(w, http.StatusCreated, coupon)
+	}
+}
+
+// Delete removes a coupon.
+func (h *Handler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := chi.URLParam(r, "code")
+
+		if err := h.service.Delete(r.Context(), code); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, normalize(code))
+	}
+}
+
+// Get lists all the coupons.
+func (h *Handler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		coupons, err := h.service.Get(r.Context())
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, coupons)
+	}
+}
+
+// GetByCode lists the coupon with the code requested.
+func (h *Handler) GetByCode() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		coupon, err := h.service.GetByCode(r.Context(), chi.URLParam(r, "code"))
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, coupon)
+	}
+}
+
+// GetRedemptions lists the orders the coupon was used on.
+func (h *Handler) GetRedemptions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		redemptions, err := h.service.GetRedemptions(r.Context(), chi.URLParam(r, "code"))
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, redemptions)
+	}
+}
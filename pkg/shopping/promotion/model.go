@@ -0,0 +1,167 @@
+// Package promotion applies coupon codes to the carts and keeps track of their redemptions.
+package promotion
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/GGP1/adak/pkg/shopping/pricing"
+
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// Kind of discount a coupon grants.
+type Kind string
+
+// Coupon kinds
+const (
+	// Percentage takes off the Value percentage of the eligible products
+	Percentage Kind = "percentage"
+	// Fixed takes off the Value amount (in the base currency), up to the eligible products total
+	Fixed Kind = "fixed"
+	// FreeShipping waives the shipping costs
+	FreeShipping Kind = "free_shipping"
+	// BuyXGetY gives away GetQuantity units for every BuyQuantity units bought of an eligible product
+	BuyXGetY Kind = "buy_x_get_y"
+)
+
+// Coupon is a code that grants a discount to the customers redeeming it.
+//
+// Amounts to be provided in a currency’s smallest unit.
+type Coupon struct {
+	Code        string   `json:"code" validate:"required,max=32"`
+	Kind        Kind     `json:"kind" validate:"required,oneof=percentage fixed free_shipping buy_x_get_y"`
+	Value       int64    `json:"value,omitempty" validate:"min=0"`
+	BuyQuantity zero.Int `json:"buy_quantity,omitempty" db:"buy_quantity"`
+	GetQuantity zero.Int `json:"get_quantity,omitempty" db:"get_quantity"`
+	// ShopID and Category restrict the products the coupon applies to, empty means all
+	ShopID   zero.String `json:"shop_id,omitempty" db:"shop_id"`
+	Category zero.String `json:"category,omitempty"`
+	StartsAt zero.Time   `json:"starts_at,omitempty" db:"starts_at"`
+	EndsAt   zero.Time   `json:"ends_at,omitempty" db:"ends_at"`
+	// MaxUses and MaxUsesPerUser limit the redemptions, zero means unlimited
+	MaxUses        zero.Int  `json:"max_uses,omitempty" db:"max_uses"`
+	MaxUsesPerUser zero.Int  `json:"max_uses_per_user,omitempty" db:"max_uses_per_user"`
+	CreatedAt      zero.Time `json:"created_at,omitempty" db:"created_at"`
+}
+
+// Item is a cart product the coupon may apply to.
+type Item struct {
+	ProductID string `db:"product_id"`
+	ShopID    string `db:"shop_id"`
+	Category  string
+	Quantity  int64
+	// Price of a single unit in the base currency
+	Price int64
+}
+
+// Discount is the result of applying a coupon to a cart.
+type Discount struct {
+	Code         string `json:"code"`
+	Amount       int64  `json:"amount"`
+	FreeShipping bool   `json:"free_shipping,omitempty"`
+}
+
+// Redemption records the use of a coupon on an order.
+type Redemption struct {
+	OrderID      string    `json:"order_id" db:"order_id"`
+	Code         string    `json:"code"`
+	UserID       string    `json:"user_id" db:"user_id"`
+	Amount       int64     `json:"amount"`
+	FreeShipping bool      `json:"free_shipping" db:"free_shipping"`
+	RedeemedAt   zero.Time `json:"redeemed_at" db:"redeemed_at"`
+}
+
+// InvalidCouponError is returned when a coupon can't be applied.
+type InvalidCouponError struct {
+	Code   string
+	Reason string
+}
+
+func (e *InvalidCouponError) Error() string {
+	return fmt.Sprintf("coupon %q is not valid: %s", e.Code, e.Reason)
+}
+
+// Validate checks the coupon values are consistent with its kind.
+func (c Coupon) Validate() error {
+	switch c.Kind {
+	case Percentage:
+		if c.Value <= 0 || c.Value > 100 {
+			return fmt.Errorf("percentage must be between 1 and 100, got %d", c.Value)
+		}
+	case Fixed:
+		if c.Value <= 0 {
+			return fmt.Errorf("fixed amount must be higher than zero, got %d", c.Value)
+		}
+	case BuyXGetY:
+		if c.BuyQuantity.Int64 <= 0 || c.GetQuantity.Int64 <= 0 {
+			return fmt.Errorf("buy and get quantities must be higher than zero")
+		}
+	}
+
+	if c.StartsAt.Valid && c.EndsAt.Valid && !c.EndsAt.Time.After(c.StartsAt.Time) {
+		return fmt.Errorf("the coupon must end after it starts")
+	}
+
+	return nil
+}
+
+// Active returns whether the coupon can be used at the time given.
+func (c Coupon) Active(now time.Time) bool {
+	if c.StartsAt.Valid && now.Before(c.StartsAt.Time) {
+		return false
+	}
+	if c.EndsAt.Valid && !now.Before(c.EndsAt.Time) {
+		return false
+	}
+	return true
+}
+
+// Apply calculates the discount the coupon grants over the items given.
+//
+// Only the items matching the coupon shop and category are taken into account.
+func (c Coupon) Apply(items []Item) Discount {
+	d := Discount{Code: c.Code}
+
+	var eligible int64
+	for _, item := range items {
+		if !c.covers(item) {
+			continue
+		}
+		eligible += item.Price * item.Quantity
+
+		if c.Kind == BuyXGetY {
+			// Every group of buy+get units contains get free units
+			group := c.BuyQuantity.Int64 + c.GetQuantity.Int64
+			d.Amount += item.Quantity / group * c.GetQuantity.Int64 * item.Price
+		}
+	}
+
+	if eligible == 0 {
+		return d
+	}
+
+	switch c.Kind {
+	case Percentage:
+		d.Amount = pricing.Percent(eligible, c.Value)
+	case Fixed:
+		d.Amount = c.Value
+		if d.Amount > eligible {
+			d.Amount = eligible
+		}
+	case FreeShipping:
+		d.FreeShipping = true
+	}
+
+	return d
+}
+
+func (c Coupon) covers(item Item) bool {
+	if c.ShopID.Valid && c.ShopID.String != item.ShopID {
+		return false
+	}
+	if c.Category.Valid && c.Category.String != item.Category {
+		return false
+	}
+	return true
+}
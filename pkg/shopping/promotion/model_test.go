@@ -0,0 +1,123 @@
+package promotion_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/GGP1/adak/pkg/shopping/promotion"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+func TestApply(t *testing.T) {
+	items := []promotion.Item{
+		{ProductID: "1", ShopID: "shop_1", Category: "books", Quantity: 3, Price: 1000},
+		{ProductID: "2", ShopID: "shop_1", Category: "music", Quantity: 1, Price: 2550},
+		{ProductID: "3", ShopID: "shop_2", Category: "books", Quantity: 5, Price: 400},
+	}
+
+	cases := []struct {
+		desc     string
+		coupon   promotion.Coupon
+		expected promotion.Discount
+	}{
+		{
+			desc:     "Percentage",
+			coupon:   promotion.Coupon{Kind: promotion.Percentage, Value: 10},
+			expected: promotion.Discount{Amount: 755},
+		},
+		{
+			desc:     "Percentage rounded half up",
+			coupon:   promotion.Coupon{Kind: promotion.Percentage, Value: 15, Category: zero.StringFrom("music")},
+			expected: promotion.Discount{Amount: 383},
+		},
+		{
+			desc:     "Fixed",
+			coupon:   promotion.Coupon{Kind: promotion.Fixed, Value: 500},
+			expected: promotion.Discount{Amount: 500},
+		},
+		{
+			desc:     "Fixed capped to the eligible total",
+			coupon:   promotion.Coupon{Kind: promotion.Fixed, Value: 5000, ShopID: zero.StringFrom("shop_2")},
+			expected: promotion.Discount{Amount: 2000},
+		},
+		{
+			desc:     "Free shipping",
+			coupon:   promotion.Coupon{Kind: promotion.FreeShipping},
+			expected: promotion.Discount{FreeShipping: true},
+		},
+		{
+			desc: "Buy 2 get 1",
+			coupon: promotion.Coupon{
+				Kind:        promotion.BuyXGetY,
+				BuyQuantity: zero.IntFrom(2),
+				GetQuantity: zero.IntFrom(1),
+				Category:    zero.StringFrom("books"),
+			},
+			// One free unit of the first product and one of the third
+			expected: promotion.Discount{Amount: 1400},
+		},
+		{
+			desc:     "Shop and category scope",
+			coupon:   promotion.Coupon{Kind: promotion.Percentage, Value: 50, ShopID: zero.StringFrom("shop_1"), Category: zero.StringFrom("books")},
+			expected: promotion.Discount{Amount: 1500},
+		},
+		{
+			desc:     "No eligible items",
+			coupon:   promotion.Coupon{Kind: promotion.FreeShipping, ShopID: zero.StringFrom("shop_3")},
+			expected: promotion.Discount{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.coupon.Apply(items))
+		})
+	}
+}
+
+func TestActive(t *testing.T) {
+	now := time.Now()
+	coupon := promotion.Coupon{
+		StartsAt: zero.TimeFrom(now.Add(-time.Hour)),
+		EndsAt:   zero.TimeFrom(now.Add(time.Hour)),
+	}
+
+	assert.True(t, coupon.Active(now))
+	assert.False(t, coupon.Active(now.Add(-2*time.Hour)))
+	assert.False(t, coupon.Active(now.Add(time.Hour)))
+	assert.True(t, promotion.Coupon{}.Active(now))
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		desc   string
+		coupon promotion.Coupon
+		valid  bool
+	}{
+		{desc: "Percentage", coupon: promotion.Coupon{Kind: promotion.Percentage, Value: 20}, valid: true},
+		{desc: "Percentage over 100", coupon: promotion.Coupon{Kind: promotion.Percentage, Value: 120}},
+		{desc: "Fixed without value", coupon: promotion.Coupon{Kind: promotion.Fixed}},
+		{desc: "Buy X get Y without quantities", coupon: promotion.Coupon{Kind: promotion.BuyXGetY}},
+		{
+			desc: "Ends before it starts",
+			coupon: promotion.Coupon{
+				Kind:     promotion.FreeShipping,
+				StartsAt: zero.TimeFrom(time.Now()),
+				EndsAt:   zero.TimeFrom(time.Now().Add(-time.Hour)),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.coupon.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
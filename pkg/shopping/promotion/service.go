@@ -0,0 +1,309 @@
+package promotion
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/pricing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// Service contains promotion functionalities.
+//
+// Redeem and Release receive a transaction as they must be called inside the one
+// creating or updating the order.
+type Service interface {
+	ApplyToCart(ctx context.Context, cartID, userID, code string) (Discount, error)
+	CartDiscount(ctx context.Context, cartID, userID string) (Discount, error)
+	Create(ctx context.Context, coupon Coupon) error
+	Delete(ctx context.Context, code string) error
+	Get(ctx context.Context) ([]Coupon, error)
+	GetByCode(ctx context.Context, code string) (Coupon, error)
+	GetRedemptions(ctx context.Context, code string) ([]Redemption, error)
+	Redeem(ctx context.Context, tx *sqlx.Tx, cartID, userID, orderID string) (Discount, error)
+	Release(ctx context.Context, tx *sqlx.Tx, orderID, cartID string) error
+	RemoveFromCart(ctx context.Context, cartID string) error
+}
+
+type service struct {
+	db         *sqlx.DB
+	currencies currency.Service
+	metrics    metrics
+}
+
+// NewService returns a new promotion service.
+//
+// The discounts are expressed in the base currency of the currency service.
+func NewService(db *sqlx.DB, currencies currency.Service) Service {
+	return &service{db, currencies, initMetrics()}
+}
+
+// ApplyToCart validates the coupon against the cart and attaches it, replacing the previous one.
+func (s *service) ApplyToCart(ctx context.Context, cartID, userID, code string) (Discount, error) {
+	s.metrics.incMethodCalls("ApplyToCart")
+
+	coupon, err := s.GetByCode(ctx, code)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return Discount{}, &InvalidCouponError{Code: normalize(code), Reason: "it does not exist"}
+		}
+		return Discount{}, err
+	}
+
+	discount, err := s.discount(ctx, s.db, coupon, cartID, userID)
+	if err != nil {
+		return Discount{}, err
+	}
+
+	q := `INSERT INTO cart_coupons (cart_id, code) VALUES ($1, $2)
+	ON CONFLICT (cart_id) DO UPDATE SET code=EXCLUDED.code`
+	if _, err := s.db.ExecContext(ctx, q, cartID, coupon.Code); err != nil {
+		return Discount{}, errors.Wrap(err, "couldn't apply the coupon")
+	}
+
+	return discount, nil
+}
+
+// CartDiscount returns the discount granted by the coupon applied to the cart, if any.
+func (s *service) CartDiscount(ctx context.Context, cartID, userID string) (Discount, error) {
+	s.metrics.incMethodCalls("CartDiscount")
+
+	var coupon Coupon
+	q := `SELECT c.* FROM coupons AS c
+	JOIN cart_coupons AS cc ON cc.code=c.code
+	WHERE cc.cart_id=$1`
+	if err := s.db.GetContext(ctx, &coupon, q, cartID); err != nil {
+		if err == sql.ErrNoRows {
+			return Discount{}, nil
+		}
+		return Discount{}, errors.Wrap(err, "couldn't find the cart coupon")
+	}
+
+	return s.discount(ctx, s.db, coupon, cartID, userID)
+}
+
+// Create creates a coupon.
+func (s *service) Create(ctx context.Context, coupon Coupon) error {
+	s.metrics.incMethodCalls("Create")
+
+	if err := coupon.Validate(); err != nil {
+		return err
+	}
+
+	q := `INSERT INTO coupons
+	(code, kind, value, buy_quantity, get_quantity, shop_id, category,
+	starts_at, ends_at, max_uses, max_uses_per_user)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	_, err := s.db.ExecContext(ctx, q, normalize(coupon.Code), coupon.Kind, coupon.Value,
+		coupon.BuyQuantity, coupon.GetQuantity, coupon.ShopID, coupon.Category,
+		coupon.StartsAt, coupon.EndsAt, coupon.MaxUses, coupon.MaxUsesPerUser)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create the coupon")
+	}
+
+	return nil
+}
+
+// Delete permanently deletes a coupon from the database.
+func (s *service) Delete(ctx context.Context, code string) error {
+	s.metrics.incMethodCalls("Delete")
+
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM coupons WHERE code=$1", normalize(code)); err != nil {
+		return errors.Wrap(err, "couldn't delete the coupon")
+	}
+
+	return nil
+}
+
+// Get returns a list with all the coupons stored in the database.
+func (s *service) Get(ctx context.Context) ([]Coupon, error) {
+	s.metrics.incMethodCalls("Get")
+
+	var coupons []Coupon
+	if err := s.db.SelectContext(ctx, &coupons, "SELECT * FROM coupons ORDER BY created_at DESC"); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the coupons")
+	}
+
+	return coupons, nil
+}
+
+// GetByCode retrieves the coupon requested from the database.
+func (s *service) GetByCode(ctx context.Context, code string) (Coupon, error) {
+	s.metrics.incMethodCalls("GetByCode")
+
+	var coupon Coupon
+	if err := s.db.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE code=$1", normalize(code)); err != nil {
+		return Coupon{}, errors.Wrap(err, "couldn't find the coupon")
+	}
+
+	return coupon, nil
+}
+
+// GetRedemptions returns the orders the coupon was redeemed on.
+func (s *service) GetRedemptions(ctx context.Context, code string) ([]Redemption, error) {
+	s.metrics.incMethodCalls("GetRedemptions")
+
+	var redemptions []Redemption
+	q := "SELECT * FROM coupon_redemptions WHERE code=$1 ORDER BY redeemed_at"
+	if err := s.db.SelectContext(ctx, &redemptions, q, normalize(code)); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the redemptions")
+	}
+
+	return redemptions, nil
+}
+
+// Redeem applies the cart coupon to the order and records its use.
+//
+// The coupon row is locked so its usage limits can't be exceeded by concurrent orders.
+// It returns a zero discount if the cart has no coupon.
+func (s *service) Redeem(ctx context.Context, tx *sqlx.Tx, cartID, userID, orderID string) (Discount, error) {
+	s.metrics.incMethodCalls("Redeem")
+
+	var code string
+	if err := tx.GetContext(ctx, &code, "SELECT code FROM cart_coupons WHERE cart_id=$1", cartID); err != nil {
+		if err == sql.ErrNoRows {
+			return Discount{}, nil
+		}
+		return Discount{}, errors.Wrap(err, "couldn't find the cart coupon")
+	}
+
+	var coupon Coupon
+	if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE code=$1 FOR UPDATE", code); err != nil {
+		return Discount{}, errors.Wrap(err, "couldn't find the coupon")
+	}
+
+	discount, err := s.discount(ctx, tx, coupon, cartID, userID)
+	if err != nil {
+		return Discount{}, err
+	}
+
+	q := `INSERT INTO coupon_redemptions
+	(order_id, code, user_id, amount, free_shipping, redeemed_at)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.ExecContext(ctx, q, orderID, coupon.Code, userID,
+		discount.Amount, discount.FreeShipping, zero.TimeFrom(time.Now()))
+	if err != nil {
+		return Discount{}, errors.Wrap(err, "couldn't save the redemption")
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM cart_coupons WHERE cart_id=$1", cartID); err != nil {
+		return Discount{}, errors.Wrap(err, "couldn't remove the cart coupon")
+	}
+
+	return discount, nil
+}
+
+// Release undoes the redemption made by the order and attaches the coupon to the cart again,
+// so it can be used when the purchase is retried.
+func (s *service) Release(ctx context.Context, tx *sqlx.Tx, orderID, cartID string) error {
+	s.metrics.incMethodCalls("Release")
+
+	var code string
+	q := "DELETE FROM coupon_redemptions WHERE order_id=$1 RETURNING code"
+	if err := tx.GetContext(ctx, &code, q, orderID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return errors.Wrap(err, "couldn't delete the redemption")
+	}
+
+	// The user may have applied another coupon to the cart meanwhile
+	q = "INSERT INTO cart_coupons (cart_id, code) VALUES ($1, $2) ON CONFLICT (cart_id) DO NOTHING"
+	if _, err := tx.ExecContext(ctx, q, cartID, code); err != nil {
+		return errors.Wrap(err, "couldn't attach the coupon to the cart")
+	}
+
+	return nil
+}
+
+// RemoveFromCart detaches the coupon from the cart.
+func (s *service) RemoveFromCart(ctx context.Context, cartID string) error {
+	s.metrics.incMethodCalls("RemoveFromCart")
+
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM cart_coupons WHERE cart_id=$1", cartID); err != nil {
+		return errors.Wrap(err, "couldn't remove the cart coupon")
+	}
+
+	return nil
+}
+
+// discount checks that the coupon can be used by the user and applies it to the cart products.
+func (s *service) discount(ctx context.Context, q sqlx.QueryerContext, coupon Coupon, cartID, userID string) (Discount, error) {
+	if !coupon.Active(time.Now()) {
+		return Discount{}, &InvalidCouponError{Code: coupon.Code, Reason: "it is not active"}
+	}
+
+	if coupon.MaxUses.Int64 > 0 || coupon.MaxUsesPerUser.Int64 > 0 {
+		var uses struct {
+			Total  int64
+			ByUser int64 `db:"by_user"`
+		}
+		usesQ := `SELECT COUNT(*) AS total, COUNT(*) FILTER (WHERE user_id=$2) AS by_user
+		FROM coupon_redemptions WHERE code=$1`
+		if err := sqlx.GetContext(ctx, q, &uses, usesQ, coupon.Code, userID); err != nil {
+			return Discount{}, errors.Wrap(err, "couldn't count the coupon redemptions")
+		}
+
+		if coupon.MaxUses.Int64 > 0 && uses.Total >= coupon.MaxUses.Int64 {
+			return Discount{}, &InvalidCouponError{Code: coupon.Code, Reason: "it reached its usage limit"}
+		}
+		if coupon.MaxUsesPerUser.Int64 > 0 && uses.ByUser >= coupon.MaxUsesPerUser.Int64 {
+			return Discount{}, &InvalidCouponError{Code: coupon.Code, Reason: "it was already used the maximum number of times"}
+		}
+	}
+
+	var products []struct {
+		Item
+		Currency string
+		// VariantSubtotal replaces the product one when it's set
+		VariantSubtotal zero.Int `db:"variant_subtotal"`
+		Discount        zero.Int
+		Taxes           zero.Int
+	}
+	itemsQ := `SELECT cp.id AS product_id, cp.quantity, p.shop_id, p.category, p.total AS price, p.currency,
+	v.subtotal AS variant_subtotal, p.discount, p.taxes
+	FROM cart_products AS cp
+	JOIN products AS p ON p.id=cp.id
+	LEFT JOIN product_variants AS v ON v.id=cp.variant_id AND v.product_id=cp.id
+	WHERE cp.cart_id=$1`
+	if err := sqlx.SelectContext(ctx, q, &products, itemsQ, cartID); err != nil {
+		return Discount{}, errors.Wrap(err, "couldn't find the cart products")
+	}
+
+	items := make([]Item, 0, len(products))
+	for _, p := range products {
+		if p.VariantSubtotal.Valid {
+			unit := pricing.Line{
+				UnitPrice:    p.VariantSubtotal.Int64,
+				Quantity:     1,
+				DiscountRate: p.Discount.Int64,
+				TaxRate:      p.Taxes.Int64,
+			}
+			p.Price = unit.Price().Total
+		}
+
+		price, err := s.currencies.Convert(ctx, p.Price, p.Currency, s.currencies.Base())
+		if err != nil {
+			return Discount{}, err
+		}
+		p.Item.Price = price
+		items = append(items, p.Item)
+	}
+
+	discount := coupon.Apply(items)
+	if discount.Amount == 0 && !discount.FreeShipping {
+		return Discount{}, &InvalidCouponError{Code: coupon.Code, Reason: "no products in the cart are eligible"}
+	}
+
+	return discount, nil
+}
+
+func normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,147 @@
+package promotion_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/promotion"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+const (
+	cartID    = "1"
+	userID    = "2"
+	productID = "3"
+	shopID    = "4"
+)
+
+func NewPromotionService(t *testing.T) (context.Context, *sqlx.DB, promotion.Service) {
+	t.Helper()
+	logger.Disable()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	db := test.StartPostgres(t)
+	service := promotion.NewService(db, currency.NewService(db, "USD"))
+	createRelationships(ctx, t, db)
+
+	t.Cleanup(func() {
+		cancel()
+	})
+
+	return ctx, db, service
+}
+
+func TestPromotionService(t *testing.T) {
+	ctx, db, s := NewPromotionService(t)
+
+	t.Run("Apply to cart", applyToCart(ctx, s))
+	t.Run("Redeem", redeem(ctx, db, s))
+	t.Run("Usage limit", usageLimit(ctx, db, s))
+}
+
+func applyToCart(ctx context.Context, s promotion.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		coupon := promotion.Coupon{Code: "save10", Kind: promotion.Percentage, Value: 10}
+		assert.NoError(t, s.Create(ctx, coupon))
+
+		discount, err := s.ApplyToCart(ctx, cartID, userID, "SAVE10")
+		assert.NoError(t, err)
+		assert.Equal(t, promotion.Discount{Code: "SAVE10", Amount: 300}, discount)
+
+		discount, err = s.CartDiscount(ctx, cartID, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(300), discount.Amount)
+
+		_, err = s.ApplyToCart(ctx, cartID, userID, "unknown")
+		var invalid *promotion.InvalidCouponError
+		assert.True(t, errors.As(err, &invalid))
+
+		assert.NoError(t, s.RemoveFromCart(ctx, cartID))
+		discount, err = s.CartDiscount(ctx, cartID, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, promotion.Discount{}, discount)
+	}
+}
+
+func redeem(ctx context.Context, db *sqlx.DB, s promotion.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		_, err := s.ApplyToCart(ctx, cartID, userID, "save10")
+		assert.NoError(t, err)
+
+		createOrder(ctx, t, db, "order_1")
+		tx := db.MustBeginTx(ctx, nil)
+		discount, err := s.Redeem(ctx, tx, cartID, userID, "order_1")
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Commit())
+		assert.Equal(t, int64(300), discount.Amount)
+
+		redemptions, err := s.GetRedemptions(ctx, "save10")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(redemptions))
+		assert.Equal(t, "order_1", redemptions[0].OrderID)
+
+		// The coupon is removed from the cart once redeemed
+		discount, err = s.CartDiscount(ctx, cartID, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, promotion.Discount{}, discount)
+	}
+}
+
+func usageLimit(ctx context.Context, db *sqlx.DB, s promotion.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		coupon := promotion.Coupon{
+			Code:           "ONCE",
+			Kind:           promotion.Fixed,
+			Value:          500,
+			ShopID:         zero.StringFrom(shopID),
+			MaxUsesPerUser: zero.IntFrom(1),
+		}
+		assert.NoError(t, s.Create(ctx, coupon))
+
+		_, err := s.ApplyToCart(ctx, cartID, userID, coupon.Code)
+		assert.NoError(t, err)
+
+		createOrder(ctx, t, db, "order_2")
+		tx := db.MustBeginTx(ctx, nil)
+		_, err = s.Redeem(ctx, tx, cartID, userID, "order_2")
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Commit())
+
+		_, err = s.ApplyToCart(ctx, cartID, userID, coupon.Code)
+		var invalid *promotion.InvalidCouponError
+		assert.True(t, errors.As(err, &invalid))
+	}
+}
+
+func createRelationships(ctx context.Context, t *testing.T, db *sqlx.DB) {
+	t.Helper()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO shops (id, name) VALUES ($1, 'shop')", shopID)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO products
+	(id, shop_id, stock, brand, category, type, weight, subtotal, total)
+	VALUES ($1, $2, 10, 'brand', 'category', 'type', 1, 1000, 1000)`, productID, shopID)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO carts (id) VALUES ($1)", cartID)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO cart_products (id, cart_id, quantity) VALUES ($1, $2, 3)",
+		productID, cartID)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO users (id, cart_id, username, email, password)
+	VALUES ($1, $2, 'user', 'user@adak.com', 'password')`, userID, cartID)
+	assert.NoError(t, err)
+}
+
+func createOrder(ctx context.Context, t *testing.T, db *sqlx.DB, id string) {
+	t.Helper()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO orders (id, user_id) VALUES ($1, $2)", id, userID)
+	assert.NoError(t, err)
+}
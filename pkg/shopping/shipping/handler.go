@@ -0,0 +1,141 @@
+package shipping
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/response"
+	"github.com/GGP1/adak/internal/validate"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Handler handles shipping endpoints.
+type Handler struct {
+	service Service
+}
+
+// NewHandler returns a new shipping handler.
+func NewHandler(service Service) Handler {
+	return Handler{service: service}
+}
+
+// CreateRate adds a rate to the zone with the id provided.
+func (h *Handler) CreateRate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		zoneID, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var rate Rate
+		if err := json.NewDecoder(r.Body).Decode(&rate); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, rate); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := rate.Validate(); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		rate.ID = uuid.NewString()
+		rate.ZoneID = zoneID
+		if err := h.service.CreateRate(ctx, rate); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, rate)
+	}
+}
+
+// CreateZone creates a new shipping zone.
+func (h *Handler) CreateZone() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var zone Zone
+		if err := json.NewDecoder(r.Body).Decode(&zone); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, zone); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		zone.ID = uuid.NewString()
+		zone.Rates = nil
+		if err := h.service.CreateZone(ctx, zone); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, zone)
+	}
+}
+
+// DeleteRate removes a rate.
+func (h *Handler) DeleteRate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "rate_id")
+		if err := validate.UUID(id); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.DeleteRate(r.Context(), id); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, id)
+	}
+}
+
+// DeleteZone removes a zone and its rates.
+func (h *Handler) DeleteZone() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := params.URLID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.DeleteZone(ctx, id); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, id)
+	}
+}
+
+// GetZones lists the zones with their rates.
+func (h *Handler) GetZones() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zones, err := h.service.GetZones(r.Context())
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, zones)
+	}
+}
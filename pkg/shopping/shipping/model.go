@@ -0,0 +1,193 @@
+// Package shipping calculates the cost of delivering the carts from the zones and rates
+// configured by the administrators.
+package shipping
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GGP1/adak/internal/jsonb"
+
+	"github.com/pkg/errors"
+)
+
+// Destination is the place where the products are delivered.
+type Destination struct {
+	Country string `json:"country" validate:"required"`
+	State   string `json:"state,omitempty"`
+	ZipCode string `json:"zip_code,omitempty"`
+}
+
+// Zone is a group of destinations sharing the same shipping rates.
+type Zone struct {
+	ID        string    `json:"id,omitempty"`
+	Name      string    `json:"name" validate:"required"`
+	Rules     Rules     `json:"rules" validate:"required,min=1,dive"`
+	Rates     []Rate    `json:"rates,omitempty" db:"-"`
+	CreatedAt time.Time `json:"created_at,omitempty" db:"created_at"`
+}
+
+// Rule matches the destinations in a country, it can be narrowed down to a state and
+// to the zip codes starting with a prefix.
+type Rule struct {
+	Country   string `json:"country" validate:"required"`
+	State     string `json:"state,omitempty"`
+	ZipPrefix string `json:"zip_prefix,omitempty"`
+}
+
+// Rules are the destinations of a zone, they are stored as a JSON array.
+type Rules []Rule
+
+// Scan implements the sql.Scanner interface.
+func (r *Rules) Scan(src interface{}) error {
+	return jsonb.Scan(src, r)
+}
+
+// Value implements the driver.Valuer interface.
+func (r Rules) Value() (driver.Value, error) {
+	if r == nil {
+		return "[]", nil
+	}
+	return jsonb.Value(r)
+}
+
+// Rate is a shipping method available in a zone, priced by the weight of the cart.
+//
+// Amounts to be provided in the base currency smallest unit.
+type Rate struct {
+	ID     string `json:"id,omitempty"`
+	ZoneID string `json:"zone_id,omitempty" db:"zone_id"`
+	Name   string `json:"name" validate:"required"`
+	Tiers  Tiers  `json:"tiers" validate:"required,min=1,dive"`
+	// FreeAbove waives the price of the carts whose total reaches it, zero disables it
+	FreeAbove int64     `json:"free_above,omitempty" db:"free_above" validate:"min=0"`
+	CreatedAt time.Time `json:"created_at,omitempty" db:"created_at"`
+}
+
+// Tier is the price of the carts weighing up to MaxWeight grams.
+type Tier struct {
+	MaxWeight int64 `json:"max_weight" validate:"required,min=1"`
+	Price     int64 `json:"price" validate:"min=0"`
+}
+
+// Tiers are the weight ranges of a rate, they are stored as a JSON array sorted by weight.
+type Tiers []Tier
+
+// Scan implements the sql.Scanner interface.
+func (t *Tiers) Scan(src interface{}) error {
+	return jsonb.Scan(src, t)
+}
+
+// Value implements the driver.Valuer interface.
+func (t Tiers) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+	return jsonb.Value(t)
+}
+
+// Option is a shipping rate available for a cart, with its price already calculated.
+type Option struct {
+	RateID   string `json:"rate_id"`
+	Name     string `json:"name"`
+	Zone     string `json:"zone"`
+	Price    int64  `json:"price"`
+	Currency string `json:"currency"`
+}
+
+// UnavailableError is returned when the rate chosen can't be used to ship the cart
+// to the destination, the rate id is empty if none was chosen.
+type UnavailableError struct {
+	RateID string
+}
+
+func (e *UnavailableError) Error() string {
+	if e.RateID == "" {
+		return "a shipping option must be chosen for the destination"
+	}
+	return fmt.Sprintf("shipping rate %q is not available for the destination and the cart weight", e.RateID)
+}
+
+// Match returns the zone containing the destination, when many do the one with the most
+// specific rule is returned.
+func Match(zones []Zone, dest Destination) (Zone, bool) {
+	var (
+		best  Zone
+		score int
+	)
+	for _, zone := range zones {
+		for _, rule := range zone.Rules {
+			if s := rule.match(dest); s > score {
+				best, score = zone, s
+			}
+		}
+	}
+
+	return best, score > 0
+}
+
+// match returns how specific the rule is for the destination, zero means it doesn't match.
+func (r Rule) match(dest Destination) int {
+	if !strings.EqualFold(strings.TrimSpace(r.Country), strings.TrimSpace(dest.Country)) {
+		return 0
+	}
+	score := 1
+
+	if r.State != "" {
+		if !strings.EqualFold(strings.TrimSpace(r.State), strings.TrimSpace(dest.State)) {
+			return 0
+		}
+		score++
+	}
+
+	if r.ZipPrefix != "" {
+		prefix := normalizeZip(r.ZipPrefix)
+		if !strings.HasPrefix(normalizeZip(dest.ZipCode), prefix) {
+			return 0
+		}
+		// Longer prefixes are more specific
+		score += 1 + len(prefix)
+	}
+
+	return score
+}
+
+// Validate checks that the rate weight ranges don't overlap.
+func (r Rate) Validate() error {
+	weights := make(map[int64]bool, len(r.Tiers))
+	for _, tier := range r.Tiers {
+		if weights[tier.MaxWeight] {
+			return errors.Errorf("there are two tiers with a max weight of %d grams", tier.MaxWeight)
+		}
+		weights[tier.MaxWeight] = true
+	}
+
+	return nil
+}
+
+// Price returns the cost of shipping a cart with the weight (in grams) and the total given,
+// false is returned if the cart is heavier than the rate tiers.
+func (r Rate) Price(weight, total int64) (int64, bool) {
+	tiers := make(Tiers, len(r.Tiers))
+	copy(tiers, r.Tiers)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MaxWeight < tiers[j].MaxWeight })
+
+	for _, tier := range tiers {
+		if weight > tier.MaxWeight {
+			continue
+		}
+		if r.FreeAbove > 0 && total >= r.FreeAbove {
+			return 0, true
+		}
+		return tier.Price, true
+	}
+
+	return 0, false
+}
+
+func normalizeZip(zip string) string {
+	return strings.ToUpper(strings.ReplaceAll(zip, " ", ""))
+}
@@ -0,0 +1,77 @@
+package shipping_test
+
+import (
+	"testing"
+
+	"github.com/GGP1/adak/pkg/shopping/shipping"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	zones := []shipping.Zone{
+		{ID: "domestic", Rules: shipping.Rules{{Country: "Argentina"}}},
+		{ID: "capital", Rules: shipping.Rules{{Country: "Argentina", State: "Buenos Aires"}}},
+		{ID: "downtown", Rules: shipping.Rules{{Country: "Argentina", ZipPrefix: "C10"}}},
+		{ID: "oceania", Rules: shipping.Rules{{Country: "New Zealand"}, {Country: "Australia"}}},
+	}
+
+	cases := []struct {
+		desc     string
+		dest     shipping.Destination
+		expected string
+	}{
+		{desc: "Country", dest: shipping.Destination{Country: "argentina", State: "Cordoba", ZipCode: "X5000"}, expected: "domestic"},
+		{desc: "State", dest: shipping.Destination{Country: "Argentina", State: "buenos aires", ZipCode: "B1900"}, expected: "capital"},
+		{desc: "Zip prefix", dest: shipping.Destination{Country: "Argentina", State: "Buenos Aires", ZipCode: "c1043"}, expected: "downtown"},
+		{desc: "Many rules", dest: shipping.Destination{Country: "Australia"}, expected: "oceania"},
+		{desc: "No match", dest: shipping.Destination{Country: "Chile"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			zone, ok := shipping.Match(zones, tc.dest)
+			assert.Equal(t, tc.expected != "", ok)
+			assert.Equal(t, tc.expected, zone.ID)
+		})
+	}
+}
+
+func TestRatePrice(t *testing.T) {
+	rate := shipping.Rate{
+		Tiers: shipping.Tiers{
+			{MaxWeight: 5000, Price: 1500},
+			{MaxWeight: 1000, Price: 800},
+		},
+		FreeAbove: 10000,
+	}
+
+	cases := []struct {
+		desc      string
+		weight    int64
+		total     int64
+		expected  int64
+		available bool
+	}{
+		{desc: "Lightest tier", weight: 1000, total: 2000, expected: 800, available: true},
+		{desc: "Heaviest tier", weight: 1001, total: 2000, expected: 1500, available: true},
+		{desc: "Free", weight: 3000, total: 10000, expected: 0, available: true},
+		{desc: "Too heavy", weight: 5001, total: 10000, available: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			price, ok := rate.Price(tc.weight, tc.total)
+			assert.Equal(t, tc.available, ok)
+			assert.Equal(t, tc.expected, price)
+		})
+	}
+}
+
+func TestValidateRate(t *testing.T) {
+	rate := shipping.Rate{Tiers: shipping.Tiers{{MaxWeight: 1000, Price: 800}, {MaxWeight: 2000, Price: 900}}}
+	assert.NoError(t, rate.Validate())
+
+	rate.Tiers = append(rate.Tiers, shipping.Tier{MaxWeight: 1000, Price: 700})
+	assert.Error(t, rate.Validate())
+}
@@ -0,0 +1,205 @@
+package shipping
+
+import (
+	"context"
+	"time"
+
+	"github.com/GGP1/adak/pkg/shopping/currency"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Service contains shipping functionalities.
+type Service interface {
+	CreateRate(ctx context.Context, rate Rate) error
+	CreateZone(ctx context.Context, zone Zone) error
+	DeleteRate(ctx context.Context, id string) error
+	DeleteZone(ctx context.Context, id string) error
+	GetZones(ctx context.Context) ([]Zone, error)
+	Options(ctx context.Context, dest Destination, weight, total int64, currency string) ([]Option, error)
+	Quote(ctx context.Context, dest Destination, weight, total int64, currency, rateID string) (Option, error)
+}
+
+type service struct {
+	db         *sqlx.DB
+	currencies currency.Service
+	metrics    metrics
+}
+
+// NewService returns a new shipping service.
+//
+// The rates are expressed in the base currency of the currency service.
+func NewService(db *sqlx.DB, currencies currency.Service) Service {
+	return &service{db, currencies, initMetrics()}
+}
+
+// CreateRate adds a rate to a zone.
+func (s *service) CreateRate(ctx context.Context, rate Rate) error {
+	s.metrics.incMethodCalls("CreateRate")
+
+	if err := rate.Validate(); err != nil {
+		return err
+	}
+
+	q := `INSERT INTO shipping_rates
+	(id, zone_id, name, tiers, free_above, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := s.db.ExecContext(ctx, q, rate.ID, rate.ZoneID, rate.Name, rate.Tiers, rate.FreeAbove, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "couldn't create the rate")
+	}
+
+	return nil
+}
+
+// CreateZone creates a shipping zone.
+func (s *service) CreateZone(ctx context.Context, zone Zone) error {
+	s.metrics.incMethodCalls("CreateZone")
+
+	q := "INSERT INTO shipping_zones (id, name, rules, created_at) VALUES ($1, $2, $3, $4)"
+	if _, err := s.db.ExecContext(ctx, q, zone.ID, zone.Name, zone.Rules, time.Now()); err != nil {
+		return errors.Wrap(err, "couldn't create the zone")
+	}
+
+	return nil
+}
+
+// DeleteRate removes a rate.
+func (s *service) DeleteRate(ctx context.Context, id string) error {
+	s.metrics.incMethodCalls("DeleteRate")
+
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM shipping_rates WHERE id=$1", id); err != nil {
+		return errors.Wrap(err, "couldn't delete the rate")
+	}
+
+	return nil
+}
+
+// DeleteZone removes a zone and its rates.
+func (s *service) DeleteZone(ctx context.Context, id string) error {
+	s.metrics.incMethodCalls("DeleteZone")
+
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM shipping_zones WHERE id=$1", id); err != nil {
+		return errors.Wrap(err, "couldn't delete the zone")
+	}
+
+	return nil
+}
+
+// GetZones returns all the zones with their rates.
+func (s *service) GetZones(ctx context.Context) ([]Zone, error) {
+	s.metrics.incMethodCalls("GetZones")
+
+	zones, err := s.zones(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []Rate
+	if err := s.db.SelectContext(ctx, &rates, "SELECT * FROM shipping_rates ORDER BY created_at"); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the rates")
+	}
+
+	indexes := make(map[string]int, len(zones))
+	for i, zone := range zones {
+		indexes[zone.ID] = i
+	}
+	for _, rate := range rates {
+		i := indexes[rate.ZoneID]
+		zones[i].Rates = append(zones[i].Rates, rate)
+	}
+
+	return zones, nil
+}
+
+// Options returns the rates available to ship a cart with the weight and total (in the
+// base currency and with the coupon discount subtracted) provided to the destination, with
+// their prices converted to the currency given. The base one is used if it's empty.
+func (s *service) Options(ctx context.Context, dest Destination, weight, total int64, currency string) ([]Option, error) {
+	s.metrics.incMethodCalls("Options")
+
+	options, _, err := s.options(ctx, dest, weight, total, currency)
+	return options, err
+}
+
+// Quote returns the option of the rate chosen to ship the cart to the destination.
+//
+// No rate is required if no zone contains the destination, otherwise an *UnavailableError
+// is returned if the rate is not one of its options, even when none can ship the cart.
+func (s *service) Quote(ctx context.Context, dest Destination, weight, total int64, currency, rateID string) (Option, error) {
+	s.metrics.incMethodCalls("Quote")
+
+	options, zoned, err := s.options(ctx, dest, weight, total, currency)
+	if err != nil {
+		return Option{}, err
+	}
+
+	if rateID == "" && !zoned {
+		return Option{}, nil
+	}
+
+	for _, option := range options {
+		if option.RateID == rateID {
+			return option, nil
+		}
+	}
+
+	return Option{}, &UnavailableError{RateID: rateID}
+}
+
+// options is like Options but it also reports whether a zone contains the destination.
+func (s *service) options(ctx context.Context, dest Destination, weight, total int64, currency string) ([]Option, bool, error) {
+	if currency == "" {
+		currency = s.currencies.Base()
+	}
+
+	zones, err := s.zones(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	zone, ok := Match(zones, dest)
+	if !ok {
+		return nil, false, nil
+	}
+
+	var rates []Rate
+	q := "SELECT * FROM shipping_rates WHERE zone_id=$1 ORDER BY created_at"
+	if err := s.db.SelectContext(ctx, &rates, q, zone.ID); err != nil {
+		return nil, false, errors.Wrap(err, "couldn't find the zone rates")
+	}
+
+	options := make([]Option, 0, len(rates))
+	for _, rate := range rates {
+		price, ok := rate.Price(weight, total)
+		if !ok {
+			continue
+		}
+
+		price, err := s.currencies.Convert(ctx, price, s.currencies.Base(), currency)
+		if err != nil {
+			return nil, false, err
+		}
+
+		options = append(options, Option{
+			RateID:   rate.ID,
+			Name:     rate.Name,
+			Zone:     zone.Name,
+			Price:    price,
+			Currency: currency,
+		})
+	}
+
+	return options, true, nil
+}
+
+// zones returns all the zones without their rates.
+func (s *service) zones(ctx context.Context) ([]Zone, error) {
+	var zones []Zone
+	if err := s.db.SelectContext(ctx, &zones, "SELECT * FROM shipping_zones ORDER BY created_at"); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the zones")
+	}
+
+	return zones, nil
+}
@@ -0,0 +1,90 @@
+package shipping_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/shipping"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShippingService(t *testing.T) {
+	logger.Disable()
+	ctx := context.Background()
+	db := test.StartPostgres(t)
+	s := shipping.NewService(db, currency.NewService(db, "USD"))
+
+	zone := shipping.Zone{
+		ID:    uuid.NewString(),
+		Name:  "Domestic",
+		Rules: shipping.Rules{{Country: "United States"}},
+	}
+	assert.NoError(t, s.CreateZone(ctx, zone))
+
+	rates := []shipping.Rate{
+		{ID: uuid.NewString(), ZoneID: zone.ID, Name: "Standard", Tiers: shipping.Tiers{{MaxWeight: 2000, Price: 500}}, FreeAbove: 5000},
+		{ID: uuid.NewString(), ZoneID: zone.ID, Name: "Freight", Tiers: shipping.Tiers{{MaxWeight: 50000, Price: 4000}}},
+	}
+	for _, rate := range rates {
+		assert.NoError(t, s.CreateRate(ctx, rate))
+	}
+	dest := shipping.Destination{Country: "United States", State: "Ohio", ZipCode: "43004"}
+
+	t.Run("Get zones", func(t *testing.T) {
+		zones, err := s.GetZones(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(zones))
+		assert.Equal(t, 2, len(zones[0].Rates))
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		options, err := s.Options(ctx, dest, 1500, 1000, "")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(options))
+		assert.Equal(t, int64(500), options[0].Price)
+		assert.Equal(t, "USD", options[0].Currency)
+
+		// Too heavy for the standard rate
+		options, err = s.Options(ctx, dest, 3000, 1000, "")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(options))
+
+		options, err = s.Options(ctx, shipping.Destination{Country: "Canada"}, 1500, 1000, "")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(options))
+	})
+
+	t.Run("Quote", func(t *testing.T) {
+		option, err := s.Quote(ctx, dest, 1500, 6000, "", rates[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), option.Price)
+
+		var unavailable *shipping.UnavailableError
+		_, err = s.Quote(ctx, dest, 1500, 1000, "", "")
+		assert.True(t, errors.As(err, &unavailable))
+
+		_, err = s.Quote(ctx, dest, 3000, 1000, "", rates[0].ID)
+		assert.True(t, errors.As(err, &unavailable))
+
+		// The destination has a zone but no rate can ship the cart
+		_, err = s.Quote(ctx, dest, 60000, 1000, "", "")
+		assert.True(t, errors.As(err, &unavailable))
+
+		// Destinations without options don't require a rate
+		_, err = s.Quote(ctx, shipping.Destination{Country: "Canada"}, 1500, 1000, "", "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, s.DeleteZone(ctx, zone.ID))
+		zones, err := s.GetZones(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(zones))
+	})
+}
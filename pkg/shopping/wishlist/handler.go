@@ -0,0 +1,368 @@
+package wishlist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/principal"
+	"github.com/GGP1/adak/internal/response"
+	"github.com/GGP1/adak/internal/sanitize"
+	"github.com/GGP1/adak/internal/validate"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+)
+
+// Handler handles wishlist endpoints.
+//
+// The id "default" can be used in the routes to refer to the user's "Saved for later" list.
+type Handler struct {
+	service Service
+}
+
+// NewHandler returns a new wishlist handler.
+func NewHandler(service Service) Handler {
+	return Handler{service: service}
+}
+
+// AddItem saves a product in the wishlist.
+func (h *Handler) AddItem() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		wishlistID, err := h.wishlistID(ctx, userID)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var itemParams ItemParams
+		if err := json.NewDecoder(r.Body).Decode(&itemParams); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, itemParams); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		item, err := h.service.AddItem(ctx, userID, wishlistID, itemParams)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, item)
+	}
+}
+
+// Create creates a new wishlist.
+func (h *Handler) Create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		var wishlist Wishlist
+		if err := json.NewDecoder(r.Body).Decode(&wishlist); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, wishlist); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		wishlist, err = h.service.Create(ctx, userID, sanitize.Normalize(wishlist.Name))
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, wishlist)
+	}
+}
+
+// Delete removes a wishlist.
+func (h *Handler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		wishlistID, err := h.wishlistID(ctx, userID)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.Delete(ctx, userID, wishlistID); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, wishlistID)
+	}
+}
+
+// Get lists the user's wishlists.
+func (h *Handler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := principal.UserID(r.Context())
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		wishlists, err := h.service.Get(r.Context(), userID)
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, wishlists)
+	}
+}
+
+// GetByID lists the wishlist with the id requested.
+func (h *Handler) GetByID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		wishlistID, err := h.wishlistID(ctx, userID)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		wishlist, err := h.service.GetByID(ctx, userID, wishlistID)
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, wishlist)
+	}
+}
+
+// GetShared lists the wishlist that has the share token requested.
+func (h *Handler) GetShared() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		shareToken := chi.URLParam(r, "token")
+
+		wishlist, err := h.service.GetShared(r.Context(), shareToken)
+		if err != nil {
+			response.Error(w, http.StatusNotFound, err)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, wishlist)
+	}
+}
+
+// MoveFromCart moves a product from the cart to the wishlist.
+func (h *Handler) MoveFromCart() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.move(w, r, h.service.MoveFromCart)
+	}
+}
+
+// MoveToCart moves a product from the wishlist to the cart.
+func (h *Handler) MoveToCart() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.move(w, r, h.service.MoveToCart)
+	}
+}
+
+// RemoveItem takes out a product from the wishlist.
+func (h *Handler) RemoveItem() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		wishlistID, err := h.wishlistID(ctx, userID)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		productID := chi.URLParam(r, "product_id")
+		if err := validate.UUID(productID); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		variantID, err := variantID(r)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.RemoveItem(ctx, userID, wishlistID, productID, variantID); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, fmt.Sprintf("product %q removed from wishlist %q", productID, wishlistID))
+	}
+}
+
+// Share creates a token to share the wishlist.
+func (h *Handler) Share() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		wishlistID, err := h.wishlistID(ctx, userID)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		shareToken, err := h.service.Share(ctx, userID, wishlistID)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, shareToken)
+	}
+}
+
+// Unshare revokes the wishlist share token.
+func (h *Handler) Unshare() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID, err := principal.UserID(ctx)
+		if err != nil {
+			response.Error(w, http.StatusForbidden, err)
+			return
+		}
+
+		wishlistID, err := h.wishlistID(ctx, userID)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := h.service.Unshare(ctx, userID, wishlistID); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, fmt.Sprintf("wishlist %q is no longer shared", wishlistID))
+	}
+}
+
+type moveFunc func(ctx context.Context, userID, wishlistID, cartID, productID, variantID string) error
+
+// move moves the product between the wishlist and the cart using the function provided.
+func (h *Handler) move(w http.ResponseWriter, r *http.Request, fn moveFunc) {
+	ctx := r.Context()
+
+	userID, err := principal.UserID(ctx)
+	if err != nil {
+		response.Error(w, http.StatusForbidden, err)
+		return
+	}
+
+	cartID, err := principal.CartID(ctx)
+	if err != nil {
+		response.Error(w, http.StatusForbidden, err)
+		return
+	}
+
+	wishlistID, err := h.wishlistID(ctx, userID)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	productID := chi.URLParam(r, "product_id")
+	if err := validate.UUID(productID); err != nil {
+		response.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	variantID, err := variantID(r)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := fn(ctx, userID, wishlistID, cartID, productID, variantID); err != nil {
+		var outOfStock *inventory.OutOfStockError
+		if errors.As(err, &outOfStock) {
+			response.Error(w, http.StatusConflict, err)
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response.JSONText(w, http.StatusOK, fmt.Sprintf("product %q moved", productID))
+}
+
+// variantID returns the variant in the "variant" query parameter, it's empty for products without options.
+func variantID(r *http.Request) (string, error) {
+	variantID := r.URL.Query().Get("variant")
+	if variantID == "" {
+		return "", nil
+	}
+	if err := validate.UUID(variantID); err != nil {
+		return "", err
+	}
+	return variantID, nil
+}
+
+// wishlistID returns the id in the URL, resolving "default" to the id of the user's default list.
+func (h *Handler) wishlistID(ctx context.Context, userID string) (string, error) {
+	if chi.URLParamFromCtx(ctx, "id") == "default" {
+		wishlist, err := h.service.Default(ctx, userID)
+		if err != nil {
+			return "", err
+		}
+		return wishlist.ID, nil
+	}
+
+	return params.URLID(ctx)
+}
@@ -0,0 +1,41 @@
+package wishlist
+
+import (
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// DefaultName is the name of the list every user has to save products for later.
+const DefaultName = "Saved for later"
+
+// Wishlist is a named list of products a user saved.
+type Wishlist struct {
+	ID     string `json:"id,omitempty"`
+	UserID string `json:"user_id,omitempty" db:"user_id"`
+	Name   string `json:"name,omitempty" validate:"required,max=64"`
+	// ShareToken grants read access to the list to anyone who has it, it's empty if it isn't shared
+	ShareToken zero.String `json:"share_token,omitempty" db:"share_token"`
+	CreatedAt  zero.Time   `json:"created_at,omitempty" db:"created_at"`
+	Items      []Item      `json:"items,omitempty"`
+}
+
+// Item is a product saved in a wishlist.
+//
+// Amounts to be provided in a currency’s smallest unit.
+type Item struct {
+	WishlistID string `json:"wishlist_id,omitempty" db:"wishlist_id"`
+	ProductID  string `json:"product_id,omitempty" db:"product_id"`
+	// VariantID is empty for products without options
+	VariantID string `json:"variant_id,omitempty" db:"variant_id"`
+	Quantity  int64  `json:"quantity,omitempty"`
+	// Price is the unit total of the product (or variant) when it was added
+	Price    int64     `json:"price,omitempty"`
+	Currency string    `json:"currency,omitempty"`
+	AddedAt  zero.Time `json:"added_at,omitempty" db:"added_at"`
+}
+
+// ItemParams contains the product to save in a wishlist.
+type ItemParams struct {
+	ProductID string `json:"product_id" validate:"uuid4_rfc4122"`
+	VariantID string `json:"variant_id,omitempty" validate:"omitempty,uuid4_rfc4122"`
+	Quantity  int64  `json:"quantity" validate:"required,min=1"`
+}
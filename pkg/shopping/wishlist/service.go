@@ -0,0 +1,373 @@
+// Package wishlist manages the lists where users save the products they may buy later.
+package wishlist
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/GGP1/adak/internal/token"
+	"github.com/GGP1/adak/pkg/shopping/cart"
+	"github.com/GGP1/adak/pkg/shopping/pricing"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v4/zero"
+)
+
+// Service contains wishlist functionalities.
+//
+// All the methods except GetShared operate only on the lists of the user given.
+type Service interface {
+	AddItem(ctx context.Context, userID, wishlistID string, params ItemParams) (Item, error)
+	Create(ctx context.Context, userID, name string) (Wishlist, error)
+	Default(ctx context.Context, userID string) (Wishlist, error)
+	Delete(ctx context.Context, userID, wishlistID string) error
+	Get(ctx context.Context, userID string) ([]Wishlist, error)
+	GetByID(ctx context.Context, userID, wishlistID string) (Wishlist, error)
+	GetShared(ctx context.Context, shareToken string) (Wishlist, error)
+	MoveFromCart(ctx context.Context, userID, wishlistID, cartID, productID, variantID string) error
+	MoveToCart(ctx context.Context, userID, wishlistID, cartID, productID, variantID string) error
+	RemoveItem(ctx context.Context, userID, wishlistID, productID, variantID string) error
+	Share(ctx context.Context, userID, wishlistID string) (string, error)
+	Unshare(ctx context.Context, userID, wishlistID string) error
+}
+
+type service struct {
+	db      *sqlx.DB
+	carts   cart.Service
+	metrics metrics
+}
+
+// NewService returns a new wishlist service.
+func NewService(db *sqlx.DB, carts cart.Service) Service {
+	return &service{db, carts, initMetrics()}
+}
+
+// AddItem saves the product in the wishlist with its current price. If it was already
+// saved, the quantities are added and the original price is kept.
+//
+// Products with options are saved by variant, priced with the variant subtotal when it has one.
+func (s *service) AddItem(ctx context.Context, userID, wishlistID string, params ItemParams) (Item, error) {
+	s.metrics.incMethodCalls("AddItem")
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Item{}, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var owner string
+	q := "SELECT user_id FROM wishlists WHERE id=$1 FOR UPDATE"
+	if err := tx.GetContext(ctx, &owner, q, wishlistID); err != nil && err != sql.ErrNoRows {
+		return Item{}, errors.Wrap(err, "couldn't find the wishlist")
+	}
+	if owner != userID {
+		return Item{}, errors.New("wishlist not found")
+	}
+
+	var product pricedProduct
+	q = "SELECT total, discount, taxes, currency, jsonb_array_length(options) AS options FROM products WHERE id=$1"
+	if err := tx.GetContext(ctx, &product, q, params.ProductID); err != nil {
+		return Item{}, errors.Wrap(err, "couldn't find the product")
+	}
+
+	price, err := product.price(ctx, tx, params)
+	if err != nil {
+		return Item{}, err
+	}
+
+	var item Item
+	q = `INSERT INTO wishlist_items
+	(wishlist_id, product_id, variant_id, quantity, price, currency, added_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (wishlist_id, product_id, variant_id) DO UPDATE SET
+	quantity=wishlist_items.quantity+EXCLUDED.quantity
+	RETURNING *`
+	err = tx.GetContext(ctx, &item, q, wishlistID, params.ProductID, params.VariantID, params.Quantity,
+		price, product.Currency, time.Now())
+	if err != nil {
+		return Item{}, errors.Wrap(err, "couldn't save the wishlist item")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Item{}, errors.Wrap(err, "committing transaction")
+	}
+
+	return item, nil
+}
+
+// Create creates a new wishlist.
+func (s *service) Create(ctx context.Context, userID, name string) (Wishlist, error) {
+	s.metrics.incMethodCalls("Create")
+
+	wishlist := Wishlist{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Name:      name,
+		CreatedAt: zero.TimeFrom(time.Now()),
+	}
+	q := "INSERT INTO wishlists (id, user_id, name, created_at) VALUES ($1, $2, $3, $4)"
+	_, err := s.db.ExecContext(ctx, q, wishlist.ID, wishlist.UserID, wishlist.Name, wishlist.CreatedAt)
+	if err != nil {
+		return Wishlist{}, errors.Wrap(err, "couldn't create the wishlist")
+	}
+
+	return wishlist, nil
+}
+
+// Default returns the user's "Saved for later" list, creating it if it doesn't exist.
+func (s *service) Default(ctx context.Context, userID string) (Wishlist, error) {
+	s.metrics.incMethodCalls("Default")
+
+	if err := s.createDefault(ctx, userID); err != nil {
+		return Wishlist{}, err
+	}
+
+	var wishlist Wishlist
+	q := "SELECT * FROM wishlists WHERE user_id=$1 AND name=$2"
+	if err := s.db.GetContext(ctx, &wishlist, q, userID, DefaultName); err != nil {
+		return Wishlist{}, errors.Wrap(err, "couldn't find the wishlist")
+	}
+
+	return wishlist, nil
+}
+
+// Delete removes a wishlist and its items.
+func (s *service) Delete(ctx context.Context, userID, wishlistID string) error {
+	s.metrics.incMethodCalls("Delete")
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM wishlists WHERE id=$1 AND user_id=$2", wishlistID, userID)
+	if err != nil {
+		return errors.Wrap(err, "couldn't delete the wishlist")
+	}
+
+	return notFound(res)
+}
+
+// Get returns the user's wishlists with their items, the default one is created if it doesn't exist.
+func (s *service) Get(ctx context.Context, userID string) ([]Wishlist, error) {
+	s.metrics.incMethodCalls("Get")
+
+	if err := s.createDefault(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	var wishlists []Wishlist
+	q := "SELECT * FROM wishlists WHERE user_id=$1 ORDER BY created_at"
+	if err := s.db.SelectContext(ctx, &wishlists, q, userID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the wishlists")
+	}
+
+	for i := range wishlists {
+		items, err := s.items(ctx, wishlists[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		wishlists[i].Items = items
+	}
+
+	return wishlists, nil
+}
+
+// GetByID returns the wishlist requested with its items.
+func (s *service) GetByID(ctx context.Context, userID, wishlistID string) (Wishlist, error) {
+	s.metrics.incMethodCalls("GetByID")
+
+	var wishlist Wishlist
+	q := "SELECT * FROM wishlists WHERE id=$1 AND user_id=$2"
+	if err := s.db.GetContext(ctx, &wishlist, q, wishlistID, userID); err != nil {
+		return Wishlist{}, errors.Wrap(err, "couldn't find the wishlist")
+	}
+
+	items, err := s.items(ctx, wishlist.ID)
+	if err != nil {
+		return Wishlist{}, err
+	}
+	wishlist.Items = items
+
+	return wishlist, nil
+}
+
+// GetShared returns the wishlist that has the share token provided, without its owner.
+func (s *service) GetShared(ctx context.Context, shareToken string) (Wishlist, error) {
+	s.metrics.incMethodCalls("GetShared")
+
+	var wishlist Wishlist
+	q := "SELECT id, name, share_token, created_at FROM wishlists WHERE share_token=$1"
+	if err := s.db.GetContext(ctx, &wishlist, q, shareToken); err != nil {
+		return Wishlist{}, errors.Wrap(err, "couldn't find the wishlist")
+	}
+
+	items, err := s.items(ctx, wishlist.ID)
+	if err != nil {
+		return Wishlist{}, err
+	}
+	wishlist.Items = items
+
+	return wishlist, nil
+}
+
+// MoveFromCart saves the cart product in the wishlist and takes it out of the cart.
+func (s *service) MoveFromCart(ctx context.Context, userID, wishlistID, cartID, productID, variantID string) error {
+	s.metrics.incMethodCalls("MoveFromCart")
+
+	cartProduct, err := s.carts.CartProduct(ctx, cartID, productID, variantID)
+	if err != nil {
+		return err
+	}
+
+	params := ItemParams{ProductID: productID, VariantID: variantID, Quantity: cartProduct.Quantity.Int64}
+	if _, err := s.AddItem(ctx, userID, wishlistID, params); err != nil {
+		return err
+	}
+
+	return s.carts.Remove(ctx, cartID, productID, variantID, cartProduct.Quantity.Int64)
+}
+
+// MoveToCart adds the saved product to the cart and removes it from the wishlist.
+//
+// The item is kept in the wishlist if the cart can't hold its stock.
+func (s *service) MoveToCart(ctx context.Context, userID, wishlistID, cartID, productID, variantID string) error {
+	s.metrics.incMethodCalls("MoveToCart")
+
+	var item Item
+	q := `SELECT i.* FROM wishlist_items AS i
+	JOIN wishlists AS w ON w.id=i.wishlist_id
+	WHERE i.wishlist_id=$1 AND i.product_id=$2 AND i.variant_id=$3 AND w.user_id=$4`
+	if err := s.db.GetContext(ctx, &item, q, wishlistID, productID, variantID, userID); err != nil {
+		return errors.Wrap(err, "couldn't find the wishlist item")
+	}
+
+	cartProduct := cart.Product{
+		ID:        zero.StringFrom(productID),
+		CartID:    zero.StringFrom(cartID),
+		Quantity:  zero.IntFrom(item.Quantity),
+		VariantID: zero.StringFrom(variantID),
+	}
+	if err := s.carts.Add(ctx, cartProduct); err != nil {
+		return err
+	}
+
+	return s.RemoveItem(ctx, userID, wishlistID, productID, variantID)
+}
+
+// RemoveItem takes out a product (or one of its variants) from the wishlist.
+func (s *service) RemoveItem(ctx context.Context, userID, wishlistID, productID, variantID string) error {
+	s.metrics.incMethodCalls("RemoveItem")
+
+	q := `DELETE FROM wishlist_items AS i USING wishlists AS w
+	WHERE w.id=i.wishlist_id AND i.wishlist_id=$1 AND i.product_id=$2 AND i.variant_id=$3 AND w.user_id=$4`
+	res, err := s.db.ExecContext(ctx, q, wishlistID, productID, variantID, userID)
+	if err != nil {
+		return errors.Wrap(err, "couldn't remove the wishlist item")
+	}
+
+	return notFound(res)
+}
+
+// Share generates a token that gives read access to the wishlist, replacing the previous one.
+func (s *service) Share(ctx context.Context, userID, wishlistID string) (string, error) {
+	s.metrics.incMethodCalls("Share")
+
+	shareToken := token.RandString(32)
+	q := "UPDATE wishlists SET share_token=$3 WHERE id=$1 AND user_id=$2"
+	res, err := s.db.ExecContext(ctx, q, wishlistID, userID, shareToken)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't share the wishlist")
+	}
+
+	if err := notFound(res); err != nil {
+		return "", err
+	}
+
+	return shareToken, nil
+}
+
+// Unshare revokes the wishlist share token.
+func (s *service) Unshare(ctx context.Context, userID, wishlistID string) error {
+	s.metrics.incMethodCalls("Unshare")
+
+	q := "UPDATE wishlists SET share_token=NULL WHERE id=$1 AND user_id=$2"
+	res, err := s.db.ExecContext(ctx, q, wishlistID, userID)
+	if err != nil {
+		return errors.Wrap(err, "couldn't unshare the wishlist")
+	}
+
+	return notFound(res)
+}
+
+func (s *service) createDefault(ctx context.Context, userID string) error {
+	q := `INSERT INTO wishlists (id, user_id, name) VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, name) DO NOTHING`
+	if _, err := s.db.ExecContext(ctx, q, uuid.NewString(), userID, DefaultName); err != nil {
+		return errors.Wrap(err, "couldn't create the default wishlist")
+	}
+
+	return nil
+}
+
+func (s *service) items(ctx context.Context, wishlistID string) ([]Item, error) {
+	var items []Item
+	q := "SELECT * FROM wishlist_items WHERE wishlist_id=$1 ORDER BY added_at"
+	if err := s.db.SelectContext(ctx, &items, q, wishlistID); err != nil {
+		return nil, errors.Wrap(err, "couldn't find the wishlist items")
+	}
+
+	return items, nil
+}
+
+// pricedProduct contains the fields used to price a wishlist item.
+type pricedProduct struct {
+	Total    int64
+	Discount zero.Int
+	Taxes    zero.Int
+	Currency string
+	// Options is the number of axes the product varies on
+	Options int
+}
+
+// price returns the unit total of the item, the variant subtotal replaces the product one when
+// it's set. Like in the cart, products with options require a variant and the others take none.
+func (p pricedProduct) price(ctx context.Context, tx *sqlx.Tx, params ItemParams) (int64, error) {
+	if params.VariantID == "" {
+		if p.Options > 0 {
+			return 0, errors.Errorf("product %q requires a variant", params.ProductID)
+		}
+		return p.Total, nil
+	}
+	if p.Options == 0 {
+		return 0, errors.Errorf("product %q has no variants", params.ProductID)
+	}
+
+	var subtotal zero.Int
+	q := "SELECT subtotal FROM product_variants WHERE id=$1 AND product_id=$2"
+	if err := tx.GetContext(ctx, &subtotal, q, params.VariantID, params.ProductID); err != nil {
+		return 0, errors.Wrap(err, "couldn't find the variant")
+	}
+	if !subtotal.Valid {
+		return p.Total, nil
+	}
+
+	line := pricing.Line{
+		UnitPrice:    subtotal.Int64,
+		Quantity:     1,
+		DiscountRate: p.Discount.Int64,
+		TaxRate:      p.Taxes.Int64,
+	}
+	return line.Price().Total, nil
+}
+
+// notFound returns an error if the statement didn't affect any wishlist.
+func notFound(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "couldn't get the rows affected")
+	}
+	if n == 0 {
+		return errors.New("wishlist not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,233 @@
+package wishlist_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GGP1/adak/internal/config"
+	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/test"
+	"github.com/GGP1/adak/pkg/product"
+	"github.com/GGP1/adak/pkg/shopping/cart"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
+	"github.com/GGP1/adak/pkg/shopping/promotion"
+	"github.com/GGP1/adak/pkg/shopping/wishlist"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	cartID    = "1"
+	userID    = "2"
+	productID = "a0d2b2c4-5cd1-4e0e-9a4f-1a7a3e0c5f11"
+	shopID    = "4"
+	// variantProductID has the size option, variantID is its "L" size
+	variantProductID = "b3f6c1d2-7e8a-4b9c-8d0e-2f1a3b4c5d6e"
+	variantID        = "c4a7d2e3-8f9b-4c0d-9e1f-3a2b4c5d6e7f"
+)
+
+func NewWishlistService(t *testing.T) (context.Context, *sqlx.DB, wishlist.Service, cart.Service, product.Service) {
+	t.Helper()
+	logger.Disable()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	db := test.StartPostgres(t)
+	mc := test.StartMemcached(t)
+	currencyService := currency.NewService(db, "USD")
+	cartService := cart.NewService(db, mc, inventory.NewService(db, config.Inventory{HoldTTL: 15}),
+		promotion.NewService(db, currencyService), currencyService)
+	service := wishlist.NewService(db, cartService)
+	createRelationships(ctx, t, db)
+
+	t.Cleanup(func() {
+		cancel()
+	})
+
+	return ctx, db, service, cartService, product.NewService(db, mc)
+}
+
+func TestWishlistService(t *testing.T) {
+	ctx, db, s, carts, products := NewWishlistService(t)
+
+	t.Run("Default", defaultList(ctx, s))
+	t.Run("Add item", addItem(ctx, s))
+	t.Run("Share", share(ctx, s))
+	t.Run("Move", move(ctx, s, carts))
+	t.Run("Move variant", moveVariant(ctx, s, carts))
+	t.Run("Price drops", priceDrops(ctx, db, s, products))
+}
+
+func defaultList(ctx context.Context, s wishlist.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		wishlists, err := s.Get(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(wishlists))
+		assert.Equal(t, wishlist.DefaultName, wishlists[0].Name)
+
+		def, err := s.Default(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, wishlists[0].ID, def.ID)
+
+		_, err = s.Create(ctx, userID, "Birthday")
+		assert.NoError(t, err)
+		_, err = s.Create(ctx, userID, "Birthday")
+		assert.Error(t, err)
+	}
+}
+
+func addItem(ctx context.Context, s wishlist.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		def, err := s.Default(ctx, userID)
+		assert.NoError(t, err)
+
+		params := wishlist.ItemParams{ProductID: productID, Quantity: 1}
+		item, err := s.AddItem(ctx, userID, def.ID, params)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1000), item.Price)
+		assert.Equal(t, "USD", item.Currency)
+
+		item, err = s.AddItem(ctx, userID, def.ID, params)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), item.Quantity)
+
+		_, err = s.AddItem(ctx, "another_user", def.ID, params)
+		assert.Error(t, err)
+	}
+}
+
+func share(ctx context.Context, s wishlist.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		def, err := s.Default(ctx, userID)
+		assert.NoError(t, err)
+
+		shareToken, err := s.Share(ctx, userID, def.ID)
+		assert.NoError(t, err)
+
+		shared, err := s.GetShared(ctx, shareToken)
+		assert.NoError(t, err)
+		assert.Equal(t, def.ID, shared.ID)
+		assert.Empty(t, shared.UserID)
+		assert.Equal(t, 1, len(shared.Items))
+
+		assert.NoError(t, s.Unshare(ctx, userID, def.ID))
+		_, err = s.GetShared(ctx, shareToken)
+		assert.Error(t, err)
+	}
+}
+
+func move(ctx context.Context, s wishlist.Service, carts cart.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		def, err := s.Default(ctx, userID)
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.MoveToCart(ctx, userID, def.ID, cartID, productID, ""))
+
+		p, err := carts.CartProduct(ctx, cartID, productID, "")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), p.Quantity.Int64)
+
+		def, err = s.GetByID(ctx, userID, def.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, def.Items)
+
+		assert.NoError(t, s.MoveFromCart(ctx, userID, def.ID, cartID, productID, ""))
+
+		def, err = s.GetByID(ctx, userID, def.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(def.Items))
+		assert.Equal(t, int64(2), def.Items[0].Quantity)
+
+		_, err = carts.CartProduct(ctx, cartID, productID, "")
+		assert.Error(t, err)
+	}
+}
+
+func moveVariant(ctx context.Context, s wishlist.Service, carts cart.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		def, err := s.Default(ctx, userID)
+		assert.NoError(t, err)
+
+		params := wishlist.ItemParams{ProductID: variantProductID, Quantity: 1}
+		_, err = s.AddItem(ctx, userID, def.ID, params)
+		assert.Error(t, err, "products with options require a variant")
+
+		params.VariantID = variantID
+		item, err := s.AddItem(ctx, userID, def.ID, params)
+		assert.NoError(t, err)
+		assert.Equal(t, variantID, item.VariantID)
+		// Variant subtotal with the product discount (10%) and taxes (20%) applied
+		assert.Equal(t, int64(1620), item.Price)
+
+		assert.NoError(t, s.MoveToCart(ctx, userID, def.ID, cartID, variantProductID, variantID))
+
+		p, err := carts.CartProduct(ctx, cartID, variantProductID, variantID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), p.Quantity.Int64)
+
+		assert.NoError(t, s.MoveFromCart(ctx, userID, def.ID, cartID, variantProductID, variantID))
+
+		_, err = carts.CartProduct(ctx, cartID, variantProductID, variantID)
+		assert.Error(t, err)
+
+		def, err = s.GetByID(ctx, userID, def.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(def.Items))
+		assert.Equal(t, variantID, def.Items[1].VariantID)
+		assert.Equal(t, int64(1620), def.Items[1].Price)
+	}
+}
+
+func priceDrops(ctx context.Context, db *sqlx.DB, s wishlist.Service, products product.Service) func(*testing.T) {
+	return func(t *testing.T) {
+		// The variant product total is lower than the variant price saved
+		drops, err := products.PriceDrops(ctx, userID)
+		assert.NoError(t, err)
+		assert.Empty(t, drops)
+
+		_, err = db.ExecContext(ctx, "UPDATE products SET total=800 WHERE id=$1", productID)
+		assert.NoError(t, err)
+
+		drops, err = products.PriceDrops(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(drops))
+		assert.Equal(t, int64(1000), drops[0].SavedPrice)
+		assert.Equal(t, int64(800), drops[0].Price)
+
+		_, err = db.ExecContext(ctx, "UPDATE product_variants SET subtotal=1000 WHERE id=$1", variantID)
+		assert.NoError(t, err)
+
+		drops, err = products.PriceDrops(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(drops))
+		assert.Equal(t, variantID, drops[1].VariantID)
+		assert.Equal(t, int64(1620), drops[1].SavedPrice)
+		assert.Equal(t, int64(1080), drops[1].Price)
+	}
+}
+
+func createRelationships(ctx context.Context, t *testing.T, db *sqlx.DB) {
+	t.Helper()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO shops (id, name) VALUES ($1, 'shop')", shopID)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO products
+	(id, shop_id, stock, brand, category, type, weight, subtotal, total)
+	VALUES ($1, $2, 10, 'brand', 'category', 'type', 1, 1000, 1000)`, productID, shopID)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO products
+	(id, shop_id, stock, brand, category, type, weight, subtotal, discount, taxes, total, options)
+	VALUES ($1, $2, 10, 'brand', 'category', 'type', 1, 1000, 10, 20, 1080, '[{"name":"size","values":["M","L"]}]')`,
+		variantProductID, shopID)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO product_variants
+	(id, product_id, sku, options, stock, subtotal)
+	VALUES ($1, $2, 'SHIRT-L', '{"size":"L"}', 5, 1500)`, variantID, variantProductID)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO carts (id) VALUES ($1)", cartID)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO users (id, cart_id, username, email, password)
+	VALUES ($1, $2, 'user', 'user@adak.com', 'password')`, userID, cartID)
+	assert.NoError(t, err)
+}
@@ -1,14 +1,21 @@
 package account
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 
-	"github.com/GGP1/adak/internal/cookie"
 	"github.com/GGP1/adak/internal/email"
+	"github.com/GGP1/adak/internal/logger"
 	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/principal"
 	"github.com/GGP1/adak/internal/response"
+	"github.com/GGP1/adak/internal/sanitize"
+	"github.com/GGP1/adak/internal/validate"
+	"github.com/GGP1/adak/pkg/auth"
+	"github.com/GGP1/adak/pkg/tracking"
 	"github.com/GGP1/adak/pkg/user"
 	"github.com/google/uuid"
 
@@ -63,13 +70,13 @@ type changePassword struct {
 	NewPassword string `json:"new_password" validate:"email,required"`
 }
 
-// ChangePassword updates the user password.
-func (h *Handler) ChangePassword() http.HandlerFunc {
+// ChangePassword updates the user password and logs out the rest of the user sessions.
+func (h *Handler) ChangePassword(s auth.Session) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var changePass changePassword
 		ctx := r.Context()
 
-		userID, err := cookie.GetValue(r, "UID")
+		userID, err := principal.UserID(ctx)
 		if err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
@@ -86,10 +93,101 @@ func (h *Handler) ChangePassword() http.HandlerFunc {
 			return
 		}
 
+		if err := s.RevokeOthers(ctx); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
 		response.JSONText(w, http.StatusOK, "successfully changed password")
 	}
 }
 
+type forgotPassword struct {
+	Email string `json:"email" validate:"email,required"`
+}
+
+// ForgotPassword sends an email with a link to reset the password.
+//
+// The reply is the same whether the account exists or not.
+func (h *Handler) ForgotPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var forgot forgotPassword
+		ctx := r.Context()
+
+		if err := json.NewDecoder(r.Body).Decode(&forgot); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, forgot); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		email := sanitize.Normalize(forgot.Email)
+		reset, err := h.accountService.RequestPasswordReset(ctx, email, tracking.GetUserIP(r))
+		switch {
+		case err == ErrResetLimit:
+			response.Error(w, http.StatusTooManyRequests, err)
+			return
+		case err == nil:
+			// Send it in the background so the response time doesn't reveal the account exists
+			go func() {
+				err := h.emailer.SendPasswordReset(context.Background(), reset.Username, reset.Email, reset.Token)
+				if err != nil {
+					logger.Errorf("failed sending password reset email: %v", err)
+				}
+			}()
+		case errors.Cause(err) != sql.ErrNoRows:
+			logger.Errorf("failed requesting password reset: %v", err)
+		}
+
+		response.JSONText(w, http.StatusOK, "if the email belongs to an account, the instructions to reset the password were sent to it")
+	}
+}
+
+type resetPassword struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=6"`
+}
+
+// ResetPassword sets the new password of the user the token was issued to and logs out all the user sessions.
+func (h *Handler) ResetPassword(s auth.Session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var reset resetPassword
+		ctx := r.Context()
+
+		if err := json.NewDecoder(r.Body).Decode(&reset); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validate.Struct(ctx, reset); err != nil {
+			response.Error(w, http.StatusBadRequest, err)
+			return
+		}
+
+		userID, err := h.accountService.ResetPassword(ctx, reset.Token, sanitize.Normalize(reset.Password))
+		if err != nil {
+			if err == ErrInvalidResetToken {
+				response.Error(w, http.StatusBadRequest, err)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := s.RevokeAll(ctx, userID); err != nil {
+			response.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.JSONText(w, http.StatusOK, "password reset, please log in again")
+	}
+}
+
 // SendChangeConfirmation takes the new email and sends an email confirmation.
 func (h *Handler) SendChangeConfirmation() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -107,7 +205,7 @@ func (h *Handler) SendChangeConfirmation() http.HandlerFunc {
 			return
 		}
 
-		userID, err := cookie.GetValue(r, "UID")
+		userID, err := principal.UserID(ctx)
 		if err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
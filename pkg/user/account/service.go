@@ -2,31 +2,68 @@ package account
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
 	"time"
 
 	"github.com/GGP1/adak/internal/logger"
 	"github.com/GGP1/adak/pkg/user"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Password reset tokens are stored hashed under "password_reset:<hash>", the hash of the last
+// token requested by each user is kept under "password_reset:user:<user_id>" to invalidate it
+// when a new one is requested.
+const (
+	resetPrefix      = "password_reset:"
+	resetUserPrefix  = "password_reset:user:"
+	resetLimitPrefix = "password_reset:limit:"
+	resetTTL         = 30 * time.Minute
+	// Reset requests allowed per email and per IP during resetLimitWindow
+	resetEmailLimit  = 3
+	resetIPLimit     = 10
+	resetLimitWindow = time.Hour
+)
+
+var (
+	// ErrResetLimit is returned when the email or the IP exceeded the password reset requests allowed.
+	ErrResetLimit = errors.New("too many password reset requests, please try again later")
+	// ErrInvalidResetToken is returned when the password reset token doesn't exist, expired or was already used.
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+)
+
 // Service provides user account operations.
 type Service interface {
 	ChangeEmail(ctx context.Context, id, newEmail, token string) error
 	ChangePassword(ctx context.Context, id, oldPass, newPass string) error
+	RequestPasswordReset(ctx context.Context, email, ip string) (Reset, error)
+	ResetPassword(ctx context.Context, token, newPass string) (string, error)
 	ValidateUserEmail(ctx context.Context, id, confirmationCode string, verified bool) error
 }
 
 type service struct {
 	db      *sqlx.DB
+	rdb     *redis.Client
 	metrics metrics
 }
 
+// Reset contains the password reset token and the user it was issued to.
+type Reset struct {
+	UserID   string
+	Username string
+	Email    string
+	Token    string
+}
+
 // NewService creates an account service.
-func NewService(db *sqlx.DB) Service {
-	return &service{db, initMetrics()}
+func NewService(db *sqlx.DB, rdb *redis.Client) Service {
+	return &service{db, rdb, initMetrics()}
 }
 
 // Change changes the user email.
@@ -34,7 +71,7 @@ func (s *service) ChangeEmail(ctx context.Context, id, newEmail, token string) e
 	s.metrics.incMethodCalls("ChangeEmail")
 
 	var user user.User
-	if err := s.db.SelectContext(ctx, &user, "SELECT * FROM users WHERE id=?", id); err != nil {
+	if err := s.db.GetContext(ctx, &user, "SELECT created_at FROM users WHERE id=$1", id); err != nil {
 		return errors.Wrap(err, "invalid email")
 	}
 
@@ -84,6 +121,92 @@ func (s *service) ChangePassword(ctx context.Context, id, oldPass, newPass strin
 	return nil
 }
 
+// RequestPasswordReset issues a single-use token to reset the password of the user with the email provided,
+// the previous token of the user is invalidated.
+//
+// The request counts against the email and IP limits even if there is no user with the email.
+func (s *service) RequestPasswordReset(ctx context.Context, email, ip string) (Reset, error) {
+	s.metrics.incMethodCalls("RequestPasswordReset")
+
+	if err := s.limitReset(ctx, "email:"+strings.ToLower(email), resetEmailLimit); err != nil {
+		return Reset{}, err
+	}
+	if err := s.limitReset(ctx, "ip:"+ip, resetIPLimit); err != nil {
+		return Reset{}, err
+	}
+
+	var user user.User
+	if err := s.db.GetContext(ctx, &user, "SELECT id, username, email FROM users WHERE email=$1", email); err != nil {
+		return Reset{}, errors.Wrap(err, "couldn't find the user")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return Reset{}, errors.Wrap(err, "generating token")
+	}
+	token := hex.EncodeToString(b)
+	hash := hashToken(token)
+
+	userKey := resetUserPrefix + user.ID
+	previous, err := s.rdb.Get(ctx, userKey).Result()
+	if err != nil && err != redis.Nil {
+		return Reset{}, errors.Wrap(err, "fetching the previous token")
+	}
+
+	pipe := s.rdb.TxPipeline()
+	if previous != "" {
+		pipe.Del(ctx, resetPrefix+previous)
+	}
+	pipe.Set(ctx, resetPrefix+hash, user.ID, resetTTL)
+	pipe.Set(ctx, userKey, hash, resetTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Reset{}, errors.Wrap(err, "couldn't save the token")
+	}
+
+	return Reset{
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Token:    token,
+	}, nil
+}
+
+// ResetPassword consumes the token and sets the new password of its user, whose id is returned.
+func (s *service) ResetPassword(ctx context.Context, token, newPass string) (string, error) {
+	s.metrics.incMethodCalls("ResetPassword")
+
+	// Get and delete the token atomically so it can't be used twice
+	key := resetPrefix + hashToken(token)
+	pipe := s.rdb.TxPipeline()
+	get := pipe.Get(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		if err == redis.Nil {
+			return "", ErrInvalidResetToken
+		}
+		return "", errors.Wrap(err, "fetching the token")
+	}
+	userID := get.Val()
+
+	newPassHash, err := bcrypt.GenerateFromPassword([]byte(newPass), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Errorf("failed generating user's password hash: %v", err)
+		return "", errors.Wrap(err, "couldn't generate the password hash")
+	}
+
+	_, err = s.db.ExecContext(ctx, "UPDATE users SET password=$2 WHERE id=$1", userID, string(newPassHash))
+	if err != nil {
+		logger.Errorf("failed updating user's password: %v", err)
+		return "", errors.Wrap(err, "couldn't reset the password")
+	}
+
+	if err := s.rdb.Del(ctx, resetUserPrefix+userID).Err(); err != nil {
+		logger.Debug(err)
+	}
+
+	return userID, nil
+}
+
 // ValidateUserEmail sets the time when the user validated its email and the token he received.
 func (s *service) ValidateUserEmail(ctx context.Context, id, confirmationCode string, verified bool) error {
 	s.metrics.incMethodCalls("ValidateUserEmail")
@@ -97,3 +220,27 @@ func (s *service) ValidateUserEmail(ctx context.Context, id, confirmationCode st
 
 	return nil
 }
+
+// limitReset counts a password reset request under the key provided and fails if the limit was exceeded.
+func (s *service) limitReset(ctx context.Context, key string, limit int64) error {
+	key = resetLimitPrefix + key
+	n, err := s.rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return errors.Wrap(err, "counting requests")
+	}
+	if n == 1 {
+		if err := s.rdb.Expire(ctx, key, resetLimitWindow).Err(); err != nil {
+			return errors.Wrap(err, "counting requests")
+		}
+	}
+
+	if n > limit {
+		return ErrResetLimit
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
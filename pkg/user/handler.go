@@ -1,14 +1,15 @@
 package user
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/GGP1/adak/internal/cookie"
 	"github.com/GGP1/adak/internal/email"
 	"github.com/GGP1/adak/internal/params"
+	"github.com/GGP1/adak/internal/principal"
 	"github.com/GGP1/adak/internal/response"
 	"github.com/GGP1/adak/internal/sanitize"
 	"github.com/GGP1/adak/internal/token"
@@ -94,6 +95,27 @@ func (h *Handler) Create() http.HandlerFunc {
 	}
 }
 
+// Registerer returns the function used to register the users that log in with an identity
+// provider for the first time, they are created as in Create.
+func Registerer(userS Service, cartS cart.Service) auth.Registerer {
+	return func(ctx context.Context, u auth.User) error {
+		user := AddUser{
+			ID:            u.ID,
+			CartID:        u.CartID,
+			Username:      u.Username,
+			Email:         u.Email,
+			Password:      u.Password,
+			VerifiedEmail: u.VerifiedEmail,
+			CreatedAt:     time.Now(),
+		}
+		if err := userS.Create(ctx, user); err != nil {
+			return err
+		}
+
+		return cartS.Create(ctx, user.CartID)
+	}
+}
+
 // Delete removes a user.
 func (h *Handler) Delete(s auth.Session) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -105,13 +127,13 @@ func (h *Handler) Delete(s auth.Session) http.HandlerFunc {
 			return
 		}
 
-		cartID, err := cookie.GetValue(r, "CID")
+		cartID, err := principal.CartID(ctx)
 		if err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
 		}
 
-		if err := token.CheckPermits(r, id); err != nil {
+		if err := token.CheckPermits(ctx, id); err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
 		}
@@ -126,7 +148,7 @@ func (h *Handler) Delete(s auth.Session) http.HandlerFunc {
 			return
 		}
 
-		if err := s.Logout(ctx, w, r); err != nil {
+		if err := s.Logout(ctx, w); err != nil {
 			response.Error(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -256,7 +278,7 @@ func (h *Handler) Update() http.HandlerFunc {
 			return
 		}
 
-		if err := token.CheckPermits(r, id); err != nil {
+		if err := token.CheckPermits(ctx, id); err != nil {
 			response.Error(w, http.StatusForbidden, err)
 			return
 		}
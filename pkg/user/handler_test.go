@@ -13,9 +13,13 @@ import (
 	"github.com/GGP1/adak/internal/config"
 	"github.com/GGP1/adak/internal/email"
 	"github.com/GGP1/adak/internal/logger"
+	"github.com/GGP1/adak/internal/principal"
 	"github.com/GGP1/adak/internal/test"
 	"github.com/GGP1/adak/pkg/auth"
 	"github.com/GGP1/adak/pkg/shopping/cart"
+	"github.com/GGP1/adak/pkg/shopping/currency"
+	"github.com/GGP1/adak/pkg/shopping/inventory"
+	"github.com/GGP1/adak/pkg/shopping/promotion"
 	"github.com/GGP1/adak/pkg/user"
 	"github.com/google/uuid"
 
@@ -49,7 +53,9 @@ func TestMain(m *testing.M) {
 	}
 
 	userService = user.NewService(db, mc)
-	cartService = cart.NewService(db, mc)
+	currencyService := currency.NewService(db, "USD")
+	cartService = cart.NewService(db, mc, inventory.NewService(db, config.Inventory{}),
+		promotion.NewService(db, currencyService), currencyService)
 	handler = user.NewHandler(true, userService, cartService, email.Emailer{}, mc)
 
 	code := m.Run()
@@ -108,14 +114,14 @@ func TestDeleteHandler(t *testing.T) {
 
 	rdb := test.StartRedis(t)
 
-	session := auth.NewSession(nil, rdb, config.Session{}, true)
+	session := auth.NewSession(nil, rdb, nil, config.Session{}, true)
 	mux := chi.NewRouter()
 	mux.Delete("/{id}", handler.Delete(session))
 
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodDelete, "/"+u.ID, nil)
-	test.AddCookie(t, req, "UID", u.ID)
-	test.AddCookie(t, req, "CID", u.CartID)
+	p := principal.Principal{UserID: u.ID, CartID: u.CartID}
+	req = req.WithContext(principal.NewContext(req.Context(), p))
 
 	mux.ServeHTTP(rec, req)
 
@@ -266,7 +272,7 @@ func TestUpdateHandler(t *testing.T) {
 
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodPut, "/"+u.ID, &buf)
-	test.AddCookie(t, req, "UID", u.ID)
+	req = req.WithContext(principal.NewContext(req.Context(), principal.Principal{UserID: u.ID}))
 
 	mux.ServeHTTP(rec, req)
 
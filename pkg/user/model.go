@@ -35,6 +35,8 @@ type AddUser struct {
 	Password  string    `json:"password,omitempty" validate:"required,min=6"`
 	IsAdmin   bool      `json:"is_admin,omitempty" db:"is_admin"`
 	CreatedAt time.Time `json:"created_at,omitempty" db:"created_at"`
+	// VerifiedEmail is set only when the email was verified by an identity provider
+	VerifiedEmail bool `json:"-" db:"verified_email"`
 }
 
 // ListUser is the structure used to list users.
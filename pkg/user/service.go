@@ -75,10 +75,10 @@ func (s *service) Create(ctx context.Context, user AddUser) error {
 		}
 	}
 	userQuery := `INSERT INTO users
-	(id, cart_id, username, email, password, is_admin, created_at)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	(id, cart_id, username, email, password, verified_email, is_admin, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
 	_, err = tx.ExecContext(ctx, userQuery, user.ID, user.CartID, user.Username,
-		user.Email, user.Password, user.IsAdmin, user.CreatedAt)
+		user.Email, user.Password, user.VerifiedEmail, user.IsAdmin, user.CreatedAt)
 	if err != nil {
 		return errors.Wrap(err, "couldn't create the user")
 	}
@@ -182,7 +182,7 @@ func (s *service) getBy(ctx context.Context, field, value string) (ListUser, err
 	q := `SELECT
 	u.id, u.cart_id, u.username, u.email, u.is_admin, u.created_at, u.updated_at, r.*
 	FROM users AS u
-	LEFT JOIN reviews AS r ON u.id = r.user_id
+	LEFT JOIN reviews AS r ON u.id = r.user_id AND r.status='approved'
 	WHERE u.` + field + `=$1`
 
 	rows, err := s.db.QueryContext(ctx, q, value)
@@ -202,7 +202,7 @@ func scan(rows *sql.Rows) (ListUser, error) {
 		err := rows.Scan(
 			&user.ID, &user.CartID, &user.Username, &user.Email, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt,
 			&r.ID, &r.Stars, &r.Comment, &r.UserID, &r.ProductID,
-			&r.ShopID, &r.CreatedAt,
+			&r.ShopID, &r.CreatedAt, &r.Verified, &r.Status, &r.UpdatedAt,
 		)
 		if err != nil {
 			return ListUser{}, errors.Wrap(err, "couldn't scan user")